@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// awsAccount is where the records of a registration are kept when that is
+// not where the credentials of the agent point: the account of a role it
+// assumes, and the region picking the partition. The zero value stands for
+// the agent's own account, as set up by the flags.
+type awsAccount struct {
+	roleARN    string
+	externalID string
+	region     string
+}
+
+// assumedRoles keeps the credentials of the roles assumed so far, so that
+// the clients a daemon creates on every reconciliation share them rather
+// than each assuming the role again.
+var assumedRoles = struct {
+	mu    sync.Mutex
+	creds map[awsAccount]*credentials.Credentials
+}{creds: map[awsAccount]*credentials.Credentials{}}
+
+// session returns a session calling AWS as the account.
+func (a awsAccount) session(logLevel *aws.LogLevelType) (*session.Session, error) {
+	sess, err := newAWSSession(logLevel)
+	if err != nil || a == (awsAccount{}) {
+		return sess, err
+	}
+	cfg := sess.Config.Copy()
+	if a.region != "" {
+		cfg.Region = aws.String(a.region)
+	}
+	if a.roleARN != "" {
+		cfg.Credentials = a.credentials(sess)
+	}
+	return session.NewSession(cfg)
+}
+
+// credentials returns the credentials of the account's role, assumed with
+// those of sess.
+func (a awsAccount) credentials(sess *session.Session) *credentials.Credentials {
+	assumedRoles.mu.Lock()
+	defer assumedRoles.mu.Unlock()
+	if c, ok := assumedRoles.creds[a]; ok {
+		return c
+	}
+	cfg := &aws.Config{Region: aws.String(firstNonEmpty(a.region, aws.StringValue(sess.Config.Region), "us-east-1"))}
+	c := stscreds.NewCredentialsWithClient(sts.New(sess, cfg), a.roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = awsCredentials.roleSessionName
+		if a.externalID != "" {
+			p.ExternalID = aws.String(a.externalID)
+		}
+		p.ExpiryWindow = credentialExpiryWindow
+	})
+	assumedRoles.creds[a] = c
+	return c
+}
+
+// route53Client returns a Route53 client of the account.
+func (a awsAccount) route53Client(logLevel *aws.LogLevelType) (*route53.Route53, error) {
+	sess, err := a.session(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return route53.New(sess), nil
+}
+
+// partition returns the partition of the account's region, or of the one
+// of the flags.
+func (a awsAccount) partition() string {
+	return awsEndpoints.partition(firstNonEmpty(a.region, awsEndpoints.region))
+}
+
+// key tells accounts apart in the keys of zones, which are only unique
+// within an account.
+func (a awsAccount) key() string {
+	return fmt.Sprintf("%s|%s", a.partition(), a.roleARN)
+}
+
+func validateRoleARN(arn string) error {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || !strings.HasPrefix(parts[5], "role/") {
+		return errors.New("Invalid role_arn " + arn + ", expected an ARN like arn:aws:iam::123456789012:role/dns-records")
+	}
+	return nil
+}
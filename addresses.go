@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// addressSource looks up an IP address of this host. It returns an empty
+// address, and no error, when the host has none of its kind, so the next
+// source can be tried.
+type addressSource func(ctx context.Context, c *ec2metadata.EC2Metadata, arg string) (string, error)
+
+// addressSources are the sources -address-source picks from. interface
+// takes the name of a network interface after a colon, e.g. interface:eth1.
+var addressSources = map[string]addressSource{
+	"elastic-ip":  elasticIPAddress,
+	"public-ipv4": metadataAddress("/public-ipv4"),
+	"local-ipv4":  metadataAddress("/local-ipv4"),
+	"interface":   interfaceAddress,
+}
+
+func metadataAddress(p string) addressSource {
+	return func(ctx context.Context, c *ec2metadata.EC2Metadata, arg string) (string, error) {
+		v, err := getMetadata(ctx, c, p)
+		if isMetadataNotFound(err) {
+			return "", nil
+		}
+		return strings.TrimSpace(v), err
+	}
+}
+
+// elasticIPAddress returns the Elastic IP associated with the instance,
+// which unlike its public-ipv4 stays the same when it is stopped and started.
+// The instance metadata doesn't tell an Elastic IP from any other public IP,
+// so it is asked for with the EC2 API.
+func elasticIPAddress(ctx context.Context, c *ec2metadata.EC2Metadata, arg string) (string, error) {
+	instanceID, err := getMetadata(ctx, c, "/instance-id")
+	if err != nil {
+		return "", err
+	}
+	ec2Client, err := newEC2Client(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	out, err := ec2Client.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: []*string{aws.String(instanceID)}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Addresses) == 0 {
+		return "", nil
+	}
+	// An instance with several private IPs may have an Elastic IP for each,
+	// the one of its primary private IP is preferred
+	localIP, err := getMetadata(ctx, c, "/local-ipv4")
+	if err != nil {
+		return "", err
+	}
+	for _, a := range out.Addresses {
+		if aws.StringValue(a.PrivateIpAddress) == localIP {
+			return aws.StringValue(a.PublicIp), nil
+		}
+	}
+	return aws.StringValue(out.Addresses[0].PublicIp), nil
+}
+
+// interfaceAddress returns the first IPv4 address of the named interface, or
+// of the first interface that is up and not a loopback when none is named.
+func interfaceAddress(ctx context.Context, c *ec2metadata.EC2Metadata, name string) (string, error) {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return "", nil
+		}
+		ifaces = append(ifaces, *iface)
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return "", err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return ipNet.IP.String(), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// parseAddressSources splits a comma separated -address-source into its
+// sources, checking each one is known.
+func parseAddressSources(s string) ([]string, error) {
+	var sources []string
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+		name := strings.SplitN(source, ":", 2)[0]
+		if _, ok := addressSources[name]; !ok {
+			return nil, errors.New("Unknown address source " + source + ", expected elastic-ip, public-ipv4, local-ipv4 or interface[:name]")
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// resolveAddress returns the address of the first of sources that has one,
+// along with that source.
+func resolveAddress(ctx context.Context, c *ec2metadata.EC2Metadata, sources []string) (string, string, error) {
+	for _, source := range sources {
+		parts := strings.SplitN(source, ":", 2)
+		arg := ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+		addr, err := addressSources[parts[0]](ctx, c, arg)
+		if err != nil {
+			return "", "", err
+		}
+		if addr != "" {
+			return addr, source, nil
+		}
+		logger.Debug("Address source has no address", fields{"address_source": source})
+	}
+	return "", "", configError("None of the address sources " + strings.Join(sources, ",") + " has an address for this host")
+}
+
+// usesAddressSource tells whether the A records of o may take their address
+// from the named source.
+func (o *options) usesAddressSource(name string) bool {
+	if o.cname || o.alias.dnsName != "" {
+		return false
+	}
+	sources, _ := parseAddressSources(o.addressSource)
+	for _, source := range sources {
+		if strings.SplitN(source, ":", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// alarmRegion returns the region of -health-check-alarm: the one given,
+// else the one of the AWS clients, else the instance's.
+func (o *options) alarmRegion(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) (string, error) {
+	if o.healthCheckAlarmRegion != "" {
+		return o.healthCheckAlarmRegion, nil
+	}
+	if awsEndpoints.region != "" {
+		return awsEndpoints.region, nil
+	}
+	doc, err := getIdentityDocument(ctx, metadataClient)
+	if err != nil {
+		return "", err
+	}
+	return doc.Region, nil
+}
+
+// findAlarmHealthCheck looks up the health check following the state of
+// -health-check-alarm, leaving o.alarmHealthCheckID empty when there is
+// none yet. The id is kept, so the daemon looks it up only once.
+func (o *options) findAlarmHealthCheck(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) error {
+	if o.alarmHealthCheckID != "" {
+		return nil
+	}
+	region, err := o.alarmRegion(ctx, metadataClient)
+	if err != nil {
+		return err
+	}
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	return r53.ListHealthChecksPagesWithContext(ctx, &route53.ListHealthChecksInput{}, func(out *route53.ListHealthChecksOutput, last bool) bool {
+		for _, c := range out.HealthChecks {
+			alarm := c.HealthCheckConfig.AlarmIdentifier
+			if aws.StringValue(c.HealthCheckConfig.Type) == route53.HealthCheckTypeCloudwatchMetric && alarm != nil &&
+				aws.StringValue(alarm.Name) == o.healthCheckAlarm && aws.StringValue(alarm.Region) == region {
+				o.alarmHealthCheckID = aws.StringValue(c.Id)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// createAlarmHealthCheck creates a health check following the state of
+// -health-check-alarm, which Route53 can keep without reaching the host,
+// e.g. in a private subnet.
+func (o *options) createAlarmHealthCheck(ctx context.Context, r53 *route53.Route53, metadataClient *ec2metadata.EC2Metadata) error {
+	region, err := o.alarmRegion(ctx, metadataClient)
+	if err != nil {
+		return err
+	}
+	out, err := r53.CreateHealthCheckWithContext(ctx, &route53.CreateHealthCheckInput{
+		// Route53 creates a health check only once for the same reference
+		CallerReference: aws.String(fmt.Sprintf("%s-%d", heritage, time.Now().UnixNano())),
+		HealthCheckConfig: &route53.HealthCheckConfig{
+			Type: aws.String(route53.HealthCheckTypeCloudwatchMetric),
+			AlarmIdentifier: &route53.AlarmIdentifier{
+				Name:   aws.String(o.healthCheckAlarm),
+				Region: aws.String(region),
+			},
+			InsufficientDataHealthStatus: aws.String(o.insufficientDataStatus),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	o.alarmHealthCheckID = aws.StringValue(out.HealthCheck.Id)
+	f := fields{"health_check_id": o.alarmHealthCheckID, "alarm_name": o.healthCheckAlarm, "alarm_region": region}
+	_, err = r53.ChangeTagsForResourceWithContext(ctx, &route53.ChangeTagsForResourceInput{
+		ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+		ResourceId:   aws.String(o.alarmHealthCheckID),
+		AddTags: []*route53.Tag{
+			{Key: aws.String("Name"), Value: aws.String(o.healthCheckAlarm)},
+			{Key: aws.String("managed-by"), Value: aws.String(heritage)},
+		},
+	})
+	if err != nil {
+		// The alarm, not the tags, finds the check again
+		logger.Warn("Error tagging health check", errorFields(err, f))
+	}
+	logger.Info("Created health check of CloudWatch alarm", f)
+	return nil
+}
+
+// healthCheck returns the health check of the records: -health-check-id,
+// or the one of -health-check-alarm.
+func (o *options) healthCheck() string {
+	if o.healthCheckAlarm != "" {
+		return o.alarmHealthCheckID
+	}
+	return o.healthCheckID
+}
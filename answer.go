@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// answerQuery holds the routing attributes of a simulated DNS query.
+type answerQuery struct {
+	// subnet is the EDNS client subnet of the query as a CIDR, if any
+	subnet     string
+	resolverIP string
+}
+
+// validate checks q, naming the flags it was given by in its errors.
+func (q answerQuery) validate(subnetFlag, resolverFlag string) error {
+	if q.subnet != "" {
+		if _, _, err := net.ParseCIDR(q.subnet); err != nil {
+			return errors.New("The " + subnetFlag + " parameter must be a CIDR, e.g. 203.0.113.0/24")
+		}
+	}
+	if q.resolverIP != "" && net.ParseIP(q.resolverIP) == nil {
+		return errors.New("The " + resolverFlag + " parameter must be an IP address")
+	}
+	return nil
+}
+
+// testDNSAnswer asks Route53 what its name servers answer for a record when
+// queried with q.
+func testDNSAnswer(ctx context.Context, r53 *route53.Route53, zoneID, name, rrType string, q answerQuery) (*route53.TestDNSAnswerOutput, error) {
+	params := &route53.TestDNSAnswerInput{
+		HostedZoneId: aws.String(normalizeZoneID(zoneID)),
+		RecordName:   aws.String(name),
+		RecordType:   aws.String(rrType),
+	}
+	if q.subnet != "" {
+		parts := strings.SplitN(q.subnet, "/", 2)
+		params.EDNS0ClientSubnetIP = aws.String(parts[0])
+		params.EDNS0ClientSubnetMask = aws.String(parts[1])
+	}
+	if q.resolverIP != "" {
+		params.ResolverIP = aws.String(q.resolverIP)
+	}
+	return r53.TestDNSAnswerWithContext(ctx, params)
+}
+
+// answerSetIdentifiers returns the set identifiers of the record sets the
+// answered values come from, telling which weighted record was picked.
+func answerSetIdentifiers(sets []*route53.ResourceRecordSet, values []string) []string {
+	var ids []string
+	for _, set := range sets {
+		if set.SetIdentifier == nil {
+			continue
+		}
+	search:
+		for _, v := range recordValues(set) {
+			for _, a := range values {
+				if sameRecordName(v, a) {
+					ids = append(ids, aws.StringValue(set.SetIdentifier))
+					break search
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// logTestAnswer logs what Route53 answers for t's name at the given stage of
+// a change. Being a diagnostic, failing to get the answer is only a warning.
+func (o *options) logTestAnswer(ctx context.Context, r53 *route53.Route53, t *target, stage string) {
+	f := t.fields()
+	f["stage"] = stage
+	out, err := testDNSAnswer(ctx, r53, t.zoneID, t.name, t.rrType, o.answerQuery)
+	if err != nil {
+		logger.Warn("Error testing DNS answer", errorFields(err, f))
+		return
+	}
+	answers := aws.StringValueSlice(out.RecordData)
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		logger.Warn("Error testing DNS answer", errorFields(err, f))
+		return
+	}
+	f["response_code"] = aws.StringValue(out.ResponseCode)
+	f["answer"] = strings.Join(answers, ",")
+	f["answer_set_identifiers"] = strings.Join(answerSetIdentifiers(sets, answers), ",")
+	f["nameserver"] = aws.StringValue(out.Nameserver)
+	if o.answerQuery.subnet != "" {
+		f["client_subnet"] = o.answerQuery.subnet
+	}
+	logger.Info("Route53 test answer", f)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// auditEntry is a line of the -audit-log, one for every change submitted to
+// Route53.
+type auditEntry struct {
+	Time          time.Time `json:"time"`
+	ZoneID        string    `json:"zone_id"`
+	Record        string    `json:"record"`
+	Type          string    `json:"type"`
+	SetIdentifier string    `json:"set_identifier,omitempty"`
+	Action        string    `json:"action"`
+	Values        []string  `json:"values,omitempty"`
+	TTL           *int64    `json:"ttl,omitempty"`
+	Weight        *int64    `json:"weight,omitempty"`
+	Comment       string    `json:"comment"`
+	ChangeID      string    `json:"change_id,omitempty"`
+	Identity      string    `json:"identity,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// auditLog appends the changes submitted to Route53 to a JSONL file,
+// rotating it once it grows past maxSize. Like the retry policy it is set up
+// from the flags of the command.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	// identity is the ARN of our credentials, looked up with the first
+	// change
+	identity         string
+	lookedUpIdentity bool
+}
+
+var audit auditLog
+
+func (a *auditLog) configure(path string, maxSizeMB, maxFiles int) error {
+	if maxSizeMB < 0 || maxFiles < 1 {
+		return errors.New("audit-log-max-size can't be negative and audit-log-max-files must be at least 1")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.path, a.maxSize, a.maxFiles = path, int64(maxSizeMB)*1024*1024, maxFiles
+	return nil
+}
+
+// record appends an entry for each of changes, submitted with comment and
+// either accepted as info or failed with err.
+func (a *auditLog) record(ctx context.Context, zoneID, comment string, changes []*route53.Change, info *route53.ChangeInfo, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.path == "" {
+		return
+	}
+	if !a.lookedUpIdentity {
+		a.lookedUpIdentity = true
+		if id, ierr := callerIdentity(ctx, nil); ierr == nil {
+			a.identity = aws.StringValue(id.Arn)
+		} else {
+			logger.Warn("Error looking up the caller identity for the audit log", errorFields(ierr, nil))
+		}
+	}
+	var lines []byte
+	now := time.Now().UTC()
+	for _, c := range changes {
+		set := c.ResourceRecordSet
+		e := auditEntry{
+			Time:          now,
+			ZoneID:        zoneID,
+			Record:        normalizeName(aws.StringValue(set.Name)),
+			Type:          aws.StringValue(set.Type),
+			SetIdentifier: aws.StringValue(set.SetIdentifier),
+			Action:        aws.StringValue(c.Action),
+			Values:        recordValues(set),
+			TTL:           set.TTL,
+			Weight:        set.Weight,
+			Comment:       comment,
+			Identity:      a.identity,
+		}
+		if set.AliasTarget != nil {
+			e.Values = []string{liveValue(set)}
+		}
+		if info != nil {
+			e.ChangeID = aws.StringValue(info.Id)
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		b, merr := json.Marshal(e)
+		if merr != nil {
+			continue
+		}
+		lines = append(append(lines, b...), '\n')
+	}
+	if werr := a.write(lines); werr != nil {
+		logger.Warn("Error writing audit log", errorFields(werr, fields{"audit_log": a.path}))
+	}
+}
+
+func (a *auditLog) write(lines []byte) error {
+	if a.maxSize > 0 {
+		if fi, err := os.Stat(a.path); err == nil && fi.Size()+int64(len(lines)) > a.maxSize {
+			if err := a.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(lines); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate renames the log to .1, the former .1 to .2 and so on, dropping
+// the oldest file once there are maxFiles.
+func (a *auditLog) rotate() error {
+	for i := a.maxFiles - 1; i >= 1; i-- {
+		from := a.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", a.path, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", a.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if a.maxFiles == 1 {
+		return os.Remove(a.path)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxBatchElements is the most ResourceRecord elements Route53 takes in one
+// change batch, those of an UPSERT counting twice.
+const maxBatchElements = 1000
+
+// maxBatchRecords is the most records changed in one change batch. Every
+// record registered is an UPSERT of its value and one of its ownership
+// marker, which makes 4 elements.
+const maxBatchRecords = maxBatchElements / 4
+
+// batchElements counts the elements of changes towards maxBatchElements.
+func batchElements(changes []*route53.Change) int {
+	n := 0
+	for _, c := range changes {
+		records := len(c.ResourceRecordSet.ResourceRecords)
+		if aws.StringValue(c.Action) == route53.ChangeActionUpsert {
+			records *= 2
+		}
+		n += records
+	}
+	return n
+}
+
+// batchRequest is a line of -stdin: a request as the serve API takes it,
+// along with the action to take on its record.
+type batchRequest struct {
+	registrationRequest
+	Action string `json:"action"`
+}
+
+// batchKey groups the records changed together: those of one zone, all
+// registered or all deregistered.
+type batchKey struct {
+	zoneID string
+	action string
+}
+
+// runBatch reads newline delimited JSON requests from in until it's closed
+// and applies them in change batches of up to size records. Every line is
+// checked before anything is changed, so a typo doesn't leave the changes
+// half applied.
+func (o *options) runBatch(ctx context.Context, in io.Reader, defaultAction string, size int) error {
+	if size < 1 || size > maxBatchRecords {
+		return configError(fmt.Sprintf("The batch-size parameter must be between 1 and %d", maxBatchRecords))
+	}
+	if o.output != "text" && o.output != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown output format %q, expected text or json", o.output))
+	}
+	zoneIDs := map[string]string{}
+	if o.zoneName != "" || o.zoneID != "" {
+		if err := o.validateZone(); err != nil {
+			return err
+		}
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		zoneIDs[normalizeName(o.zone())] = zoneID
+	}
+
+	batches := map[batchKey][]*target{}
+	var keys []batchKey
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		lineError := func(err error) error {
+			return withExitCode(exitConfig, fmt.Errorf("Line %d of stdin: %v", line, err))
+		}
+		var req batchRequest
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			return lineError(err)
+		}
+		action := firstNonEmpty(req.Action, defaultAction)
+		if action != "register" && action != "deregister" {
+			return lineError(fmt.Errorf("Unknown action %q, expected register or deregister", action))
+		}
+		zone := normalizeName(firstNonEmpty(req.Zone, o.zone()))
+		if zone == "" {
+			return lineError(errors.New("No zone given, and neither the zonename nor the zoneId parameter"))
+		}
+		t, err := req.target(zone, o.weight, o.ttl)
+		if err == nil && action == "register" {
+			err = validateRequestValue(t)
+		}
+		if err != nil {
+			return lineError(err)
+		}
+		t.force = o.force
+		// Route53 rejects a change batch changing a record twice
+		id := t.name + "|" + t.rrType + "|" + t.setIdentifier
+		if seen[id] {
+			return lineError(fmt.Errorf("%s %s with set identifier %s is given more than once", t.name, t.rrType, t.setIdentifier))
+		}
+		seen[id] = true
+		zoneID, ok := zoneIDs[zone]
+		if !ok {
+			if zoneID, err = getDNSHostedZoneID(ctx, o.account, zone); err != nil {
+				return err
+			}
+			zoneIDs[zone] = zoneID
+		}
+		t.zoneID = zoneID
+		k := batchKey{zoneID, action}
+		if batches[k] == nil {
+			keys = append(keys, k)
+		}
+		batches[k] = append(batches[k], t)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		logger.Info("No requests on stdin", nil)
+		return nil
+	}
+
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	total, failed, code := 0, 0, 0
+	for _, k := range keys {
+		ts := batches[k]
+		for start := 0; start < len(ts); start += size {
+			end := start + size
+			if end > len(ts) {
+				end = len(ts)
+			}
+			total++
+			if err := o.applyBatch(ctx, r53, k.action, ts[start:end]); err != nil {
+				if failed == 0 {
+					code = exitCode(err)
+				}
+				failed++
+				logger.Error("Batch failed", errorFields(err, fields{"zone_id": k.zoneID, "action": k.action, "records": end - start}))
+			}
+		}
+	}
+	if failed > 0 {
+		return withExitCode(code, fmt.Errorf("%d of %d batches failed", failed, total))
+	}
+	return nil
+}
+
+// applyBatch registers or deregisters ts in a single change batch.
+func (o *options) applyBatch(ctx context.Context, r53 *route53.Route53, action string, ts []*target) error {
+	var info *route53.ChangeInfo
+	var err error
+	if action == "register" {
+		info, err = upsertRecords(ctx, r53, ts)
+	} else {
+		info, err = deleteRecords(ctx, r53, ts)
+	}
+	for _, t := range ts {
+		o.printResult(t, info, err)
+		o.runHooks(ctx, action, newResult(t, info, err))
+	}
+	if err == nil && info != nil {
+		logger.Info("Batch applied", fields{"zone_id": ts[0].zoneID, "action": action, "records": len(ts), "change_id": aws.StringValue(info.Id)})
+	}
+	return err
+}
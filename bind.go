@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// bindNameField is the field of the value of each record type holding a
+// domain name, which zone files may give relative to the origin.
+var bindNameField = map[string]int{
+	"CNAME": 0,
+	"NS":    0,
+	"PTR":   0,
+	"MX":    1,
+	"SRV":   3,
+}
+
+// writeBindZone writes the records of export as a BIND zone file. Alias
+// records and records with a routing policy have no zone file form, they are
+// written as comments.
+func writeBindZone(w io.Writer, export exportedZone) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; %s exported from %s at %s\n", export.Zone, export.ZoneID, export.Exported.Format("2006-01-02T15:04:05Z07:00"))
+	fmt.Fprintf(bw, "$ORIGIN %s.\n", export.Zone)
+	skipped := 0
+	for _, r := range export.Records {
+		if r.Alias != nil {
+			fmt.Fprintf(bw, "; %s\t%s\tALIAS %s (zone %s)\n", r.Name, r.Type, r.Alias.DNSName, r.Alias.ZoneID)
+			skipped++
+			continue
+		}
+		prefix := ""
+		if r.SetIdentifier != "" {
+			// Only one of the sets sharing the name would answer
+			fmt.Fprintf(bw, "; set_identifier %s\n", r.SetIdentifier)
+			prefix = "; "
+			skipped++
+		}
+		ttl := defaultTTL(r.Type)
+		if r.TTL != nil {
+			ttl = *r.TTL
+		}
+		for _, v := range r.Values {
+			fmt.Fprintf(bw, "%s%s\t%d\tIN\t%s\t%s\n", prefix, r.Name, ttl, r.Type, bindValue(r.Type, v, ""))
+		}
+	}
+	if skipped > 0 {
+		logger.Warn("Records without a zone file form were written as comments", fields{"zone": export.Zone, "records": skipped})
+	}
+	return bw.Flush()
+}
+
+// bindValue returns value with its domain name absolute, qualified with
+// origin when it's relative. Route53 takes names without the trailing dot as
+// absolute, zone files as relative.
+func bindValue(rrType, value, origin string) string {
+	i, ok := bindNameField[rrType]
+	if !ok {
+		return value
+	}
+	f := strings.Fields(value)
+	if i >= len(f) || strings.HasSuffix(f[i], ".") {
+		return value
+	}
+	if origin != "" {
+		f[i] = bindName(f[i], origin)
+	}
+	f[i] += "."
+	return strings.Join(f, " ")
+}
+
+// bindName returns the full name of a name of a zone file, which unlike a
+// -hostname is relative to origin unless it ends with a dot.
+func bindName(name, origin string) string {
+	switch {
+	case name == apexHostname:
+		return origin
+	case strings.HasSuffix(name, "."):
+		return normalizeName(name)
+	}
+	return normalizeName(name + "." + origin)
+}
+
+// readBindZone reads the records of a BIND zone file, the names relative to
+// zone unless the file sets another $ORIGIN. The values of the lines sharing
+// their name and type make up a single record set, with the TTL of the first.
+func readBindZone(in io.Reader, zone string) ([]exportedRecord, error) {
+	origin := normalizeName(zone)
+	// Records without a TTL take the one of $TTL, or else the one of the
+	// record before them
+	var directiveTTL, lastTTL *int64
+	owner := ""
+	var records []exportedRecord
+	index := map[string]int{}
+	lines, err := bindLines(in)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		lineError := func(err error) error {
+			return fmt.Errorf("line %d: %v", l.number, err)
+		}
+		f := l.fields
+		switch strings.ToUpper(f[0]) {
+		case "$ORIGIN":
+			if len(f) != 2 {
+				return nil, lineError(errors.New("$ORIGIN takes a name"))
+			}
+			origin = bindName(f[1], origin)
+			continue
+		case "$TTL":
+			if len(f) != 2 {
+				return nil, lineError(errors.New("$TTL takes a TTL"))
+			}
+			v, err := parseBindTTL(f[1])
+			if err != nil {
+				return nil, lineError(err)
+			}
+			directiveTTL = &v
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, lineError(errors.New(f[0] + " isn't supported"))
+		}
+		if !l.continued {
+			owner, f = bindName(f[0], origin), f[1:]
+		}
+		if owner == "" {
+			return nil, lineError(errors.New("record without a name"))
+		}
+		// TTL and class come in either order, both optional
+		ttl := lastTTL
+		if directiveTTL != nil {
+			ttl = directiveTTL
+		}
+		for len(f) > 0 {
+			if v, err := parseBindTTL(f[0]); err == nil {
+				ttl, f = &v, f[1:]
+				continue
+			}
+			if strings.EqualFold(f[0], "IN") {
+				f = f[1:]
+				continue
+			}
+			break
+		}
+		if len(f) < 2 {
+			return nil, lineError(errors.New("expected a type and a value"))
+		}
+		rrType := strings.ToUpper(f[0])
+		value := bindValue(rrType, strings.Join(f[1:], " "), origin)
+		lastTTL = ttl
+		name := relativeName(owner, zone)
+		k := owner + "|" + rrType
+		if i, ok := index[k]; ok {
+			records[i].Values = append(records[i].Values, value)
+			continue
+		}
+		index[k] = len(records)
+		records = append(records, exportedRecord{Name: name, Type: rrType, TTL: ttl, Values: []string{value}})
+	}
+	return records, nil
+}
+
+// bindLine is a record or directive of a zone file, continued on the
+// following lines while its parentheses are open.
+type bindLine struct {
+	number int
+	fields []string
+	// continued is set when the line starts with a blank, naming no owner
+	continued bool
+}
+
+// bindLines splits a zone file into its lines, without comments. Quoted
+// strings are kept as one field, quotes included.
+func bindLines(in io.Reader) ([]bindLine, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lines []bindLine
+	var current *bindLine
+	depth := 0
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		if current == nil {
+			current = &bindLine{number: number, continued: strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")}
+		}
+		field := ""
+		quoted, inField := false, false
+		end := func() {
+			if inField {
+				current.fields = append(current.fields, field)
+			}
+			field, inField = "", false
+		}
+	scan:
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case c == '\\' && i+1 < len(text):
+				field += text[i : i+2]
+				inField = true
+				i++
+			case c == '"':
+				field += `"`
+				inField = true
+				quoted = !quoted
+			case quoted:
+				field += string(c)
+			case c == ';':
+				break scan
+			case c == '(':
+				end()
+				depth++
+			case c == ')':
+				end()
+				if depth == 0 {
+					return nil, fmt.Errorf("line %d: unbalanced parentheses", number)
+				}
+				depth--
+			case c == ' ' || c == '\t':
+				end()
+			default:
+				field += string(c)
+				inField = true
+			}
+		}
+		if quoted {
+			return nil, fmt.Errorf("line %d: unterminated string", number)
+		}
+		end()
+		if depth > 0 {
+			continue
+		}
+		if len(current.fields) > 0 {
+			lines = append(lines, *current)
+		}
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		return nil, errors.New("unbalanced parentheses at the end of the file")
+	}
+	return lines, nil
+}
+
+// parseBindTTL parses a TTL in seconds, or with BIND's units, e.g. 1h30m.
+func parseBindTTL(s string) (int64, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && v >= 0 {
+		return v, nil
+	}
+	units := map[byte]int64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var total, n int64
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			n, digits = n*10+int64(c-'0'), true
+		case units[c|0x20] > 0 && digits:
+			total, n, digits = total+n*units[c|0x20], 0, false
+		default:
+			return 0, errors.New("invalid TTL " + s)
+		}
+	}
+	if digits || total == 0 && s == "" {
+		return 0, errors.New("invalid TTL " + s)
+	}
+	return total, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBindTTL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "300", want: 300},
+		{in: "0", want: 0},
+		{in: "1h", want: 3600},
+		{in: "1h30m", want: 5400},
+		{in: "1W2D", want: 777600},
+		{in: "90s", want: 90},
+		{in: "", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1h30", wantErr: true},
+		{in: "h", wantErr: true},
+		{in: "IN", wantErr: true},
+		{in: "A", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBindTTL(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBindTTL(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseBindTTL(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestBindValue(t *testing.T) {
+	tests := []struct {
+		rrType, value, origin string
+		want                  string
+	}{
+		{"A", "10.0.0.1", "example.com", "10.0.0.1"},
+		{"CNAME", "web", "example.com", "web.example.com."},
+		{"CNAME", "web.example.org.", "example.com", "web.example.org."},
+		{"CNAME", "web.example.org", "", "web.example.org."},
+		{"MX", "10 mail", "example.com", "10 mail.example.com."},
+		{"SRV", "10 5 443 api", "example.com", "10 5 443 api.example.com."},
+		{"CNAME", "@", "example.com", "example.com."},
+	}
+	for _, tt := range tests {
+		if got := bindValue(tt.rrType, tt.value, tt.origin); got != tt.want {
+			t.Errorf("bindValue(%s, %q, %q) = %q, want %q", tt.rrType, tt.value, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestReadBindZone(t *testing.T) {
+	ttl := func(v int64) *int64 { return &v }
+	zone := `; exported
+$TTL 1h
+@	IN	SOA	ns1 hostmaster (
+		2026030101 ; serial
+		7200 3600 1209600 300 )
+www	300	IN	A	10.0.0.1
+	IN	300	A	10.0.0.2
+api		CNAME	www
+mail.example.com.	MX	10 mail
+txt	TXT	"v=spf1 ; not a comment" "second"
+$ORIGIN sub.example.com.
+db	60	AAAA	2001:db8::1
+`
+	want := []exportedRecord{
+		// Import leaves out the SOA, its names are kept as written
+		{Name: "@", Type: "SOA", TTL: ttl(3600), Values: []string{"ns1 hostmaster 2026030101 7200 3600 1209600 300"}},
+		{Name: "www", Type: "A", TTL: ttl(300), Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "api", Type: "CNAME", TTL: ttl(3600), Values: []string{"www.example.com."}},
+		{Name: "mail", Type: "MX", TTL: ttl(3600), Values: []string{"10 mail.example.com."}},
+		{Name: "txt", Type: "TXT", TTL: ttl(3600), Values: []string{`"v=spf1 ; not a comment" "second"`}},
+		{Name: "db.sub", Type: "AAAA", TTL: ttl(60), Values: []string{"2001:db8::1"}},
+	}
+	got, err := readBindZone(strings.NewReader(zone), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("readBindZone returned %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Name != w.Name || g.Type != w.Type || g.TTL == nil || *g.TTL != *w.TTL || !reflect.DeepEqual(g.Values, w.Values) {
+			t.Errorf("record %d = %s %s %v %q, want %s %s %d %q", i, g.Name, g.Type, g.TTL, g.Values, w.Name, w.Type, *w.TTL, w.Values)
+		}
+	}
+}
+
+func TestReadBindZoneErrors(t *testing.T) {
+	for _, zone := range []string{
+		"www 300 IN A (10.0.0.1\n",
+		"www 300 IN A 10.0.0.1 )\n",
+		"www 300 IN TXT \"unterminated\n",
+		"$INCLUDE other.zone\n",
+		"$ORIGIN\n",
+		"$TTL 1x\n",
+		"www 300 IN\n",
+		" 300 IN A 10.0.0.1\n",
+	} {
+		if _, err := readBindZone(strings.NewReader(zone), "example.com"); err == nil {
+			t.Errorf("readBindZone(%q) succeeded, want an error", zone)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Tags telling apart the calculated health checks this tool manages, one
+// per record name and type.
+const (
+	calculatedRecordTag = "route53_register:record"
+	calculatedTypeTag   = "route53_register:type"
+)
+
+// maxTaggedResources is how many resources ListTagsForResources takes at once.
+const maxTaggedResources = 10
+
+// updateCalculatedHealthChecks keeps a calculated health check for each
+// record name of ts, healthy while at least -calculated-health-threshold
+// of the health checks of the weighted records sharing the name are, so
+// the name can serve as a failover target elsewhere. The records were
+// changed already, so failures are only logged.
+func (o *options) updateCalculatedHealthChecks(ctx context.Context, ts []*target) {
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		logger.Warn("Error updating calculated health check", errorFields(err, nil))
+		return
+	}
+	done := map[string]bool{}
+	for _, t := range ts {
+		if done[t.name+"|"+t.rrType] {
+			continue
+		}
+		done[t.name+"|"+t.rrType] = true
+		if err := o.updateCalculatedHealthCheck(ctx, r53, t); err != nil {
+			logger.Warn("Error updating calculated health check", errorFields(err, fields{"record_name": t.name, "record_type": t.rrType}))
+		}
+	}
+}
+
+func (o *options) updateCalculatedHealthCheck(ctx context.Context, r53 *route53.Route53, t *target) error {
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return err
+	}
+	check, err := findCalculatedHealthCheck(ctx, r53, t.name, t.rrType)
+	if err != nil {
+		return err
+	}
+	var children []string
+	for _, set := range sets {
+		id := aws.StringValue(set.HealthCheckId)
+		if id != "" && (check == nil || id != aws.StringValue(check.Id)) {
+			children = append(children, id)
+		}
+	}
+	sort.Strings(children)
+	f := fields{"record_name": t.name, "record_type": t.rrType, "children": len(children)}
+	if len(children) == 0 {
+		logger.Warn("No record of the name has a health check, leaving the calculated health check alone", f)
+		return nil
+	}
+	threshold := o.calculatedHealthThreshold
+	if threshold > int64(len(children)) {
+		threshold = int64(len(children))
+	}
+	f["health_threshold"] = threshold
+
+	if check == nil {
+		out, err := r53.CreateHealthCheckWithContext(ctx, &route53.CreateHealthCheckInput{
+			// Route53 creates a health check only once for the same reference
+			CallerReference: aws.String(fmt.Sprintf("%s-%d", heritage, time.Now().UnixNano())),
+			HealthCheckConfig: &route53.HealthCheckConfig{
+				Type:              aws.String(route53.HealthCheckTypeCalculated),
+				ChildHealthChecks: aws.StringSlice(children),
+				HealthThreshold:   aws.Int64(threshold),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		id := aws.StringValue(out.HealthCheck.Id)
+		f["health_check_id"] = id
+		// Untagged, the check would be created again next time
+		if _, err := r53.ChangeTagsForResourceWithContext(ctx, &route53.ChangeTagsForResourceInput{
+			ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+			ResourceId:   aws.String(id),
+			AddTags: []*route53.Tag{
+				{Key: aws.String("Name"), Value: aws.String(t.name + " " + t.rrType)},
+				{Key: aws.String("managed-by"), Value: aws.String(heritage)},
+				{Key: aws.String(calculatedRecordTag), Value: aws.String(t.name)},
+				{Key: aws.String(calculatedTypeTag), Value: aws.String(t.rrType)},
+			},
+		}); err != nil {
+			return err
+		}
+		logger.Info("Created calculated health check", f)
+		return nil
+	}
+
+	f["health_check_id"] = aws.StringValue(check.Id)
+	config := check.HealthCheckConfig
+	current := aws.StringValueSlice(config.ChildHealthChecks)
+	sort.Strings(current)
+	if strings.Join(current, ",") == strings.Join(children, ",") && aws.Int64Value(config.HealthThreshold) == threshold {
+		logger.Debug("Calculated health check is up to date", f)
+		return nil
+	}
+	_, err = r53.UpdateHealthCheckWithContext(ctx, &route53.UpdateHealthCheckInput{
+		HealthCheckId: check.Id,
+		// Fails rather than overwrite a concurrent update by another host
+		HealthCheckVersion: check.HealthCheckVersion,
+		ChildHealthChecks:  aws.StringSlice(children),
+		HealthThreshold:    aws.Int64(threshold),
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Updated calculated health check", f)
+	return nil
+}
+
+// findCalculatedHealthCheck returns the calculated health check managed for
+// the records of name and type, nil when there is none yet.
+func findCalculatedHealthCheck(ctx context.Context, r53 *route53.Route53, name, rrType string) (*route53.HealthCheck, error) {
+	calculated := map[string]*route53.HealthCheck{}
+	var ids []string
+	err := r53.ListHealthChecksPagesWithContext(ctx, &route53.ListHealthChecksInput{}, func(out *route53.ListHealthChecksOutput, last bool) bool {
+		for _, c := range out.HealthChecks {
+			if c.HealthCheckConfig != nil && aws.StringValue(c.HealthCheckConfig.Type) == route53.HealthCheckTypeCalculated {
+				calculated[aws.StringValue(c.Id)] = c
+				ids = append(ids, aws.StringValue(c.Id))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; start < len(ids); start += maxTaggedResources {
+		end := start + maxTaggedResources
+		if end > len(ids) {
+			end = len(ids)
+		}
+		out, err := r53.ListTagsForResourcesWithContext(ctx, &route53.ListTagsForResourcesInput{
+			ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+			ResourceIds:  aws.StringSlice(ids[start:end]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, set := range out.ResourceTagSets {
+			tags := map[string]string{}
+			for _, tag := range set.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			if tags[calculatedRecordTag] == name && tags[calculatedTypeTag] == rrType {
+				return calculated[aws.StringValue(set.ResourceId)], nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// changeStatus is the status of a change as change-status reports it.
+type changeStatus struct {
+	ID          string    `json:"change_id"`
+	Status      string    `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Comment     string    `json:"comment,omitempty"`
+	// InSyncAfter is how long after its submission the change was seen in
+	// sync, only known when it was pending at first
+	InSyncAfter string `json:"in_sync_after,omitempty"`
+	Waited      string `json:"waited,omitempty"`
+}
+
+func runChangeStatus(args []string) error {
+	var o options
+	fs := newFlagSet("change-status")
+	o.addCommonFlags(fs)
+	wait := fs.Bool("wait", true, "poll pending changes until they are in sync, failing when -timeout runs out first")
+	interval := fs.Duration("interval", changeSyncDelay, "how often a pending change is polled")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if fs.NArg() == 0 {
+		return configError("Missing change id, e.g. change-status /change/C2682N5HXP0BZ4")
+	}
+	if *interval <= 0 {
+		return configError("The interval parameter must be positive")
+	}
+	if *format != "text" && *format != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected text or json", *format))
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, id := range fs.Args() {
+		s, err := pollChange(ctx, r53, normalizeChangeID(id), *wait, *interval)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			json.NewEncoder(os.Stdout).Encode(s)
+		} else {
+			s.print()
+		}
+		if s.Status != route53.ChangeStatusInsync {
+			pending = append(pending, s.ID)
+		}
+	}
+	if len(pending) > 0 {
+		return withExitCode(exitVerifyFailed, errors.New("Change "+strings.Join(pending, ", ")+" isn't in sync yet"))
+	}
+	return nil
+}
+
+// normalizeChangeID takes a change id the way the API returns it
+// (/change/C123), bare (C123) or as an ARN, and returns the first form.
+func normalizeChangeID(id string) string {
+	if i := strings.LastIndex(id, "change/"); i >= 0 {
+		id = id[i+len("change/"):]
+	}
+	return "/change/" + id
+}
+
+// pollChange gets the status of a change, polling it every interval until
+// it is in sync when wait is set. Running out of time while it is pending
+// returns the last status rather than failing, for the caller to report.
+func pollChange(ctx context.Context, r53 *route53.Route53, id string, wait bool, interval time.Duration) (*changeStatus, error) {
+	started := time.Now()
+	var s *changeStatus
+	for {
+		out, err := r53.GetChangeWithContext(ctx, &route53.GetChangeInput{Id: aws.String(id)})
+		if err != nil {
+			if s != nil && ctx.Err() != nil {
+				return s, nil
+			}
+			return nil, withExitCode(exitChangeFailed, fmt.Errorf("Error getting change %s: %v", id, err))
+		}
+		info := out.ChangeInfo
+		first := s == nil
+		s = &changeStatus{
+			ID:          aws.StringValue(info.Id),
+			Status:      aws.StringValue(info.Status),
+			SubmittedAt: aws.TimeValue(info.SubmittedAt),
+			Comment:     aws.StringValue(info.Comment),
+		}
+		f := fields{"change_id": s.ID, "status": s.Status, "submitted_at": s.SubmittedAt}
+		if s.Status == route53.ChangeStatusInsync {
+			if !first {
+				// Seen pending before, so it went in sync since the last poll
+				s.InSyncAfter = time.Since(s.SubmittedAt).Round(time.Second).String()
+				s.Waited = time.Since(started).Round(time.Second).String()
+				f["in_sync_after"], f["waited"] = s.InSyncAfter, s.Waited
+			}
+			logger.Debug("Change in sync", f)
+			return s, nil
+		}
+		logger.Debug("Change pending", f)
+		if !wait {
+			return s, nil
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			s.Waited = time.Since(started).Round(time.Second).String()
+			return s, nil
+		}
+	}
+}
+
+func (s *changeStatus) print() {
+	line := fmt.Sprintf("%s %s submitted %s", s.Status, s.ID, s.SubmittedAt.UTC().Format(time.RFC3339))
+	switch {
+	case s.InSyncAfter != "":
+		line += fmt.Sprintf(", in sync after %s (waited %s)", s.InSyncAfter, s.Waited)
+	case s.Status != route53.ChangeStatusInsync:
+		line += fmt.Sprintf(", pending for %s", time.Since(s.SubmittedAt).Round(time.Second))
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func runCheck(args []string) error {
+	var o options
+	fs := newFlagSet("check")
+	o.addZoneFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	// Every check runs even when an earlier one failed, so a single run
+	// lists everything that is missing
+	var failed error
+	report := func(action, resource, detail string, err error) {
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			fmt.Printf("FAIL %s on %s: %s\n", action, resource, errorMessage(err))
+			return
+		}
+		fmt.Printf("OK   %s on %s %s\n", action, resource, detail)
+	}
+
+	identity, err := callerIdentity(ctx, o.logLevel())
+	report("sts:GetCallerIdentity", "*", aws.StringValue(identity.Arn), err)
+
+	zoneID := o.zoneID
+	if zoneID == "" {
+		zoneID, err = getDNSHostedZoneID(ctx, o.account, o.zoneName)
+		report("route53:ListHostedZonesByName", "*", o.zoneName+" is "+zoneID, err)
+	}
+	if zoneID != "" {
+		zoneID = normalizeZoneID(zoneID)
+		if o.zoneID != "" && o.zoneName != "" {
+			err = checkZoneName(ctx, o.account, zoneID, o.zoneName)
+			report("route53:GetHostedZone", awsEndpoints.arn("route53", "", "", "hostedzone/"+zoneID), "is named "+o.zoneName, err)
+		}
+		r53, err := newRoute53Client(o.logLevel())
+		if err == nil {
+			_, err = r53.ListResourceRecordSetsWithContext(ctx, &route53.ListResourceRecordSetsInput{
+				HostedZoneId: aws.String(zoneID),
+				MaxItems:     aws.String("1"),
+			})
+		}
+		report("route53:ListResourceRecordSets", awsEndpoints.arn("route53", "", "", "hostedzone/"+zoneID), "", err)
+		if r53 != nil {
+			checkZoneDelegation(ctx, r53, zoneID, o.zoneName)
+		}
+	}
+	return failed
+}
+
+// callerIdentity returns who the credentials of the AWS clients belong to.
+func callerIdentity(ctx context.Context, logLevel *aws.LogLevelType) (*sts.GetCallerIdentityOutput, error) {
+	sess, err := newAWSSession(logLevel)
+	if err != nil {
+		return &sts.GetCallerIdentityOutput{}, err
+	}
+	cfg := &aws.Config{}
+	if aws.StringValue(sess.Config.Region) == "" {
+		// STS has a global endpoint, which is the one of us-east-1
+		cfg.Region = aws.String("us-east-1")
+	}
+	out, err := sts.New(sess, cfg).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return &sts.GetCallerIdentityOutput{}, err
+	}
+	return out, nil
+}
+
+// errorMessage describes err on a single line, AWS errors by code and message.
+func errorMessage(err error) string {
+	f := errorFields(err, nil)
+	msg := fmt.Sprint(f["error"])
+	if code, ok := f["aws_error_code"]; ok {
+		msg = fmt.Sprintf("%s: %s", code, msg)
+	}
+	return strings.Replace(msg, "\n", " ", -1)
+}
+
+// checkZoneDelegation prints whether the zone is delegated to the name
+// servers of the hosted zone. Records of an undelegated zone don't resolve,
+// but the credentials work all the same, so it's only a warning.
+func checkZoneDelegation(ctx context.Context, r53 *route53.Route53, zoneID, zone string) {
+	var err error
+	if zone == "" {
+		if zone, err = hostedZoneName(ctx, awsAccount{}, zoneID); err != nil {
+			fmt.Printf("WARN delegation not checked: %s\n", errorMessage(err))
+			return
+		}
+	}
+	servers, err := checkDelegation(ctx, r53, zoneID, normalizeName(zone))
+	switch {
+	case err != nil:
+		fmt.Printf("WARN delegation of %s: %s\n", zone, errorMessage(err))
+	case servers != nil:
+		fmt.Printf("OK   delegation of %s to %s\n", zone, strings.Join(servers, ", "))
+	}
+}
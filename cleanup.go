@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// instanceStateChange is the part of an EventBridge "EC2 Instance
+// State-change Notification" event we use.
+type instanceStateChange struct {
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		InstanceID string `json:"instance-id"`
+		State      string `json:"state"`
+	} `json:"detail"`
+}
+
+const instanceStateChangeType = "EC2 Instance State-change Notification"
+
+func runCleanup(args []string) error {
+	var o options
+	fs := newFlagSet("cleanup")
+	o.addZoneFlags(fs)
+	queueURL := fs.String("queue-url", "", "SQS queue EventBridge sends EC2 instance state-change events to (required)")
+	onStop := fs.String("on-stop", "drain", "what to do with the records of stopped instances: drain, deregister or nothing")
+	waitTime := fs.Duration("wait-time", 20*time.Second, "how long each receive waits for events to arrive, up to 20s")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *queueURL == "" {
+		return configError("The queue-url parameter is required")
+	}
+	if *onStop != "drain" && *onStop != "deregister" && *onStop != "nothing" {
+		return configError("Unknown on-stop " + *onStop + ", expected drain, deregister or nothing")
+	}
+	if *waitTime < 0 || *waitTime > 20*time.Second {
+		return configError("The wait-time parameter must be between 0s and 20s")
+	}
+
+	running, stopRunning := untilStopped()
+	defer stopRunning()
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return err
+	}
+	sess, cfg, err := newRegionalSession(running, metadataClient, o.logLevel())
+	if err != nil {
+		return err
+	}
+	queue := sqs.New(sess, cfg)
+	for running.Err() == nil {
+		out, err := queue.ReceiveMessageWithContext(running, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(*queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(int64(*waitTime / time.Second)),
+		})
+		if err != nil {
+			if running.Err() != nil {
+				break
+			}
+			// The client already retried, back off before receiving again
+			logger.Error("Receiving events failed", errorFields(err, fields{"queue_url": *queueURL}))
+			sleepContext(running, *waitTime)
+			continue
+		}
+		for _, m := range out.Messages {
+			traced, root := tracing.StartTrace(running, "cleanup", fields{"message_id": aws.StringValue(m.MessageId)})
+			ctx, cancel := o.withTimeout(traced)
+			err := o.handleStateChange(ctx, aws.StringValue(m.Body), *onStop)
+			if err == nil {
+				_, err = queue.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(*queueURL),
+					ReceiptHandle: m.ReceiptHandle,
+				})
+			}
+			cancel()
+			root.End(err)
+			if err != nil {
+				// The event is received again once its visibility timeout
+				// has passed
+				logger.Error("Handling event failed", errorFields(err, fields{"message_id": aws.StringValue(m.MessageId)}))
+			}
+			if ferr := tracing.Flush(root); ferr != nil {
+				logger.Warn("Error exporting traces", errorFields(ferr, nil))
+			}
+		}
+	}
+	return nil
+}
+
+// handleStateChange deregisters the records of a terminated instance, and
+// drains or deregisters those of a stopped one. Other events are dropped.
+func (o *options) handleStateChange(ctx context.Context, body, onStop string) error {
+	var event instanceStateChange
+	if err := json.Unmarshal([]byte(body), &event); err != nil || event.DetailType != instanceStateChangeType || event.Detail.InstanceID == "" {
+		logger.Warn("Dropping event that isn't an EC2 instance state change", nil)
+		return nil
+	}
+	action := ""
+	switch event.Detail.State {
+	case "terminated":
+		action = "deregister"
+	case "stopped":
+		action = onStop
+	}
+	f := fields{"instance_id": event.Detail.InstanceID, "state": event.Detail.State}
+	if action == "" || action == "nothing" {
+		logger.Debug("Ignoring instance state change", f)
+		return nil
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	ts := instanceTargets(sets, zoneID, event.Detail.InstanceID)
+	if len(ts) == 0 {
+		logger.Info("Instance has no records", f)
+		return nil
+	}
+	if action == "drain" {
+		_, err = setDrained(ctx, r53, ts, true)
+	} else {
+		_, err = deleteRecords(ctx, r53, ts)
+	}
+	return err
+}
+
+// instanceTargets returns the weighted records hosts registered with the
+// instance id as their set identifier. Records kept by controller or
+// discover, and shared records, are left to those that keep them.
+func instanceTargets(sets []*route53.ResourceRecordSet, zoneID, instanceID string) []*target {
+	var ts []*target
+	for _, markerSet := range sets {
+		name, ok := isOwnerRecordName(normalizeName(aws.StringValue(markerSet.Name)))
+		if !ok || aws.StringValue(markerSet.Type) != route53.RRTypeTxt || aws.StringValue(markerSet.SetIdentifier) != instanceID {
+			continue
+		}
+		owned := false
+		for _, v := range recordValues(markerSet) {
+			if m, ok := parseOwnerMarker(v); ok && m.source == "" && m.id == instanceID {
+				owned = true
+			}
+		}
+		if !owned {
+			continue
+		}
+		for _, set := range sets {
+			if sameRecordName(aws.StringValue(set.Name), name) && aws.StringValue(set.SetIdentifier) == instanceID && aws.StringValue(set.Type) != route53.RRTypeTxt {
+				ts = append(ts, &target{
+					zoneID:        zoneID,
+					name:          name,
+					rrType:        aws.StringValue(set.Type),
+					setIdentifier: instanceID,
+					weight:        aws.Int64Value(set.Weight),
+				})
+			}
+		}
+	}
+	return ts
+}
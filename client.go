@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// clientActions are the requests the client command sends, each to the
+// serve endpoint of the same name.
+var clientActions = []string{"register", "deregister", "status"}
+
+// runClient registers records through a serve command instead of calling
+// AWS itself, so hosts and containers without IAM access get records too.
+// The action is the first argument: register (the default), deregister or
+// status.
+func runClient(args []string) error {
+	action := "register"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	var o options
+	fs := newFlagSet("client")
+	o.addCommonFlags(fs)
+	server := fs.String("server", firstNonEmpty(os.Getenv("ROUTE53_REGISTER_SERVER"), "http://127.0.0.1:8053"), "URL of the serve API (default $ROUTE53_REGISTER_SERVER)")
+	var req registrationRequest
+	fs.StringVar(&req.Zone, "zone", "", "zone the hostname is relative to, one granted by the server's auth policy (default the server's zone)")
+	fs.StringVar(&req.Hostname, "hostname", "", "name of the record, relative to the zone (required)")
+	fs.StringVar(&req.Type, "type", route53.RRTypeA, "type of the record: A, AAAA or CNAME")
+	fs.StringVar(&req.Value, "value", "", "value of the record (default the first IPv4 address of this host's interfaces for A records)")
+	hostname, _ := os.Hostname()
+	fs.StringVar(&req.SetIdentifier, "set-identifier", hostname, "identifier of this record among the records sharing its name")
+	weight := fs.Int64("weight", defaultWeight, "weight of the record (default the server's)")
+	ttl := fs.Int64("ttl", 0, "TTL of the record in seconds (default the server's)")
+	auth := fs.String("auth", authNone, "how to authenticate to the server: none, mtls or sigv4")
+	tlsCert := fs.String("tls-cert", "", "PEM client certificate to authenticate with (required with -auth mtls)")
+	tlsKey := fs.String("tls-key", "", "PEM private key of -tls-cert")
+	serverCA := fs.String("server-ca", "", "PEM file of CA certificates the server's certificate is verified with in addition to the system's")
+	serverID := fs.String("sigv4-server-id", "route53_register", "-sigv4-server-id of the server, signed into the requests with -auth sigv4")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if !containsString(clientActions, action) {
+		return configError("Unknown action " + action + ", expected one of " + strings.Join(clientActions, ", "))
+	}
+	if req.Hostname == "" {
+		return configError("The hostname parameter is required")
+	}
+	if req.SetIdentifier == "" {
+		return configError("The set-identifier parameter is required, this host has no hostname to default to")
+	}
+	switch *auth {
+	case authNone, authSigV4:
+	case authMTLS:
+		if *tlsCert == "" || *tlsKey == "" {
+			return configError("The mtls auth needs the tls-cert and tls-key parameters")
+		}
+	default:
+		return configError("Unknown auth " + *auth + ", expected none, mtls or sigv4")
+	}
+	// The server's defaults apply unless given
+	if o.setFlags["weight"] {
+		req.Weight = weight
+	}
+	if o.setFlags["ttl"] {
+		req.TTL = ttl
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	if action == "register" && req.Value == "" && strings.ToUpper(req.Type) == route53.RRTypeA {
+		addr, err := interfaceAddress(ctx, nil, "")
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			return configError("This host has no IPv4 address to register, give a -value")
+		}
+		req.Value = addr
+	}
+	c, err := newServeClient(*server, *tlsCert, *tlsKey, *serverCA)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if *auth == authSigV4 {
+		c.serverID = *serverID
+	}
+	body, err := c.call(ctx, action, req)
+	if err != nil {
+		return err
+	}
+	logger.Info("Request served", fields{"action": action, "server": *server, "record_name": body["record_name"]})
+	return json.NewEncoder(os.Stdout).Encode(body)
+}
+
+// serveClient calls the API of a serve command.
+type serveClient struct {
+	addr   string
+	client *http.Client
+	// serverID is set when the requests are signed with SigV4
+	serverID string
+}
+
+func newServeClient(addr, certFile, keyFile, serverCA string) (*serveClient, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("Invalid server URL " + addr)
+	}
+	cfg := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if serverCA != "" {
+		pem, err := ioutil.ReadFile(serverCA)
+		if err != nil {
+			return nil, err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in server CA " + serverCA)
+		}
+		cfg.RootCAs = roots
+	}
+	return &serveClient{
+		addr:   strings.TrimSuffix(addr, "/"),
+		client: &http.Client{Timeout: time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cfg}},
+	}, nil
+}
+
+// call sends req to the endpoint of action, returning the body of the
+// answer. Refusals end the run with the status matching their cause.
+func (c *serveClient) call(ctx context.Context, action string, req registrationRequest) (map[string]interface{}, error) {
+	var httpReq *http.Request
+	var err error
+	if action == "status" {
+		q := url.Values{"hostname": {req.Hostname}, "type": {req.Type}, "set_identifier": {req.SetIdentifier}}
+		if req.Zone != "" {
+			q.Set("zone", req.Zone)
+		}
+		httpReq, err = http.NewRequest("GET", c.addr+"/v1/status?"+q.Encode(), nil)
+	} else {
+		b, merr := json.Marshal(req)
+		if merr != nil {
+			return nil, merr
+		}
+		httpReq, err = http.NewRequest("POST", c.addr+"/v1/"+action, bytes.NewReader(b))
+		if err == nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.serverID != "" {
+		if err := c.sign(httpReq); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Invalid answer from %s: %s: %v", c.addr, resp.Status, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, nil
+	}
+	err = fmt.Errorf("%s: %v", resp.Status, body["error"])
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, withExitCode(exitAccessDenied, err)
+	case resp.StatusCode == http.StatusBadGateway:
+		return nil, withExitCode(exitChangeFailed, err)
+	case resp.StatusCode == http.StatusConflict:
+		return nil, withExitCode(exitNotOwned, err)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return nil, withExitCode(exitConfig, err)
+	}
+	return nil, err
+}
+
+// sign adds the headers of a SigV4 signed STS GetCallerIdentity request to
+// req, which the server passes on to STS to learn who we are.
+func (c *serveClient) sign(req *http.Request) error {
+	sess, err := newAWSSession(nil)
+	if err != nil {
+		return err
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint, err := awsEndpoints.EndpointFor("sts", region)
+	if err != nil {
+		return err
+	}
+	signingRegion := firstNonEmpty(endpoint.SigningRegion, region)
+	stsReq, err := http.NewRequest("POST", endpoint.URL+"/", nil)
+	if err != nil {
+		return err
+	}
+	stsReq.Header.Set("Content-Type", stsIdentityContentType)
+	stsReq.Header.Set(serverIDHeader, c.serverID)
+	if _, err := v4.NewSigner(sess.Config.Credentials).Sign(stsReq, strings.NewReader(stsIdentityBody), "sts", signingRegion, time.Now()); err != nil {
+		return withExitCode(exitAccessDenied, err)
+	}
+	for _, h := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token", serverIDHeader} {
+		if v := stsReq.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	return nil
+}
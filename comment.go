@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// maxCommentLength is the longest change batch comment Route53 takes.
+const maxCommentLength = 256
+
+// commentData is what a -change-comment template is executed with.
+type commentData struct {
+	// Comment is the comment the change would have without a template,
+	// e.g. "Host A Record Created"
+	Comment    string
+	ZoneID     string
+	InstanceID string
+	AMI        string
+	Hostname   string
+	User       string
+	// Time is the time of the change in RFC 3339, UTC
+	Time string
+}
+
+// changeComment renders the comment of every change batch from the
+// -change-comment template. Like the audit log it is set up from the flags
+// of the command.
+type changeComment struct {
+	mu   sync.Mutex
+	tmpl *template.Template
+	// instanceID and ami are read from the instance metadata the first
+	// time a template uses them
+	instanceID, ami string
+	lookedUp        bool
+}
+
+var changeComments changeComment
+
+func (c *changeComment) configure(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tmpl = nil
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New("change-comment").Funcs(template.FuncMap{
+		"env": os.Getenv,
+	}).Parse(text)
+	if err == nil {
+		// Catches fields commentData doesn't have before any change is made
+		err = tmpl.Execute(ioutil.Discard, commentData{})
+	}
+	if err != nil {
+		return errors.New("Invalid change-comment template: " + err.Error())
+	}
+	c.tmpl = tmpl
+	return nil
+}
+
+// render returns the comment of a change to zoneID, comment itself when
+// there is no template or it fails.
+func (c *changeComment) render(ctx context.Context, zoneID, comment string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tmpl == nil {
+		return comment
+	}
+	data := commentData{
+		Comment: comment,
+		ZoneID:  zoneID,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+	}
+	data.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		data.User = u.Username
+	}
+	c.lookUpInstance(ctx)
+	data.InstanceID, data.AMI = c.instanceID, c.ami
+	var buf bytes.Buffer
+	if err := c.tmpl.Execute(&buf, data); err != nil {
+		logger.Warn("Error rendering change-comment template, using the default comment", errorFields(err, nil))
+		return comment
+	}
+	rendered := strings.TrimSpace(buf.String())
+	if len(rendered) > maxCommentLength {
+		rendered = rendered[:maxCommentLength]
+	}
+	return rendered
+}
+
+// lookUpInstance reads the instance id and AMI the first time a template
+// refers to them. Off EC2 they stay empty.
+func (c *changeComment) lookUpInstance(ctx context.Context) {
+	text := c.tmpl.Root.String()
+	if c.lookedUp || !strings.Contains(text, ".InstanceID") && !strings.Contains(text, ".AMI") {
+		return
+	}
+	c.lookedUp = true
+	metadataClient, err := newMetadataClient()
+	if err == nil {
+		if c.instanceID, err = getMetadata(ctx, metadataClient, "/instance-id"); err == nil {
+			c.ami, err = getMetadata(ctx, metadataClient, "/ami-id")
+		}
+	}
+	if err != nil {
+		logger.Debug("Error reading instance for change comment", errorFields(err, nil))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionShells are the shells completion writes a script for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// errFlagsCollected stops a command right after it defined its flags, when
+// they are collected for completion.
+var errFlagsCollected = errors.New("flags collected")
+
+// flagCollector, when set, gets the flag set of the command being run
+// instead of the command parsing it.
+var flagCollector func(fs *flag.FlagSet)
+
+// commandFlags returns the flags of c, sorted by name, running it only as
+// far as defining them.
+func commandFlags(c command) []*flag.Flag {
+	var flags []*flag.Flag
+	flagCollector = func(fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, f)
+		})
+	}
+	defer func() { flagCollector = nil }()
+	c.run(nil)
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// commandActions are the actions commands take as their first argument.
+var commandActions = map[string][]string{
+	"client":     clientActions,
+	"service":    serviceActions,
+	"completion": completionShells,
+}
+
+// flagValues returns the values of a flag taking one of a few, and whether
+// it takes a file instead.
+func flagValues(f *flag.Flag) (values []string, file bool) {
+	if isBoolFlag(f) {
+		return nil, false
+	}
+	switch f.Name {
+	case "log-format":
+		return []string{"text", "json"}, false
+	case "log-level":
+		return []string{"debug", "info", "warn", "error"}, false
+	case "output":
+		return []string{"text", "json"}, false
+	case "operation":
+		return policyOperations, false
+	case "all":
+		return []string{"fail", "softfail", "neutral"}, false
+	case "policy", "subdomain-policy":
+		return []string{"none", "quarantine", "reject"}, false
+	case "adkim", "aspf":
+		return []string{"r", "s"}, false
+	case "address-source":
+		return []string{"elastic-ip", "public-ipv4", "local-ipv4", "interface"}, false
+	}
+	return nil, f.Name == "config" || f.Name == "ca-bundle" || strings.HasSuffix(f.Name, "-file") || f.Name == "file"
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func runCompletion(args []string) error {
+	shell := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		shell, args = args[0], args[1:]
+	}
+	var o options
+	fs := newFlagSet("completion")
+	o.addCommonFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	program := "route53_register"
+	switch shell {
+	case "bash":
+		writeBashCompletion(os.Stdout, program)
+	case "zsh":
+		// zsh runs the bash completion through its compatibility layer
+		fmt.Fprintf(os.Stdout, "#compdef %s\nautoload -U +X bashcompinit && bashcompinit\n", program)
+		writeBashCompletion(os.Stdout, program)
+	case "fish":
+		writeFishCompletion(os.Stdout, program)
+	default:
+		return configError(fmt.Sprintf("Unknown shell %q, expected %s", shell, strings.Join(completionShells, ", ")))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, program string) {
+	fn := "_" + program
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" command=register\n")
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(w, "  if [ \"$COMP_CWORD\" -eq 1 ] && [[ \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n    return\n  fi\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "  case \"${COMP_WORDS[1]}\" in\n    %s) command=\"${COMP_WORDS[1]}\" ;;\n  esac\n", strings.Join(names, "|"))
+	fmt.Fprintf(w, "  case \"$command\" in\n")
+	for _, c := range commands {
+		var flagNames []string
+		var values []string
+		var files []string
+		for _, f := range commandFlags(c) {
+			flagNames = append(flagNames, "-"+f.Name)
+			vs, file := flagValues(f)
+			switch {
+			case len(vs) > 0:
+				values = append(values, fmt.Sprintf("      -%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.Name, strings.Join(vs, " ")))
+			case file:
+				files = append(files, "-"+f.Name)
+			}
+		}
+		fmt.Fprintf(w, "  %s)\n    case \"$prev\" in\n", c.name)
+		for _, v := range values {
+			fmt.Fprint(w, v)
+		}
+		if len(files) > 0 {
+			fmt.Fprintf(w, "      %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(files, "|"))
+		}
+		fmt.Fprintf(w, "    esac\n")
+		if actions := commandActions[c.name]; len(actions) > 0 {
+			fmt.Fprintf(w, "    if [ \"$COMP_CWORD\" -eq 2 ] && [[ \"$cur\" != -* ]]; then\n")
+			fmt.Fprintf(w, "      COMPREPLY=($(compgen -W %q -- \"$cur\")); return\n    fi\n", strings.Join(actions, " "))
+		}
+		fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(flagNames, " "))
+	}
+	fmt.Fprintf(w, "  esac\n}\ncomplete -o default %s %s\n", fn, program)
+}
+
+func writeFishCompletion(w io.Writer, program string) {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(w, "complete -c %s -f\n", program)
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", program, c.name, fishQuote(c.description))
+	}
+	for _, c := range commands {
+		// Flags without a command are those of register
+		condition := "__fish_seen_subcommand_from " + c.name
+		if c.name == "register" {
+			condition = "not __fish_seen_subcommand_from " + strings.Join(names[1:], " ")
+		}
+		if actions := commandActions[c.name]; len(actions) > 0 {
+			fmt.Fprintf(w, "complete -c %s -n '%s' -a %s\n", program, condition, fishQuote(strings.Join(actions, " ")))
+		}
+		for _, f := range commandFlags(c) {
+			line := fmt.Sprintf("complete -c %s -n '%s' -o %s -d %s", program, condition, f.Name, fishQuote(firstLine(f.Usage)))
+			vs, file := flagValues(f)
+			switch {
+			case len(vs) > 0:
+				line += " -x -a " + fishQuote(strings.Join(vs, " "))
+			case file:
+				line += " -r -F"
+			case !isBoolFlag(f):
+				line += " -x"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// firstLine returns the usage of a flag up to its first clause, short
+// enough for a completion menu.
+func firstLine(usage string) string {
+	if i := strings.IndexAny(usage, ";("); i > 0 {
+		usage = usage[:i]
+	}
+	return strings.TrimSpace(usage)
+}
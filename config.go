@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the content of a -config file. Being YAML, it may as well be
+// written as JSON.
+type config struct {
+	Registrations []registration `yaml:"registrations"`
+}
+
+// registration describes one record of a -config file. Fields that are left
+// out keep the value of the corresponding flag.
+type registration struct {
+	Zone             string   `yaml:"zone"`
+	ZoneID           string   `yaml:"zone_id"`
+	Hostname         string   `yaml:"hostname"`
+	Hostnames        []string `yaml:"hostnames"`
+	HostnameFile     string   `yaml:"hostname_file"`
+	ValueFile        string   `yaml:"value_file"`
+	Type             string   `yaml:"type"`
+	CNAMETarget      string   `yaml:"cname_target"`
+	AddressSource    string   `yaml:"address_source"`
+	Routing          string   `yaml:"routing"`
+	SetIdentifier    string   `yaml:"set_identifier"`
+	Weight           *int64   `yaml:"weight"`
+	TTL              *int64   `yaml:"ttl"`
+	HealthCheckID    string   `yaml:"health_check_id"`
+	HealthCheckAlarm string   `yaml:"health_check_alarm"`
+	AliasTarget      string   `yaml:"alias_target"`
+	AliasZoneID      string   `yaml:"alias_zone_id"`
+	MXName           string   `yaml:"mx_name"`
+	MXPriority       *int64   `yaml:"mx_priority"`
+	RoleARN          string   `yaml:"role_arn"`
+	ExternalID       string   `yaml:"external_id"`
+	Region           string   `yaml:"region"`
+}
+
+func loadConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err = yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// registrations returns the options of every record a command works on:
+// the one described by the flags, or each one of the -config file with the
+// flags given on the command line taking precedence over the file.
+func (o *options) registrations() ([]*options, error) {
+	if o.configFile == "" {
+		if o.hostnameFile == "" && o.valueFile == "" {
+			return []*options{o}, nil
+		}
+		// A copy, so that reading the files again starts from the flags
+		ro := *o
+		if err := ro.readRecordFiles(); err != nil {
+			return nil, withExitCode(exitConfig, err)
+		}
+		return []*options{&ro}, nil
+	}
+	c, err := loadConfig(o.configFile)
+	if err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	if len(c.Registrations) == 0 {
+		return nil, configError("Config file " + o.configFile + " has no registrations")
+	}
+	var regs []*options
+	for i, r := range c.Registrations {
+		ro := *o
+		if err := ro.apply(r); err != nil {
+			return nil, withExitCode(exitConfig, fmt.Errorf("Registration %d of %s: %v", i+1, o.configFile, err))
+		}
+		if err := ro.resolveParameters(); err != nil {
+			return nil, err
+		}
+		if err := ro.readRecordFiles(); err != nil {
+			return nil, withExitCode(exitConfig, fmt.Errorf("Registration %d of %s: %v", i+1, o.configFile, err))
+		}
+		regs = append(regs, &ro)
+	}
+	return regs, nil
+}
+
+// apply sets the options described by r, except those given as flags.
+func (o *options) apply(r registration) error {
+	setString := func(flagName string, dst *string, v string) {
+		if v != "" && !o.setFlags[flagName] {
+			*dst = v
+		}
+	}
+	setInt := func(flagName string, dst *int64, v *int64) {
+		if v != nil && !o.setFlags[flagName] {
+			*dst = *v
+		}
+	}
+	setString("zonename", &o.zoneName, r.Zone)
+	setString("zoneId", &o.zoneID, r.ZoneID)
+	if (r.Hostname != "" || len(r.Hostnames) > 0) && !o.setFlags["hostname"] {
+		o.hostnames = nil
+		if r.Hostname != "" {
+			o.hostnames = append(o.hostnames, r.Hostname)
+		}
+		o.hostnames = append(o.hostnames, r.Hostnames...)
+	}
+	setString("hostname-file", &o.hostnameFile, r.HostnameFile)
+	setString("value-file", &o.valueFile, r.ValueFile)
+	setString("set-identifier", &o.setIdentifier, r.SetIdentifier)
+	setString("cname-target", &o.cnameTarget, r.CNAMETarget)
+	setString("address-source", &o.addressSource, r.AddressSource)
+	setString("health-check-id", &o.healthCheckID, r.HealthCheckID)
+	setString("health-check-alarm", &o.healthCheckAlarm, r.HealthCheckAlarm)
+	setString("alias-target", &o.alias.dnsName, r.AliasTarget)
+	setString("alias-zone-id", &o.alias.zoneID, r.AliasZoneID)
+	setInt("weight", &o.weight, r.Weight)
+	setInt("ttl", &o.ttl, r.TTL)
+	setString("mx-name", &o.mxName, r.MXName)
+	setInt("mx-priority", &o.mxPriority, r.MXPriority)
+	// Not the -role-arn of web identity tokens, but a role assumed with
+	// whatever credentials the agent has
+	o.account = awsAccount{roleARN: r.RoleARN, externalID: r.ExternalID}
+	setString("region", &o.account.region, r.Region)
+
+	if r.ExternalID != "" && r.RoleARN == "" {
+		return errors.New("external_id needs a role_arn to assume")
+	}
+	if r.RoleARN != "" {
+		if err := validateRoleARN(r.RoleARN); err != nil {
+			return err
+		}
+	}
+
+	switch r.Type {
+	case "":
+	case route53.RRTypeA, route53.RRTypeCname:
+		if !o.setFlags["cname"] {
+			o.cname = r.Type == route53.RRTypeCname
+		}
+	default:
+		return fmt.Errorf("Unknown type %q, expected A or CNAME", r.Type)
+	}
+	switch r.Routing {
+	case "":
+	case "weighted", "shared":
+		if !o.setFlags["shared"] {
+			o.shared = r.Routing == "shared"
+		}
+	default:
+		return fmt.Errorf("Unknown routing %q, expected weighted or shared", r.Routing)
+	}
+	return nil
+}
+
+// eachRegistration runs fn for every registration, carrying on past failed
+// ones so a single bad record doesn't hold back the others. Registrations of
+// different zones run concurrently, up to -parallel at once, keeping hosts
+// registering into many zones quick to boot. Those of one zone run in order,
+// as they may share ownership markers and locks.
+func (o *options) eachRegistration(fn func(*options) error) error {
+	regs, err := o.registrations()
+	if err != nil {
+		return err
+	}
+	if len(regs) == 1 {
+		return fn(regs[0])
+	}
+	if o.parallel < 1 {
+		return configError("The parallel parameter must be at least 1")
+	}
+	var zones [][]int
+	byZone := map[string]int{}
+	for i, r := range regs {
+		k := normalizeName(r.zoneName)
+		if r.zoneID != "" {
+			k = normalizeZoneID(r.zoneID)
+		}
+		// Zones of the same name in other accounts are other zones
+		k = r.account.key() + "|" + k
+		z, ok := byZone[k]
+		if !ok {
+			z = len(zones)
+			byZone[k] = z
+			zones = append(zones, nil)
+		}
+		zones[z] = append(zones[z], i)
+	}
+	errs := make([]error, len(regs))
+	slots := make(chan struct{}, o.parallel)
+	var wg sync.WaitGroup
+	for _, z := range zones {
+		// Taking the slot before starting the goroutine keeps at most
+		// -parallel of them around, and starts the zones in the file's order
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(z []int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			for _, i := range z {
+				errs[i] = fn(regs[i])
+			}
+		}(z)
+	}
+	wg.Wait()
+
+	// The status is the one of the first failure in the file, whichever
+	// finished first
+	failed, code := 0, 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed == 0 {
+			code = exitCode(err)
+		}
+		failed++
+		logger.Error("Registration failed", errorFields(err, fields{"hostname": regs[i].hostnames.String(), "zone_name": regs[i].zoneName}))
+	}
+	if failed > 0 {
+		return withExitCode(code, fmt.Errorf("%d of %d registrations failed", failed, len(regs)))
+	}
+	return nil
+}
+
+// recordKey identifies the record registering hostname with o makes, telling
+// whether a reloaded config still declares it. It leaves out the zone name
+// looked up by id, which reloaded registrations don't know yet.
+func (o *options) recordKey(hostname string) string {
+	key := fmt.Sprintf("%s|%s|%s|%s|%t|%t|%s|%s", o.account.key(), normalizeZoneID(o.zoneID), o.zoneName, qualifyName(hostname, o.zoneName), o.cname, o.shared, o.setIdentifier, o.alias.dnsName)
+	if o.shared {
+		// The value of a shared record is only one of many, so the old one
+		// of a -value-file has to be removed rather than replaced
+		key += "|" + o.fileValue
+	}
+	return key
+}
+
+// droppedRegistrations returns the parts of the registrations in old whose
+// records none of regs declares anymore.
+func droppedRegistrations(old, regs []*options) []*options {
+	declared := map[string]bool{}
+	for _, r := range regs {
+		for _, h := range r.recordHostnames() {
+			declared[r.recordKey(h)] = true
+		}
+	}
+	var dropped []*options
+	for _, r := range old {
+		var hostnames stringList
+		for _, h := range r.recordHostnames() {
+			if !declared[r.recordKey(h)] {
+				hostnames = append(hostnames, h)
+			}
+		}
+		if len(hostnames) > 0 {
+			d := *r
+			d.hostnames = hostnames
+			dropped = append(dropped, &d)
+		}
+	}
+	return dropped
+}
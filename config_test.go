@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	weight, ttl := int64(20), int64(30)
+	tests := []struct {
+		name     string
+		flags    options
+		setFlags []string
+		r        registration
+		check    func(o options) bool
+		wantErr  bool
+	}{
+		{
+			name: "file sets the options",
+			r: registration{Zone: "example.com", Hostname: "web", Hostnames: []string{"api"}, Type: "CNAME", Routing: "shared",
+				SetIdentifier: "web-1", Weight: &weight, TTL: &ttl, HealthCheckID: "hc-1"},
+			check: func(o options) bool {
+				return o.zoneName == "example.com" && o.hostnames.String() == "web,api" && o.cname && o.shared &&
+					o.setIdentifier == "web-1" && o.weight == 20 && o.ttl == 30 && o.healthCheckID == "hc-1"
+			},
+		},
+		{
+			name:  "left out fields keep the flags",
+			flags: options{zoneName: "example.com", hostnames: stringList{"web"}, weight: 10, ttl: 60, cname: true},
+			r:     registration{SetIdentifier: "web-1"},
+			check: func(o options) bool {
+				return o.zoneName == "example.com" && o.hostnames.String() == "web" && o.weight == 10 && o.ttl == 60 && o.cname && o.setIdentifier == "web-1"
+			},
+		},
+		{
+			name:     "flags given on the command line win",
+			flags:    options{zoneName: "example.org", hostnames: stringList{"api"}, weight: 5, cname: true},
+			setFlags: []string{"zonename", "hostname", "weight", "cname"},
+			r:        registration{Zone: "example.com", Hostname: "web", Weight: &weight, Type: "A"},
+			check: func(o options) bool {
+				return o.zoneName == "example.org" && o.hostnames.String() == "api" && o.weight == 5 && o.cname
+			},
+		},
+		{
+			name:  "weighted routing turns off shared",
+			flags: options{shared: true},
+			r:     registration{Routing: "weighted", Type: "A"},
+			check: func(o options) bool {
+				return !o.shared && !o.cname
+			},
+		},
+		{name: "unknown type", r: registration{Type: "MX"}, wantErr: true},
+		{name: "unknown routing", r: registration{Routing: "latency"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		o := tt.flags
+		o.setFlags = map[string]bool{}
+		for _, f := range tt.setFlags {
+			o.setFlags[f] = true
+		}
+		err := o.apply(tt.r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: apply succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: apply failed: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(o) {
+			t.Errorf("%s: apply set %+v", tt.name, o)
+		}
+	}
+}
+
+func TestDroppedRegistrations(t *testing.T) {
+	reg := func(zone string, hostnames ...string) *options {
+		return &options{zoneName: zone, hostnames: hostnames, setIdentifier: "web-1"}
+	}
+	with := func(o *options, f func(*options)) *options {
+		f(o)
+		return o
+	}
+	tests := []struct {
+		name     string
+		old, new []*options
+		want     []string
+	}{
+		{
+			name: "unchanged",
+			old:  []*options{reg("example.com", "web", "api")},
+			new:  []*options{reg("example.com", "web", "api")},
+		},
+		{
+			name: "reordered",
+			old:  []*options{reg("example.com", "web"), reg("example.org", "api")},
+			new:  []*options{reg("example.org", "api"), reg("example.com", "web")},
+		},
+		{
+			name: "moved to another registration",
+			old:  []*options{reg("example.com", "web", "api")},
+			new:  []*options{reg("example.com", "web"), reg("example.com", "api")},
+		},
+		{
+			name: "hostname removed",
+			old:  []*options{reg("example.com", "web", "api", "www")},
+			new:  []*options{reg("example.com", "api")},
+			want: []string{"example.com|web,www"},
+		},
+		{
+			name: "registration removed",
+			old:  []*options{reg("example.com", "web"), reg("example.org", "api")},
+			new:  []*options{reg("example.com", "web")},
+			want: []string{"example.org|api"},
+		},
+		{
+			name: "other zone",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{reg("example.org", "web")},
+			want: []string{"example.com|web"},
+		},
+		{
+			name: "other set identifier",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{with(reg("example.com", "web"), func(o *options) { o.setIdentifier = "web-2" })},
+			want: []string{"example.com|web"},
+		},
+		{
+			name: "other type",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{with(reg("example.com", "web"), func(o *options) { o.cname = true })},
+			want: []string{"example.com|web"},
+		},
+		{
+			name: "now shared",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{with(reg("example.com", "web"), func(o *options) { o.shared = true })},
+			want: []string{"example.com|web"},
+		},
+		{
+			name: "full name of the same record",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{reg("example.com", "Web.example.com.")},
+		},
+		{
+			name: "zone id in another form",
+			old:  []*options{with(reg("", "web"), func(o *options) { o.zoneID = "Z123" })},
+			new:  []*options{with(reg("", "web"), func(o *options) { o.zoneID = "/hostedzone/Z123" })},
+		},
+		{
+			// Only the record matters, not how the registration is set up
+			name: "other weight",
+			old:  []*options{reg("example.com", "web")},
+			new:  []*options{with(reg("example.com", "web"), func(o *options) { o.weight = 50 })},
+		},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, d := range droppedRegistrations(tt.old, tt.new) {
+			got = append(got, d.zoneName+"|"+d.hostnames.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: droppedRegistrations = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEachRegistration(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registrations.yaml")
+	err := ioutil.WriteFile(file, []byte(`registrations:
+  - {zone: a.example.com, hostname: a1}
+  - {zone: b.example.com, hostname: b1}
+  - {zone: a.example.com, hostname: a2}
+  - {zone: b.example.com, hostname: b2}
+  - {zone: a.example.com, hostname: a3}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &options{configFile: file, parallel: 2}
+
+	// a1 only returns once b1 has started, which it never would if the zones
+	// ran one after the other
+	bStarted := make(chan struct{})
+	var mu sync.Mutex
+	order := map[string][]string{}
+	err = o.eachRegistration(func(r *options) error {
+		name := r.hostnames.String()
+		mu.Lock()
+		order[r.zoneName] = append(order[r.zoneName], name)
+		mu.Unlock()
+		switch name {
+		case "a1":
+			select {
+			case <-bStarted:
+			case <-time.After(5 * time.Second):
+				t.Error("the registrations of b.example.com didn't start while a.example.com was worked on")
+			}
+		case "b1":
+			close(bStarted)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"a.example.com": {"a1", "a2", "a3"}, "b.example.com": {"b1", "b2"}}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("registrations ran in the order %v, want %v", order, want)
+	}
+}
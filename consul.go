@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// consulSource tags the ownership markers of the records mirrored from
+// Consul, which are removed once their instance is gone or unhealthy.
+const consulSource = "consul"
+
+// consulInstance is a healthy instance of a Consul service.
+type consulInstance struct {
+	service string
+	// id tells the instance apart from those of the same service on other
+	// nodes, and is the set identifier of its record
+	id      string
+	address string
+}
+
+type consulService struct {
+	ID      string
+	Service string
+	Address string
+	Tags    []string
+}
+
+func runConsul(args []string) error {
+	var o options
+	fs := newFlagSet("consul")
+	o.addZoneFlags(fs)
+	addr := fs.String("consul-addr", firstNonEmpty(os.Getenv("CONSUL_HTTP_ADDR"), "http://127.0.0.1:8500"), "address of the Consul API")
+	token := fs.String("consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "Consul ACL token allowed to read the services")
+	agent := fs.Bool("agent", false, "mirror the services of the local agent rather than the whole catalog")
+	services := fs.String("services", "", "services to mirror, separated by commas (default: all but consul)")
+	tag := fs.String("tag", "", "only mirror the instances carrying this tag")
+	routing := fs.String("routing", "multivalue", "routing policy of the records: multivalue or weighted")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records in seconds (default 60)")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of each instance's record with -routing weighted")
+	interval := fs.Duration("interval", 30*time.Second, "how often to reconcile the records with Consul")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *routing != "multivalue" && *routing != "weighted" {
+		return configError("Unknown routing " + *routing + ", expected multivalue or weighted")
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	var only []string
+	for _, name := range strings.Split(*services, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			only = append(only, name)
+		}
+	}
+	consul := newAPIClient("Consul", *addr, "X-Consul-Token", *token)
+	return o.repeat("consul", fields{"consul_addr": *addr}, *once, *interval, func(ctx context.Context) error {
+		var instances []consulInstance
+		var err error
+		if *agent {
+			instances, err = consul.agentInstances(ctx, only, *tag)
+		} else {
+			instances, err = consul.catalogInstances(ctx, only, *tag)
+		}
+		if err != nil {
+			return err
+		}
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		desired := o.consulRecords(instances, *routing == "weighted")
+		r53, err := newRoute53Client(o.logLevel())
+		if err != nil {
+			return err
+		}
+		sets, err := listRecordSets(ctx, r53, zoneID)
+		if err != nil {
+			return err
+		}
+		changes := syncChanges(sets, desired, nil, consulSource, false, time.Now())
+		return submitSyncChanges(ctx, r53, zoneID, "Consul Records Mirrored", sets, changes, len(desired), *dryRun)
+	})
+}
+
+// mirrored tells whether the instances of a service, carrying tags, are to
+// be mirrored.
+func mirrored(service string, tags, only []string, tag string) bool {
+	if len(only) == 0 && service == "consul" {
+		return false
+	}
+	if len(only) > 0 && !containsString(only, service) {
+		return false
+	}
+	return tag == "" || containsString(tags, tag)
+}
+
+// catalogInstances returns the instances of the catalog's services whose
+// health checks all pass.
+func (c *apiClient) catalogInstances(ctx context.Context, only []string, tag string) ([]consulInstance, error) {
+	var catalog map[string][]string
+	if err := c.get(ctx, "/v1/catalog/services", &catalog); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var instances []consulInstance
+	for _, name := range names {
+		if !mirrored(name, catalog[name], only, tag) {
+			continue
+		}
+		path := "/v1/health/service/" + url.PathEscape(name) + "?passing=true"
+		if tag != "" {
+			path += "&tag=" + url.QueryEscape(tag)
+		}
+		var entries []struct {
+			Node struct {
+				Node    string
+				Address string
+			}
+			Service consulService
+		}
+		if err := c.get(ctx, path, &entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !mirrored(e.Service.Service, e.Service.Tags, only, tag) {
+				continue
+			}
+			instances = append(instances, consulInstance{
+				service: e.Service.Service,
+				id:      e.Node.Node + "/" + e.Service.ID,
+				address: firstNonEmpty(e.Service.Address, e.Node.Address),
+			})
+		}
+	}
+	return instances, nil
+}
+
+// agentInstances returns the services of the local agent whose health
+// checks all pass.
+func (c *apiClient) agentInstances(ctx context.Context, only []string, tag string) ([]consulInstance, error) {
+	var self struct {
+		Config struct {
+			NodeName string
+		}
+		Member struct {
+			Addr string
+		}
+	}
+	if err := c.get(ctx, "/v1/agent/self", &self); err != nil {
+		return nil, err
+	}
+	var services map[string]consulService
+	if err := c.get(ctx, "/v1/agent/services", &services); err != nil {
+		return nil, err
+	}
+	var checks map[string]struct {
+		ServiceID string
+		Status    string
+	}
+	if err := c.get(ctx, "/v1/agent/checks", &checks); err != nil {
+		return nil, err
+	}
+	failing := map[string]bool{}
+	for _, check := range checks {
+		if check.Status != "passing" {
+			failing[check.ServiceID] = true
+		}
+	}
+	var instances []consulInstance
+	for _, s := range services {
+		if failing[s.ID] || !mirrored(s.Service, s.Tags, only, tag) {
+			continue
+		}
+		instances = append(instances, consulInstance{
+			service: s.Service,
+			id:      self.Config.NodeName + "/" + s.ID,
+			address: firstNonEmpty(s.Address, self.Member.Addr),
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].id < instances[j].id })
+	return instances, nil
+}
+
+// consulRecords returns a record for every instance under the name of its
+// service, identified by the instance, as multivalue answer records or
+// weighted ones.
+func (o *options) consulRecords(instances []consulInstance, weighted bool) map[syncKey]*route53.ResourceRecordSet {
+	zone := o.zone()
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for _, i := range instances {
+		name := qualifyName(i.service, zone)
+		f := fields{"service": i.service, "instance": i.id}
+		if err := validateDNSName(name); err != nil {
+			logger.Warn("Service name isn't a valid record name, leaving it out", errorFields(err, f))
+			continue
+		}
+		ip := net.ParseIP(i.address)
+		if ip == nil {
+			logger.Warn("Instance address isn't an IP, leaving it out", f)
+			continue
+		}
+		rrType := route53.RRTypeA
+		if ip.To4() == nil {
+			rrType = route53.RRTypeAaaa
+		}
+		set := &route53.ResourceRecordSet{
+			Name:            aws.String(name),
+			Type:            aws.String(rrType),
+			ResourceRecords: resourceRecords([]string{i.address}),
+			SetIdentifier:   aws.String(i.id),
+			TTL:             aws.Int64(recordTTL(o.ttl, rrType)),
+		}
+		if weighted {
+			set.Weight = aws.Int64(o.weight)
+		} else {
+			set.MultiValueAnswer = aws.Bool(true)
+		}
+		desired[setKey(set)] = set
+	}
+	return desired
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// controllerSource tags the ownership markers of the records kept by the
+// controller, which removes them itself once their instance is gone.
+const controllerSource = "controller"
+
+// instanceRecord is the record the controller keeps for an instance.
+type instanceRecord struct {
+	name       string
+	instanceID string
+	ip         string
+	tags       map[string]string
+}
+
+func runController(args []string) error {
+	var o options
+	fs := newFlagSet("controller")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "name of the weighted records of the instances, relative to the zone (required)")
+	asgName := fs.String("asg-name", "", "Auto Scaling group whose instances to register")
+	var tagFilters stringList
+	fs.Var(&tagFilters, "tag-filter", "register the instances carrying this tag, as Key=Value (may be repeated, instances must carry every one)")
+	address := fs.String("address", "private", "which IP of each instance to register: private or public")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records in seconds (default 60)")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of each instance's record")
+	interval := fs.Duration("interval", time.Minute, "how often to reconcile the records with the instances")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *hostname == "" || isApex(*hostname) {
+		return configError("The hostname parameter is required")
+	}
+	filters, err := instanceFilters(*asgName, tagFilters)
+	if err != nil {
+		return err
+	}
+	if *address != "private" && *address != "public" {
+		return configError("Unknown address " + *address + ", expected private or public")
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	return o.repeat("controller", fields{"hostname": *hostname}, *once, *interval, func(ctx context.Context) error {
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		name := o.recordName(*hostname)
+		if err := validateDNSName(name); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		instances, err := describeInstances(ctx, filters, *address == "public")
+		if err != nil {
+			return err
+		}
+		for i := range instances {
+			instances[i].name = name
+		}
+		return o.syncInstances(ctx, zoneID, instances, name, controllerSource, *dryRun)
+	})
+}
+
+// instanceFilters returns the DescribeInstances filters matching the running
+// members of an Auto Scaling group and the instances carrying tags.
+func instanceFilters(asgName string, tags []string) ([]*ec2.Filter, error) {
+	if asgName == "" && len(tags) == 0 {
+		return nil, configError("Either asg-name or tag-filter parameter is required")
+	}
+	filters := []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}}}
+	if asgName != "" {
+		// Auto Scaling tags its instances with the name of their group
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:aws:autoscaling:groupName"), Values: []*string{aws.String(asgName)}})
+	}
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, configError("Invalid tag-filter " + tag + ", expected Key=Value")
+		}
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + kv[0]), Values: []*string{aws.String(kv[1])}})
+	}
+	return filters, nil
+}
+
+// describeInstances returns the instances matching filters with their
+// private, or public, IP. Instances without one are left out.
+func describeInstances(ctx context.Context, filters []*ec2.Filter, public bool) ([]instanceRecord, error) {
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return nil, err
+	}
+	ec2Client, err := newEC2Client(ctx, metadataClient)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instanceRecord
+	err = ec2Client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{Filters: filters}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				ip := aws.StringValue(i.PrivateIpAddress)
+				if public {
+					ip = aws.StringValue(i.PublicIpAddress)
+				}
+				if ip == "" {
+					logger.Debug("Instance has no address, leaving it out", fields{"instance_id": aws.StringValue(i.InstanceId)})
+					continue
+				}
+				tags := map[string]string{}
+				for _, tag := range i.Tags {
+					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+				instances = append(instances, instanceRecord{instanceID: aws.StringValue(i.InstanceId), ip: ip, tags: tags})
+			}
+		}
+		return true
+	})
+	return instances, err
+}
+
+// syncInstances makes the weighted A records of source named name, or all of
+// them when name is empty, match the instances, one record identified by the
+// instance id for each of them.
+func (o *options) syncInstances(ctx context.Context, zoneID string, instances []instanceRecord, name, source string, dryRun bool) error {
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for _, i := range instances {
+		set := &route53.ResourceRecordSet{
+			Name:            aws.String(i.name),
+			Type:            aws.String(route53.RRTypeA),
+			ResourceRecords: resourceRecords([]string{i.ip}),
+			SetIdentifier:   aws.String(i.instanceID),
+			TTL:             aws.Int64(recordTTL(o.ttl, route53.RRTypeA)),
+			Weight:          aws.Int64(o.weight),
+		}
+		desired[setKey(set)] = set
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	var scope func(string) bool
+	if name != "" {
+		scope = func(n string) bool { return n == name }
+	}
+	changes := syncChanges(sets, desired, scope, source, false, time.Now())
+	return submitSyncChanges(ctx, r53, zoneID, "Instance Records Synced", sets, changes, len(desired), dryRun)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-ini/ini"
+)
+
+// credentialSource decides where the AWS clients get their credentials from.
+// Like the retry policy it is set up from the flags of the command.
+type credentialSource struct {
+	webIdentityTokenFile string
+	roleARN              string
+	roleSessionName      string
+	profile              string
+	// containerURL is the endpoint of the ECS agent, or of the EKS Pod
+	// Identity agent, serving the credentials of the task or pod
+	containerURL string
+}
+
+var awsCredentials credentialSource
+
+// configure sets the source up, falling back to the variables EKS sets for
+// IAM roles for service accounts and to AWS_PROFILE.
+func (c *credentialSource) configure(tokenFile, roleARN, sessionName, profile string) error {
+	*c = credentialSource{
+		webIdentityTokenFile: firstNonEmpty(tokenFile, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")),
+		roleARN:              firstNonEmpty(roleARN, os.Getenv("AWS_ROLE_ARN")),
+		roleSessionName:      firstNonEmpty(sessionName, os.Getenv("AWS_ROLE_SESSION_NAME"), "route53_register"),
+		profile:              firstNonEmpty(profile, os.Getenv("AWS_PROFILE")),
+	}
+	if (c.webIdentityTokenFile == "") != (c.roleARN == "") {
+		return errors.New("web-identity-token-file and role-arn must be given together")
+	}
+	var err error
+	c.containerURL, err = containerCredentialsURL(os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"))
+	return err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// chain returns the credentials of the AWS clients: the environment, then a
+// web identity token, then the SSO login or shared credentials of the
+// profile, then the ECS task or EKS pod role, or else the EC2 instance role.
+func (c credentialSource) chain() *credentials.Credentials {
+	chainProviders := []credentials.Provider{&credentials.EnvProvider{}}
+	if c.webIdentityTokenFile != "" {
+		chainProviders = append(chainProviders, &webIdentityProvider{
+			tokenFile:   c.webIdentityTokenFile,
+			roleARN:     c.roleARN,
+			sessionName: c.roleSessionName,
+		})
+	}
+	if p, err := loadSSOProfile(c.profile); err != nil {
+		logger.Warn("Error reading SSO settings of profile", errorFields(err, fields{"profile": c.profile}))
+	} else if p != nil {
+		chainProviders = append(chainProviders, p)
+	}
+	chainProviders = append(chainProviders, &credentials.SharedCredentialsProvider{Profile: c.profile})
+	if c.containerURL != "" {
+		chainProviders = append(chainProviders, &containerProvider{url: c.containerURL})
+	} else if metadataClient, err := newMetadataClient(); err != nil {
+		logger.Warn("Error creating the instance metadata client, going on without the instance role", errorFields(err, nil))
+	} else {
+		chainProviders = append(chainProviders, &ec2RoleProvider{client: metadataClient})
+	}
+	return credentials.NewCredentials(&credentials.ChainProvider{
+		// A configured token or profile that doesn't work should say why
+		// rather than end up as "no valid providers"
+		VerboseErrors: true,
+		Providers:     chainProviders,
+	})
+}
+
+// credentialExpiryWindow is how long before they expire temporary
+// credentials are refreshed.
+const credentialExpiryWindow = time.Minute
+
+// webIdentityProvider exchanges an OIDC token, like the one EKS mounts into
+// pods of a service account, for the credentials of a role.
+type webIdentityProvider struct {
+	credentials.Expiry
+	tokenFile   string
+	roleARN     string
+	sessionName string
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	// The token is rotated, read it again on every refresh
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	sess, err := session.NewSession(awsHTTP.config(awsEndpoints.config(retries.config(&aws.Config{
+		// The token authenticates the call
+		Credentials: credentials.AnonymousCredentials,
+	}))))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	cfg := &aws.Config{}
+	if aws.StringValue(sess.Config.Region) == "" {
+		cfg.Region = aws.String("us-east-1")
+	}
+	out, err := sts.New(sess, cfg).AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.SetExpiration(aws.TimeValue(out.Credentials.Expiration), credentialExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+		ProviderName:    "WebIdentityCredentials",
+	}, nil
+}
+
+// ecsCredentialsHost serves the credentials of ECS tasks, EC2 and Fargate
+// alike, at the path in AWS_CONTAINER_CREDENTIALS_RELATIVE_URI.
+const ecsCredentialsHost = "169.254.170.2"
+
+// containerCredentialsHosts are the hosts of the agents a full URI may point
+// at over plain HTTP, besides loopback addresses: the ECS agent and the EKS
+// Pod Identity agent.
+var containerCredentialsHosts = []string{ecsCredentialsHost, "169.254.170.23", "fd00:ec2::23"}
+
+// containerCredentialsURL returns the endpoint serving the credentials of
+// the task or pod we run in, empty when we don't run in one. Credentials
+// sent over plain HTTP must not leave the host, so a full URI with another
+// host needs HTTPS.
+func containerCredentialsURL(relative, full string) (string, error) {
+	if relative != "" {
+		return "http://" + ecsCredentialsHost + relative, nil
+	}
+	if full == "" {
+		return "", nil
+	}
+	u, err := url.Parse(full)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("Invalid AWS_CONTAINER_CREDENTIALS_FULL_URI %q", full)
+	}
+	if u.Scheme == "https" {
+		return full, nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); u.Scheme == "http" && (host == "localhost" || ip != nil && ip.IsLoopback() || containsString(containerCredentialsHosts, host)) {
+		return full, nil
+	}
+	return "", fmt.Errorf("AWS_CONTAINER_CREDENTIALS_FULL_URI %q must use HTTPS, or point at a loopback address or the ECS or EKS Pod Identity agent", full)
+}
+
+// containerProvider gets the credentials of the role of an ECS task or of an
+// EKS pod with a Pod Identity association from the agent serving them. The
+// agent is on the host or its link-local address, so the request never goes
+// through -proxy.
+type containerProvider struct {
+	credentials.Expiry
+	url string
+}
+
+var containerCredentialsClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+func (p *containerProvider) Retrieve() (credentials.Value, error) {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	// EKS Pod Identity rotates the token of the file, read it again on
+	// every refresh
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		token = string(b)
+	}
+	if token = strings.TrimSpace(token); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := containerCredentialsClient.Do(req)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Error getting container credentials from %s: %v", p.url, err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+		Code            string
+		Message         string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("Error reading container credentials from %s: %v", p.url, err)
+	}
+	if resp.StatusCode != http.StatusOK || out.AccessKeyID == "" {
+		return credentials.Value{}, fmt.Errorf("Error getting container credentials from %s: %s: %s %s", p.url, resp.Status, out.Code, out.Message)
+	}
+	p.SetExpiration(out.Expiration, credentialExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		ProviderName:    "ContainerCredentials",
+	}, nil
+}
+
+// ec2RoleCredentialsPath lists the role of the instance profile, and below
+// it serves the role's credentials.
+const ec2RoleCredentialsPath = "/meta-data/iam/security-credentials/"
+
+// ec2RoleProvider gets the credentials of the instance role. The SDK's own
+// provider reads them with IMDSv1 requests, which instances requiring IMDSv2
+// refuse, so they are read like the rest of the metadata, with its token.
+type ec2RoleProvider struct {
+	credentials.Expiry
+	client *ec2metadata.EC2Metadata
+}
+
+func (p *ec2RoleProvider) Retrieve() (credentials.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ec2RoleTimeout)
+	defer cancel()
+	roles, err := metadataRequest(ctx, p.client, ec2RoleCredentialsPath)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Error getting the instance role: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return credentials.Value{}, errors.New("The instance has no role")
+	}
+	content, err := metadataRequest(ctx, p.client, ec2RoleCredentialsPath+role)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Error getting the credentials of instance role %s: %v", role, err)
+	}
+	var out struct {
+		Code            string
+		Message         string
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(content), &out); err != nil {
+		return credentials.Value{}, fmt.Errorf("Error reading the credentials of instance role %s: %v", role, err)
+	}
+	if out.Code != "Success" {
+		return credentials.Value{}, fmt.Errorf("Error getting the credentials of instance role %s: %s %s", role, out.Code, out.Message)
+	}
+	p.SetExpiration(out.Expiration, credentialExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		ProviderName:    ec2rolecreds.ProviderName,
+	}, nil
+}
+
+// ec2RoleTimeout bounds reading the instance role's credentials, which the
+// clients do on their own without a context.
+const ec2RoleTimeout = 30 * time.Second
+
+// ssoProvider gets the credentials of a role through the token `aws sso
+// login` caches, which the tool never refreshes itself.
+type ssoProvider struct {
+	credentials.Expiry
+	startURL  string
+	region    string
+	accountID string
+	roleName  string
+	// cacheKey names the cached token, the sso-session of the profile or,
+	// for profiles without one, its start URL
+	cacheKey string
+}
+
+// loadSSOProfile reads the SSO settings of a profile from the AWS config
+// file, returning nil when it has none.
+func loadSSOProfile(profile string) (*ssoProvider, error) {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		path = filepath.Join(homeDir(), ".aws", "config")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	f, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	name := "default"
+	if profile != "" && profile != "default" {
+		name = "profile " + profile
+	}
+	s, err := f.GetSection(name)
+	if err != nil {
+		return nil, nil
+	}
+	p := &ssoProvider{
+		startURL:  s.Key("sso_start_url").String(),
+		region:    s.Key("sso_region").String(),
+		accountID: s.Key("sso_account_id").String(),
+		roleName:  s.Key("sso_role_name").String(),
+	}
+	p.cacheKey = p.startURL
+	if sessionName := s.Key("sso_session").String(); sessionName != "" {
+		ss, err := f.GetSection("sso-session " + sessionName)
+		if err != nil {
+			return nil, errors.New("sso-session " + sessionName + " is not defined")
+		}
+		p.startURL = ss.Key("sso_start_url").String()
+		p.region = ss.Key("sso_region").String()
+		p.cacheKey = sessionName
+	}
+	if p.accountID == "" && p.roleName == "" {
+		return nil, nil
+	}
+	if p.startURL == "" || p.region == "" || p.accountID == "" || p.roleName == "" {
+		return nil, errors.New("SSO profiles need sso_start_url, sso_region, sso_account_id and sso_role_name")
+	}
+	return p, nil
+}
+
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	return os.Getenv("USERPROFILE")
+}
+
+// ssoToken is the part of an `aws sso login` cache file we use.
+type ssoToken struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+func (p *ssoProvider) Retrieve() (credentials.Value, error) {
+	sum := sha1.Sum([]byte(p.cacheKey))
+	path := filepath.Join(homeDir(), ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("No cached SSO token, run aws sso login: %v", err)
+	}
+	var token ssoToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return credentials.Value{}, fmt.Errorf("Error reading cached SSO token %s: %v", path, err)
+	}
+	// Older versions of the AWS CLI wrote UTC instead of Z
+	expires, err := time.Parse(time.RFC3339, strings.Replace(token.ExpiresAt, "UTC", "Z", 1))
+	if err != nil || time.Now().After(expires) {
+		return credentials.Value{}, errors.New("The cached SSO token has expired, run aws sso login")
+	}
+
+	u := "https://portal.sso." + p.region + ".amazonaws.com/federation/credentials?" + url.Values{
+		"account_id": {p.accountID},
+		"role_name":  {p.roleName},
+	}.Encode()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", token.AccessToken)
+	resp, err := awsHTTP.client.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return credentials.Value{}, fmt.Errorf("Error getting SSO role credentials: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			// Expiration is in milliseconds since the epoch
+			Expiration int64 `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return credentials.Value{}, err
+	}
+	c := out.RoleCredentials
+	p.SetExpiration(time.Unix(0, c.Expiration*int64(time.Millisecond)), credentialExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		ProviderName:    "SSOCredentials",
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestEC2RoleProvider(t *testing.T) {
+	// Like an instance requiring IMDSv2, the service refuses requests
+	// without the token it handed out
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+			fmt.Fprint(w, "imds-token")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "web-role\n")
+		case "/latest/meta-data/iam/security-credentials/web-role":
+			fmt.Fprintf(w, `{"Code": "Success", "AccessKeyId": "AKID", "SecretAccessKey": "SECRET", "Token": "TOKEN", "Expiration": %q}`, expiration.Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	metadataTokens.forget()
+	defer metadataTokens.forget()
+
+	client := ec2metadata.New(session.Must(session.NewSession()), &aws.Config{Endpoint: aws.String(server.URL + "/latest")})
+	p := &ec2RoleProvider{client: client}
+	v, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "AKID" || v.SecretAccessKey != "SECRET" || v.SessionToken != "TOKEN" {
+		t.Errorf("Retrieve = %+v, want the credentials of web-role", v)
+	}
+	if p.IsExpired() {
+		t.Error("credentials expiring in an hour are expired")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+)
+
+// daemonState is what the health endpoints report about the reconciliation loop.
+type daemonState struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	started     time.Time
+	lastAttempt time.Time
+	lastErr     error
+	inSync      bool
+}
+
+func (s *daemonState) record(inSync bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAttempt = time.Now()
+	s.lastErr = err
+	s.inSync = inSync
+}
+
+// alive tells whether the loop is making progress, having finished a
+// reconciliation, or started, within the last 3 intervals.
+func (s *daemonState) alive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.lastAttempt
+	if last.IsZero() {
+		last = s.started
+	}
+	return time.Since(last) < 3*s.interval
+}
+
+// handler serves /healthz, failing when the last reconciliation failed
+// or the loop hasn't run for a while, and /readyz, failing when the record
+// didn't match this host at the last reconciliation. With debug it also
+// serves the profiling endpoints.
+func (s *daemonState) handler(debug bool) http.Handler {
+	mux := http.NewServeMux()
+	if debug {
+		handleDebug(mux, s.started)
+	}
+	reply := func(w http.ResponseWriter, ok bool, body map[string]interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		body["ok"] = ok
+		json.NewEncoder(w).Encode(body)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		body := map[string]interface{}{"last_attempt": s.lastAttempt, "version": version}
+		ok := s.lastErr == nil && time.Since(s.lastAttempt) < 3*s.interval
+		if s.lastErr != nil {
+			body["error"] = s.lastErr.Error()
+		}
+		reply(w, ok, body)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		reply(w, s.inSync, map[string]interface{}{"in_sync": s.inSync})
+	})
+	return mux
+}
+
+// runDaemon keeps this host's records registered until it's told to stop,
+// checking them every -interval and registering each one again when it
+// drifted or when its ownership marker is due for a refresh. With -config,
+// SIGHUP makes it read the file again, and changes to the -hostname-file and
+// -value-file of the registrations are picked up as they happen.
+func (o *options) runDaemon() error {
+	regs, err := o.validRegistrations()
+	if err != nil {
+		return err
+	}
+	if o.interval <= 0 {
+		return configError("The interval parameter must be positive")
+	}
+	if o.debugEndpoints && o.healthAddr == "" {
+		return configError("The debug-endpoints parameter needs -health-addr to serve them on")
+	}
+	if o.healthProbeURL != "" {
+		if err := o.validateHealthProbe(regs); err != nil {
+			return err
+		}
+	}
+	window, err := o.newRegistrationWindow()
+	if err != nil {
+		return err
+	}
+	outOfServiceAction := o.unhealthyAction
+	if window != nil {
+		if err := validateOutOfServiceAction("inactive-action", o.inactiveAction, regs); err != nil {
+			return err
+		}
+		if o.healthProbeURL != "" && o.inactiveAction != o.unhealthyAction {
+			return configError("The inactive-action and unhealthy-action parameters must be the same, both taking the records out of service")
+		}
+		outOfServiceAction = o.inactiveAction
+	}
+	// The -lock-file itself is only held for each pass
+	daemonLock, err := o.lockDaemon()
+	if err != nil {
+		return err
+	}
+	defer daemonLock.release()
+	logger.Info("Starting daemon", versionFields(fields{"interval": o.interval.String(), "registrations": len(regs)}))
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return err
+	}
+	state := &daemonState{interval: o.interval, started: time.Now()}
+	// Under systemd, the watchdog restarts a wedged daemon
+	defer notifyState("STOPPING=1")
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go runWatchdog(stopWatchdog, state.alive)
+	if o.healthAddr != "" {
+		server := &http.Server{Addr: o.healthAddr, Handler: state.handler(o.debugEndpoints)}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Health endpoint failed", errorFields(err, fields{"addr": o.healthAddr}))
+			}
+		}()
+		defer server.Close()
+	}
+
+	// Stopping cancels the reconciliation in progress, if any
+	running, stopRunning := untilStopped()
+	defer stopRunning()
+	if o.deregisterOnStop {
+		// regs as they are when the daemon stops, after any reload
+		defer func() {
+			if running.Err() != nil {
+				o.deregisterAtStop(regs)
+			}
+		}()
+	}
+	// Without a config file there is nothing to reload, and SIGHUP keeps
+	// stopping the daemon as it always did
+	reload := make(chan os.Signal, 1)
+	if o.configFile != "" {
+		signal.Notify(reload, syscall.SIGHUP)
+	}
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	// Without either file there is nothing to watch
+	files := newRecordFileWatch(regs)
+	var fileChecks <-chan time.Time
+	if o.configFile != "" || o.hostnameFile != "" || o.valueFile != "" {
+		fileTicker := time.NewTicker(recordFilePollInterval)
+		defer fileTicker.Stop()
+		fileChecks = fileTicker.C
+	}
+	var health *localHealth
+	var probes <-chan time.Time
+	if o.healthProbeURL != "" {
+		health = o.newLocalHealth()
+		probeTicker := time.NewTicker(o.healthProbeInterval)
+		defer probeTicker.Stop()
+		probes = probeTicker.C
+	}
+	// outOfService is whether the records were taken out of service, as
+	// they are while the service is unhealthy or outside the window
+	outOfService := false
+	updateService := func() {
+		out := (health != nil && health.unhealthy) || (window != nil && !window.check(time.Now()))
+		if out == outOfService {
+			return
+		}
+		lock, err := o.lockHost(running, true)
+		if err != nil {
+			logger.Error("Changing records failed, trying again later", errorFields(err, nil))
+			return
+		}
+		defer lock.release()
+		if o.setInService(running, regs, outOfServiceAction, !out) {
+			outOfService = out
+		}
+	}
+
+	lastRegistered := make([]time.Time, len(regs))
+	ready := false
+	for {
+		traced, root := tracing.StartTrace(running, "reconcile", nil)
+		ctx, cancel := o.withTimeout(traced)
+		var err error
+		updateService()
+		// Registering would put the records back into service
+		allInSync := !outOfService
+		if allInSync {
+			allInSync, err = o.reconcileAll(ctx, running, metadataClient, regs, lastRegistered)
+		}
+		cancel()
+		root.End(err)
+		if ferr := tracing.Flush(root); ferr != nil {
+			logger.Warn("Error exporting traces", errorFields(ferr, nil))
+		}
+		if running.Err() != nil {
+			return nil
+		}
+		state.record(allInSync, err)
+		if !ready && allInSync && err == nil {
+			// Units ordered after ours start once the records are in place
+			notifyState("READY=1")
+			ready = true
+		}
+		if err != nil {
+			notifyState("STATUS=Reconciliation failed: " + err.Error())
+		} else if allInSync {
+			notifyState("STATUS=Records in sync")
+		}
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case <-probes:
+				health.probe(running)
+				updateService()
+			case <-reload:
+				if reloaded, err := o.reload(running, metadataClient, regs); err != nil {
+					logger.Error("Reloading config failed, keeping the current one", errorFields(err, fields{"config": o.configFile}))
+				} else {
+					// Registering every record again brings changed ones up to date
+					regs, lastRegistered = reloaded, make([]time.Time, len(reloaded))
+					files = newRecordFileWatch(reloaded)
+				}
+				break wait
+			case <-fileChecks:
+				changed := files.changed()
+				if len(changed) == 0 {
+					continue
+				}
+				f := fields{"files": strings.Join(changed, ",")}
+				logger.Info("Record files changed, reloading", f)
+				reloaded, err := o.reload(running, metadataClient, regs)
+				if err != nil {
+					logger.Error("Reloading record files failed, keeping the current records", errorFields(err, f))
+					continue
+				}
+				regs, lastRegistered = reloaded, make([]time.Time, len(reloaded))
+				files = newRecordFileWatch(reloaded)
+				break wait
+			case <-running.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// reconcileAll reconciles the records of regs, holding the -lock-file only
+// for the pass so that drain, undrain and deregister can run in between. It
+// reports whether all of them match the host, and the last error.
+func (o *options) reconcileAll(ctx, running context.Context, metadataClient *ec2metadata.EC2Metadata, regs []*options, lastRegistered []time.Time) (bool, error) {
+	lock, err := o.lockHost(ctx, true)
+	if err != nil {
+		if running.Err() == nil {
+			logger.Error("Reconciliation failed", errorFields(err, nil))
+		}
+		return false, err
+	}
+	defer lock.release()
+	allInSync := true
+	for i, r := range regs {
+		inSync, registered, rerr := r.reconcile(ctx, metadataClient, time.Since(lastRegistered[i]) >= o.refresh)
+		if registered {
+			lastRegistered[i] = time.Now()
+		}
+		allInSync = allInSync && inSync
+		if rerr != nil && running.Err() == nil {
+			logger.Error("Reconciliation failed", errorFields(rerr, fields{"hostname": r.hostnames.String(), "zone_name": r.zoneName}))
+			err = rerr
+		}
+	}
+	return allInSync, err
+}
+
+// reconcile registers this host's records if any of them doesn't match the
+// host or force is set, keeping drained records drained. It reports whether
+// the records match the host afterwards and whether they were registered.
+func (o *options) reconcile(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, force bool) (bool, bool, error) {
+	if !force {
+		r53, err := o.account.route53Client(o.logLevel())
+		if err != nil {
+			return false, false, err
+		}
+		ts, err := o.resolveTargets(ctx, metadataClient)
+		if err != nil {
+			return false, false, err
+		}
+		drifted := false
+		for _, t := range ts {
+			sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+			if err != nil {
+				return false, false, err
+			}
+			if err := t.loadDrained(ctx, r53); err != nil {
+				return false, false, err
+			}
+			if drift := t.drift(sets); len(drift) > 0 {
+				f := t.fields()
+				f["drift"] = diffStrings(drift)
+				logger.Warn("Record drifted, registering again", f)
+				o.notifySlack(ctx, levelWarn, fmt.Sprintf("Record %s %s drifted (%s), registering it again", t.name, t.rrType, strings.Join(diffStrings(drift), "; ")))
+				drifted = true
+			}
+		}
+		if !drifted {
+			return true, false, nil
+		}
+	}
+	if err := o.changeHostRecord(ctx, "register", registerKeepingDrain); err != nil {
+		return false, false, err
+	}
+	return true, true, nil
+}
+
+// stopRequests carries the requests to stop that don't come as signals,
+// like those of the Windows service control manager.
+var stopRequests = make(chan string, 1)
+
+// untilStopped returns a context that is cancelled on SIGINT or SIGTERM, or
+// on a stop request.
+func untilStopped() (context.Context, context.CancelFunc) {
+	running, stopRunning := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-stop:
+			logger.Info("Stopping", fields{"signal": sig.String()})
+		case reason := <-stopRequests:
+			logger.Info("Stopping", fields{"reason": reason})
+		case <-running.Done():
+			return
+		}
+		stopRunning()
+	}()
+	return running, stopRunning
+}
+
+// validRegistrations returns the registrations of o, failing when any of
+// them is invalid.
+func (o *options) validRegistrations() ([]*options, error) {
+	regs, err := o.registrations()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range regs {
+		if err := r.validateRecord(); err != nil {
+			return nil, err
+		}
+	}
+	return regs, nil
+}
+
+// reload reads the config file again and deregisters the records the
+// daemon maintained that it no longer declares, returning the new
+// registrations.
+func (o *options) reload(running context.Context, metadataClient *ec2metadata.EC2Metadata, regs []*options) (reloaded []*options, err error) {
+	running, root := tracing.StartTrace(running, "reload", fields{"config": o.configFile})
+	defer func() {
+		root.End(err)
+		if ferr := tracing.Flush(root); ferr != nil {
+			logger.Warn("Error exporting traces", errorFields(ferr, nil))
+		}
+	}()
+	// Parameters named in the config may have changed as well
+	parameters.forget()
+	reloaded, err = o.validRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := o.withTimeout(running)
+	defer cancel()
+	if dropped := droppedRegistrations(regs, reloaded); len(dropped) > 0 {
+		err = o.withHostLock(ctx, func() error {
+			for _, d := range dropped {
+				if derr := d.changeHostRecord(ctx, "deregister", deregisterTargets); derr != nil {
+					logger.Error("Deregistering dropped record failed", errorFields(derr, fields{"hostname": d.hostnames.String(), "zone_name": d.zoneName}))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	f := fields{"registrations": len(reloaded)}
+	if o.configFile != "" {
+		f["config"] = o.configFile
+	}
+	logger.Info("Reloaded config", f)
+	return reloaded, nil
+}
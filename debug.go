@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// handleDebug adds net/http/pprof under /debug/pprof/ and a summary of the
+// runtime under /debug/stats to mux, for chasing memory and goroutine leaks
+// of a process that has been running for a while.
+func handleDebug(mux *http.ServeMux, started time.Time) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"version":         version,
+			"go_version":      runtime.Version(),
+			"uptime_seconds":  int64(time.Since(started).Seconds()),
+			"goroutines":      runtime.NumGoroutine(),
+			"heap_alloc":      m.HeapAlloc,
+			"heap_inuse":      m.HeapInuse,
+			"heap_objects":    m.HeapObjects,
+			"sys":             m.Sys,
+			"total_alloc":     m.TotalAlloc,
+			"num_gc":          m.NumGC,
+			"gc_pause_total":  time.Duration(m.PauseTotalNs).String(),
+			"last_gc":         time.Unix(0, int64(m.LastGC)),
+			"gc_cpu_fraction": m.GCCPUFraction,
+		})
+	})
+}
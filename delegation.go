@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// checkDelegation checks that the zone is delegated to the name servers of
+// the hosted zone zoneID, returning them. The resolver follows the
+// delegation of the parent zone, so it answers with the name servers of
+// whichever zone the parent delegates to. Private zones aren't delegated, for
+// them it returns no name servers.
+func checkDelegation(ctx context.Context, r53 *route53.Route53, zoneID, zone string) ([]string, error) {
+	out, err := r53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(normalizeZoneID(zoneID))})
+	if err != nil {
+		return nil, err
+	}
+	if out.HostedZone.Config != nil && aws.BoolValue(out.HostedZone.Config.PrivateZone) || out.DelegationSet == nil {
+		return nil, nil
+	}
+	want := normalizeNames(aws.StringValueSlice(out.DelegationSet.NameServers))
+	records, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return want, fmt.Errorf("%s isn't delegated, its parent zone needs NS records for %s", zone, strings.Join(want, ", "))
+	}
+	if err != nil {
+		return want, err
+	}
+	var hosts []string
+	for _, ns := range records {
+		hosts = append(hosts, ns.Host)
+	}
+	got := normalizeNames(hosts)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		return want, fmt.Errorf("%s is delegated to %s instead of the name servers of hosted zone %s, %s", zone, strings.Join(got, ", "), zoneID, strings.Join(want, ", "))
+	}
+	return want, nil
+}
+
+// normalizeNames returns names normalized and sorted.
+func normalizeNames(names []string) []string {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = normalizeName(name)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// findParentZone returns the closest public hosted zone above zone, the one
+// that delegates it.
+func findParentZone(ctx context.Context, r53 *route53.Route53, zone string) (string, string, error) {
+	labels := strings.Split(normalizeName(zone), ".")
+	for i := 1; i < len(labels); i++ {
+		name := strings.Join(labels[i:], ".")
+		out, err := r53.ListHostedZonesByNameWithContext(ctx, &route53.ListHostedZonesByNameInput{DNSName: aws.String(name)})
+		if err != nil {
+			return "", "", err
+		}
+		// A private zone of the same name may be listed first
+		for _, z := range out.HostedZones {
+			if !sameRecordName(aws.StringValue(z.Name), name) {
+				break
+			}
+			if z.Config == nil || !aws.BoolValue(z.Config.PrivateZone) {
+				return aws.StringValue(z.Id), name, nil
+			}
+		}
+	}
+	return "", "", &exitError{exitZoneNotFound, errors.New("No public hosted zone above " + zone + " to delegate it from")}
+}
+
+// delegateZone creates or updates the NS records of the parent zone
+// delegating the public hosted zone zoneID to its name servers, or removes
+// them. The parent is parentZoneID, or else the closest public zone above.
+// A delegation to other name servers, e.g. those of a zone of the same name
+// that was deleted since, is replaced, but only removed when it's ours.
+func delegateZone(ctx context.Context, r53 *route53.Route53, zoneID, parentZoneID string, remove bool) error {
+	out, err := r53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(normalizeZoneID(zoneID))})
+	if err != nil {
+		return err
+	}
+	if out.HostedZone.Config != nil && aws.BoolValue(out.HostedZone.Config.PrivateZone) || out.DelegationSet == nil {
+		return configError("Hosted zone " + normalizeZoneID(zoneID) + " is private, only public zones are delegated")
+	}
+	zone := normalizeName(aws.StringValue(out.HostedZone.Name))
+	var parent string
+	if parentZoneID == "" {
+		if parentZoneID, parent, err = findParentZone(ctx, r53, zone); err != nil {
+			return err
+		}
+	} else {
+		// The parent is looked up with r53, it may be in the account of a
+		// registration's role_arn
+		p, err := r53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(normalizeZoneID(parentZoneID))})
+		if err != nil {
+			return err
+		}
+		parent = normalizeName(aws.StringValue(p.HostedZone.Name))
+		if parent == zone || !inZone(zone, parent) {
+			return configError("Zone " + zone + " is not below the parent zone " + parent)
+		}
+	}
+	f := fields{"zone_id": zoneID, "zone_name": zone, "parent_zone_id": parentZoneID, "parent_zone_name": parent}
+
+	sets, err := findRecordSets(ctx, r53, parentZoneID, zone, route53.RRTypeNs)
+	if err != nil {
+		return err
+	}
+	current := findPlainSet(sets)
+	want := normalizeNames(aws.StringValueSlice(out.DelegationSet.NameServers))
+	got := normalizeNames(recordValues(current))
+	ours := strings.Join(got, ",") == strings.Join(want, ",")
+	if current != nil {
+		f["old_name_servers"] = strings.Join(got, ",")
+	}
+	var changes []*route53.Change
+	switch {
+	case remove && current == nil:
+		logger.Info("Zone isn't delegated, nothing to remove", f)
+		return nil
+	case remove && !ours:
+		logger.Warn("Zone is delegated to other name servers, leaving the delegation alone", f)
+		return nil
+	case remove:
+		changes = replaceRecordSet(current, zone, route53.RRTypeNs, 0, nil)
+	case ours:
+		logger.Debug("Zone already delegated", f)
+		return nil
+	default:
+		if current != nil {
+			logger.Warn("Zone is delegated to other name servers, replacing the delegation", f)
+		}
+		var values []string
+		for _, ns := range want {
+			values = append(values, ns+".")
+		}
+		changes = replaceRecordSet(current, zone, route53.RRTypeNs, defaultTTL(route53.RRTypeNs), values)
+	}
+	comment := "Zone Delegated"
+	if remove {
+		comment = "Zone Delegation Removed"
+	}
+	info, err := submitChanges(ctx, r53, parentZoneID, comment, changes)
+	if err != nil {
+		return err
+	}
+	f["name_servers"] = strings.Join(want, ",")
+	f["change_id"] = aws.StringValue(info.Id)
+	if remove {
+		logger.Info("Removed zone delegation", f)
+	} else {
+		logger.Info("Delegated zone", f)
+	}
+	return nil
+}
+
+// runDelegate creates the NS records delegating a zone in its parent zone,
+// or removes them with -remove, e.g. when tearing down an environment.
+func runDelegate(args []string) error {
+	var o options
+	fs := newFlagSet("delegate")
+	o.addZoneFlags(fs)
+	fs.StringVar(&o.parentZoneID, "parent-zone-id", "", "hosted zone id of the parent zone the NS records are kept in (default the closest public zone above the zone)")
+	remove := fs.Bool("remove", false, "remove the delegation instead, when it points at the zone's name servers")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	return delegateZone(ctx, r53, zoneID, o.parentZoneID, *remove)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// noValue stands for a field a record set doesn't have, e.g. the weight of
+// a plain record.
+const noValue = "(none)"
+
+// fieldDiff is a field of a record set that differs between the live set
+// and the one that would replace it.
+type fieldDiff struct {
+	field, old, new string
+}
+
+func (d fieldDiff) String() string {
+	return d.field + ": " + d.old + " → " + d.new
+}
+
+func diffStrings(diffs []fieldDiff) []string {
+	s := make([]string, len(diffs))
+	for i, d := range diffs {
+		s[i] = d.String()
+	}
+	return s
+}
+
+// diffRecordSets returns the fields of old that new changes: values or
+// alias target, TTL, weight and health check. Either may be nil, for a set
+// being created or deleted, whose fields then all differ.
+func diffRecordSets(old, new *route53.ResourceRecordSet) []fieldDiff {
+	o, n := recordSetFields(old), recordSetFields(new)
+	var diffs []fieldDiff
+	for _, field := range []string{"alias", "values", "ttl", "weight", "health_check"} {
+		if o[field] == n[field] {
+			continue
+		}
+		if field == "values" && old != nil && new != nil && sameValues(old, new) {
+			continue
+		}
+		diffs = append(diffs, fieldDiff{field, firstNonEmpty(o[field], noValue), firstNonEmpty(n[field], noValue)})
+	}
+	return diffs
+}
+
+func recordSetFields(set *route53.ResourceRecordSet) map[string]string {
+	f := map[string]string{}
+	if set == nil {
+		return f
+	}
+	if a := set.AliasTarget; a != nil {
+		f["alias"] = strings.TrimSuffix(aws.StringValue(a.DNSName), ".") + " (zone " + aws.StringValue(a.HostedZoneId) + ")"
+	}
+	values := recordValues(set)
+	sort.Strings(values)
+	f["values"] = strings.Join(values, ",")
+	if set.TTL != nil {
+		f["ttl"] = strconv.FormatInt(aws.Int64Value(set.TTL), 10)
+	}
+	if set.Weight != nil {
+		f["weight"] = strconv.FormatInt(aws.Int64Value(set.Weight), 10)
+	}
+	f["health_check"] = aws.StringValue(set.HealthCheckId)
+	return f
+}
+
+// sameValues reports whether two sets hold the same values, TXT values
+// split differently being the same.
+func sameValues(a, b *route53.ResourceRecordSet) bool {
+	x, y := comparableValues(a), comparableValues(b)
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, "\n") == strings.Join(y, "\n")
+}
+
+// diffPrinter prints changes of record sets for a reviewer, in color when
+// writing to a terminal unless $NO_COLOR is set.
+type diffPrinter struct {
+	w     io.Writer
+	color bool
+}
+
+func newDiffPrinter(f *os.File) *diffPrinter {
+	return &diffPrinter{w: f, color: isTerminal(f) && os.Getenv("NO_COLOR") == ""}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *diffPrinter) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// change prints what c does to the live set, nil when there is none:
+//
+//	~ web.myzone.internal. A web-1
+//	    values: 10.0.3.7 → 10.0.3.9
+//	    ttl: 60 → 300
+func (p *diffPrinter) change(c *route53.Change, live *route53.ResourceRecordSet) {
+	set := c.ResourceRecordSet
+	switch aws.StringValue(c.Action) {
+	case route53.ChangeActionDelete:
+		p.header(colorRed, "-", set)
+		p.fields(diffRecordSets(set, nil), false)
+	case route53.ChangeActionCreate:
+		p.header(colorGreen, "+", set)
+		p.fields(diffRecordSets(nil, set), true)
+	default:
+		if live == nil {
+			p.header(colorGreen, "+", set)
+			p.fields(diffRecordSets(nil, set), true)
+			return
+		}
+		p.header(colorYellow, "~", set)
+		p.diffs(diffRecordSets(live, set))
+	}
+}
+
+func (p *diffPrinter) header(color, sign string, set *route53.ResourceRecordSet) {
+	line := sign + " " + aws.StringValue(set.Name) + " " + aws.StringValue(set.Type)
+	if set.SetIdentifier != nil {
+		line += " " + aws.StringValue(set.SetIdentifier)
+	}
+	fmt.Fprintln(p.w, p.paint(color, line))
+}
+
+// fields prints the fields of a set that is created, or deleted, alone.
+func (p *diffPrinter) fields(diffs []fieldDiff, created bool) {
+	for _, d := range diffs {
+		if created {
+			fmt.Fprintf(p.w, "    %s: %s\n", d.field, p.paint(colorGreen, d.new))
+		} else {
+			fmt.Fprintf(p.w, "    %s: %s\n", d.field, p.paint(colorRed, d.old))
+		}
+	}
+}
+
+// diffs prints each changed field with its old and new value.
+func (p *diffPrinter) diffs(diffs []fieldDiff) {
+	for _, d := range diffs {
+		fmt.Fprintf(p.w, "    %s: %s → %s\n", d.field, p.paint(colorRed, d.old), p.paint(colorGreen, d.new))
+	}
+}
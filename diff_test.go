@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestDiffRecordSets(t *testing.T) {
+	withTTL := func(set *route53.ResourceRecordSet, ttl int64) *route53.ResourceRecordSet {
+		set.TTL = aws.Int64(ttl)
+		return set
+	}
+	withHealthCheck := func(set *route53.ResourceRecordSet, id string) *route53.ResourceRecordSet {
+		set.HealthCheckId = aws.String(id)
+		return set
+	}
+	alias := &route53.ResourceRecordSet{
+		Name:        aws.String("web.example.com."),
+		Type:        aws.String("A"),
+		AliasTarget: &route53.AliasTarget{DNSName: aws.String("lb.example.com."), HostedZoneId: aws.String("Z1")},
+	}
+	tests := []struct {
+		name     string
+		old, new *route53.ResourceRecordSet
+		want     []string
+	}{
+		{
+			name: "same",
+			old:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.1", "10.0.0.2"),
+			new:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.2", "10.0.0.1"),
+		},
+		{
+			name: "values",
+			old:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.1"),
+			new:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.2"),
+			want: []string{"values: 10.0.0.1 → 10.0.0.2"},
+		},
+		{
+			name: "ttl and weight",
+			old:  testRecordSet("web.example.com", "A", "web-1", 10, "10.0.0.1"),
+			new:  withTTL(testRecordSet("web.example.com", "A", "web-1", 20, "10.0.0.1"), 300),
+			want: []string{"ttl: 60 → 300", "weight: 10 → 20"},
+		},
+		{
+			name: "health check",
+			old:  testRecordSet("web.example.com", "A", "web-1", 10, "10.0.0.1"),
+			new:  withHealthCheck(testRecordSet("web.example.com", "A", "web-1", 10, "10.0.0.1"), "hc-1"),
+			want: []string{"health_check: (none) → hc-1"},
+		},
+		{
+			name: "alias",
+			old:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.1"),
+			new:  alias,
+			want: []string{"alias: (none) → lb.example.com (zone Z1)", "values: 10.0.0.1 → (none)", "ttl: 60 → (none)"},
+		},
+		{
+			name: "TXT split differently",
+			old:  testRecordSet("web.example.com", "TXT", "", 0, `"abc" "def"`),
+			new:  testRecordSet("web.example.com", "TXT", "", 0, `"abcdef"`),
+		},
+		{
+			name: "created",
+			new:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.1"),
+			want: []string{"values: (none) → 10.0.0.1", "ttl: (none) → 60"},
+		},
+		{
+			name: "deleted",
+			old:  testRecordSet("web.example.com", "A", "", 0, "10.0.0.1"),
+			want: []string{"values: 10.0.0.1 → (none)", "ttl: 60 → (none)"},
+		},
+	}
+	for _, tt := range tests {
+		if got := diffStrings(diffRecordSets(tt.old, tt.new)); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: diffRecordSets = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffPrinterChange(t *testing.T) {
+	live := testRecordSet("web.example.com", "A", "web-1", 10, "10.0.0.1")
+	tests := []struct {
+		name   string
+		action string
+		set    *route53.ResourceRecordSet
+		live   *route53.ResourceRecordSet
+		want   string
+	}{
+		{
+			name:   "update",
+			action: route53.ChangeActionUpsert,
+			set:    testRecordSet("web.example.com", "A", "web-1", 10, "10.0.0.2"),
+			live:   live,
+			want:   "~ web.example.com. A web-1\n    values: 10.0.0.1 → 10.0.0.2\n",
+		},
+		{
+			name:   "upsert without a live set",
+			action: route53.ChangeActionUpsert,
+			set:    testRecordSet("web.example.com", "A", "", 0, "10.0.0.2"),
+			want:   "+ web.example.com. A\n    values: 10.0.0.2\n    ttl: 60\n",
+		},
+		{
+			name:   "delete",
+			action: route53.ChangeActionDelete,
+			set:    live,
+			live:   live,
+			want:   "- web.example.com. A web-1\n    values: 10.0.0.1\n    ttl: 60\n    weight: 10\n",
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		(&diffPrinter{w: &buf}).change(&route53.Change{Action: aws.String(tt.action), ResourceRecordSet: tt.set}, tt.live)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: change printed\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
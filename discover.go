@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// discoverSource tags the ownership markers of the records kept by discover,
+// which removes them itself once no instance is tagged with them anymore.
+const discoverSource = "tags"
+
+func runDiscover(args []string) error {
+	var o options
+	fs := newFlagSet("discover")
+	o.addCommonFlags(fs)
+	nameTag := fs.String("name-tag", "dns:name", "tag holding the names to register an instance under, relative to its zone and separated by commas")
+	zoneTag := fs.String("zone-tag", "dns:zone", "tag holding the name of the zone to register an instance in")
+	zones := fs.String("zones", "", "zones to remove records from even when no instance is tagged with them anymore, separated by commas")
+	address := fs.String("address", "private", "which IP of each instance to register: private or public")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records in seconds (default 60)")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of each instance's record")
+	interval := fs.Duration("interval", time.Minute, "how often to reconcile the records with the instances")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if *nameTag == "" || *zoneTag == "" {
+		return configError("The name-tag and zone-tag parameters can't be empty")
+	}
+	if *address != "private" && *address != "public" {
+		return configError("Unknown address " + *address + ", expected private or public")
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	// Zones once tagged are kept in, so their records are removed when the
+	// last instance tagged with them goes away
+	known := map[string]bool{}
+	for _, zone := range strings.Split(*zones, ",") {
+		if zone = normalizeName(strings.TrimSpace(zone)); zone != "" {
+			known[zone] = true
+		}
+	}
+	filters := []*ec2.Filter{
+		{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}},
+		{Name: aws.String("tag-key"), Values: []*string{aws.String(*nameTag)}},
+	}
+	return o.repeat("discover", fields{"name_tag": *nameTag, "zone_tag": *zoneTag}, *once, *interval, func(ctx context.Context) error {
+		instances, err := describeInstances(ctx, filters, *address == "public")
+		if err != nil {
+			return err
+		}
+		byZone := taggedRecords(instances, *nameTag, *zoneTag)
+		for zone := range byZone {
+			known[zone] = true
+		}
+		return o.syncTaggedZones(ctx, known, byZone, *dryRun)
+	})
+}
+
+// taggedRecords returns the records the instances are tagged with, by zone.
+// Instances with invalid tags are left out rather than failing the others.
+func taggedRecords(instances []instanceRecord, nameTag, zoneTag string) map[string][]instanceRecord {
+	byZone := map[string][]instanceRecord{}
+	for _, i := range instances {
+		zone := normalizeName(i.tags[zoneTag])
+		if zone == "" {
+			logger.Warn("Instance has no "+zoneTag+" tag, leaving it out", fields{"instance_id": i.instanceID})
+			continue
+		}
+		for _, hostname := range strings.Split(i.tags[nameTag], ",") {
+			hostname = strings.TrimSpace(hostname)
+			name := qualifyName(hostname, zone)
+			f := fields{"instance_id": i.instanceID, "hostname": hostname}
+			if hostname == "" || !inZone(name, zone) {
+				logger.Warn("Instance is tagged with a name that isn't in its zone, leaving it out", f)
+				continue
+			}
+			if err := validateDNSName(name); err != nil {
+				logger.Warn("Instance is tagged with an invalid name, leaving it out", errorFields(err, f))
+				continue
+			}
+			r := i
+			r.name = name
+			byZone[zone] = append(byZone[zone], r)
+		}
+	}
+	return byZone
+}
+
+// syncTaggedZones brings the records of every zone in line with the
+// instances tagged with it, carrying on past zones that fail.
+func (o *options) syncTaggedZones(ctx context.Context, zones map[string]bool, byZone map[string][]instanceRecord, dryRun bool) error {
+	var names []string
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	failed, code := 0, 0
+	for _, zone := range names {
+		err := o.syncTaggedZone(ctx, zone, byZone[zone], dryRun)
+		if err != nil {
+			if failed == 0 {
+				code = exitCode(err)
+			}
+			failed++
+			logger.Error("Discovery failed", errorFields(err, fields{"zone_name": zone}))
+		}
+	}
+	if failed > 0 {
+		return withExitCode(code, fmt.Errorf("%d of %d zones failed", failed, len(names)))
+	}
+	return nil
+}
+
+func (o *options) syncTaggedZone(ctx context.Context, zone string, instances []instanceRecord, dryRun bool) error {
+	zoneID, err := getDNSHostedZoneID(ctx, o.account, zone)
+	if err != nil {
+		return err
+	}
+	return o.syncInstances(ctx, zoneID, instances, "", discoverSource, dryRun)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal DNS client, enough to ask a given server about a record and read
+// back the answer. net.Resolver hides which server answered and what the
+// record itself holds, which is exactly what verification is after.
+
+const (
+	dnsTypeA     = 1
+	dnsTypeNS    = 2
+	dnsTypeCNAME = 5
+	dnsTypeMX    = 15
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+)
+
+// dnsTypes maps the record types we can ask for to their codes.
+var dnsTypes = map[string]uint16{
+	"A":     dnsTypeA,
+	"NS":    dnsTypeNS,
+	"CNAME": dnsTypeCNAME,
+	"MX":    dnsTypeMX,
+	"TXT":   dnsTypeTXT,
+	"AAAA":  dnsTypeAAAA,
+}
+
+// dnsTimeout bounds a single query when ctx has no earlier deadline.
+const dnsTimeout = 5 * time.Second
+
+var errDNSTruncated = errors.New("truncated DNS response")
+
+// dnsQuery asks server (host or host:port) for the records of the given type
+// under name and returns their values formatted the way Route53 shows them.
+// A name that doesn't exist yields no values rather than an error.
+func dnsQuery(ctx context.Context, server, name string, qtype uint16) ([]string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query, err := dnsPackQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := dnsExchange(ctx, "udp", server, id, query, qtype)
+	if err == errDNSTruncated {
+		answers, err = dnsExchange(ctx, "tcp", server, id, query, qtype)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error querying %s for %s: %v", server, name, err)
+	}
+	return answers, nil
+}
+
+func dnsExchange(ctx context.Context, network, server string, id uint16, query []byte, qtype uint16) ([]string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(dnsTimeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	var resp []byte
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err = conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err = io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err = io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, 65535)
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+		resp = resp[:n]
+	}
+	return dnsParseAnswers(resp, id, qtype)
+}
+
+func dnsPackQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	// Recursion desired, so recursive resolvers look the name up; name
+	// servers of the zone answer from it either way
+	binary.BigEndian.PutUint16(msg[2:], 1<<8)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("Invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	return msg, nil
+}
+
+func dnsParseAnswers(msg []byte, id uint16, qtype uint16) ([]string, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, errors.New("malformed DNS response")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&(1<<9) != 0 {
+		return nil, errDNSTruncated
+	}
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case 3:
+		// NXDOMAIN: the record doesn't exist (yet)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("DNS response code %d", rcode)
+	}
+	qdcount, ancount := int(binary.BigEndian.Uint16(msg[4:])), int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if _, off, err = dnsReadName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	var values []string
+	for i := 0; i < ancount; i++ {
+		if _, off, err = dnsReadName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errors.New("malformed DNS answer")
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, errors.New("malformed DNS answer")
+		}
+		// Answers may lead to the record through CNAMEs, those of other
+		// types are skipped
+		if rrType == qtype {
+			v, err := dnsFormatData(msg, off, length, rrType)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		off += length
+	}
+	return values, nil
+}
+
+// dnsReadName reads a possibly compressed name at off, returning it with a
+// trailing dot along with the offset following it.
+func dnsReadName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("malformed DNS name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 32 {
+				return "", 0, errors.New("malformed DNS name")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("malformed DNS name")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func dnsFormatData(msg []byte, off, length int, rrType uint16) (string, error) {
+	data := msg[off : off+length]
+	switch rrType {
+	case dnsTypeA, dnsTypeAAAA:
+		return net.IP(data).String(), nil
+	case dnsTypeCNAME, dnsTypeNS:
+		name, _, err := dnsReadName(msg, off)
+		return name, err
+	case dnsTypeMX:
+		if length < 3 {
+			return "", errors.New("malformed MX record")
+		}
+		name, _, err := dnsReadName(msg, off+2)
+		return strconv.Itoa(int(binary.BigEndian.Uint16(data))) + " " + name, err
+	case dnsTypeTXT:
+		var parts []string
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return "", errors.New("malformed TXT record")
+			}
+			parts = append(parts, strconv.Quote(string(data[i+1:i+1+n])))
+			i += 1 + n
+		}
+		return strings.Join(parts, " "), nil
+	}
+	return fmt.Sprintf("%x", data), nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// The DNSRecord custom resource, see the README for its definition.
+const (
+	dnsRecordAPI    = "/apis/route53register.reflog.github.io/v1alpha1"
+	dnsRecordSource = "crd"
+)
+
+type dnsRecord struct {
+	Metadata kubeMeta        `json:"metadata"`
+	Spec     dnsRecordSpec   `json:"spec"`
+	Status   dnsRecordStatus `json:"status"`
+}
+
+type dnsRecordSpec struct {
+	// Name is relative to the zone, like the names of sync files
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	TTL           *int64   `json:"ttl"`
+	Values        []string `json:"values"`
+	RoutingPolicy string   `json:"routingPolicy"`
+	SetIdentifier string   `json:"setIdentifier"`
+	Weight        *int64   `json:"weight"`
+}
+
+type dnsRecordStatus struct {
+	ObservedGeneration int64           `json:"observedGeneration,omitempty"`
+	RecordName         string          `json:"recordName"`
+	Conditions         []kubeCondition `json:"conditions"`
+}
+
+type kubeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+}
+
+// The reasons of the Ready condition of DNSRecords.
+const (
+	reasonSynced     = "Synced"
+	reasonInvalid    = "Invalid"
+	reasonConflict   = "Conflict"
+	reasonSyncFailed = "SyncFailed"
+)
+
+func runDNSRecords(args []string) error {
+	var o options
+	fs := newFlagSet("dnsrecords")
+	o.addZoneFlags(fs)
+	apiURL := fs.String("kube-api", "", "URL of the Kubernetes API server, e.g. http://127.0.0.1:8001 for kubectl proxy (default: the cluster this runs in)")
+	namespace := fs.String("namespace", "", "only reconcile the DNSRecords of this namespace (default: all namespaces)")
+	clusterName := fs.String("cluster-name", "", "name of this cluster, so several clusters may keep records in the same zone")
+	interval := fs.Duration("interval", time.Minute, "how often to reconcile the records with the DNSRecords")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made, leaving the DNSRecords' status alone")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	source, err := clusterSource(dnsRecordSource, *clusterName)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(*apiURL)
+	if err != nil {
+		return err
+	}
+	return o.repeat("dnsrecords", fields{"source": source}, *once, *interval, func(ctx context.Context) error {
+		return o.syncDNSRecords(ctx, kube, *namespace, source, *dryRun)
+	})
+}
+
+// syncDNSRecords makes the records kept by source match the DNSRecords, and
+// reports in each DNSRecord's Ready condition how that went.
+func (o *options) syncDNSRecords(ctx context.Context, kube *kubeClient, namespace, source string, dryRun bool) error {
+	var list struct {
+		Items []dnsRecord `json:"items"`
+	}
+	if err := kube.get(dnsRecordAPI+namespaceScope(namespace)+"/dnsrecords", &list); err != nil {
+		return err
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+
+	records := list.Items
+	sort.Slice(records, func(i, j int) bool { return records[i].Metadata.String() < records[j].Metadata.String() })
+	desired, results := dnsRecordSets(records, o.zone(), sets, source)
+	changes := syncChanges(sets, desired, nil, source, false, time.Now())
+	syncErr := submitSyncChanges(ctx, r53, zoneID, "DNSRecords Synced", sets, changes, len(desired), dryRun)
+	if dryRun {
+		return syncErr
+	}
+	for i := range records {
+		r, status := &records[i], results[i]
+		if c := &status.Conditions[0]; syncErr != nil && c.Reason == reasonSynced {
+			c.Status, c.Reason, c.Message = "False", reasonSyncFailed, syncErr.Error()
+			status.RecordName = ""
+		}
+		if err := updateDNSRecordStatus(kube, r, status); err != nil {
+			logger.Warn("Updating DNSRecord status failed", errorFields(err, fields{"object": r.Metadata.String()}))
+		}
+	}
+	return syncErr
+}
+
+// dnsRecordSets returns the record sets the DNSRecords call for, along with
+// the status of each DNSRecord as far as it's known before syncing.
+// DNSRecords that are invalid, or claim a record another DNSRecord already
+// claimed or that isn't kept by source, are left out.
+func dnsRecordSets(records []dnsRecord, zone string, sets []*route53.ResourceRecordSet, source string) (map[syncKey]*route53.ResourceRecordSet, []dnsRecordStatus) {
+	current := map[syncKey]bool{}
+	for _, set := range sets {
+		current[setKey(set)] = true
+	}
+	owned := sourceRecords(sets, source)
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	claimed := map[syncKey]string{}
+	results := make([]dnsRecordStatus, len(records))
+	for i, r := range records {
+		c := kubeCondition{Status: "False", Reason: reasonInvalid}
+		set, err := r.Spec.recordSet(zone)
+		if err != nil {
+			c.Message = err.Error()
+			results[i].Conditions = []kubeCondition{c}
+			continue
+		}
+		k := setKey(set)
+		_, ours := owned[k]
+		switch owner, ok := claimed[k]; {
+		case ok:
+			c.Reason, c.Message = reasonConflict, "The record is claimed by DNSRecord "+owner
+		case current[k] && !ours:
+			c.Reason, c.Message = reasonConflict, "The record exists and isn't kept by route53_register"
+		default:
+			claimed[k] = r.Metadata.String()
+			desired[k] = set
+			c = kubeCondition{Status: "True", Reason: reasonSynced, Message: "The record is in sync"}
+			results[i].RecordName = k.name
+		}
+		results[i].Conditions = []kubeCondition{c}
+	}
+	return desired, results
+}
+
+func (s dnsRecordSpec) recordSet(zone string) (*route53.ResourceRecordSet, error) {
+	switch s.RoutingPolicy {
+	case "", "simple":
+		if s.SetIdentifier != "" {
+			return nil, errors.New("setIdentifier needs routingPolicy weighted")
+		}
+	case "weighted":
+		if s.SetIdentifier == "" {
+			return nil, errors.New("routingPolicy weighted needs a setIdentifier")
+		}
+	default:
+		return nil, fmt.Errorf("unknown routingPolicy %s, expected simple or weighted", s.RoutingPolicy)
+	}
+	set, err := desiredRecord{
+		Name:          s.Name,
+		Type:          s.Type,
+		TTL:           s.TTL,
+		Values:        s.Values,
+		SetIdentifier: s.SetIdentifier,
+		Weight:        s.Weight,
+	}.recordSet(zone)
+	if err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(set.Name)
+	if !inZone(name, normalizeName(zone)) {
+		return nil, errors.New(name + " isn't in zone " + zone)
+	}
+	if err := validateDNSName(name); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// updateDNSRecordStatus writes status to r, leaving it alone when it already
+// says the same so an unchanged DNSRecord isn't written to on every pass.
+func updateDNSRecordStatus(kube *kubeClient, r *dnsRecord, status dnsRecordStatus) error {
+	status.ObservedGeneration = r.Metadata.Generation
+	c := &status.Conditions[0]
+	c.Type, c.ObservedGeneration = "Ready", r.Metadata.Generation
+	c.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+	for _, old := range r.Status.Conditions {
+		if old.Type != c.Type || old.Status != c.Status {
+			continue
+		}
+		c.LastTransitionTime = old.LastTransitionTime
+		if old == *c && r.Status.ObservedGeneration == status.ObservedGeneration && r.Status.RecordName == status.RecordName {
+			return nil
+		}
+	}
+	return kube.patchStatus(dnsRecordAPI+"/namespaces/"+url.PathEscape(r.Metadata.Namespace)+"/dnsrecords/"+url.PathEscape(r.Metadata.Name), status)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// The vendored SDK predates DNSSEC signing in Route53, so GetDNSSEC is
+// described here the way the SDK describes its operations.
+
+type getDNSSECInput struct {
+	_ struct{} `type:"structure"`
+
+	HostedZoneID *string `location:"uri" locationName:"Id" type:"string" required:"true"`
+}
+
+type getDNSSECOutput struct {
+	_ struct{} `type:"structure"`
+
+	Status         *dnssecStatus    `type:"structure"`
+	KeySigningKeys []*keySigningKey `locationNameList:"member" type:"list"`
+}
+
+type dnssecStatus struct {
+	_ struct{} `type:"structure"`
+
+	// ServeSignature is SIGNING, NOT_SIGNING, DELETING or INTERNAL_FAILURE
+	ServeSignature *string `type:"string"`
+	StatusMessage  *string `type:"string"`
+}
+
+type keySigningKey struct {
+	_ struct{} `type:"structure"`
+
+	Name                     *string `type:"string"`
+	KeyTag                   *int64  `type:"integer"`
+	SigningAlgorithmMnemonic *string `type:"string"`
+	DSRecord                 *string `type:"string"`
+	// Status is ACTIVE, INACTIVE, DELETING, ACTION_NEEDED or INTERNAL_FAILURE
+	Status        *string `type:"string"`
+	StatusMessage *string `type:"string"`
+}
+
+// getDNSSEC returns the DNSSEC signing status of a hosted zone and its key
+// signing keys.
+func getDNSSEC(ctx context.Context, r53 *route53.Route53, zoneID string) (*getDNSSECOutput, error) {
+	op := &request.Operation{
+		Name:       "GetDNSSEC",
+		HTTPMethod: "GET",
+		HTTPPath:   "/2013-04-01/hostedzone/{Id}/dnssec",
+	}
+	out := &getDNSSECOutput{}
+	req := r53.NewRequest(op, &getDNSSECInput{HostedZoneID: aws.String(normalizeZoneID(zoneID))}, out)
+	req.SetContext(ctx)
+	return out, req.Send()
+}
+
+// signing tells whether Route53 signs the answers of the zone.
+func (out *getDNSSECOutput) signing() bool {
+	return out.Status != nil && aws.StringValue(out.Status.ServeSignature) == "SIGNING"
+}
+
+// printDNSSECStatus prints the signing status of a zone signed with DNSSEC
+// and the status of its key signing keys. Zones that aren't signed print
+// nothing, as do zones whose status can't be read.
+func printDNSSECStatus(ctx context.Context, r53 *route53.Route53, zoneID string) {
+	out, err := getDNSSEC(ctx, r53, zoneID)
+	if err != nil {
+		logger.Debug("Error getting DNSSEC status", errorFields(err, fields{"zone_id": zoneID}))
+		return
+	}
+	if out.Status == nil || aws.StringValue(out.Status.ServeSignature) == "NOT_SIGNING" {
+		return
+	}
+	fmt.Printf("DNSSEC %s %s\n", aws.StringValue(out.Status.ServeSignature), aws.StringValue(out.Status.StatusMessage))
+	for _, k := range out.KeySigningKeys {
+		fmt.Printf("  key signing key %s (key tag %d, %s) is %s %s\n", aws.StringValue(k.Name), aws.Int64Value(k.KeyTag),
+			aws.StringValue(k.SigningAlgorithmMnemonic), aws.StringValue(k.Status), aws.StringValue(k.StatusMessage))
+	}
+}
+
+// dsRecord is how ds prints the DS record of a key signing key.
+type dsRecord struct {
+	Zone   string `json:"zone"`
+	KSK    string `json:"key_signing_key"`
+	KeyTag int64  `json:"key_tag"`
+	Status string `json:"status"`
+	DS     string `json:"ds"`
+}
+
+func runDS(args []string) error {
+	var o options
+	fs := newFlagSet("ds")
+	o.addZoneFlags(fs)
+	format := fs.String("format", "text", "output format: text (zone file lines) or json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected text or json", *format))
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	out, err := getDNSSEC(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	if !out.signing() {
+		status := "NOT_SIGNING"
+		if out.Status != nil {
+			status = aws.StringValue(out.Status.ServeSignature)
+		}
+		return withExitCode(exitVerifyFailed, errors.New("DNSSEC signing isn't enabled for zone "+zoneID+", its status is "+status))
+	}
+	zone := normalizeName(o.zone())
+	records := []dsRecord{}
+	for _, k := range out.KeySigningKeys {
+		// Inactive keys don't sign, the parent shouldn't point at them
+		if aws.StringValue(k.Status) != "ACTIVE" {
+			continue
+		}
+		records = append(records, dsRecord{
+			Zone:   zone,
+			KSK:    aws.StringValue(k.Name),
+			KeyTag: aws.Int64Value(k.KeyTag),
+			Status: aws.StringValue(k.Status),
+			DS:     aws.StringValue(k.DSRecord),
+		})
+	}
+	if len(records) == 0 {
+		return withExitCode(exitVerifyFailed, errors.New("Zone "+zoneID+" has no active key signing key"))
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	for _, r := range records {
+		fmt.Printf("%s.\tIN\tDS\t%s\n", zone, strings.TrimSpace(r.DS))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runDrain(args []string) error {
+	var o options
+	fs := newFlagSet("drain")
+	o.addRecordFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.withHostLock(ctx, func() error {
+		return o.eachRegistration(func(r *options) error {
+			return r.changeHostRecord(ctx, "drain", func(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+				return setDrained(ctx, r53, ts, true)
+			})
+		})
+	})
+}
+
+func runUndrain(args []string) error {
+	var o options
+	fs := newFlagSet("undrain")
+	o.addRecordFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.withHostLock(ctx, func() error {
+		return o.eachRegistration(func(r *options) error {
+			return r.changeHostRecord(ctx, "undrain", func(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+				return setDrained(ctx, r53, ts, false)
+			})
+		})
+	})
+}
+
+// setDrained sets the weight of the records of ts to zero, remembering the
+// weight each had in its ownership marker, or restores those weights.
+// Undraining a record that wasn't drained by this tool restores it to its
+// configured weight.
+func setDrained(ctx context.Context, r53 *route53.Route53, ts []*target, drain bool) (*route53.ChangeInfo, error) {
+	if ts[0].shared {
+		return nil, errors.New("Shared records have no weight to drain, deregister the host instead")
+	}
+	var changes []*route53.Change
+	var changed []*target
+	weights := map[*target]int64{}
+	for _, t := range ts {
+		c, weight, err := drainChanges(ctx, r53, t, drain)
+		if err != nil {
+			return nil, err
+		}
+		if len(c) == 0 {
+			logger.Info("Record already drained", t.fields())
+			continue
+		}
+		changes = append(changes, c...)
+		changed = append(changed, t)
+		weights[t] = weight
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	info, err := submitChanges(ctx, r53, ts[0].zoneID, "Host "+ts[0].rrType+" Record Weight Changed", changes)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range changed {
+		f := t.fields()
+		f["weight"] = weights[t]
+		f["change_id"] = aws.StringValue(info.Id)
+		logger.Info("Record weight changed", f)
+	}
+	return info, nil
+}
+
+// loadDrained sets the drained weight of t from its ownership marker, so
+// that a drained record matches what registering t produces.
+func (t *target) loadDrained(ctx context.Context, r53 *route53.Route53) error {
+	if t.shared {
+		return nil
+	}
+	markerSets, err := findRecordSets(ctx, r53, t.zoneID, ownerRecordName(t.name), route53.RRTypeTxt)
+	if err != nil {
+		return err
+	}
+	for _, v := range recordValues(findIdentifiedSet(markerSets, t.setIdentifier)) {
+		if m, ok := parseOwnerMarker(v); ok && m.id == t.setIdentifier {
+			t.drainedWeight = m.drainedWeight
+		}
+	}
+	return nil
+}
+
+// registerKeepingDrain registers the targets like registerTargets, keeping
+// drained records drained, so that the daemon refreshing or repairing them
+// doesn't undo a drain.
+func registerKeepingDrain(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+	for _, t := range ts {
+		if err := t.loadDrained(ctx, r53); err != nil {
+			return nil, err
+		}
+	}
+	return registerTargets(ctx, r53, ts)
+}
+
+// drainChanges returns the changes draining or undraining t's record along
+// with the weight it ends up with, no changes when it's already drained.
+func drainChanges(ctx context.Context, r53 *route53.Route53, t *target, drain bool) ([]*route53.Change, int64, error) {
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return nil, 0, err
+	}
+	current := findIdentifiedSet(sets, t.setIdentifier)
+	if current == nil {
+		return nil, 0, errors.New("Record " + t.name + " (" + t.setIdentifier + ") is not registered")
+	}
+	markerSets, err := findRecordSets(ctx, r53, t.zoneID, ownerRecordName(t.name), route53.RRTypeTxt)
+	if err != nil {
+		return nil, 0, err
+	}
+	var marker ownerMarker
+	if set := findIdentifiedSet(markerSets, t.setIdentifier); set != nil && len(set.ResourceRecords) > 0 {
+		marker, _ = parseOwnerMarker(aws.StringValue(set.ResourceRecords[0].Value))
+	}
+	marker.id = t.setIdentifier
+	marker.registered = time.Now()
+
+	weight := aws.Int64Value(current.Weight)
+	if drain {
+		if weight == 0 {
+			return nil, 0, nil
+		}
+		marker.drainedWeight = weight
+		weight = 0
+	} else {
+		weight = t.weight
+		if marker.drainedWeight > 0 {
+			weight = marker.drainedWeight
+		}
+		marker.drainedWeight = 0
+	}
+
+	updated := *current
+	updated.Weight = aws.Int64(weight)
+	markerSet := t.markerSet(marker.registered)
+	markerSet.ResourceRecords = resourceRecords([]string{marker.String()})
+	changes := []*route53.Change{
+		{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: &updated,
+		},
+		{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: markerSet,
+		},
+	}
+	return changes, weight, nil
+}
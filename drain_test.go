@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestDrainChanges(t *testing.T) {
+	marker := func(drained int64) *route53.ResourceRecordSet {
+		m := ownerMarker{id: "web-1", registered: testNow, drainedWeight: drained}
+		return testRecordSet(ownerRecordName("web.example.com"), "TXT", "web-1", defaultWeight, m.String())
+	}
+	tests := []struct {
+		name        string
+		sets        []*route53.ResourceRecordSet
+		drain       bool
+		wantChanges bool
+		wantWeight  int64
+		wantDrained int64
+		wantErr     bool
+	}{
+		{
+			name:        "drain",
+			sets:        []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 20, "10.0.0.1"), marker(0)},
+			drain:       true,
+			wantChanges: true,
+			wantWeight:  0,
+			wantDrained: 20,
+		},
+		{
+			name:  "drain again",
+			sets:  []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 0, "10.0.0.1"), marker(20)},
+			drain: true,
+		},
+		{
+			name:        "undrain",
+			sets:        []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 0, "10.0.0.1"), marker(20)},
+			wantChanges: true,
+			wantWeight:  20,
+		},
+		{
+			// Without a drained weight the configured one is restored
+			name:        "undrain a record drained by hand",
+			sets:        []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 0, "10.0.0.1")},
+			wantChanges: true,
+			wantWeight:  defaultWeight,
+		},
+		{
+			name:    "not registered",
+			sets:    []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-2", 20, "10.0.0.2")},
+			drain:   true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		_, r53 := newFakeRoute53(t, tt.sets...)
+		tg := &target{zoneID: "Z1", name: "web.example.com", rrType: "A", value: "10.0.0.1", setIdentifier: "web-1", weight: defaultWeight, ttl: 60}
+		changes, weight, err := drainChanges(context.Background(), r53, tg, tt.drain)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: drainChanges succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: drainChanges failed: %v", tt.name, err)
+			continue
+		}
+		if !tt.wantChanges {
+			if len(changes) > 0 {
+				t.Errorf("%s: drainChanges = %s, want no changes", tt.name, changes)
+			}
+			continue
+		}
+		if len(changes) != 2 {
+			t.Errorf("%s: drainChanges = %s, want the record and its marker", tt.name, changes)
+			continue
+		}
+		set, markerSet := changes[0].ResourceRecordSet, changes[1].ResourceRecordSet
+		if weight != tt.wantWeight || aws.Int64Value(set.Weight) != tt.wantWeight || liveValue(set) != "10.0.0.1" {
+			t.Errorf("%s: drainChanges set weight %d on %s, want %d", tt.name, weight, set, tt.wantWeight)
+		}
+		m, ok := parseOwnerMarker(liveValue(markerSet))
+		if !ok || m.id != "web-1" || m.drainedWeight != tt.wantDrained {
+			t.Errorf("%s: drainChanges set the marker %s, want drained weight %d", tt.name, liveValue(markerSet), tt.wantDrained)
+		}
+	}
+}
+
+func TestRegisterKeepingDrain(t *testing.T) {
+	drained := ownerMarker{id: "web-1", registered: testNow, drainedWeight: 20}
+	tests := []struct {
+		name        string
+		sets        []*route53.ResourceRecordSet
+		wantWeight  int64
+		wantDrained int64
+	}{
+		{
+			name:        "drained record stays drained",
+			sets:        []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 0, "10.0.0.9"), testRecordSet(ownerRecordName("web.example.com"), "TXT", "web-1", defaultWeight, drained.String())},
+			wantWeight:  0,
+			wantDrained: 20,
+		},
+		{
+			name:       "record in service is repaired",
+			sets:       []*route53.ResourceRecordSet{testRecordSet("web.example.com", "A", "web-1", 5, "10.0.0.9")},
+			wantWeight: 30,
+		},
+		{
+			name:       "missing record is created",
+			wantWeight: 30,
+		},
+	}
+	for _, tt := range tests {
+		f, r53 := newFakeRoute53(t, tt.sets...)
+		tg := &target{zoneID: "Z1", name: "web.example.com", rrType: "A", value: "10.0.0.1", setIdentifier: "web-1", weight: 30, ttl: 60}
+		if _, err := registerKeepingDrain(context.Background(), r53, []*target{tg}); err != nil {
+			t.Errorf("%s: registerKeepingDrain failed: %v", tt.name, err)
+			continue
+		}
+		set := f.find("web.example.com", "A", "web-1")
+		if set == nil || aws.Int64Value(set.Weight) != tt.wantWeight || liveValue(set) != "10.0.0.1" {
+			t.Errorf("%s: registered %s, want weight %d", tt.name, set, tt.wantWeight)
+		}
+		markerSet := f.find(ownerRecordName("web.example.com"), "TXT", "web-1")
+		if m, ok := parseOwnerMarker(liveValue(markerSet)); !ok || m.drainedWeight != tt.wantDrained {
+			t.Errorf("%s: marker %s, want drained weight %d", tt.name, liveValue(markerSet), tt.wantDrained)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// endpointSettings decides which endpoints the AWS clients call. It is the
+// EndpointResolver of every client we create, set up from the flags of the
+// command like the retry policy.
+type endpointSettings struct {
+	region  string
+	useFIPS bool
+}
+
+var awsEndpoints endpointSettings
+
+func (e *endpointSettings) configure(region string, useFIPS bool) error {
+	*e = endpointSettings{region: region, useFIPS: useFIPS}
+	if useFIPS && e.partition(region) == "aws-cn" {
+		return errors.New("The China regions have no FIPS endpoints")
+	}
+	return nil
+}
+
+// config returns cfg with the region and endpoints of these settings.
+func (e endpointSettings) config(cfg *aws.Config) *aws.Config {
+	if e.region != "" {
+		cfg.Region = aws.String(e.region)
+	}
+	cfg.EndpointResolver = e
+	return cfg
+}
+
+// partition returns the partition region belongs to. Regions this SDK
+// doesn't know, which are newer than it, are in the standard partition.
+func (e endpointSettings) partition(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok && region != "" {
+		return p.ID()
+	}
+	return "aws"
+}
+
+// arn returns the ARN of a resource in the partition of the configured
+// region.
+func (e endpointSettings) arn(service, region, account, resource string) string {
+	return "arn:" + e.partition(e.region) + ":" + service + ":" + region + ":" + account + ":" + resource
+}
+
+// route53Endpoints are the endpoints of the Route53 API in each partition.
+// This SDK only knows the one of the standard partition, other partitions
+// would get a regional endpoint Route53 doesn't have.
+var route53Endpoints = map[string]endpoints.ResolvedEndpoint{
+	"aws":        {URL: "https://route53.amazonaws.com", SigningRegion: "us-east-1"},
+	"aws-cn":     {URL: "https://route53.amazonaws.com.cn", SigningRegion: "cn-northwest-1"},
+	"aws-us-gov": {URL: "https://route53.us-gov.amazonaws.com", SigningRegion: "us-gov-west-1"},
+}
+
+// EndpointFor implements endpoints.Resolver.
+func (e endpointSettings) EndpointFor(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	partition := e.partition(region)
+	var resolved endpoints.ResolvedEndpoint
+	if service == endpoints.Route53ServiceID {
+		resolved = route53Endpoints[partition]
+		resolved.SigningName = service
+	} else {
+		var err error
+		if resolved, err = endpoints.DefaultResolver().EndpointFor(service, region, opts...); err != nil {
+			return resolved, err
+		}
+	}
+	// The endpoints of GovCloud are FIPS validated already
+	if e.useFIPS && partition == "aws" {
+		u, err := url.Parse(resolved.URL)
+		if err != nil {
+			return resolved, err
+		}
+		if u.Host == "sts.amazonaws.com" {
+			// The global endpoint has no FIPS counterpart, the one of
+			// us-east-1 does
+			u.Host = "sts.us-east-1.amazonaws.com"
+		}
+		labels := strings.SplitN(u.Host, ".", 2)
+		if len(labels) == 2 && !strings.HasSuffix(labels[0], "-fips") {
+			u.Host = labels[0] + "-fips." + labels[1]
+		}
+		resolved.URL = u.String()
+		if resolved.SigningName == "" {
+			resolved.SigningName = service
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Exit statuses, telling wrapper scripts and systemd what kind of failure
+// ended the run. Transient failures (metadata, throttling) are worth
+// retrying, the others need somebody to look at them. The flag package
+// exits with exitConfig on unknown flags as well.
+const (
+	exitFailure      = 1
+	exitConfig       = 2
+	exitMetadata     = 3
+	exitZoneNotFound = 4
+	exitAccessDenied = 5
+	exitThrottled    = 6
+	exitChangeFailed = 7
+	exitVerifyFailed = 8
+	exitNotOwned     = 9
+)
+
+// exitError is an error ending the run with a given exit status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// withExitCode makes err end the run with code, unless it already carries
+// an exit status.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exitError); ok {
+		return err
+	}
+	return &exitError{code, err}
+}
+
+// configError is an error in the flags or files we were given.
+func configError(msg string) error {
+	return &exitError{exitConfig, errors.New(msg)}
+}
+
+// unwrapExitError returns the error an exitError carries, or err itself.
+func unwrapExitError(err error) error {
+	if e, ok := err.(*exitError); ok {
+		return e.err
+	}
+	return err
+}
+
+// accessDeniedCodes are the AWS error codes of requests without valid
+// credentials or permissions.
+var accessDeniedCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"UnauthorizedOperation":       true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"NoCredentialProviders":       true,
+	"EnvAccessKeyNotFound":        true,
+	"EnvSecretNotFound":           true,
+}
+
+// exitCode returns the exit status err should end the run with. Access,
+// throttling and missing zone errors from AWS take precedence over the
+// status of the step that failed.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	code, cause := exitFailure, err
+	if e, ok := err.(*exitError); ok {
+		code, cause = e.code, e.err
+	}
+	if aerr, ok := cause.(awserr.Error); ok {
+		switch {
+		case accessDeniedCodes[aerr.Code()]:
+			return exitAccessDenied
+		case request.IsErrorThrottle(cause):
+			return exitThrottled
+		case aerr.Code() == route53.ErrCodeNoSuchHostedZone:
+			return exitZoneNotFound
+		}
+	}
+	return code
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+// exportedZone is the file export writes and import reads.
+type exportedZone struct {
+	Zone     string           `json:"zone" yaml:"zone"`
+	ZoneID   string           `json:"zone_id" yaml:"zone_id"`
+	Exported time.Time        `json:"exported" yaml:"exported"`
+	Records  []exportedRecord `json:"records" yaml:"records"`
+}
+
+// exportedRecord is a record set of an exportedZone, carrying everything
+// needed to create it again.
+type exportedRecord struct {
+	// Name is relative to the zone, as in sync files
+	Name             string         `json:"name" yaml:"name"`
+	Type             string         `json:"type" yaml:"type"`
+	TTL              *int64         `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Values           []string       `json:"values,omitempty" yaml:"values,omitempty"`
+	Alias            *exportedAlias `json:"alias,omitempty" yaml:"alias,omitempty"`
+	SetIdentifier    string         `json:"set_identifier,omitempty" yaml:"set_identifier,omitempty"`
+	Weight           *int64         `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Region           string         `json:"region,omitempty" yaml:"region,omitempty"`
+	Failover         string         `json:"failover,omitempty" yaml:"failover,omitempty"`
+	GeoLocation      *exportedGeo   `json:"geo_location,omitempty" yaml:"geo_location,omitempty"`
+	MultiValueAnswer bool           `json:"multivalue_answer,omitempty" yaml:"multivalue_answer,omitempty"`
+	HealthCheckID    string         `json:"health_check_id,omitempty" yaml:"health_check_id,omitempty"`
+}
+
+type exportedAlias struct {
+	DNSName              string `json:"dns_name" yaml:"dns_name"`
+	ZoneID               string `json:"zone_id" yaml:"zone_id"`
+	EvaluateTargetHealth bool   `json:"evaluate_target_health,omitempty" yaml:"evaluate_target_health,omitempty"`
+}
+
+type exportedGeo struct {
+	Continent   string `json:"continent,omitempty" yaml:"continent,omitempty"`
+	Country     string `json:"country,omitempty" yaml:"country,omitempty"`
+	Subdivision string `json:"subdivision,omitempty" yaml:"subdivision,omitempty"`
+}
+
+func newExportedRecord(set *route53.ResourceRecordSet, zone string) exportedRecord {
+	r := exportedRecord{
+		Name:             relativeName(aws.StringValue(set.Name), zone),
+		Type:             aws.StringValue(set.Type),
+		TTL:              set.TTL,
+		Values:           recordValues(set),
+		SetIdentifier:    aws.StringValue(set.SetIdentifier),
+		Weight:           set.Weight,
+		Region:           aws.StringValue(set.Region),
+		Failover:         aws.StringValue(set.Failover),
+		MultiValueAnswer: aws.BoolValue(set.MultiValueAnswer),
+		HealthCheckID:    aws.StringValue(set.HealthCheckId),
+	}
+	if a := set.AliasTarget; a != nil {
+		r.Alias = &exportedAlias{
+			DNSName:              normalizeName(aws.StringValue(a.DNSName)),
+			ZoneID:               aws.StringValue(a.HostedZoneId),
+			EvaluateTargetHealth: aws.BoolValue(a.EvaluateTargetHealth),
+		}
+	}
+	if g := set.GeoLocation; g != nil {
+		r.GeoLocation = &exportedGeo{
+			Continent:   aws.StringValue(g.ContinentCode),
+			Country:     aws.StringValue(g.CountryCode),
+			Subdivision: aws.StringValue(g.SubdivisionCode),
+		}
+	}
+	return r
+}
+
+func runExport(args []string) error {
+	var o options
+	fs := newFlagSet("export")
+	o.addZoneFlags(fs)
+	file := fs.String("file", "-", "file to write the records to, - for stdout")
+	format := fs.String("format", "yaml", "format of the file: yaml, json or bind (a BIND zone file)")
+	prefix := fs.String("prefix", "", "only export records whose name starts with this prefix")
+	owned := fs.Bool("owned", false, "only export records registered by this tool, along with their ownership markers")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *format != "yaml" && *format != "json" && *format != "bind" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected yaml, json or bind", *format))
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+
+	zone := normalizeName(o.zone())
+	owners := ownedRecords(sets)
+	export := exportedZone{Zone: zone, ZoneID: zoneID, Exported: time.Now().UTC(), Records: []exportedRecord{}}
+	for _, set := range sets {
+		name := normalizeName(aws.StringValue(set.Name))
+		recordName := name
+		if owner, ok := isOwnerRecordName(name); ok {
+			// Markers go along with the records they belong to
+			recordName = owner
+		}
+		if !strings.HasPrefix(recordName, *prefix) {
+			continue
+		}
+		if *owned && !owners[ownedKey(recordName, aws.StringValue(set.SetIdentifier))] {
+			continue
+		}
+		export.Records = append(export.Records, newExportedRecord(set, zone))
+	}
+
+	if *file == "-" {
+		err = writeExport(os.Stdout, *format, export)
+	} else {
+		var f *os.File
+		if f, err = os.Create(*file); err != nil {
+			return err
+		}
+		err = writeExport(f, *format, export)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("Zone exported", fields{"zone_id": zoneID, "records": len(export.Records), "file": *file})
+	return nil
+}
+
+func writeExport(w io.Writer, format string, export exportedZone) error {
+	switch format {
+	case "bind":
+		return writeBindZone(w, export)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+	}
+	b, err := yaml.Marshal(export)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
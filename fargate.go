@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fargate tasks have no instance metadata. With -fargate the paths of it we
+// read are answered from the ECS task metadata endpoint instead: the task
+// stands in for the instance, its id for the instance id and the private IP
+// of its ENI for local-ipv4.
+
+// taskMetadataSource answers instance metadata paths from the task
+// metadata. It is set up by -fargate, like the other sources of the clients.
+type taskMetadataSource struct {
+	// endpoint is $ECS_CONTAINER_METADATA_URI_V4, or the one of version 3
+	endpoint string
+
+	mu   sync.Mutex
+	task *taskMetadata
+}
+
+var taskMetadataEndpoint taskMetadataSource
+
+// taskMetadata is the part of the task metadata we use.
+type taskMetadata struct {
+	TaskARN          string `json:"TaskARN"`
+	AvailabilityZone string `json:"AvailabilityZone"`
+	Containers       []struct {
+		Networks []struct {
+			IPv4Addresses  []string `json:"IPv4Addresses"`
+			MACAddress     string   `json:"MACAddress"`
+			PrivateDNSName string   `json:"PrivateDNSName"`
+		} `json:"Networks"`
+	} `json:"Containers"`
+}
+
+var taskMetadataClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+func (s *taskMetadataSource) configure() error {
+	s.endpoint = firstNonEmpty(os.Getenv("ECS_CONTAINER_METADATA_URI_V4"), os.Getenv("ECS_CONTAINER_METADATA_URI"))
+	if s.endpoint == "" {
+		return errors.New("The fargate parameter needs the task metadata endpoint, ECS_CONTAINER_METADATA_URI_V4 isn't set; run in an ECS task on platform version 1.4 or later")
+	}
+	return nil
+}
+
+// enabled tells whether metadata comes from the task rather than the
+// instance.
+func (s *taskMetadataSource) enabled() bool {
+	return s.endpoint != ""
+}
+
+// get answers a request for an instance metadata path from the task
+// metadata, which is read once as it doesn't change during the task's life.
+func (s *taskMetadataSource) get(ctx context.Context, httpPath string) (string, error) {
+	t, err := s.load(ctx)
+	if err != nil {
+		return "", withExitCode(exitMetadata, err)
+	}
+	if httpPath == path.Join("/", "dynamic", "instance-identity/document") {
+		// arn:aws:ecs:<region>:<account>:task/<cluster>/<id>
+		parts := strings.Split(t.TaskARN, ":")
+		if len(parts) < 6 {
+			return "", withExitCode(exitMetadata, fmt.Errorf("Invalid task ARN %q", t.TaskARN))
+		}
+		b, err := json.Marshal(map[string]string{
+			"instanceId":       t.id(),
+			"accountId":        parts[4],
+			"region":           parts[3],
+			"availabilityZone": t.AvailabilityZone,
+			"privateIp":        t.network("ipv4"),
+		})
+		return string(b), err
+	}
+	var v string
+	switch strings.TrimPrefix(httpPath, "/meta-data") {
+	case "/instance-id":
+		v = t.id()
+	case "/local-ipv4":
+		v = t.network("ipv4")
+	case "/local-hostname":
+		v = t.network("hostname")
+	case "/mac":
+		v = t.network("mac")
+	case "/placement/availability-zone":
+		v = t.AvailabilityZone
+	}
+	if v == "" {
+		// Public addresses, instance types and the like have no
+		// counterpart in a task
+		return "", withExitCode(exitMetadata, metadataNotFoundError(httpPath))
+	}
+	return v, nil
+}
+
+func (s *taskMetadataSource) load(ctx context.Context) (*taskMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.task != nil {
+		return s.task, nil
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(s.endpoint, "/")+"/task", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := taskMetadataClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading task metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error reading task metadata: %s", resp.Status)
+	}
+	var t taskMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, fmt.Errorf("Error reading task metadata: %v", err)
+	}
+	s.task = &t
+	return s.task, nil
+}
+
+// id returns the id of the task, the last part of its ARN.
+func (t *taskMetadata) id() string {
+	return t.TaskARN[strings.LastIndex(t.TaskARN, "/")+1:]
+}
+
+// network returns a field of the task's ENI, which all of its containers
+// share in the awsvpc network mode of Fargate.
+func (t *taskMetadata) network(field string) string {
+	for _, c := range t.Containers {
+		for _, n := range c.Networks {
+			switch {
+			case field == "ipv4" && len(n.IPv4Addresses) > 0:
+				return n.IPv4Addresses[0]
+			case field == "hostname" && n.PrivateDNSName != "":
+				return n.PrivateDNSName
+			case field == "mac" && n.MACAddress != "":
+				return n.MACAddress
+			}
+		}
+	}
+	return ""
+}
+
+// deregisterAtStop removes the records of regs once the daemon was told to
+// stop, as ECS does with SIGTERM when it stops a task, so that they don't
+// point at an address the next task may get. It runs on a context of its
+// own, the daemon's being done, bounded by the time ECS gives a task to
+// stop before killing it.
+func (o *options) deregisterAtStop(regs []*options) {
+	ctx, cancel := context.WithTimeout(context.Background(), ecsStopTimeout)
+	defer cancel()
+	err := o.withHostLock(ctx, func() error {
+		for _, r := range regs {
+			if err := r.changeHostRecord(ctx, "deregister", deregisterTargets); err != nil {
+				logger.Error("Deregistering on stop failed", errorFields(err, fields{"hostname": r.hostnames.String(), "zone_name": r.zoneName}))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Deregistering on stop failed", errorFields(err, nil))
+	}
+}
+
+// ecsStopTimeout is a little less than the 30 seconds ECS waits by default
+// for a task's containers to exit after SIGTERM before killing them.
+const ecsStopTimeout = 25 * time.Second
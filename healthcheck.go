@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// healthyCheckerShare is the share of Route53 health checkers that must
+// report success for Route53 itself to consider an endpoint healthy.
+const healthyCheckerShare = 0.18
+
+// healthCheckHealthy reports whether Route53 currently considers a health
+// check healthy, judging from the last observation of each of its checkers.
+func healthCheckHealthy(ctx context.Context, r53 *route53.Route53, healthCheckID string) (bool, error) {
+	out, err := r53.GetHealthCheckStatusWithContext(ctx, &route53.GetHealthCheckStatusInput{
+		HealthCheckId: aws.String(healthCheckID),
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(out.HealthCheckObservations) == 0 {
+		// Calculated and CloudWatch alarm checks have no checkers to ask
+		return true, nil
+	}
+	var successes int
+	for _, o := range out.HealthCheckObservations {
+		if o.StatusReport != nil && strings.HasPrefix(aws.StringValue(o.StatusReport.Status), "Success") {
+			successes++
+		}
+	}
+	return float64(successes) > healthyCheckerShare*float64(len(out.HealthCheckObservations)), nil
+}
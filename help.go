@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// commandGroups orders the commands of the usage by what they work on.
+// Commands missing here are listed under Other.
+var commandGroups = []struct {
+	title    string
+	commands []string
+}{
+	{"This host's records", []string{"register", "deregister", "drain", "undrain", "status", "rollback"}},
+	{"Zones", []string{"list", "export", "import", "sync", "shift", "swap", "migrate", "prune", "history", "resolve", "change-status", "delegate", "ds", "traffic-policy"}},
+	{"Mail", []string{"spf", "dkim", "dmarc"}},
+	{"Fleets and platforms", []string{"controller", "discover", "cleanup", "kubernetes", "dnsrecords", "nomad", "consul", "serve", "client"}},
+	{"Setup", []string{"check", "iam-policy", "systemd-unit", "service", "completion"}},
+}
+
+// commandExamples are shown at the end of a command's help.
+var commandExamples = map[string][]string{
+	"register": {
+		"register -zonename myzone.internal -hostname web",
+		"register -zonename myzone.internal -hostname web -set-identifier instance-id -weight 10",
+		"register -config /etc/route53_register.yaml -daemon -health-addr :9053",
+	},
+	"deregister": {"deregister -zonename myzone.internal -hostname web -set-identifier instance-id"},
+	"drain":      {"drain -zonename myzone.internal -hostname web -set-identifier instance-id"},
+	"status":     {"status -config /etc/route53_register.yaml -output json"},
+	"list":       {"list -zonename myzone.internal -owned"},
+	"export":     {"export -zonename myzone.internal -file backup.yaml"},
+	"import":     {"import -zonename myzone.internal -file backup.yaml -dry-run"},
+	"sync":       {"sync -zonename myzone.internal -prefix static. -file records.yaml"},
+	"check":      {"check -zonename myzone.internal"},
+	"swap": {
+		"swap -zonename example.com -hostname app -a blue -b green",
+		"swap -zonename example.com -a app-blue -b app-green -swap values",
+	},
+	"migrate": {
+		"migrate -zonename example.com -hostname www -value 203.0.113.20 -verify-resolvers 8.8.8.8,1.1.1.1",
+	},
+	"resolve": {
+		"resolve -zonename myzone.internal -hostname web -subnet 203.0.113.0/24 -queries 50",
+	},
+	"change-status": {
+		"change-status -timeout 5m /change/C2682N5HXP0BZ4",
+	},
+	"delegate":   {"delegate -zonename team.example.com", "delegate -zonename team.example.com -remove"},
+	"spf":        {"spf -zonename example.com -mx -include _spf.google.com -all fail"},
+	"dkim":       {"dkim -zonename example.com -selector mail1 -public-key-file /etc/opendkim/keys/mail1.pub"},
+	"dmarc":      {"dmarc -zonename example.com -policy quarantine -pct 25 -rua dmarc-reports@example.com"},
+	"iam-policy": {"iam-policy -config /etc/route53_register.yaml -operation daemon"},
+	"systemd-unit": {
+		"systemd-unit -- -config /etc/route53_register.yaml > /etc/systemd/system/route53_register.service",
+	},
+	"service":    {"service install -- -config C:\\ProgramData\\route53_register\\config.yaml"},
+	"completion": {"completion bash > /etc/bash_completion.d/route53_register"},
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n", os.Args[0])
+	listed := map[string]bool{}
+	printGroup := func(title string, names []string) {
+		fmt.Fprintf(os.Stderr, "\n%s:\n", title)
+		for _, name := range names {
+			if c, ok := findCommand(name); ok {
+				fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.description)
+				listed[name] = true
+			}
+		}
+	}
+	for _, g := range commandGroups {
+		printGroup(g.title, g.commands)
+	}
+	var others []string
+	for _, c := range commands {
+		if !listed[c.name] {
+			others = append(others, c.name)
+		}
+	}
+	if len(others) > 0 {
+		printGroup("Other", others)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' to see the flags of a command, and '%s completion bash|zsh|fish' to complete them in the shell.\n", os.Args[0], os.Args[0])
+	fmt.Fprintf(os.Stderr, "Run '%s -version' to see which build this is.\n", os.Args[0])
+}
+
+// printCommandUsage is the help of a command: what it does, its flags and
+// examples.
+func printCommandUsage(name string, fs *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]\n", os.Args[0], name)
+	if c, ok := findCommand(name); ok {
+		fmt.Fprintf(os.Stderr, "\n%s\n", capitalize(c.description))
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	fs.PrintDefaults()
+	if examples := commandExamples[name]; len(examples) > 0 {
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		for _, e := range examples {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", os.Args[0], e)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s -h' to see all commands.\n", os.Args[0])
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func capitalize(s string) string {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
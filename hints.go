@@ -0,0 +1,89 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// failureKind names the kinds of common AWS failures that have a known
+// remedy.
+type failureKind string
+
+const (
+	failureAccessDenied         failureKind = "access_denied"
+	failureExpiredCredentials   failureKind = "expired_credentials"
+	failureInvalidCredentials   failureKind = "invalid_credentials"
+	failureNoCredentials        failureKind = "no_credentials"
+	failureZoneNotFound         failureKind = "zone_not_found"
+	failureInvalidChange        failureKind = "invalid_change"
+	failureThrottled            failureKind = "throttled"
+	failureMetadataUnauthorized failureKind = "metadata_unauthorized"
+	failureNotOwned             failureKind = "not_owned"
+)
+
+// failure describes an error of a known kind, with what to do about it.
+type failure struct {
+	kind failureKind
+	hint string
+	// fields are the details taken from the error message, e.g. the action
+	// that was denied
+	fields fields
+}
+
+var (
+	// deniedPattern matches the action and resource of IAM's AccessDenied
+	// messages: "User: arn:... is not authorized to perform: route53:X on
+	// resource: arn:..."
+	deniedPattern = regexp.MustCompile(`not authorized to perform: (\S+)(?: on resource: (\S+))?`)
+	// invalidChangePattern matches the change an InvalidChangeBatch is
+	// about, Route53 puts each failed change in brackets
+	invalidChangePattern = regexp.MustCompile(`\[(.+)\]`)
+)
+
+// describeFailure returns the kind of err and what to do about it, nil for
+// errors without a known remedy.
+func describeFailure(err error) *failure {
+	err = unwrapExitError(err)
+	if _, ok := err.(metadataUnauthorizedError); ok {
+		return &failure{kind: failureMetadataUnauthorized, hint: "The instance requires IMDSv2 session tokens and the token request failed, usually because its response doesn't reach containers; raise the hop limit with aws ec2 modify-instance-metadata-options --http-put-response-hop-limit 2, or give the values the metadata would as flags"}
+	}
+	if _, ok := err.(notOwnedError); ok {
+		return &failure{kind: failureNotOwned, hint: "The record wasn't registered by route53_register, or its ownership marker was deleted; make sure it isn't somebody else's and pass -force to remove it anyway"}
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return nil
+	}
+	switch code := aerr.Code(); {
+	case code == "AccessDenied" || code == "AccessDeniedException" || code == "UnauthorizedOperation":
+		f := &failure{kind: failureAccessDenied, hint: "The credentials lack a permission; route53_register iam-policy -operation <command> prints the policy a command needs", fields: fields{}}
+		if m := deniedPattern.FindStringSubmatch(aerr.Message()); m != nil {
+			f.fields["denied_action"] = m[1]
+			if m[2] != "" {
+				f.fields["denied_resource"] = m[2]
+			}
+			f.hint = "Allow " + m[1] + " to the credentials; route53_register iam-policy -operation <command> prints the policy a command needs"
+		}
+		return f
+	case code == "ExpiredToken" || code == "ExpiredTokenException" || code == "RequestExpired":
+		return &failure{kind: failureExpiredCredentials, hint: "The credentials expired; log in again with aws sso login, or get new session credentials"}
+	case code == "InvalidClientTokenId" || code == "SignatureDoesNotMatch" || code == "UnrecognizedClientException":
+		return &failure{kind: failureInvalidCredentials, hint: "AWS doesn't know the access key, or its secret doesn't match; check AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and the -profile"}
+	case code == "NoCredentialProviders":
+		return &failure{kind: failureNoCredentials, hint: "No credentials were found; attach an instance role, or set -profile, AWS_PROFILE or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"}
+	case code == route53.ErrCodeNoSuchHostedZone:
+		return &failure{kind: failureZoneNotFound, hint: "The hosted zone doesn't exist in the account of the credentials; check -zoneId, or look it up by -zonename"}
+	case code == route53.ErrCodeInvalidChangeBatch:
+		f := &failure{kind: failureInvalidChange, hint: "Route53 rejected a change, usually because a record was changed by somebody else meanwhile or conflicts with another record of the name; status shows how the records differ", fields: fields{}}
+		if m := invalidChangePattern.FindStringSubmatch(aerr.Message()); m != nil {
+			f.fields["invalid_change"] = m[1]
+		}
+		return f
+	case request.IsErrorThrottle(err):
+		return &failure{kind: failureThrottled, hint: "Route53 allows five requests per second per account; -max-retries, -max-backoff and -jitter spread out the retries of hosts starting together"}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// historyEntry is a change history prints, from the audit log or CloudTrail.
+type historyEntry struct {
+	auditEntry
+	Source string `json:"source"`
+	// Status is the status of the change as GetChange reports it now
+	Status string `json:"status,omitempty"`
+}
+
+// historyFilter picks the changes history shows.
+type historyFilter struct {
+	zoneID string
+	record string
+	since  time.Time
+}
+
+func (f historyFilter) matches(e auditEntry) bool {
+	if f.zoneID != "" && normalizeZoneID(e.ZoneID) != f.zoneID {
+		return false
+	}
+	if f.record != "" && normalizeName(e.Record) != f.record && normalizeName(e.Record) != ownerRecordName(f.record) {
+		return false
+	}
+	return !e.Time.Before(f.since)
+}
+
+func runHistory(args []string) error {
+	var o options
+	fs := newFlagSet("history")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "only show the changes of this record, relative to the zone, and of its ownership marker")
+	since := fs.Duration("since", 24*time.Hour, "how far back to show changes")
+	limit := fs.Int("limit", 50, "show at most this many of the most recent changes (no limit when 0)")
+	useCloudTrail := fs.Bool("cloudtrail", false, "also look up the changes made by anybody in CloudTrail, which keeps them for 90 days")
+	changeStatus := fs.Bool("change-status", true, "get the current status of each change with GetChange")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if *format != "table" && *format != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected table or json", *format))
+	}
+	if o.auditLogFile == "" && !*useCloudTrail {
+		return configError("Either the audit-log or the cloudtrail parameter is required, history is read from the audit log or CloudTrail")
+	}
+	filter := historyFilter{since: time.Now().Add(-*since)}
+	if o.zoneID != "" && *hostname == "" {
+		// The audit log can be read without AWS access
+		filter.zoneID = normalizeZoneID(o.zoneID)
+	} else if o.zoneName != "" || o.zoneID != "" {
+		if err := o.validateZone(); err != nil {
+			return err
+		}
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		filter.zoneID = normalizeZoneID(zoneID)
+	}
+	if *hostname != "" {
+		if filter.zoneID == "" {
+			return configError("The hostname parameter needs the zonename or zoneId parameter")
+		}
+		filter.record = qualifyName(*hostname, o.zone())
+	}
+
+	var entries []historyEntry
+	if o.auditLogFile != "" {
+		audited, err := readAuditLogs(o.auditLogFile, filter)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, audited...)
+	}
+	if *useCloudTrail {
+		looked, err := lookupCloudTrailChanges(ctx, filter, o.logLevel())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, looked...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+	if *changeStatus {
+		r53, err := newRoute53Client(o.logLevel())
+		if err != nil {
+			return err
+		}
+		addChangeStatus(ctx, r53, entries)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []historyEntry{}
+		}
+		return enc.Encode(entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tNAME\tTYPE\tSET ID\tVALUES\tCHANGE\tSTATUS\tIDENTITY\tSOURCE")
+	for _, e := range entries {
+		status := e.Status
+		if e.Error != "" {
+			status = "FAILED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, e.Record, e.Type,
+			dash(e.SetIdentifier), dash(strings.Join(e.Values, ",")), dash(e.ChangeID), dash(status), dash(e.Identity), e.Source)
+	}
+	return w.Flush()
+}
+
+// readAuditLogs reads the entries of the audit log at path matching filter,
+// starting with the oldest rotated file.
+func readAuditLogs(path string, filter historyFilter) ([]historyEntry, error) {
+	files := []string{path}
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	var entries []historyEntry
+	for _, file := range files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			var e auditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// A line cut short by a crash shouldn't hide the others
+				logger.Warn("Skipping invalid audit log line", errorFields(err, fields{"audit_log": file, "line": line}))
+				continue
+			}
+			if filter.matches(e) {
+				entries = append(entries, historyEntry{auditEntry: e, Source: "audit-log"})
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// cloudTrailChange is the part of the CloudTrail record of a
+// ChangeResourceRecordSets call we use.
+type cloudTrailChange struct {
+	UserIdentity struct {
+		Arn string `json:"arn"`
+	} `json:"userIdentity"`
+	ErrorMessage      string `json:"errorMessage"`
+	RequestParameters struct {
+		HostedZoneID string `json:"hostedZoneId"`
+		ChangeBatch  struct {
+			Comment string `json:"comment"`
+			Changes []struct {
+				Action            string `json:"action"`
+				ResourceRecordSet struct {
+					Name            string `json:"name"`
+					Type            string `json:"type"`
+					SetIdentifier   string `json:"setIdentifier"`
+					TTL             *int64 `json:"tTL"`
+					Weight          *int64 `json:"weight"`
+					ResourceRecords []struct {
+						Value string `json:"value"`
+					} `json:"resourceRecords"`
+					AliasTarget *struct {
+						DNSName string `json:"dNSName"`
+					} `json:"aliasTarget"`
+				} `json:"resourceRecordSet"`
+			} `json:"changes"`
+		} `json:"changeBatch"`
+	} `json:"requestParameters"`
+	ResponseElements *struct {
+		ChangeInfo struct {
+			ID string `json:"id"`
+		} `json:"changeInfo"`
+	} `json:"responseElements"`
+}
+
+// lookupCloudTrailChanges returns the record changes CloudTrail recorded
+// since filter.since matching filter. Route53 being a global service, its
+// calls are recorded in the region of its endpoint.
+func lookupCloudTrailChanges(ctx context.Context, filter historyFilter, logLevel *aws.LogLevelType) ([]historyEntry, error) {
+	sess, err := newAWSSession(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	region := route53Endpoints[awsEndpoints.partition(awsEndpoints.region)].SigningRegion
+	client := cloudtrail.New(sess, &aws.Config{Region: aws.String(region)})
+	var entries []historyEntry
+	err = client.LookupEventsPagesWithContext(ctx, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{{
+			AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventName),
+			AttributeValue: aws.String("ChangeResourceRecordSets"),
+		}},
+		StartTime: aws.Time(filter.since),
+		EndTime:   aws.Time(time.Now()),
+	}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+		for _, event := range page.Events {
+			var c cloudTrailChange
+			if err := json.Unmarshal([]byte(aws.StringValue(event.CloudTrailEvent)), &c); err != nil {
+				logger.Warn("Skipping unreadable CloudTrail event", errorFields(err, fields{"event_id": aws.StringValue(event.EventId)}))
+				continue
+			}
+			p := c.RequestParameters
+			for _, change := range p.ChangeBatch.Changes {
+				set := change.ResourceRecordSet
+				e := auditEntry{
+					Time:          aws.TimeValue(event.EventTime),
+					ZoneID:        "/hostedzone/" + normalizeZoneID(p.HostedZoneID),
+					Record:        normalizeName(set.Name),
+					Type:          set.Type,
+					SetIdentifier: set.SetIdentifier,
+					Action:        change.Action,
+					TTL:           set.TTL,
+					Weight:        set.Weight,
+					Comment:       p.ChangeBatch.Comment,
+					Identity:      c.UserIdentity.Arn,
+					Error:         c.ErrorMessage,
+				}
+				for _, rr := range set.ResourceRecords {
+					e.Values = append(e.Values, rr.Value)
+				}
+				if set.AliasTarget != nil {
+					e.Values = []string{strings.TrimSuffix(set.AliasTarget.DNSName, ".")}
+				}
+				if c.ResponseElements != nil {
+					e.ChangeID = c.ResponseElements.ChangeInfo.ID
+				}
+				if filter.matches(e) {
+					entries = append(entries, historyEntry{auditEntry: e, Source: "cloudtrail"})
+				}
+			}
+		}
+		return true
+	})
+	return entries, err
+}
+
+// addChangeStatus sets the current status of the changes of entries, asking
+// for each change once. Changes whose status can't be got are left without.
+func addChangeStatus(ctx context.Context, r53 *route53.Route53, entries []historyEntry) {
+	statuses := map[string]string{}
+	for i := range entries {
+		id := entries[i].ChangeID
+		if id == "" {
+			continue
+		}
+		status, ok := statuses[id]
+		if !ok {
+			out, err := r53.GetChangeWithContext(ctx, &route53.GetChangeInput{Id: aws.String(id)})
+			if err != nil {
+				logger.Debug("Error getting change status", errorFields(err, fields{"change_id": id}))
+			} else {
+				status = aws.StringValue(out.ChangeInfo.Status)
+			}
+			statuses[id] = status
+		}
+		entries[i].Status = status
+	}
+}
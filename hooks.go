@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// hookEvent is what the hooks are told about the outcome of an operation on
+// a record.
+type hookEvent struct {
+	Operation string `json:"operation"`
+	result
+}
+
+// env returns the ROUTE53_REGISTER_* variables the exec hooks get.
+func (e hookEvent) env() []string {
+	return []string{
+		"ROUTE53_REGISTER_OPERATION=" + e.Operation,
+		"ROUTE53_REGISTER_RECORD=" + e.Record,
+		"ROUTE53_REGISTER_TYPE=" + e.Type,
+		"ROUTE53_REGISTER_VALUE=" + e.Value,
+		"ROUTE53_REGISTER_ZONE_ID=" + e.ZoneID,
+		"ROUTE53_REGISTER_CHANGE_ID=" + e.ChangeID,
+		"ROUTE53_REGISTER_STATUS=" + e.Status,
+		"ROUTE53_REGISTER_ERROR=" + e.Error,
+	}
+}
+
+// runHooks runs the -on-success-exec or -on-failure-exec command and calls
+// the -webhook-url with the outcome of an operation on a record. Hooks that
+// fail are logged, they don't fail the operation.
+func (o *options) runHooks(ctx context.Context, operation string, r result) {
+	command := o.onSuccessExec
+	if r.Status == "FAILED" {
+		command = o.onFailureExec
+	}
+	if command == "" && o.webhookURL == "" {
+		return
+	}
+	e := hookEvent{Operation: operation, result: r}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logger.Warn("Error encoding hook payload", errorFields(err, nil))
+		return
+	}
+	if command != "" {
+		if err := execHook(ctx, command, e.env(), payload); err != nil {
+			logger.Warn("Exec hook failed", errorFields(err, fields{"command": command, "record_name": r.Record}))
+		}
+	}
+	if o.webhookURL != "" {
+		if err := postWebhook(ctx, o.webhookURL, payload); err != nil {
+			logger.Warn("Webhook failed", errorFields(err, fields{"record_name": r.Record}))
+		}
+	}
+}
+
+// execHook runs command with sh, or cmd on Windows, with the outcome in its
+// environment and on its stdin. Its output goes to stderr, keeping stdout
+// for -output json.
+func execHook(ctx context.Context, command string, env []string, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
+
+// postWebhook POSTs payload to url, failing unless it's accepted. It goes
+// through -proxy like the AWS calls, trusting -ca-bundle.
+func postWebhook(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := awsHTTP.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errLockHeld is returned by tryLockFile when another process holds the lock.
+var errLockHeld = errors.New("lock file is held")
+
+// hostLock is the -lock-file, held while a command changes this host's
+// records so that two runs on one host, e.g. cloud-init retrying a boot
+// script while the first run still waits for Route53, don't interleave their
+// changes. The lock is tied to the open file, so the system releases it
+// however its holder exits.
+type hostLock struct {
+	path string
+	file *os.File
+}
+
+// defaultLockFile is the -lock-file every run on the host agrees on.
+func defaultLockFile() string {
+	return filepath.Join(os.TempDir(), "route53_register.lock")
+}
+
+// lockHost takes the -lock-file, waiting up to -lock-timeout for another run
+// to release it, or failing right away unless wait is set. It returns a nil
+// lock when -lock-file is empty, or when the file can't be opened at all,
+// the lock only guarding against runs overlapping.
+func (o *options) lockHost(ctx context.Context, wait bool) (*hostLock, error) {
+	if o.lockFile == "" {
+		return nil, nil
+	}
+	deadline := time.Now().Add(o.lockTimeout)
+	for logged := false; ; logged = true {
+		file, err := tryLockFile(o.lockFile)
+		if err == nil {
+			l := &hostLock{path: o.lockFile, file: file}
+			l.writePID()
+			logger.Debug("Acquired lock file", fields{"lock_file": o.lockFile})
+			return l, nil
+		}
+		f := fields{"lock_file": o.lockFile, "holder_pid": lockHolder(o.lockFile)}
+		if err != errLockHeld {
+			logger.Warn("Error opening lock file, going on without it", errorFields(err, f))
+			return nil, nil
+		}
+		if !wait || time.Now().After(deadline) {
+			return nil, fmt.Errorf("Another run of route53_register (pid %s) holds lock file %s", f["holder_pid"], o.lockFile)
+		}
+		if !logged {
+			logger.Info("Waiting for another run of route53_register to finish", f)
+		}
+		if err := sleepContext(ctx, 500*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockDaemon takes the lock of a daemon, next to the -lock-file, without
+// waiting. The daemon holds it until it exits, so that a second daemon on the
+// host fails to start rather than registering the same records alongside the
+// first one. Like lockHost it returns a nil lock when -lock-file is empty or
+// the file can't be opened.
+func (o *options) lockDaemon() (*hostLock, error) {
+	if o.lockFile == "" {
+		return nil, nil
+	}
+	path := o.lockFile + ".daemon"
+	file, err := tryLockFile(path)
+	if err == errLockHeld {
+		return nil, fmt.Errorf("Another route53_register daemon (pid %s) is running on this host, holding lock file %s", lockHolder(path), path)
+	}
+	if err != nil {
+		logger.Warn("Error opening lock file, going on without it", errorFields(err, fields{"lock_file": path}))
+		return nil, nil
+	}
+	l := &hostLock{path: path, file: file}
+	l.writePID()
+	return l, nil
+}
+
+// withHostLock runs fn holding the -lock-file.
+func (o *options) withHostLock(ctx context.Context, fn func() error) error {
+	l, err := o.lockHost(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+	return fn()
+}
+
+// writePID writes our process id to the lock file, for runs waiting for it
+// to tell who they wait for.
+func (l *hostLock) writePID() {
+	if err := l.file.Truncate(0); err != nil {
+		// Opened read only, the file belonging to another user
+		return
+	}
+	l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+}
+
+// release gives the lock up. The file is left in place, as removing it would
+// let a waiting run and a new one lock two different files.
+func (l *hostLock) release() {
+	if l == nil {
+		return
+	}
+	if err := l.file.Close(); err != nil {
+		logger.Warn("Error releasing lock file", errorFields(err, fields{"lock_file": l.path}))
+	}
+}
+
+// lockHolder returns the process id written to the lock file at path, or
+// unknown.
+func lockHolder(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if pid := strings.TrimSpace(string(b)); err == nil && pid != "" {
+		return pid
+	}
+	return "unknown"
+}
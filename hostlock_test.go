@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockDaemon(t *testing.T) {
+	o := &options{lockFile: filepath.Join(t.TempDir(), "route53_register.lock")}
+	first, err := o.lockDaemon()
+	if err != nil || first == nil {
+		t.Fatalf("lockDaemon = %v, %v, want a lock", first, err)
+	}
+	if _, err := o.lockDaemon(); err == nil || !strings.Contains(err.Error(), "daemon") {
+		t.Errorf("second lockDaemon = %v, want the daemon lock to be held", err)
+	}
+	// Passes of the daemon still take the -lock-file itself
+	l, err := o.lockHost(context.Background(), false)
+	if err != nil || l == nil {
+		t.Fatalf("lockHost while the daemon lock is held = %v, %v, want a lock", l, err)
+	}
+	l.release()
+
+	first.release()
+	again, err := o.lockDaemon()
+	if err != nil || again == nil {
+		t.Fatalf("lockDaemon after release = %v, %v, want a lock", again, err)
+	}
+	again.release()
+
+	if l, err := (&options{}).lockDaemon(); l != nil || err != nil {
+		t.Errorf("lockDaemon without -lock-file = %v, %v, want no lock", l, err)
+	}
+}
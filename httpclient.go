@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// httpSettings decide how the AWS clients and the webhooks reach their
+// endpoints, which in locked down networks may only be through a TLS
+// intercepting proxy. The instance metadata is always reached directly.
+type httpSettings struct {
+	client *http.Client
+}
+
+var awsHTTP = httpSettings{client: http.DefaultClient}
+
+// configure sets up the client AWS calls are made with. Without a proxy the
+// usual HTTPS_PROXY and NO_PROXY variables apply.
+func (h *httpSettings) configure(proxy, caBundle string, requestTimeout time.Duration) error {
+	if requestTimeout < 0 {
+		return errors.New("request-timeout can't be negative")
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return errors.New("Invalid proxy URL " + proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return err
+		}
+		// The bundle adds to the system's roots, so endpoints the proxy
+		// doesn't intercept still verify
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return errors.New("No certificates found in CA bundle " + caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	h.client = &http.Client{Transport: transport, Timeout: requestTimeout}
+	return nil
+}
+
+// config returns cfg using the configured client.
+func (h httpSettings) config(cfg *aws.Config) *aws.Config {
+	cfg.HTTPClient = h.client
+	return cfg
+}
+
+// apiClient calls the JSON HTTP API of a local agent, e.g. Nomad or Consul,
+// sending its ACL token, if any, in tokenHeader.
+type apiClient struct {
+	name        string
+	addr        string
+	tokenHeader string
+	token       string
+	client      *http.Client
+}
+
+func newAPIClient(name, addr, tokenHeader, token string) *apiClient {
+	return &apiClient{
+		name:        name,
+		addr:        strings.TrimSuffix(addr, "/"),
+		tokenHeader: tokenHeader,
+		token:       token,
+		client:      &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (c *apiClient) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequest("GET", c.addr+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set(c.tokenHeader, c.token)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s API GET %s: %s", c.name, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
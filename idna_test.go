@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestPunycode(t *testing.T) {
+	// Examples of RFC 3492 and common domain names
+	tests := []struct {
+		unicode, ascii string
+	}{
+		{"example.com", "example.com"},
+		{"bücher.example.com", "xn--bcher-kva.example.com"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え.jp", "xn--r8jz45g.jp"},
+		{"пример.рф", "xn--e1afmkfd.xn--p1ai"},
+		{"web.ñ.example", "web.xn--ida.example"},
+	}
+	for _, tt := range tests {
+		if got := toASCII(tt.unicode); got != tt.ascii {
+			t.Errorf("toASCII(%q) = %q, want %q", tt.unicode, got, tt.ascii)
+		}
+		if got := toUnicode(tt.ascii); got != tt.unicode {
+			t.Errorf("toUnicode(%q) = %q, want %q", tt.ascii, got, tt.unicode)
+		}
+	}
+}
+
+func TestToUnicodeInvalid(t *testing.T) {
+	// Labels that aren't valid punycode are left as they are
+	for _, name := range []string{"xn--ab!c.example.com", "web.xn--ü.example"} {
+		if got := toUnicode(name); got != name {
+			t.Errorf("toUnicode(%q) = %q, want it unchanged", name, got)
+		}
+	}
+}
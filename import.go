@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+// importSource tags the ownership markers of the records created by import,
+// so a later import may update them.
+const importSource = "import"
+
+func runImport(args []string) error {
+	var o options
+	fs := newFlagSet("import")
+	o.addZoneFlags(fs)
+	file := fs.String("file", "", "file to read the records from, - for stdin (required)")
+	format := fs.String("format", "", "format of the file: yaml, json, csv or bind (default by the file's extension: .json, .csv, .zone or .db, yaml otherwise)")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	batchSize := fs.Int("batch-size", 100, "most records changed in one change batch")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *file == "" {
+		return configError("The file parameter is required")
+	}
+	if *batchSize < 1 || *batchSize > maxBatchRecords {
+		return configError(fmt.Sprintf("The batch-size parameter must be between 1 and %d", maxBatchRecords))
+	}
+	if *format == "" {
+		*format = importFormat(*file)
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	zone := normalizeName(o.zone())
+	records, err := readImportFile(*file, *format, zone)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	desired, markers, err := importedRecordSets(records, zone)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("%s: %v", *file, err))
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	batches := importChanges(sets, desired, markers, time.Now(), *batchSize)
+	if len(batches) == 0 {
+		logger.Info("Records already imported", fields{"zone_id": zoneID, "records": len(desired)})
+		return nil
+	}
+	for _, changes := range batches {
+		if err := submitSyncChanges(ctx, r53, zoneID, "Records Imported", sets, changes, len(desired), *dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFormat picks the format of file by its extension.
+func importFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	case ".zone", ".db":
+		return "bind"
+	}
+	return "yaml"
+}
+
+// readImportFile reads the records of a file written by export, of a CSV
+// file of name,type,value lines or of a BIND zone file of zone.
+func readImportFile(file, format, zone string) ([]exportedRecord, error) {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var z exportedZone
+	switch format {
+	case "yaml":
+		err = yaml.UnmarshalStrict(b, &z)
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&z)
+	case "csv":
+		z.Records, err = readCSVRecords(bytes.NewReader(b))
+	case "bind":
+		z.Records, err = readBindZone(bytes.NewReader(b), zone)
+	default:
+		return nil, fmt.Errorf("Unknown format %q, expected yaml, json, csv or bind", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", file, err)
+	}
+	return z.Records, nil
+}
+
+// readCSVRecords reads name,type,value lines, an optional header line
+// naming those columns first. The values of lines sharing their name and
+// type make up a single record set.
+func readCSVRecords(in io.Reader) ([]exportedRecord, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	var records []exportedRecord
+	index := map[string]int{}
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(row[0], "name") && strings.EqualFold(row[1], "type") {
+			continue
+		}
+		k := normalizeName(row[0]) + "|" + strings.ToUpper(row[1])
+		if i, ok := index[k]; ok {
+			records[i].Values = append(records[i].Values, row[2])
+			continue
+		}
+		index[k] = len(records)
+		records = append(records, exportedRecord{Name: row[0], Type: row[1], Values: []string{row[2]}})
+	}
+}
+
+// recordSet returns the record set of r in zone.
+func (r exportedRecord) recordSet(zone string) (*route53.ResourceRecordSet, error) {
+	if r.Name == "" || r.Type == "" {
+		return nil, errors.New("name and type are required")
+	}
+	name := qualifyName(r.Name, zone)
+	if !inZone(name, zone) {
+		return nil, errors.New("record " + r.Name + " is outside of zone " + zone)
+	}
+	set := &route53.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: aws.String(strings.ToUpper(r.Type)),
+	}
+	switch {
+	case r.Alias != nil && len(r.Values) > 0:
+		return nil, errors.New("record " + r.Name + " has both values and an alias")
+	case r.Alias != nil:
+		set.AliasTarget = &route53.AliasTarget{
+			DNSName:              aws.String(r.Alias.DNSName),
+			HostedZoneId:         aws.String(r.Alias.ZoneID),
+			EvaluateTargetHealth: aws.Bool(r.Alias.EvaluateTargetHealth),
+		}
+	case len(r.Values) > 0:
+		set.ResourceRecords = resourceRecords(formatRecordValues(*set.Type, r.Values))
+		set.TTL = aws.Int64(defaultTTL(*set.Type))
+		if r.TTL != nil {
+			set.TTL = r.TTL
+		}
+	default:
+		return nil, errors.New("record " + r.Name + " has no values")
+	}
+	if r.SetIdentifier != "" {
+		set.SetIdentifier = aws.String(r.SetIdentifier)
+	}
+	set.Weight = r.Weight
+	if r.Region != "" {
+		set.Region = aws.String(r.Region)
+	}
+	if r.Failover != "" {
+		set.Failover = aws.String(r.Failover)
+	}
+	if g := r.GeoLocation; g != nil {
+		set.GeoLocation = &route53.GeoLocation{}
+		if g.Continent != "" {
+			set.GeoLocation.ContinentCode = aws.String(g.Continent)
+		}
+		if g.Country != "" {
+			set.GeoLocation.CountryCode = aws.String(g.Country)
+		}
+		if g.Subdivision != "" {
+			set.GeoLocation.SubdivisionCode = aws.String(g.Subdivision)
+		}
+	}
+	if r.MultiValueAnswer {
+		set.MultiValueAnswer = aws.Bool(true)
+	}
+	if r.HealthCheckID != "" {
+		set.HealthCheckId = aws.String(r.HealthCheckID)
+	}
+	routed := r.Weight != nil || r.Region != "" || r.Failover != "" || r.GeoLocation != nil || r.MultiValueAnswer
+	if routed != (r.SetIdentifier != "") {
+		return nil, errors.New("record " + r.Name + " needs both a set_identifier and a routing policy, or neither")
+	}
+	return set, nil
+}
+
+// importedRecordSets returns the record sets of records, apart from the SOA
+// and NS records of the apex, which belong to the zone, and the ownership
+// markers exported along with the records.
+func importedRecordSets(records []exportedRecord, zone string) (desired, markers map[syncKey]*route53.ResourceRecordSet, err error) {
+	desired = map[syncKey]*route53.ResourceRecordSet{}
+	markers = map[syncKey]*route53.ResourceRecordSet{}
+	for i, r := range records {
+		set, err := r.recordSet(zone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Record %d: %v", i+1, err)
+		}
+		k := setKey(set)
+		if k.name == zone && (k.rrType == route53.RRTypeSoa || k.rrType == route53.RRTypeNs) {
+			continue
+		}
+		if desired[k] != nil || markers[k] != nil {
+			return nil, nil, fmt.Errorf("Record %d: %s %s is listed more than once", i+1, k.name, k.rrType)
+		}
+		if _, ok := isOwnerRecordName(k.name); ok && k.rrType == route53.RRTypeTxt {
+			markers[k] = set
+			continue
+		}
+		desired[k] = set
+	}
+	return desired, markers, nil
+}
+
+// importChanges returns the changes creating or updating the desired record
+// sets, split into batches of up to size records and within the elements
+// Route53 takes in one batch. Records that exist without an ownership marker
+// are left alone, like sync does. The records sharing a marker set are
+// changed in the same batch, as a batch may change the marker set only once.
+func importChanges(sets []*route53.ResourceRecordSet, desired, fileMarkers map[syncKey]*route53.ResourceRecordSet, now time.Time, size int) [][]*route53.Change {
+	current := map[syncKey]*route53.ResourceRecordSet{}
+	for _, set := range sets {
+		current[setKey(set)] = set
+	}
+	owned := ownedRecords(sets)
+
+	var keys []syncKey
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	byMarker := map[syncKey][]syncKey{}
+	var markerKeys []syncKey
+	for _, k := range sortKeys(keys) {
+		want, live := desired[k], current[k]
+		if live != nil && sameRecordSet(live, want) {
+			continue
+		}
+		if live != nil && !owned[ownedKey(k.name, k.setIdentifier)] {
+			logger.Warn("Record exists but wasn't created by this tool, leaving it alone", fields{"record_name": k.name, "record_type": k.rrType})
+			continue
+		}
+		mk := k.markerKey()
+		if byMarker[mk] == nil {
+			markerKeys = append(markerKeys, mk)
+		}
+		byMarker[mk] = append(byMarker[mk], k)
+	}
+
+	var batches [][]*route53.Change
+	var batch []*route53.Change
+	records, elements := 0, 0
+	for _, mk := range markerKeys {
+		ks := byMarker[mk]
+		var group []*route53.Change
+		for _, k := range ks {
+			group = append(group, &route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: desired[k],
+			})
+		}
+		// The markers go on as they are, or as exported when the zone
+		// lost them, each record getting one of import on top
+		base := current[mk]
+		if base == nil {
+			base = fileMarkers[mk]
+		}
+		var values []string
+		for _, v := range recordValues(base) {
+			if m, ok := parseOwnerMarker(v); !ok || m.source != importSource {
+				values = append(values, v)
+			}
+		}
+		for _, m := range importMarkers(current[mk], ks, now) {
+			values = append(values, m.String())
+		}
+		group = append(group, syncMarkerChange(current[mk], mk, values))
+		groupElements := batchElements(group)
+		if records > 0 && (records+len(ks) > size || elements+groupElements > maxBatchElements) {
+			batches = append(batches, batch)
+			batch, records, elements = nil, 0, 0
+		}
+		batch = append(batch, group...)
+		records += len(ks)
+		elements += groupElements
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// importMarkers returns the markers of import for the records ks sharing
+// the marker set live, which is kept for the records of ks that are
+// unchanged by this import but were imported before.
+func importMarkers(live *route53.ResourceRecordSet, ks []syncKey, now time.Time) []ownerMarker {
+	var markers []ownerMarker
+	for _, v := range recordValues(live) {
+		if m, ok := parseOwnerMarker(v); ok && m.source == importSource && !containsKeyType(ks, m.id) {
+			markers = append(markers, m)
+		}
+	}
+	for _, k := range ks {
+		markers = append(markers, ownerMarker{id: k.rrType, registered: now, source: importSource})
+	}
+	return markers
+}
+
+func containsKeyType(ks []syncKey, rrType string) bool {
+	for _, k := range ks {
+		if k.rrType == rrType {
+			return true
+		}
+	}
+	return false
+}
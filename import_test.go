@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestReadCSVRecords(t *testing.T) {
+	in := `name,type,value
+# comment
+web, A, 10.0.0.1
+Web.,A,10.0.0.2
+web,aaaa,2001:db8::1
+txt,TXT,"v=spf1 -all"
+`
+	want := []exportedRecord{
+		{Name: "web", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "web", Type: "aaaa", Values: []string{"2001:db8::1"}},
+		{Name: "txt", Type: "TXT", Values: []string{"v=spf1 -all"}},
+	}
+	got, err := readCSVRecords(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCSVRecords = %+v, want %+v", got, want)
+	}
+
+	for _, in := range []string{"web,A\n", "web,A,10.0.0.1,60\n", "web,A,\"10.0.0.1\n"} {
+		if _, err := readCSVRecords(strings.NewReader(in)); err == nil {
+			t.Errorf("readCSVRecords(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestImportChanges(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	set := func(name, rrType string, values ...string) *route53.ResourceRecordSet {
+		s, err := exportedRecord{Name: name, Type: rrType, Values: values}.recordSet("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	imported := ownerMarker{id: "A", registered: now.Add(-time.Hour), source: importSource}
+	registered := ownerMarker{id: "web-host", registered: now.Add(-time.Hour)}
+	sets := []*route53.ResourceRecordSet{
+		set("old", "A", "10.0.0.1"),
+		set("_route53_register.old", "TXT", imported.String(), registered.String()),
+		set("same", "A", "10.0.0.2"),
+		set("_route53_register.same", "TXT", imported.String()),
+		set("manual", "A", "10.0.0.3"),
+	}
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for _, s := range []*route53.ResourceRecordSet{
+		set("old", "A", "10.0.1.1"),
+		set("old", "AAAA", "2001:db8::1"),
+		set("same", "A", "10.0.0.2"),
+		set("manual", "A", "10.0.1.3"),
+		set("new", "A", "10.0.1.4"),
+	} {
+		desired[setKey(s)] = s
+	}
+
+	batches := importChanges(sets, desired, nil, now, 2)
+	// The records of old share their marker set, so they go in one batch
+	// even though it gets over the size
+	want := [][]string{
+		{"UPSERT new.example.com A", "UPSERT _route53_register.new.example.com TXT"},
+		{"UPSERT old.example.com A", "UPSERT old.example.com AAAA", "UPSERT _route53_register.old.example.com TXT"},
+	}
+	var got [][]string
+	for _, batch := range batches {
+		var changes []string
+		for _, c := range batch {
+			changes = append(changes, aws.StringValue(c.Action)+" "+normalizeName(aws.StringValue(c.ResourceRecordSet.Name))+" "+aws.StringValue(c.ResourceRecordSet.Type))
+		}
+		got = append(got, changes)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("importChanges = %q, want %q", got, want)
+	}
+
+	// The marker of the host stays, import gets one marker per record type
+	var ids []string
+	for _, v := range recordValues(batches[1][2].ResourceRecordSet) {
+		m, ok := parseOwnerMarker(v)
+		if !ok {
+			t.Errorf("marker value %s isn't an ownership marker", v)
+			continue
+		}
+		if m.source == importSource && !m.registered.Equal(now) {
+			t.Errorf("marker %s wasn't refreshed", v)
+		}
+		ids = append(ids, m.source+":"+m.id)
+	}
+	if want := []string{":web-host", "import:A", "import:AAAA"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("marker ids = %q, want %q", ids, want)
+	}
+
+	if batches := importChanges(sets, map[syncKey]*route53.ResourceRecordSet{setKey(sets[2]): sets[2]}, nil, now, 2); len(batches) != 0 {
+		t.Errorf("importChanges of an imported record = %s, want no changes", batches)
+	}
+}
+
+func TestImportChangesElements(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for _, name := range []string{"a", "b", "c"} {
+		var values []string
+		for i := 0; i < 200; i++ {
+			values = append(values, fmt.Sprintf("10.0.%d.%d", i/250, i%250))
+		}
+		s, err := exportedRecord{Name: name, Type: "A", Values: values}.recordSet("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		desired[setKey(s)] = s
+	}
+	// Each record and its marker make 402 elements, so the third one goes
+	// in a batch of its own although the size allows more records
+	batches := importChanges(nil, desired, nil, now, maxBatchRecords)
+	var sizes []int
+	for _, batch := range batches {
+		if n := batchElements(batch); n > maxBatchElements {
+			t.Errorf("batch of %d elements, want at most %d", n, maxBatchElements)
+		}
+		sizes = append(sizes, len(batch))
+	}
+	if want := []int{4, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("importChanges made batches of %v changes, want %v", sizes, want)
+	}
+}
+
+func TestBatchElements(t *testing.T) {
+	set := testRecordSet("web.example.com", "A", "", 0, "10.0.0.1", "10.0.0.2")
+	changes := []*route53.Change{
+		{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: set},
+		{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: set},
+		{Action: aws.String(route53.ChangeActionCreate), ResourceRecordSet: set},
+	}
+	if got := batchElements(changes); got != 8 {
+		t.Errorf("batchElements = %d, want 8", got)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// serviceAccountDir is where Kubernetes mounts the token and CA certificate
+// of a pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient calls the Kubernetes API as the pod's service account, or
+// through kubectl proxy outside of a cluster. The few calls we make don't
+// warrant depending on client-go.
+type kubeClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newKubeClient returns a client of the API server at apiURL, or of the
+// cluster the pod runs in when it's empty.
+func newKubeClient(apiURL string) (*kubeClient, error) {
+	if apiURL != "" {
+		return &kubeClient{baseURL: strings.TrimSuffix(apiURL, "/"), client: &http.Client{Timeout: time.Minute}}, nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, configError("Not running in a Kubernetes cluster, give the API server with -kube-api, e.g. http://127.0.0.1:8001 for kubectl proxy")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	pem, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, configError("No certificates found in " + serviceAccountDir + "/ca.crt")
+	}
+	return &kubeClient{
+		baseURL: "https://" + net.JoinHostPort(host, port),
+		token:   strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   time.Minute,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}, nil
+}
+
+// do makes a request of the API, decoding the response into out unless it
+// is nil.
+func (c *kubeClient) do(method, path, contentType string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		// The token is the pod's, refreshed by the kubelet on disk
+		if token, err := ioutil.ReadFile(serviceAccountDir + "/token"); err == nil {
+			c.token = strings.TrimSpace(string(token))
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &status) == nil && status.Message != "" {
+			return fmt.Errorf("Kubernetes API %s %s: %s: %s", method, path, resp.Status, status.Message)
+		}
+		return fmt.Errorf("Kubernetes API %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *kubeClient) get(path string, out interface{}) error {
+	return c.do("GET", path, "", nil, out)
+}
+
+// patchStatus replaces the status of the object at path, which must have a
+// status subresource.
+func (c *kubeClient) patchStatus(path string, status interface{}) error {
+	return c.do("PATCH", path+"/status", "application/merge-patch+json", map[string]interface{}{"status": status}, nil)
+}
+
+// namespaceScope is the part of API paths limiting a list to namespace, all
+// namespaces when it's empty.
+func namespaceScope(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	return "/namespaces/" + url.PathEscape(namespace)
+}
+
+// kubeMeta is the part of the metadata of Kubernetes objects we use.
+type kubeMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Generation  int64             `json:"generation"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+func (m kubeMeta) String() string {
+	return m.Namespace + "/" + m.Name
+}
+
+// kubeLoadBalancer is the status of a Service of type LoadBalancer or an
+// Ingress once it got a load balancer.
+type kubeLoadBalancer struct {
+	Ingress []struct {
+		IP       string `json:"ip"`
+		Hostname string `json:"hostname"`
+	} `json:"ingress"`
+}
+
+// The annotations a Service or Ingress opts in with. hostnameAnnotation
+// lists the names to register, separated by commas, either relative to the
+// zone or full names. aliasZoneAnnotation makes the records alias A records
+// to the load balancer, which is in that hosted zone, instead of CNAMEs.
+const (
+	hostnameAnnotation  = "route53-register/hostname"
+	aliasZoneAnnotation = "route53-register/alias-zone-id"
+)
+
+// kubernetesSource tags the ownership markers of the records kept for
+// Kubernetes objects. With -cluster-name it is suffixed by the name of the
+// cluster, so each cluster only removes its own records.
+const kubernetesSource = "k8s"
+
+// kubeObject is a Service or an Ingress with the load balancer it got.
+type kubeObject struct {
+	kind         string
+	meta         kubeMeta
+	loadBalancer kubeLoadBalancer
+}
+
+type kubeServiceList struct {
+	Items []struct {
+		Metadata kubeMeta `json:"metadata"`
+		Spec     struct {
+			Type string `json:"type"`
+		} `json:"spec"`
+		Status struct {
+			LoadBalancer kubeLoadBalancer `json:"loadBalancer"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubeIngressList struct {
+	Items []struct {
+		Metadata kubeMeta `json:"metadata"`
+		Status   struct {
+			LoadBalancer kubeLoadBalancer `json:"loadBalancer"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// clusterSource returns the source of the records kept for a cluster,
+// telling the clusters sharing a zone apart when they're given names.
+func clusterSource(source, clusterName string) (string, error) {
+	if clusterName == "" {
+		return source, nil
+	}
+	if strings.ContainsAny(clusterName, ",=\" ") {
+		return "", configError("The cluster-name parameter can't contain commas, equal signs, quotes or spaces")
+	}
+	return source + ":" + clusterName, nil
+}
+
+func runKubernetes(args []string) error {
+	var o options
+	fs := newFlagSet("kubernetes")
+	o.addZoneFlags(fs)
+	apiURL := fs.String("kube-api", "", "URL of the Kubernetes API server, e.g. http://127.0.0.1:8001 for kubectl proxy (default: the cluster this runs in)")
+	namespace := fs.String("namespace", "", "only register the Services and Ingresses of this namespace (default: all namespaces)")
+	ingresses := fs.Bool("ingresses", true, "register Ingresses as well as Services of type LoadBalancer")
+	clusterName := fs.String("cluster-name", "", "name of this cluster, so several clusters may keep records in the same zone")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the A and CNAME records in seconds (default 60 for A, 300 for CNAME records)")
+	interval := fs.Duration("interval", time.Minute, "how often to reconcile the records with the cluster")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	source, err := clusterSource(kubernetesSource, *clusterName)
+	if err != nil {
+		return err
+	}
+	kube, err := newKubeClient(*apiURL)
+	if err != nil {
+		return err
+	}
+	return o.repeat("kubernetes", fields{"source": source}, *once, *interval, func(ctx context.Context) error {
+		objects, err := kube.loadBalancers(*namespace, *ingresses)
+		if err != nil {
+			return err
+		}
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		desired := o.kubeRecords(objects)
+		r53, err := newRoute53Client(o.logLevel())
+		if err != nil {
+			return err
+		}
+		sets, err := listRecordSets(ctx, r53, zoneID)
+		if err != nil {
+			return err
+		}
+		changes := syncChanges(sets, desired, nil, source, false, time.Now())
+		return submitSyncChanges(ctx, r53, zoneID, "Kubernetes Records Synced", sets, changes, len(desired), *dryRun)
+	})
+}
+
+// loadBalancers lists the Services of type LoadBalancer, and the Ingresses,
+// that carry the hostname annotation.
+func (c *kubeClient) loadBalancers(namespace string, ingresses bool) ([]kubeObject, error) {
+	scope := namespaceScope(namespace)
+	var objects []kubeObject
+	var services kubeServiceList
+	if err := c.get("/api/v1"+scope+"/services", &services); err != nil {
+		return nil, err
+	}
+	for _, s := range services.Items {
+		if s.Spec.Type == "LoadBalancer" && s.Metadata.Annotations[hostnameAnnotation] != "" {
+			objects = append(objects, kubeObject{"Service", s.Metadata, s.Status.LoadBalancer})
+		}
+	}
+	if ingresses {
+		var list kubeIngressList
+		if err := c.get("/apis/networking.k8s.io/v1"+scope+"/ingresses", &list); err != nil {
+			return nil, err
+		}
+		for _, i := range list.Items {
+			if i.Metadata.Annotations[hostnameAnnotation] != "" {
+				objects = append(objects, kubeObject{"Ingress", i.Metadata, i.Status.LoadBalancer})
+			}
+		}
+	}
+	// The first object claiming a name keeps it, whatever order the API
+	// returned them in
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].meta.String() != objects[j].meta.String() {
+			return objects[i].meta.String() < objects[j].meta.String()
+		}
+		return objects[i].kind > objects[j].kind
+	})
+	return objects, nil
+}
+
+// kubeRecords returns the record sets the objects call for. Objects still
+// waiting for their load balancer, and names that can't be registered, are
+// left out with a warning rather than failing the others.
+func (o *options) kubeRecords(objects []kubeObject) map[syncKey]*route53.ResourceRecordSet {
+	zone := o.zone()
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	claimed := map[string]string{}
+	for _, obj := range objects {
+		f := fields{"kind": obj.kind, "object": obj.meta.String()}
+		var ips []string
+		hostname := ""
+		for _, ingress := range obj.loadBalancer.Ingress {
+			if ingress.IP != "" {
+				ips = append(ips, ingress.IP)
+			}
+			if ingress.Hostname != "" && hostname == "" {
+				hostname = ingress.Hostname
+			}
+		}
+		if len(ips) == 0 && hostname == "" {
+			logger.Debug("Object has no load balancer yet, leaving it out", f)
+			continue
+		}
+		aliasZoneID := obj.meta.Annotations[aliasZoneAnnotation]
+		for _, h := range strings.Split(obj.meta.Annotations[hostnameAnnotation], ",") {
+			h = strings.TrimSpace(h)
+			name := qualifyName(h, zone)
+			f := fields{"kind": obj.kind, "object": obj.meta.String(), "hostname": h}
+			if h == "" || !inZone(name, zone) {
+				logger.Warn("Object is annotated with a name that isn't in the zone, leaving it out", f)
+				continue
+			}
+			if err := validateDNSName(name); err != nil {
+				logger.Warn("Object is annotated with an invalid name, leaving it out", errorFields(err, f))
+				continue
+			}
+			if owner, ok := claimed[name]; ok {
+				f["claimed_by"] = owner
+				logger.Warn("Name is already claimed by another object, leaving it out", f)
+				continue
+			}
+			set := &route53.ResourceRecordSet{Name: aws.String(name)}
+			switch {
+			case hostname != "" && aliasZoneID != "":
+				set.Type = aws.String(route53.RRTypeA)
+				set.AliasTarget = &route53.AliasTarget{
+					DNSName:              aws.String(hostname),
+					HostedZoneId:         aws.String(aliasZoneID),
+					EvaluateTargetHealth: aws.Bool(false),
+				}
+			case hostname != "":
+				if name == zone {
+					logger.Warn("The zone apex can't have a CNAME record, annotate the object with "+aliasZoneAnnotation+" for an alias record, leaving it out", f)
+					continue
+				}
+				set.Type = aws.String(route53.RRTypeCname)
+				set.ResourceRecords = resourceRecords([]string{hostname})
+				set.TTL = aws.Int64(recordTTL(o.ttl, route53.RRTypeCname))
+			default:
+				sort.Strings(ips)
+				set.Type = aws.String(route53.RRTypeA)
+				set.ResourceRecords = resourceRecords(ips)
+				set.TTL = aws.Int64(recordTTL(o.ttl, route53.RRTypeA))
+			}
+			claimed[name] = obj.kind + " " + obj.meta.String()
+			desired[setKey(set)] = set
+		}
+	}
+	return desired
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// listedRecord is how list prints a record set.
+type listedRecord struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Values        []string `json:"values,omitempty"`
+	AliasTarget   string   `json:"alias_target,omitempty"`
+	TTL           *int64   `json:"ttl,omitempty"`
+	Weight        *int64   `json:"weight,omitempty"`
+	SetIdentifier string   `json:"set_identifier,omitempty"`
+	HealthCheckID string   `json:"health_check_id,omitempty"`
+	Owned         bool     `json:"owned"`
+}
+
+func runList(args []string) error {
+	var o options
+	fs := newFlagSet("list")
+	o.addZoneFlags(fs)
+	prefix := fs.String("prefix", "", "only list records whose name starts with this prefix")
+	owned := fs.Bool("owned", false, "only list records registered by this tool")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected table or json", *format))
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+
+	owners := ownedRecords(sets)
+	records := []listedRecord{}
+	for _, set := range sets {
+		// Wildcard names are listed with their *, not the \052 Route53 returns
+		name := unescapeName(aws.StringValue(set.Name))
+		if _, ok := isOwnerRecordName(name); ok || !strings.HasPrefix(name, *prefix) {
+			continue
+		}
+		isOwned := owners[ownedKey(name, aws.StringValue(set.SetIdentifier))]
+		if *owned && !isOwned {
+			continue
+		}
+		r := listedRecord{
+			Name:          name,
+			Type:          aws.StringValue(set.Type),
+			Values:        recordValues(set),
+			TTL:           set.TTL,
+			Weight:        set.Weight,
+			SetIdentifier: aws.StringValue(set.SetIdentifier),
+			HealthCheckID: aws.StringValue(set.HealthCheckId),
+			Owned:         isOwned,
+		}
+		if set.AliasTarget != nil {
+			r.AliasTarget = aws.StringValue(set.AliasTarget.DNSName)
+		}
+		records = append(records, r)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tVALUES\tTTL\tWEIGHT\tSET ID\tHEALTH CHECK\tOWNED")
+	for _, r := range records {
+		values := strings.Join(r.Values, ",")
+		if r.AliasTarget != "" {
+			values = "ALIAS " + r.AliasTarget
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\n", r.Name, r.Type, values,
+			optionalInt(r.TTL), optionalInt(r.Weight), dash(r.SetIdentifier), dash(r.HealthCheckID), r.Owned)
+	}
+	return w.Flush()
+}
+
+func ownedKey(name, setIdentifier string) string {
+	return normalizeName(name) + "\x00" + setIdentifier
+}
+
+// ownedRecords returns the set of name/set identifier pairs (see ownedKey)
+// that carry an ownership marker.
+func ownedRecords(sets []*route53.ResourceRecordSet) map[string]bool {
+	owned := map[string]bool{}
+	for _, set := range sets {
+		name, ok := isOwnerRecordName(aws.StringValue(set.Name))
+		if !ok || aws.StringValue(set.Type) != route53.RRTypeTxt {
+			continue
+		}
+		for _, v := range recordValues(set) {
+			if _, ok := parseOwnerMarker(v); ok {
+				owned[ownedKey(name, aws.StringValue(set.SetIdentifier))] = true
+				break
+			}
+		}
+	}
+	return owned
+}
+
+func optionalInt(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// localHealth is the state of the local service the daemon probes with
+// -health-probe. The records are taken out of service after
+// -unhealthy-threshold failed probes in a row and put back after
+// -healthy-threshold successful ones.
+type localHealth struct {
+	url                string
+	unhealthyThreshold int
+	healthyThreshold   int
+	client             *http.Client
+
+	// failures and successes count the probes in a row with that outcome
+	failures, successes int
+	// unhealthy is what the probes say; the daemon keeps track of what was
+	// applied to the records, which lags behind when changing them failed
+	unhealthy bool
+}
+
+// validateHealthProbe checks the -health-probe parameters. Draining needs a
+// weight, so it doesn't apply to shared records.
+func (o *options) validateHealthProbe(regs []*options) error {
+	if err := validateHealthURL(o.healthProbeURL); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if o.healthProbeInterval <= 0 {
+		return configError("The health-probe-interval parameter must be positive")
+	}
+	if o.unhealthyThreshold < 1 || o.healthyThreshold < 1 {
+		return configError("The unhealthy-threshold and healthy-threshold parameters must be at least 1")
+	}
+	return validateOutOfServiceAction("unhealthy-action", o.unhealthyAction, regs)
+}
+
+// validateOutOfServiceAction checks the action of the parameter name, which
+// takes the records of regs out of service.
+func validateOutOfServiceAction(name, action string, regs []*options) error {
+	switch action {
+	case "drain":
+		for _, r := range regs {
+			if r.shared {
+				return configError("Shared records have no weight to drain, use -" + name + " deregister")
+			}
+		}
+	case "deregister":
+	default:
+		return configError("The " + name + " parameter must be drain or deregister")
+	}
+	return nil
+}
+
+func (o *options) newLocalHealth() *localHealth {
+	return &localHealth{
+		url:                o.healthProbeURL,
+		unhealthyThreshold: o.unhealthyThreshold,
+		healthyThreshold:   o.healthyThreshold,
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+}
+
+// probe probes the service once, updating whether it's considered unhealthy.
+func (h *localHealth) probe(ctx context.Context) {
+	f := fields{"url": h.url}
+	if err := probeHealth(ctx, h.client, h.url); err != nil {
+		h.failures, h.successes = h.failures+1, 0
+		f["failures"] = h.failures
+		logger.Debug("Health probe failed", errorFields(err, f))
+		if !h.unhealthy && h.failures >= h.unhealthyThreshold {
+			h.unhealthy = true
+			logger.Warn("Service is unhealthy, taking the records out of service", errorFields(err, f))
+		}
+		return
+	}
+	h.failures, h.successes = 0, h.successes+1
+	if h.unhealthy && h.successes >= h.healthyThreshold {
+		h.unhealthy = false
+		logger.Info("Service recovered, putting the records back into service", f)
+	}
+}
+
+// setInService takes the records of regs out of service with action, or
+// puts them back into service, reporting whether all of them were changed.
+// Records that couldn't be changed are tried again later.
+func (o *options) setInService(running context.Context, regs []*options, action string, inService bool) bool {
+	ctx, cancel := o.withTimeout(running)
+	defer cancel()
+	operation, change := action, drainRegistered(true)
+	switch {
+	case inService && action == "drain":
+		operation, change = "undrain", drainRegistered(false)
+	case !inService && action == "deregister":
+		change = deregisterTargets
+	case inService:
+		operation, change = "register", registerTargets
+	}
+	failed := false
+	for _, r := range regs {
+		if err := r.changeHostRecord(ctx, operation, change); err != nil {
+			logger.Error("Changing record failed, trying again later", errorFields(err, fields{"hostname": r.hostnames.String(), "zone_name": r.zoneName, "operation": operation}))
+			failed = true
+		}
+	}
+	return !failed
+}
+
+// drainRegistered drains or undrains the records of the targets that are
+// registered. Those that aren't carry no traffic to drain, and the daemon
+// registers them once they're back in service.
+func drainRegistered(drain bool) hostChange {
+	return func(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+		var registered []*target
+		for _, t := range ts {
+			sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+			if err != nil {
+				return nil, err
+			}
+			if findIdentifiedSet(sets, t.setIdentifier) != nil {
+				registered = append(registered, t)
+			}
+		}
+		if len(registered) == 0 {
+			return nil, nil
+		}
+		return setDrained(ctx, r53, registered, drain)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// lockLease is how long a lock is held before other hosts may consider its
+// owner dead and take it over.
+const lockLease = 2 * time.Minute
+
+// fleetLock is a lease on an item in a DynamoDB table (partition key LockID,
+// type string) used to serialize changes to a record many hosts write to.
+type fleetLock struct {
+	db    *dynamodb.DynamoDB
+	table string
+	key   string
+	owner string
+}
+
+func newFleetLock(ctx context.Context, table, key string, metadataClient *ec2metadata.EC2Metadata, logLevel *aws.LogLevelType) (*fleetLock, error) {
+	sess, cfg, err := newRegionalSession(ctx, metadataClient, logLevel)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &fleetLock{
+		db:    dynamodb.New(sess, cfg),
+		table: table,
+		key:   key,
+		owner: fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+	}, nil
+}
+
+// Acquire blocks until the lock is taken or timeout elapses. A lock whose
+// lease has expired is taken over.
+func (l *fleetLock) Acquire(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for wait := 250 * time.Millisecond; ; wait *= 2 {
+		now := time.Now()
+		_, err := l.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(l.table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"LockID":  {S: aws.String(l.key)},
+				"Owner":   {S: aws.String(l.owner)},
+				"Expires": {N: aws.String(strconv.FormatInt(now.Add(lockLease).Unix(), 10))},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(LockID) OR Expires < :now"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			},
+		})
+		if err == nil {
+			logger.Debug("Acquired lock", fields{"lock": l.key, "owner": l.owner})
+			return nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+			return err
+		}
+		if now.Add(wait).After(deadline) {
+			return errors.New("timed out waiting for lock " + l.key)
+		}
+		if wait > 5*time.Second {
+			wait = 5 * time.Second
+		}
+		if err = sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Release gives the lock up, unless it has already been taken over by
+// somebody else after our lease expired.
+func (l *fleetLock) Release(ctx context.Context) error {
+	_, err := l.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(l.key)},
+		},
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(l.owner)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		logger.Warn("Lock was taken over by another host before release", fields{"lock": l.key, "owner": l.owner})
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+type severity int
+
+const (
+	levelDebug severity = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var severityNames = map[severity]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func parseSeverity(name string) (severity, error) {
+	for s, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q, expected debug, info, warn or error", name)
+}
+
+// fields are the structured attributes of a log line, e.g. zone_id or record_name.
+type fields map[string]interface{}
+
+// leveledLogger writes log lines as text or as one JSON object per line.
+type leveledLogger struct {
+	mu         sync.Mutex
+	out        io.Writer
+	json       bool
+	minimum    severity
+	timeFormat string
+	// system, when set, gets every line as well, e.g. to write it to the
+	// Windows event log when we run as a service
+	system func(s severity, line string)
+}
+
+var logger = &leveledLogger{out: os.Stderr, minimum: levelInfo, timeFormat: "2006/01/02 15:04:05"}
+
+func (l *leveledLogger) configure(format, level string) error {
+	minimum, err := parseSeverity(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "text":
+		l.json = false
+	case "json":
+		l.json = true
+	default:
+		return fmt.Errorf("Unknown log format %q, expected text or json", format)
+	}
+	l.mu.Lock()
+	l.minimum = minimum
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *leveledLogger) Debug(msg string, f fields) { l.log(levelDebug, msg, f) }
+func (l *leveledLogger) Info(msg string, f fields)  { l.log(levelInfo, msg, f) }
+func (l *leveledLogger) Warn(msg string, f fields)  { l.log(levelWarn, msg, f) }
+func (l *leveledLogger) Error(msg string, f fields) { l.log(levelError, msg, f) }
+
+func (l *leveledLogger) log(s severity, msg string, f fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s < l.minimum {
+		return
+	}
+	now := time.Now()
+	if l.json {
+		line := map[string]interface{}{}
+		for k, v := range f {
+			line[k] = v
+		}
+		line["time"] = now.UTC().Format(time.RFC3339Nano)
+		line["level"] = severityNames[s]
+		line["msg"] = msg
+		b, err := json.Marshal(line)
+		if err != nil {
+			b = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+		}
+		l.out.Write(append(b, '\n'))
+		if l.system != nil {
+			l.system(s, string(b))
+		}
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s", now.Format(l.timeFormat), strings.ToUpper(severityNames[s]), msg)
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, " %s=%v", k, f[k])
+	}
+	if l.system != nil {
+		l.system(s, buf.String())
+	}
+	buf.WriteByte('\n')
+	l.out.Write(buf.Bytes())
+}
+
+// errorFields describes err for logging, including the AWS error code and
+// request id when it comes from an AWS API call.
+func errorFields(err error, f fields) fields {
+	out := fields{"error": err.Error()}
+	for k, v := range f {
+		out[k] = v
+	}
+	err = unwrapExitError(err)
+	if aerr, ok := err.(awserr.Error); ok {
+		if aerr.Message() != "" {
+			out["error"] = aerr.Message()
+		}
+		out["aws_error_code"] = aerr.Code()
+		if aerr.OrigErr() != nil {
+			out["cause"] = aerr.OrigErr().Error()
+		}
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		out["aws_request_id"] = rerr.RequestID()
+	}
+	if f := describeFailure(err); f != nil {
+		out["error_kind"], out["hint"] = string(f.kind), f.hint
+		for k, v := range f.fields {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxSPFLookups is how many mechanisms of an SPF record may need a DNS
+// lookup, RFC 7208 failing the check of records with more.
+const maxSPFLookups = 10
+
+// minDKIMKeyBits is the smallest RSA key RFC 8301 lets verifiers accept.
+const minDKIMKeyBits = 1024
+
+// mailRecord is the TXT record of a mail authentication scheme. Its name
+// may hold TXT values of other uses, e.g. domain verifications at the
+// apex, which are kept as they are.
+type mailRecord struct {
+	// scheme is SPF, DKIM or DMARC
+	scheme string
+	name   string
+	// version is the tag its value starts with, telling it apart from the
+	// other values of the name
+	version string
+	value   string
+}
+
+// mailFlags are the flags the spf, dkim and dmarc commands share.
+type mailFlags struct {
+	hostname string
+	ttl      int64
+	print    bool
+}
+
+func (o *options) addMailFlags(fs *flag.FlagSet, m *mailFlags) {
+	o.addZoneFlags(fs)
+	fs.StringVar(&m.hostname, "hostname", apexHostname, "domain the record is for, relative to the zone, @ for the zone apex")
+	fs.Int64Var(&m.ttl, "ttl", 0, "TTL of the record in seconds (default 300)")
+	fs.BoolVar(&m.print, "print", false, "print the record as a zone file line instead of changing it in the zone, e.g. to add it at another DNS provider")
+}
+
+// mailDomain returns the zone id, unless only printing, and the full name
+// of the -hostname the record is for.
+func (o *options) mailDomain(ctx context.Context, m *mailFlags) (string, string, error) {
+	if err := o.validateZone(); err != nil {
+		return "", "", err
+	}
+	if m.ttl < 0 || m.ttl > maxTTL {
+		return "", "", configError("The ttl parameter must be between 0 and " + strconv.Itoa(maxTTL))
+	}
+	zoneID := ""
+	if !m.print || o.zoneName == "" {
+		var err error
+		if zoneID, err = o.resolveZoneID(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	domain := o.recordName(m.hostname)
+	if !inZone(domain, normalizeName(o.zone())) {
+		return "", "", configError("Hostname " + m.hostname + " is not in zone " + o.zone())
+	}
+	if err := validateDNSName(domain); err != nil {
+		return "", "", withExitCode(exitConfig, err)
+	}
+	return zoneID, domain, nil
+}
+
+// putMailRecord prints r, or replaces the value of its scheme among the TXT
+// values of its name with it.
+func (o *options) putMailRecord(ctx context.Context, zoneID string, m *mailFlags, r mailRecord) error {
+	ttl := recordTTL(m.ttl, route53.RRTypeTxt)
+	if m.print {
+		fmt.Printf("%s.\t%d\tIN\tTXT\t%s\n", r.name, ttl, formatTXT(r.value))
+		return nil
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := findRecordSets(ctx, r53, zoneID, r.name, route53.RRTypeTxt)
+	if err != nil {
+		return err
+	}
+	current := findPlainSet(sets)
+	values, changed := r.values(current, ttl)
+	f := fields{"zone_id": zoneID, "record_name": r.name, "record_type": route53.RRTypeTxt, "value": r.value}
+	if !changed {
+		logger.Info("Record is up to date", f)
+		return nil
+	}
+	changes := replaceRecordSet(current, r.name, route53.RRTypeTxt, ttl, values)
+	info, err := submitChanges(ctx, r53, zoneID, r.scheme+" Record Updated", changes)
+	if err != nil {
+		return err
+	}
+	f["change_id"] = aws.StringValue(info.Id)
+	logger.Info("Record updated", f)
+	return nil
+}
+
+// values returns the TXT values of current with the one of r's scheme
+// replaced by r, and whether that changes them or their ttl.
+func (r mailRecord) values(current *route53.ResourceRecordSet, ttl int64) ([]string, bool) {
+	values := []string{formatTXT(r.value)}
+	changed := current == nil || aws.Int64Value(current.TTL) != ttl
+	found := 0
+	for _, v := range recordValues(current) {
+		if !hasMailVersion(parseTXT(v), r.version) {
+			values = append(values, v)
+			continue
+		}
+		found++
+		changed = changed || parseTXT(v) != r.value
+	}
+	// A name with two records of a scheme has neither, receivers ignore both
+	return values, changed || found != 1
+}
+
+// hasMailVersion tells whether a TXT value is one of the scheme starting
+// with the version tag, e.g. v=spf1.
+func hasMailVersion(value, version string) bool {
+	if len(value) < len(version) || !strings.EqualFold(value[:len(version)], version) {
+		return false
+	}
+	rest := value[len(version):]
+	return rest == "" || rest[0] == ' ' || rest[0] == ';'
+}
+
+func runSPF(args []string) error {
+	var o options
+	var m mailFlags
+	fs := newFlagSet("spf")
+	o.addMailFlags(fs, &m)
+	var includes, ip4s, ip6s stringList
+	fs.Var(&includes, "include", "domain whose SPF record is included, e.g. _spf.google.com for a mail service sending on the domain's behalf (may be repeated)")
+	fs.Var(&ip4s, "ip4", "IPv4 address or CIDR range allowed to send mail (may be repeated)")
+	fs.Var(&ip6s, "ip6", "IPv6 address or CIDR range allowed to send mail (may be repeated)")
+	mx := fs.Bool("mx", false, "allow the hosts of the domain's MX record to send mail, e.g. relays registered with -mx-name")
+	a := fs.Bool("a", false, "allow the addresses of the domain's A records to send mail")
+	all := fs.String("all", "softfail", "what happens to mail from other hosts: fail, softfail or neutral")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	terms := []string{"v=spf1"}
+	if *a {
+		terms = append(terms, "a")
+	}
+	if *mx {
+		terms = append(terms, "mx")
+	}
+	for _, ip := range ip4s {
+		if err := validateSPFAddress(ip, true); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		terms = append(terms, "ip4:"+ip)
+	}
+	for _, ip := range ip6s {
+		if err := validateSPFAddress(ip, false); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		terms = append(terms, "ip6:"+ip)
+	}
+	for _, include := range includes {
+		if err := validateDNSName(normalizeName(include)); err != nil {
+			return withExitCode(exitConfig, fmt.Errorf("Invalid include: %v", err))
+		}
+		terms = append(terms, "include:"+normalizeName(include))
+	}
+	if lookups := len(includes) + boolCount(*a, *mx); lookups > maxSPFLookups {
+		return configError(fmt.Sprintf("The SPF record needs %d DNS lookups, more than the %d receivers allow", lookups, maxSPFLookups))
+	}
+	qualifiers := map[string]string{"fail": "-", "softfail": "~", "neutral": "?"}
+	qualifier, ok := qualifiers[*all]
+	if !ok {
+		return configError("Unknown all " + *all + ", expected fail, softfail or neutral")
+	}
+	terms = append(terms, qualifier+"all")
+
+	zoneID, domain, err := o.mailDomain(ctx, &m)
+	if err != nil {
+		return err
+	}
+	return o.putMailRecord(ctx, zoneID, &m, mailRecord{scheme: "SPF", name: domain, version: "v=spf1", value: strings.Join(terms, " ")})
+}
+
+func boolCount(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// validateSPFAddress checks an address or CIDR range of an ip4 or ip6
+// mechanism.
+func validateSPFAddress(s string, v4 bool) error {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(s); err != nil {
+			return fmt.Errorf("Invalid address %q, expected an address or a CIDR range", s)
+		}
+	}
+	if (ip.To4() != nil) != v4 {
+		if v4 {
+			return fmt.Errorf("%s is not an IPv4 address, use -ip6", s)
+		}
+		return fmt.Errorf("%s is not an IPv6 address, use -ip4", s)
+	}
+	return nil
+}
+
+func runDKIM(args []string) error {
+	var o options
+	var m mailFlags
+	fs := newFlagSet("dkim")
+	o.addMailFlags(fs, &m)
+	selector := fs.String("selector", "", "DKIM selector the mail is signed with, the record being named <selector>._domainkey.<hostname> (required)")
+	keyFile := fs.String("public-key-file", "", "PEM file of the public key the mail is signed with, RSA or Ed25519, as written by openssl pkey -pubout")
+	key := fs.String("public-key", "", "base64 public key the mail is signed with, instead of -public-key-file")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if *selector == "" {
+		return configError("The selector parameter is required")
+	}
+	if err := validateDNSName(normalizeName(*selector)); err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("Invalid selector: %v", err))
+	}
+	if (*keyFile == "") == (*key == "") {
+		return configError("Either the public-key-file or the public-key parameter is required")
+	}
+	data := []byte(*key)
+	if *keyFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(*keyFile); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+	keyType, encoded, err := dkimPublicKey(data)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+
+	zoneID, domain, err := o.mailDomain(ctx, &m)
+	if err != nil {
+		return err
+	}
+	name := normalizeName(*selector) + "._domainkey." + domain
+	if err := validateDNSName(name); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	value := fmt.Sprintf("v=DKIM1; k=%s; p=%s", keyType, encoded)
+	return o.putMailRecord(ctx, zoneID, &m, mailRecord{scheme: "DKIM", name: name, version: "v=DKIM1", value: value})
+}
+
+// dkimPublicKey returns the key type and p= tag of a DKIM public key given
+// as PEM or base64: the SubjectPublicKeyInfo of RSA keys, and the bare key
+// of Ed25519 keys, as RFC 8463 has it.
+func dkimPublicKey(data []byte) (string, string, error) {
+	var der []byte
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		if der, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), "")); err != nil {
+			return "", "", errors.New("The public key is neither PEM nor base64")
+		}
+	}
+	if len(der) == ed25519.PublicKeySize {
+		return "ed25519", base64.StdEncoding.EncodeToString(der), nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid public key: %v", err)
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minDKIMKeyBits {
+			return "", "", fmt.Errorf("The RSA key has %d bits, verifiers ignore signatures of keys with less than %d", k.N.BitLen(), minDKIMKeyBits)
+		}
+		return "rsa", base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "ed25519", base64.StdEncoding.EncodeToString(k), nil
+	}
+	return "", "", fmt.Errorf("Unsupported public key type %T, expected RSA or Ed25519", pub)
+}
+
+func runDMARC(args []string) error {
+	var o options
+	var m mailFlags
+	fs := newFlagSet("dmarc")
+	o.addMailFlags(fs, &m)
+	policy := fs.String("policy", "none", "what receivers do with mail failing DMARC: none (only report), quarantine or reject")
+	subdomainPolicy := fs.String("subdomain-policy", "", "policy of the subdomains of the domain: none, quarantine or reject (default -policy)")
+	pct := fs.Int("pct", 100, "percentage of the failing mail -policy applies to, for rolling it out gradually")
+	var rua, ruf stringList
+	fs.Var(&rua, "rua", "address aggregate reports are mailed to, e.g. dmarc@example.com (may be repeated)")
+	fs.Var(&ruf, "ruf", "address failure reports are mailed to (may be repeated)")
+	adkim := fs.String("adkim", "", "DKIM alignment: r (relaxed) or s (strict) (default relaxed)")
+	aspf := fs.String("aspf", "", "SPF alignment: r (relaxed) or s (strict) (default relaxed)")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	policies := []string{"none", "quarantine", "reject"}
+	if !containsString(policies, *policy) {
+		return configError("Unknown policy " + *policy + ", expected none, quarantine or reject")
+	}
+	tags := []string{"v=DMARC1", "p=" + *policy}
+	if *subdomainPolicy != "" {
+		if !containsString(policies, *subdomainPolicy) {
+			return configError("Unknown subdomain-policy " + *subdomainPolicy + ", expected none, quarantine or reject")
+		}
+		tags = append(tags, "sp="+*subdomainPolicy)
+	}
+	if *pct < 0 || *pct > 100 {
+		return configError("The pct parameter must be between 0 and 100")
+	}
+	if *pct != 100 {
+		tags = append(tags, "pct="+strconv.Itoa(*pct))
+	}
+	for _, r := range []struct {
+		tag       string
+		addresses stringList
+	}{{"rua", rua}, {"ruf", ruf}} {
+		if len(r.addresses) == 0 {
+			continue
+		}
+		var uris []string
+		for _, a := range r.addresses {
+			a = strings.TrimPrefix(a, "mailto:")
+			if i := strings.Index(a, "@"); i <= 0 || i == len(a)-1 || strings.ContainsAny(a, ",; ") {
+				return configError("Invalid " + r.tag + " address " + a + ", expected an email address")
+			}
+			uris = append(uris, "mailto:"+a)
+		}
+		tags = append(tags, r.tag+"="+strings.Join(uris, ","))
+	}
+	for _, a := range []struct{ tag, value string }{{"adkim", *adkim}, {"aspf", *aspf}} {
+		switch a.value {
+		case "":
+		case "r", "s":
+			tags = append(tags, a.tag+"="+a.value)
+		default:
+			return configError("Unknown " + a.tag + " " + a.value + ", expected r or s")
+		}
+	}
+
+	zoneID, domain, err := o.mailDomain(ctx, &m)
+	if err != nil {
+		return err
+	}
+	return o.putMailRecord(ctx, zoneID, &m, mailRecord{scheme: "DMARC", name: "_dmarc." + domain, version: "v=DMARC1", value: strings.Join(tags, "; ")})
+}
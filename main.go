@@ -1,192 +1,94 @@
 package main
 
-import (
-	"flag"
-	"log"
-	"time"
+import "os"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/route53"
-)
-
-const defaultTTL = 0
 const defaultWeight = 1
 
 func logErrorAndFail(err error) {
 	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func logErrorNoFatal(err error) {
-	if err != nil {
-		log.Print(err)
+		logger.Error("Failed", errorFields(err, nil))
+		os.Exit(exitCode(err))
 	}
 }
 
-func getDNSHostedZoneID(DNSName string) (string, error) {
-	sess, err := session.NewSession()
-	if err != nil {
-		return "", err
-	}
-	r53 := route53.New(sess)
-	params := &route53.ListHostedZonesByNameInput{
-		DNSName: aws.String(DNSName),
-	}
-
-	zones, err := r53.ListHostedZonesByName(params)
-
-	if err == nil {
-		if len(zones.HostedZones) > 0 {
-			return aws.StringValue(zones.HostedZones[0].Id), nil
-		}
-	}
-
-	return "", err
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
 }
 
-func createARecord(hostedZoneID, DNSName, hostName, localIP string, logLevel *aws.LogLevelType) error {
-	sess, err := session.NewSession(&aws.Config{Credentials: credentials.NewEnvCredentials(), LogLevel: logLevel})
-	if err != nil {
-		return err
-	}
-	r53 := route53.New(sess)
-	// This API call creates a new DNS record for this host
-	params := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{
-				{
-					Action: aws.String(route53.ChangeActionUpsert),
-					ResourceRecordSet: &route53.ResourceRecordSet{
-						Name: aws.String(hostName + "." + DNSName),
-						// It creates an A record with the IP of the host running the agent
-						Type: aws.String(route53.RRTypeA),
-						ResourceRecords: []*route53.ResourceRecord{
-							{
-								Value: aws.String(localIP),
-							},
-						},
-						SetIdentifier: aws.String(hostName),
-						// TTL=0 to avoid DNS caches
-						TTL:    aws.Int64(defaultTTL),
-						Weight: aws.Int64(defaultWeight),
-					},
-				},
-			},
-			Comment: aws.String("Host A Record Created"),
-		},
-		HostedZoneId: aws.String(hostedZoneID),
+var commands []command
+
+func init() {
+	// Assigned here rather than in the declaration because printUsage,
+	// used by every command's help, refers back to the list
+	commands = []command{
+		{"register", "create or update this host's record (default when no command is given)", runRegister},
+		{"deregister", "remove this host's record", runDeregister},
+		{"drain", "set the weight of this host's record to zero, keeping the record", runDrain},
+		{"undrain", "restore the weight of a drained record", runUndrain},
+		{"rollback", "restore this host's records as they were before register last changed them, as saved to -rollback-file", runRollback},
+		{"shift", "gradually move weight from one weighted record to another, rolling back on failed health checks", runShift},
+		{"swap", "exchange the weights or values of two records in one change, for a blue/green cutover", runSwap},
+		{"migrate", "move a record to new values safely: lower its TTL, wait out the old one, change and verify the values, then restore the TTL", runMigrate},
+		{"list", "print the records in the zone", runList},
+		{"status", "check whether this host's record matches what register would create, failing on drift", runStatus},
+		{"export", "write the records of the zone to a YAML or JSON file, e.g. as a backup before a migration", runExport},
+		{"import", "create or update records from a file written by export, or a CSV file, in batches, leaving records it didn't create alone", runImport},
+		{"resolve", "show which of the record sets of a name Route53 answers with for a client subnet or resolver, against the share their routing policy gives them", runResolve},
+		{"change-status", "report whether Route53 has applied changes submitted by any tool, waiting until they have", runChangeStatus},
+		{"delegate", "create the NS records delegating a public zone in its parent zone, or remove them", runDelegate},
+		{"ds", "print the DS record the parent zone needs for the zone's DNSSEC signing key", runDS},
+		{"history", "show recent changes of the zone or a record from the audit log and CloudTrail, with who made them", runHistory},
+		{"traffic-policy", "create, update or delete the Route53 traffic policy instance of a name instead of a plain record", runTrafficPolicy},
+		{"spf", "create or update the SPF record of a domain, keeping its other TXT values", runSPF},
+		{"dkim", "create or update the DKIM record of a selector from its public key", runDKIM},
+		{"dmarc", "create or update the DMARC record of a domain", runDMARC},
+		{"prune", "remove records registered by this tool that haven't been refreshed for a while", runPrune},
+		{"check", "check that the credentials work and may read the zone, listing each permission that is missing", runCheck},
+		{"iam-policy", "print the IAM policy an operation needs, scoped to the hosted zone", runIAMPolicy},
+		{"systemd-unit", "print a systemd unit running the daemon with the flags given after --, supervised by readiness and watchdog notifications", runSystemdUnit},
+		{"service", "install, uninstall or run the daemon as a Windows service, with the flags given after --", runService},
+		{"completion", "print a bash, zsh or fish script completing the commands and their flags", runCompletion},
+		{"sync", "keep the records under a prefix of the zone matching a file, creating, updating and removing them", runSync},
+		{"cleanup", "drain or remove the records of instances that stopped or were terminated, as EventBridge reports them through SQS", runCleanup},
+		{"discover", "keep a weighted record for every instance tagged with its name and zone, in every zone they name", runDiscover},
+		{"kubernetes", "keep alias, CNAME or A records for the load balancers of annotated Kubernetes Services and Ingresses", runKubernetes},
+		{"dnsrecords", "keep the records requested by DNSRecord custom resources of a Kubernetes cluster, reporting back in their status", runDNSRecords},
+		{"nomad", "keep A and SRV records for the running allocations of Nomad jobs carrying route53.register meta", runNomad},
+		{"consul", "mirror the healthy instances of Consul services into multivalue or weighted records", runConsul},
+		{"serve", "register records on behalf of other processes through an HTTP API, under allowed name prefixes", runServe},
+		{"client", "register, deregister or check a record through the API of a serve command, without AWS credentials", runClient},
+		{"controller", "keep a weighted record for every instance of an Auto Scaling group or with given tags, from a central host", runController},
 	}
-	_, err = r53.ChangeResourceRecordSets(params)
-	logErrorNoFatal(err)
-	if err == nil {
-		log.Print("Record " + hostName + " created, resolves to " + localIP)
-	}
-	return err
-}
-
-func createCNAMERecord(hostedZoneID, DNSName, hostName, localName string, logLevel *aws.LogLevelType) error {
-	sess, err := session.NewSession(&aws.Config{Credentials: credentials.NewEnvCredentials(), LogLevel: logLevel})
-	if err != nil {
-		return err
-	}
-	r53 := route53.New(sess)
-	// This API call creates a new DNS record for this host
-	params := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{
-				{
-					Action: aws.String(route53.ChangeActionUpsert),
-					ResourceRecordSet: &route53.ResourceRecordSet{
-						Name: aws.String(hostName + "." + DNSName),
-						// It creates an A record with the IP of the host running the agent
-						Type: aws.String(route53.RRTypeCname),
-						ResourceRecords: []*route53.ResourceRecord{
-							{
-								Value: aws.String(localName),
-							},
-						},
-						SetIdentifier: aws.String(hostName),
-						// TTL=0 to avoid DNS caches
-						TTL:    aws.Int64(defaultTTL),
-						Weight: aws.Int64(defaultWeight),
-					},
-				},
-			},
-			Comment: aws.String("Host CName Record Created"),
-		},
-		HostedZoneId: aws.String(hostedZoneID),
-	}
-	_, err = r53.ChangeResourceRecordSets(params)
-	logErrorNoFatal(err)
-	if err == nil {
-		log.Print("Record " + hostName + " created, resolves to " + localName)
-	}
-	return err
 }
 
 func main() {
-	logLevel := aws.LogLevel(aws.LogOff)
-	var err error
-	var sum int
-	var zoneID string
-
-	var hostname = flag.String("hostname", "", "which name to use for the new entry")
-	var cname = flag.Bool("cname", false, "whether to create CNAME record instead of an A record. (will use public hostname instead of IP)")
-	var debug = flag.Bool("debug", false, "enable aws logging")
-	var DNSName = flag.String("zonename", "", "which zone to use for registering records")
-	var zoneIDArg = flag.String("zoneId", "", "route53 zone id which to use for registering records (instead of searching zone by name)")
-	flag.Parse()
-
-	if *debug {
-		logLevel = aws.LogLevel(aws.LogDebugWithRequestErrors | aws.LogDebugWithHTTPBody)
-	}
-
-	if *DNSName == "" && *zoneIDArg == "" {
-		log.Fatal("Either zonename or zoneId parameter is required. It sepecifies the zone in which record is added!")
-	}
-
-	if *hostname == "" {
-		log.Fatal("Either host or ip params are needed!")
-	}
-
-	if *zoneIDArg == "" {
-		for {
-			// We try to get the Hosted Zone Id using exponential backoff
-			zoneID, err = getDNSHostedZoneID(*DNSName)
-			if err == nil {
+	args := os.Args[1:]
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		printUsage()
+		return
+	}
+	if len(args) == 1 && (args[0] == "-version" || args[0] == "--version") {
+		printVersion()
+		return
+	}
+	// Without a command we behave like older versions and register the host
+	name, run := "register", runRegister
+	if len(args) > 0 {
+		for _, c := range commands {
+			if args[0] == c.name {
+				name, run = c.name, c.run
+				args = args[1:]
 				break
 			}
-			if sum > 8 {
-				logErrorAndFail(err)
-			}
-			time.Sleep(time.Duration(sum) * time.Second)
-			sum += 2
 		}
-	} else {
-		zoneID = "/hostedzone/" + *zoneIDArg
 	}
-
-	sess, err := session.NewSession()
-	logErrorAndFail(err)
-	metadataClient := ec2metadata.New(sess)
-
-	if *cname == false {
-		localIP, err := metadataClient.GetMetadata("/local-ipv4")
-		logErrorAndFail(err)
-		if err = createARecord(zoneID, *DNSName, *hostname, localIP, logLevel); err != nil {
-			log.Print("Error creating host A record")
-		}
-	} else {
-		localName, err := metadataClient.GetMetadata("/public-hostname")
-		logErrorAndFail(err)
-		if err = createCNAMERecord(zoneID, *DNSName, *hostname, localName, logLevel); err != nil {
-			log.Print("Error creating host CName record")
-		}
-
+	root := tracing.StartProcessTrace("route53_register " + name)
+	err := run(args)
+	root.End(err)
+	if ferr := tracing.Flush(root); ferr != nil {
+		logger.Warn("Error exporting traces", errorFields(ferr, nil))
 	}
-
+	logErrorAndFail(err)
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// The ec2metadata client of the SDK doesn't take a context, so these build
+// the same requests it does but with ctx attached, keeping a hung metadata
+// endpoint from stalling the run.
+
+func metadataRequest(ctx context.Context, c *ec2metadata.EC2Metadata, httpPath string) (string, error) {
+	if taskMetadataEndpoint.enabled() {
+		return taskMetadataEndpoint.get(ctx, httpPath)
+	}
+	header := http.Header{}
+	if token := metadataTokens.get(ctx, c); token != "" {
+		header.Set("X-aws-ec2-metadata-token", token)
+	}
+	content, status, err := sendMetadataRequest(ctx, c, "GET", httpPath, header)
+	switch {
+	case err == nil:
+		return content, nil
+	case status == http.StatusNotFound:
+		return "", withExitCode(exitMetadata, metadataNotFoundError(httpPath))
+	case status == http.StatusUnauthorized:
+		// The token may have been invalidated, get a new one next time
+		metadataTokens.forget()
+		return "", withExitCode(exitMetadata, metadataUnauthorizedError(httpPath))
+	}
+	return "", withExitCode(exitMetadata, err)
+}
+
+// sendMetadataRequest sends a request to the metadata service, returning
+// the body and the status of the response.
+func sendMetadataRequest(ctx context.Context, c *ec2metadata.EC2Metadata, method, httpPath string, header http.Header) (string, int, error) {
+	op := &request.Operation{
+		Name:       "GetMetadata",
+		HTTPMethod: method,
+		HTTPPath:   httpPath,
+	}
+	var content string
+	req := c.NewRequest(op, nil, nil)
+	req.SetContext(ctx)
+	for k, v := range header {
+		req.HTTPRequest.Header[k] = v
+	}
+	req.Handlers.Unmarshal.Clear()
+	req.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		defer r.HTTPResponse.Body.Close()
+		b, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		if err != nil {
+			r.Error = awserr.New("SerializationError", "unable to read EC2 metadata response", err)
+			return
+		}
+		content = string(b)
+	})
+	err := req.Send()
+	status := 0
+	if req.HTTPResponse != nil {
+		status = req.HTTPResponse.StatusCode
+	}
+	return content, status, err
+}
+
+// IMDSv2 has every request carry a session token, which a PUT to /api/token
+// hands out. Instances may require it, refusing requests without one. Old
+// metadata services fail the PUT, and in containers beyond the hop limit
+// of its response it hangs; then requests go without a token, as IMDSv1
+// has them.
+
+const (
+	metadataTokenTTL = 6 * time.Hour
+	// metadataTokenTimeout bounds the PUT, which hangs rather than fails
+	// where the hop limit drops its response
+	metadataTokenTimeout = 2 * time.Second
+	// metadataTokenRetry is how long requests go without a token after the
+	// PUT failed before it's tried again
+	metadataTokenRetry = 5 * time.Minute
+)
+
+// metadataSession keeps the IMDSv2 token the metadata requests carry.
+type metadataSession struct {
+	mu    sync.Mutex
+	token string
+	// renew is when to get a new token, or try again after the PUT failed
+	renew time.Time
+}
+
+var metadataTokens metadataSession
+
+// get returns the token to send, empty when the metadata service didn't
+// give one.
+func (s *metadataSession) get(ctx context.Context, c *ec2metadata.EC2Metadata) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.renew) {
+		return s.token
+	}
+	ctx, cancel := context.WithTimeout(ctx, metadataTokenTimeout)
+	defer cancel()
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(int(metadataTokenTTL/time.Second)))
+	token, _, err := sendMetadataRequest(ctx, c, "PUT", "/api/token", header)
+	if err == nil && token == "" {
+		err = errors.New("empty token")
+	}
+	if err != nil {
+		logger.Debug("No IMDSv2 token from the instance metadata service, falling back to IMDSv1", errorFields(err, nil))
+		s.token, s.renew = "", time.Now().Add(metadataTokenRetry)
+		return ""
+	}
+	// Renewed ahead of its expiry, so that no request carries an expired one
+	s.token, s.renew = token, time.Now().Add(metadataTokenTTL-time.Minute)
+	return token
+}
+
+// forget drops the token, so that the next request gets a new one.
+func (s *metadataSession) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token, s.renew = "", time.Time{}
+}
+
+// metadataNotFoundError is returned for paths the instance has no metadata
+// at, like /meta-data/public-hostname on instances without a public IP.
+type metadataNotFoundError string
+
+func (e metadataNotFoundError) Error() string {
+	return "The instance metadata has nothing at " + string(e)
+}
+
+// metadataUnauthorizedError is returned when the metadata service refuses
+// our requests, as it does on instances requiring IMDSv2 session tokens
+// when we couldn't get one.
+type metadataUnauthorizedError string
+
+func (e metadataUnauthorizedError) Error() string {
+	return "The instance metadata service refused the request for " + string(e)
+}
+
+func isMetadataNotFound(err error) bool {
+	_, ok := unwrapExitError(err).(metadataNotFoundError)
+	return ok
+}
+
+// getMetadata reads a path below /latest/meta-data.
+func getMetadata(ctx context.Context, c *ec2metadata.EC2Metadata, p string) (string, error) {
+	return metadataRequest(ctx, c, path.Join("/", "meta-data", p))
+}
+
+// getIdentityDocument reads the instance identity document.
+func getIdentityDocument(ctx context.Context, c *ec2metadata.EC2Metadata) (ec2metadata.EC2InstanceIdentityDocument, error) {
+	var doc ec2metadata.EC2InstanceIdentityDocument
+	content, err := metadataRequest(ctx, c, path.Join("/", "dynamic", "instance-identity/document"))
+	if err != nil {
+		return doc, err
+	}
+	if err = json.Unmarshal([]byte(content), &doc); err != nil {
+		return doc, withExitCode(exitMetadata, awserr.New("SerializationError", "failed to decode EC2 instance identity document", err))
+	}
+	return doc, nil
+}
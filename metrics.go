@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// parseDimensions parses -cloudwatch-dimensions, a comma separated list of Name=Value pairs.
+func parseDimensions(s string) ([]*cloudwatch.Dimension, error) {
+	var dimensions []*cloudwatch.Dimension
+	if s == "" {
+		return nil, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.New("Invalid CloudWatch dimension " + pair + ", expected Name=Value")
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(kv[0]), Value: aws.String(kv[1])})
+	}
+	return dimensions, nil
+}
+
+// putCloudWatchMetrics records the outcome of an operation on t as the
+// RegistrationSucceeded and RegistrationFailed metrics, one of them 1 and the
+// other 0 so that alarms can tell failures from missing data, along with its
+// RegistrationLatency.
+func (o *options) putCloudWatchMetrics(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, operation string, t *target, err error, elapsed time.Duration) error {
+	dimensions, derr := parseDimensions(o.cloudWatchDimensions)
+	if derr != nil {
+		return derr
+	}
+	dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String("Operation"), Value: aws.String(operation)})
+	if t != nil {
+		dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String("RecordName"), Value: aws.String(t.name)})
+	}
+
+	succeeded, failed := 1.0, 0.0
+	if err != nil {
+		succeeded, failed = 0, 1
+	}
+	now := time.Now()
+	datum := func(name string, value float64, unit string) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Timestamp:  aws.Time(now),
+			Value:      aws.Float64(value),
+			Unit:       aws.String(unit),
+		}
+	}
+
+	sess, cfg, serr := newRegionalSession(ctx, metadataClient, o.logLevel())
+	if serr != nil {
+		return serr
+	}
+	_, perr := cloudwatch.New(sess, cfg).PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(o.cloudWatchNamespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			datum("RegistrationSucceeded", succeeded, cloudwatch.StandardUnitCount),
+			datum("RegistrationFailed", failed, cloudwatch.StandardUnitCount),
+			datum("RegistrationLatency", elapsed.Seconds()*1000, cloudwatch.StandardUnitMilliseconds),
+		},
+	})
+	return perr
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// migration moves a record to new values the way a careful operator does by
+// hand: lower its TTL, wait for resolvers to drop what they cached under the
+// old one, change the values, verify them and put the TTL back.
+type migration struct {
+	r53    *route53.Route53
+	zoneID string
+	// set is the record set as it was before the migration
+	set       *route53.ResourceRecordSet
+	newValues []string
+	lowTTL    int64
+}
+
+func runMigrate(args []string) error {
+	var o options
+	fs := newFlagSet("migrate")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "name of the record to migrate, relative to the zone, @ for the zone apex (required)")
+	rrType := fs.String("type", route53.RRTypeA, "type of the record: A, AAAA or CNAME")
+	setIdentifier := fs.String("set-identifier", "", "set identifier of the record, for one of several weighted records of the name")
+	value := fs.String("value", "", "new values of the record, separated by commas (required)")
+	lowTTL := fs.Int64("low-ttl", 60, "TTL the record has while it's migrated")
+	ttl := fs.Int64("ttl", 0, "TTL the record gets once migrated (default the one it had before)")
+	hold := fs.Duration("hold", 0, "how long to keep the low TTL after the values were changed and verified, so that a switch back reaches clients quickly")
+	fs.StringVar(&o.verifyResolvers, "verify-resolvers", "", "recursive resolvers to wait for as well before the TTL is restored, separated by commas, e.g. 8.8.8.8,1.1.1.1")
+	fs.DurationVar(&o.verifyResolversTimeout, "verify-resolvers-timeout", 5*time.Minute, "how long to wait for the -verify-resolvers to answer with the new values")
+	fs.DurationVar(&o.verifyResolversInterval, "verify-resolvers-interval", 5*time.Second, "how often the -verify-resolvers are asked for the record while waiting")
+	dryRun := fs.Bool("dry-run", false, "only print the steps the migration would take")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *hostname == "" || *value == "" {
+		return configError("The hostname and value parameters are required")
+	}
+	t := strings.ToUpper(*rrType)
+	values := splitList(*value)
+	if err := validateMigrationValues(t, values); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if *lowTTL <= 0 || *lowTTL > maxTTL || *ttl < 0 || *ttl > maxTTL {
+		return withExitCode(exitConfig, fmt.Errorf("The low-ttl parameter must be between 1 and %d, and ttl between 0 and %d", maxTTL, maxTTL))
+	}
+	if o.verifyResolversTimeout <= 0 || o.verifyResolversInterval <= 0 {
+		return configError("The verify-resolvers-timeout and verify-resolvers-interval parameters must be positive")
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	name := o.recordName(*hostname)
+	sets, err := findRecordSets(ctx, r53, zoneID, name, t)
+	if err != nil {
+		return err
+	}
+	set := findPlainSet(sets)
+	if *setIdentifier != "" {
+		set = findIdentifiedSet(sets, *setIdentifier)
+	}
+	if set == nil {
+		return withExitCode(exitConfig, fmt.Errorf("There is no %s record %s%s to migrate", t, name, setIdentifierSuffix(*setIdentifier)))
+	}
+	if set.AliasTarget != nil {
+		return configError("Alias records have no TTL of their own, change their target instead")
+	}
+
+	m := &migration{r53: r53, zoneID: zoneID, set: set, newValues: values, lowTTL: *lowTTL}
+	oldTTL := aws.Int64Value(set.TTL)
+	if oldTTL < m.lowTTL {
+		m.lowTTL = oldTTL
+	}
+	restoreTTL := *ttl
+	if restoreTTL == 0 {
+		restoreTTL = oldTTL
+		if oldTTL <= *lowTTL {
+			logger.Warn("Record has a low TTL already, keeping it; give -ttl for the one to restore when an earlier migration was interrupted", m.fields(fields{"ttl": oldTTL}))
+		}
+	}
+
+	if *dryRun {
+		if oldTTL > m.lowTTL {
+			logger.Info("Would lower the TTL, then wait for the old one to run out", m.fields(fields{"ttl": m.lowTTL, "old_ttl": oldTTL, "wait": (time.Duration(oldTTL) * time.Second).String()}))
+		}
+		logger.Info("Would change the values and verify them", m.fields(fields{"values": strings.Join(values, ","), "old_values": strings.Join(recordValues(set), ",")}))
+		logger.Info("Would restore the TTL", m.fields(fields{"ttl": restoreTTL}))
+		return nil
+	}
+
+	if oldTTL > m.lowTTL {
+		if err := m.upsert(ctx, recordValues(set), m.lowTTL, "TTL Lowered For Migration"); err != nil {
+			return err
+		}
+		wait := time.Duration(oldTTL) * time.Second
+		logger.Info("TTL lowered, waiting for the old one to run out", m.fields(fields{"ttl": m.lowTTL, "old_ttl": oldTTL, "wait": wait.String()}))
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+	if err := m.upsert(ctx, values, m.lowTTL, "Record Migrated"); err != nil {
+		return err
+	}
+	logger.Info("Values changed", m.fields(fields{"values": strings.Join(values, ","), "old_values": strings.Join(recordValues(set), ",")}))
+	if err := o.verifyMigration(ctx, m); err != nil {
+		logger.Warn("Migration failed verification, switching back to the old values", errorFields(err, m.fields(nil)))
+		if rerr := m.upsert(ctx, recordValues(set), m.lowTTL, "Migration Rolled Back"); rerr != nil {
+			return rerr
+		}
+		return withExitCode(exitVerifyFailed, fmt.Errorf("Migration of %s rolled back, keeping the TTL of %d; %v", name, m.lowTTL, err))
+	}
+	if *hold > 0 {
+		logger.Info("Holding the low TTL", m.fields(fields{"hold": hold.String()}))
+		if err := sleepContext(ctx, *hold); err != nil {
+			return err
+		}
+	}
+	if restoreTTL != m.lowTTL {
+		if err := m.upsert(ctx, values, restoreTTL, "TTL Restored After Migration"); err != nil {
+			return err
+		}
+	}
+	logger.Info("Record migrated", m.fields(fields{"values": strings.Join(values, ","), "ttl": restoreTTL}))
+	return nil
+}
+
+// validateMigrationValues checks the new values of a record of rrType.
+func validateMigrationValues(rrType string, values []string) error {
+	for _, v := range values {
+		ip := net.ParseIP(v)
+		switch rrType {
+		case route53.RRTypeA:
+			if ip == nil || ip.To4() == nil {
+				return errors.New("The value " + v + " isn't an IPv4 address")
+			}
+		case route53.RRTypeAaaa:
+			if ip == nil || ip.To4() != nil {
+				return errors.New("The value " + v + " isn't an IPv6 address")
+			}
+		case route53.RRTypeCname:
+			if len(values) > 1 {
+				return errors.New("A CNAME record has a single value")
+			}
+			if err := validateDNSName(v); err != nil {
+				return err
+			}
+		default:
+			return errors.New("Unsupported type " + rrType + ", expected A, AAAA or CNAME")
+		}
+	}
+	return nil
+}
+
+func setIdentifierSuffix(setIdentifier string) string {
+	if setIdentifier == "" {
+		return ""
+	}
+	return " with set identifier " + setIdentifier
+}
+
+// upsert gives the record values and ttl, waiting until Route53 serves them.
+func (m *migration) upsert(ctx context.Context, values []string, ttl int64, comment string) error {
+	set := *m.set
+	set.ResourceRecords = resourceRecords(values)
+	set.TTL = aws.Int64(ttl)
+	info, err := submitChanges(ctx, m.r53, m.zoneID, comment, []*route53.Change{
+		{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: &set},
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("Waiting for the change to be in sync", m.fields(fields{"change_id": aws.StringValue(info.Id)}))
+	return waitInSync(ctx, m.r53, info)
+}
+
+// targets returns the new values of the record as targets to verify.
+func (m *migration) targets() []*target {
+	var ts []*target
+	for _, v := range m.newValues {
+		ts = append(ts, &target{
+			zoneID:        m.zoneID,
+			name:          aws.StringValue(m.set.Name),
+			rrType:        aws.StringValue(m.set.Type),
+			value:         v,
+			setIdentifier: aws.StringValue(m.set.SetIdentifier),
+			ttl:           m.lowTTL,
+			shared:        m.set.SetIdentifier == nil,
+		})
+	}
+	return ts
+}
+
+// verifyMigration checks that the name servers of the zone answer with the
+// new values, then waits for any -verify-resolvers to.
+func (o *options) verifyMigration(ctx context.Context, m *migration) error {
+	servers, err := zoneNameServers(ctx, m.r53, m.zoneID)
+	if err != nil {
+		return err
+	}
+	ts := m.targets()
+	for _, t := range ts {
+		if !t.shared {
+			if err := verifyRecordSet(ctx, m.r53, t); err != nil {
+				return err
+			}
+		}
+	}
+	for _, server := range servers {
+		for _, t := range ts {
+			if err := verifyAnswer(ctx, server, t); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info("Record verified", m.fields(fields{"servers": strings.Join(servers, ",")}))
+	if resolvers := splitList(o.verifyResolvers); len(resolvers) > 0 {
+		return o.verifyPropagation(ctx, ts, resolvers)
+	}
+	return nil
+}
+
+func (m *migration) fields(f fields) fields {
+	if f == nil {
+		f = fields{}
+	}
+	f["zone_id"] = m.zoneID
+	f["record_name"] = aws.StringValue(m.set.Name)
+	f["record_type"] = aws.StringValue(m.set.Type)
+	if m.set.SetIdentifier != nil {
+		f["set_identifier"] = aws.StringValue(m.set.SetIdentifier)
+	}
+	return f
+}
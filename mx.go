@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxMXPriority is the largest preference of an MX record, a 16 bit number.
+const maxMXPriority = 65535
+
+// validateMX checks the -mx-name and -mx-priority of a host that adds
+// itself to an MX record.
+func (o *options) validateMX() error {
+	if o.mxName == "" {
+		return nil
+	}
+	if o.cname || o.alias.dnsName != "" {
+		return configError("The mx-name parameter needs an A record of this host, MX records can't point at a CNAME or alias")
+	}
+	if o.mxPriority < 0 || o.mxPriority > maxMXPriority {
+		return configError("The mx-priority parameter must be between 0 and " + strconv.Itoa(maxMXPriority))
+	}
+	if zone := normalizeName(o.zone()); zone != "" {
+		name := o.recordName(o.mxName)
+		if !inZone(name, zone) {
+			return configError("The mx-name " + o.mxName + " is not in zone " + zone)
+		}
+		if err := validateDNSName(name); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+	return nil
+}
+
+// mxTarget is the value this host adds to the -mx-name record: its
+// priority and the name of its first record. MX records are shared by
+// every relay of a domain, like -shared records, as mail servers try all
+// of them in the order of their priority.
+func (o *options) mxTarget(ts []*target) *target {
+	return &target{
+		zoneID: ts[0].zoneID,
+		name:   o.recordName(o.mxName),
+		rrType: route53.RRTypeMx,
+		value:  fmt.Sprintf("%d %s.", o.mxPriority, ts[0].name),
+		ttl:    recordTTL(o.ttl, route53.RRTypeMx),
+		shared: true,
+		force:  o.force,
+	}
+}
+
+// withMX extends change to the -mx-name record: operations putting the
+// host in service add it to the record once its own records are in place,
+// and those taking it out remove it first, so the record never sends mail
+// to a host that isn't there.
+func (o *options) withMX(operation string, change hostChange) hostChange {
+	if o.mxName == "" {
+		return change
+	}
+	add := operation == "register" || operation == "undrain"
+	if !add && operation != "deregister" && operation != "drain" {
+		return change
+	}
+	return func(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+		mx := []*target{o.mxTarget(ts)}
+		var info, mxInfo *route53.ChangeInfo
+		var err error
+		if !add {
+			if mxInfo, err = updateSharedRecords(ctx, r53, mx, false); err != nil {
+				return nil, err
+			}
+		}
+		if info, err = change(ctx, r53, ts); err != nil {
+			return nil, err
+		}
+		if add {
+			if mxInfo, err = updateSharedRecords(ctx, r53, mx, true); err != nil {
+				return nil, err
+			}
+		}
+		if info == nil {
+			info = mxInfo
+		}
+		return info, nil
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// apexHostname is the -hostname naming the zone apex, as in zone files.
+const apexHostname = "@"
+
+func isApex(hostname string) bool {
+	return hostname == apexHostname || hostname == ""
+}
+
+// normalizeName returns a DNS name the way we compare and send names:
+// unescaped, lower case, in its ASCII form and without the trailing dot of
+// absolute names.
+func normalizeName(name string) string {
+	return toASCII(strings.ToLower(strings.TrimSuffix(unescapeName(name), ".")))
+}
+
+// unescapeName decodes the \ddd octal escapes Route53 returns names with,
+// e.g. \052 for the * of wildcard records.
+func unescapeName(name string) string {
+	if !strings.Contains(name, `\`) {
+		return name
+	}
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if n, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(n))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, name[i])
+	}
+	return string(b)
+}
+
+// inZone tells whether name, normalized, is zone or one of its subdomains.
+func inZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// qualifyName returns the full name of a record named relative to zone.
+// Names that already end with the zone, or with a dot, are full names.
+func qualifyName(name, zone string) string {
+	zone = normalizeName(zone)
+	if isApex(name) {
+		return zone
+	}
+	absolute := strings.HasSuffix(name, ".")
+	name = normalizeName(name)
+	if absolute || zone == "" || inZone(name, zone) {
+		return name
+	}
+	return name + "." + zone
+}
+
+// relativeName is the reverse of qualifyName: the name of a record relative
+// to zone, @ for the apex and an absolute name when it's outside of zone.
+func relativeName(name, zone string) string {
+	name, zone = normalizeName(name), normalizeName(zone)
+	switch {
+	case name == zone:
+		return apexHostname
+	case inZone(name, zone):
+		return strings.TrimSuffix(name, "."+zone)
+	}
+	return name + "."
+}
+
+// recordHostnames returns the -hostname values, the zone apex when none
+// were given.
+func (o *options) recordHostnames() []string {
+	if len(o.hostnames) == 0 {
+		return []string{apexHostname}
+	}
+	return o.hostnames
+}
+
+// zone returns the name of the zone records are registered in, which is
+// empty until it was looked up when only -zoneId was given.
+func (o *options) zone() string {
+	if o.zoneName != "" {
+		return o.zoneName
+	}
+	return o.resolvedZoneName
+}
+
+// recordName returns the name of the record registered for hostname.
+func (o *options) recordName(hostname string) string {
+	return qualifyName(hostname, o.zone())
+}
+
+// uniqueSuffixLength is how many of the last characters of the instance id
+// -unique adds to names, enough to tell apart the instances of a group.
+const uniqueSuffixLength = 8
+
+// uniqueName returns name with a suffix derived from instanceID added to its
+// first label, e.g. web-cdef0123.example.com for web.example.com.
+func uniqueName(name, instanceID string) string {
+	suffix := strings.TrimPrefix(instanceID, "i-")
+	if len(suffix) > uniqueSuffixLength {
+		suffix = suffix[len(suffix)-uniqueSuffixLength:]
+	}
+	labels := strings.SplitN(name, ".", 2)
+	labels[0] += "-" + suffix
+	return strings.Join(labels, ".")
+}
+
+// insertLabels returns the full name with labels added in between the part
+// relative to zone and the zone itself, e.g. web.eu-west-1a.example.com for
+// web.example.com.
+func insertLabels(name, zone string, labels []string) string {
+	suffix := strings.Join(labels, ".")
+	if name == zone {
+		return suffix + "." + zone
+	}
+	return strings.TrimSuffix(name, "."+zone) + "." + suffix + "." + zone
+}
+
+// validateDNSName checks that name, normalized, is a valid record name:
+// labels of letters, digits, hyphens and underscores, not starting or ending
+// with a hyphen, of up to 63 characters, and at most 253 characters in all.
+// Underscores aren't allowed in host names, but are in names like
+// my_service or _dmarc that Route53 takes all the same. The first label may
+// be the * of a wildcard record.
+func validateDNSName(name string) error {
+	if name == "" {
+		return errors.New("The record name is empty")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("%s is longer than the 253 characters a DNS name may have", name)
+	}
+	for i, label := range strings.Split(name, ".") {
+		if label == "*" && i == 0 {
+			// Wildcard records answer for any name below the rest
+			continue
+		}
+		if label == "" {
+			return fmt.Errorf("%s has an empty label", name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("Label %s of %s is longer than 63 characters", label, name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("Label %s of %s starts or ends with a hyphen", label, name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("Label %s of %s has the character %q, only letters, digits, hyphens and underscores are allowed", label, name, c)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"web.example.com", "web.example.com"},
+		{"web.example.com.", "web.example.com"},
+		{"Web.EXAMPLE.com.", "web.example.com"},
+		{"", ""},
+		// Route53 escapes the * of wildcard records
+		{`\052.example.com.`, "*.example.com"},
+		{`a\055b.example.com`, "a-b.example.com"},
+		{`web\.example.com`, `web\.example.com`},
+		// Internationalized names are sent in their punycode form
+		{"Bücher.example.com", "xn--bcher-kva.example.com"},
+		{"münchen.de.", "xn--mnchen-3ya.de"},
+		{"xn--bcher-kva.example.com", "xn--bcher-kva.example.com"},
+	}
+	for _, tt := range tests {
+		if got := normalizeName(tt.in); got != tt.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInZone(t *testing.T) {
+	tests := []struct {
+		name, zone string
+		want       bool
+	}{
+		{"example.com", "example.com", true},
+		{"web.example.com", "example.com", true},
+		{"a.b.example.com", "example.com", true},
+		{"webexample.com", "example.com", false},
+		{"example.com", "web.example.com", false},
+		{"example.org", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := inZone(tt.name, tt.zone); got != tt.want {
+			t.Errorf("inZone(%q, %q) = %v, want %v", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestQualifyName(t *testing.T) {
+	tests := []struct {
+		name, zone, want string
+	}{
+		{"web", "example.com", "web.example.com"},
+		{"web", "example.com.", "web.example.com"},
+		{"Web", "Example.COM", "web.example.com"},
+		{"@", "example.com", "example.com"},
+		{"", "example.com", "example.com"},
+		{"web.example.com", "example.com", "web.example.com"},
+		{"web.example.com.", "example.com", "web.example.com"},
+		{"web.example.org.", "example.com", "web.example.org"},
+		// Without the trailing dot a name outside the zone is relative
+		{"web.example.org", "example.com", "web.example.org.example.com"},
+		{"web.eu", "example.com", "web.eu.example.com"},
+		{"web", "", "web"},
+	}
+	for _, tt := range tests {
+		if got := qualifyName(tt.name, tt.zone); got != tt.want {
+			t.Errorf("qualifyName(%q, %q) = %q, want %q", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDNSName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"web.example.com", false},
+		{"my_service.example.com", false},
+		{"_dmarc.example.com", false},
+		{"web-1.example.com", false},
+		{"1.example.com", false},
+		{strings.Repeat("a", 63) + ".example.com", false},
+		{"", true},
+		{strings.Repeat("a", 64) + ".example.com", true},
+		{strings.Repeat("a.", 127) + "com", true},
+		{"web..example.com", true},
+		{".example.com", true},
+		{"-web.example.com", true},
+		{"web-.example.com", true},
+		{"web server.example.com", true},
+		{"web/1.example.com", true},
+		{"Web.example.com", true},
+		// Only the first label may be a wildcard
+		{"*.example.com", false},
+		{"web.*.example.com", true},
+		{"*web.example.com", true},
+	}
+	for _, tt := range tests {
+		err := validateDNSName(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateDNSName(%q) = %v, want an error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
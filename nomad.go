@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// nomadSource tags the ownership markers of the records kept for Nomad
+// allocations, which are removed once their allocation stops.
+const nomadSource = "nomad"
+
+// nomadAllocation is the part of a Nomad allocation we use.
+// AllocModifyIndex changes whenever the allocation itself does.
+type nomadAllocation struct {
+	ID               string
+	Namespace        string
+	TaskGroup        string
+	ClientStatus     string
+	AllocModifyIndex uint64
+	Job              *struct {
+		Meta       map[string]string
+		TaskGroups []struct {
+			Name string
+			Meta map[string]string
+		}
+	}
+	AllocatedResources *struct {
+		Shared struct {
+			Ports    []nomadPort
+			Networks []nomadNetwork
+		}
+		Tasks map[string]struct {
+			Networks []nomadNetwork
+		}
+	}
+}
+
+type nomadPort struct {
+	Label  string
+	Value  int
+	HostIP string
+}
+
+type nomadNetwork struct {
+	IP            string
+	ReservedPorts []nomadPort
+	DynamicPorts  []nomadPort
+}
+
+// The meta keys a job or task group opts in with. nomadRegisterMeta lists
+// the names to register, relative to the zone and separated by commas.
+// nomadPortMeta names the port label to publish SRV records for.
+const (
+	nomadRegisterMeta = "route53.register"
+	nomadPortMeta     = "route53.port"
+)
+
+func runNomad(args []string) error {
+	var o options
+	fs := newFlagSet("nomad")
+	o.addZoneFlags(fs)
+	addr := fs.String("nomad-addr", firstNonEmpty(os.Getenv("NOMAD_ADDR"), "http://127.0.0.1:4646"), "address of the Nomad API")
+	token := fs.String("nomad-token", os.Getenv("NOMAD_TOKEN"), "Nomad ACL token allowed to read the jobs' allocations")
+	namespace := fs.String("namespace", "*", "Nomad namespace whose allocations to register, * for all of them")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records in seconds (default 60)")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of each allocation's A record")
+	interval := fs.Duration("interval", 30*time.Second, "how often to reconcile the records with the allocations")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	nomad := newAPIClient("Nomad", *addr, "X-Nomad-Token", *token)
+	// Allocations don't change once running, so each is only fetched again
+	// when its index moves
+	cache := map[string]nomadAllocation{}
+	return o.repeat("nomad", fields{"nomad_addr": *addr}, *once, *interval, func(ctx context.Context) error {
+		allocs, err := nomad.nomadAllocations(ctx, *namespace, cache)
+		if err != nil {
+			return err
+		}
+		zoneID, err := o.resolveZoneID(ctx)
+		if err != nil {
+			return err
+		}
+		desired := o.nomadRecords(allocs)
+		r53, err := newRoute53Client(o.logLevel())
+		if err != nil {
+			return err
+		}
+		sets, err := listRecordSets(ctx, r53, zoneID)
+		if err != nil {
+			return err
+		}
+		changes := syncChanges(sets, desired, nil, nomadSource, false, time.Now())
+		return submitSyncChanges(ctx, r53, zoneID, "Nomad Records Synced", sets, changes, len(desired), *dryRun)
+	})
+}
+
+// nomadAllocations returns the running allocations of namespace, fetching
+// the ones cache has no up to date copy of. The list only has stubs, without
+// the job's meta and the allocated ports.
+func (c *apiClient) nomadAllocations(ctx context.Context, namespace string, cache map[string]nomadAllocation) ([]nomadAllocation, error) {
+	var stubs []nomadAllocation
+	if err := c.get(ctx, "/v1/allocations?namespace="+url.QueryEscape(namespace), &stubs); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var allocs []nomadAllocation
+	for _, stub := range stubs {
+		if stub.ClientStatus != "running" {
+			continue
+		}
+		seen[stub.ID] = true
+		alloc, ok := cache[stub.ID]
+		if !ok || alloc.AllocModifyIndex != stub.AllocModifyIndex {
+			alloc = nomadAllocation{}
+			if err := c.get(ctx, "/v1/allocation/"+url.PathEscape(stub.ID)+"?namespace="+url.QueryEscape(stub.Namespace), &alloc); err != nil {
+				return nil, err
+			}
+			cache[stub.ID] = alloc
+		}
+		allocs = append(allocs, alloc)
+	}
+	for id := range cache {
+		if !seen[id] {
+			delete(cache, id)
+		}
+	}
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].ID < allocs[j].ID })
+	return allocs, nil
+}
+
+// meta returns the meta of the allocation's job, overridden by that of its
+// task group.
+func (a nomadAllocation) meta() map[string]string {
+	meta := map[string]string{}
+	if a.Job == nil {
+		return meta
+	}
+	for k, v := range a.Job.Meta {
+		meta[k] = v
+	}
+	for _, g := range a.Job.TaskGroups {
+		if g.Name == a.TaskGroup {
+			for k, v := range g.Meta {
+				meta[k] = v
+			}
+		}
+	}
+	return meta
+}
+
+// networks returns the group networks of the allocation, then those of its
+// tasks, older jobs having their networks on the tasks.
+func (a nomadAllocation) networks() []nomadNetwork {
+	if a.AllocatedResources == nil {
+		return nil
+	}
+	networks := append([]nomadNetwork(nil), a.AllocatedResources.Shared.Networks...)
+	var tasks []string
+	for name := range a.AllocatedResources.Tasks {
+		tasks = append(tasks, name)
+	}
+	sort.Strings(tasks)
+	for _, name := range tasks {
+		networks = append(networks, a.AllocatedResources.Tasks[name].Networks...)
+	}
+	return networks
+}
+
+// address returns the host IP of the allocation and, when label is given,
+// the host port with that label. It returns a zero port when there is none.
+func (a nomadAllocation) address(label string) (string, int) {
+	ip := ""
+	if a.AllocatedResources != nil {
+		for _, p := range a.AllocatedResources.Shared.Ports {
+			if ip == "" {
+				ip = p.HostIP
+			}
+			if label != "" && p.Label == label {
+				return p.HostIP, p.Value
+			}
+		}
+	}
+	for _, n := range a.networks() {
+		if ip == "" {
+			ip = n.IP
+		}
+		for _, ports := range [][]nomadPort{n.ReservedPorts, n.DynamicPorts} {
+			for _, p := range ports {
+				if label != "" && p.Label == label {
+					return n.IP, p.Value
+				}
+			}
+		}
+	}
+	return ip, 0
+}
+
+// nomadRecords returns the records the allocations call for: a weighted A
+// record under each name for every allocation, identified by its id, and
+// with a port label an A record of its own that the name's SRV record,
+// _<label>._tcp.<name>, points at with the allocation's port.
+func (o *options) nomadRecords(allocs []nomadAllocation) map[syncKey]*route53.ResourceRecordSet {
+	zone := o.zone()
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for _, alloc := range allocs {
+		meta := alloc.meta()
+		if meta[nomadRegisterMeta] == "" {
+			continue
+		}
+		label := meta[nomadPortMeta]
+		ip, port := alloc.address(label)
+		f := fields{"alloc_id": alloc.ID}
+		if ip == "" {
+			logger.Warn("Allocation has no address, leaving it out", f)
+			continue
+		}
+		if label != "" && port == 0 {
+			f["port_label"] = label
+			logger.Warn("Allocation has no port with the label, leaving out its SRV records", f)
+		}
+		for _, hostname := range strings.Split(meta[nomadRegisterMeta], ",") {
+			hostname = strings.TrimSpace(hostname)
+			name := qualifyName(hostname, zone)
+			f := fields{"alloc_id": alloc.ID, "hostname": hostname}
+			if hostname == "" || isApex(hostname) || !inZone(name, zone) {
+				logger.Warn("Allocation is registered under a name that isn't in the zone, leaving it out", f)
+				continue
+			}
+			if err := validateDNSName(name); err != nil {
+				logger.Warn("Allocation is registered under an invalid name, leaving it out", errorFields(err, f))
+				continue
+			}
+			set := &route53.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            aws.String(route53.RRTypeA),
+				ResourceRecords: resourceRecords([]string{ip}),
+				SetIdentifier:   aws.String(alloc.ID),
+				TTL:             aws.Int64(recordTTL(o.ttl, route53.RRTypeA)),
+				Weight:          aws.Int64(o.weight),
+			}
+			desired[setKey(set)] = set
+			if port == 0 {
+				continue
+			}
+			// SRV targets are names, so each allocation gets one
+			target := uniqueName(name, alloc.ID)
+			host := &route53.ResourceRecordSet{
+				Name:            aws.String(target),
+				Type:            aws.String(route53.RRTypeA),
+				ResourceRecords: resourceRecords([]string{ip}),
+				TTL:             aws.Int64(recordTTL(o.ttl, route53.RRTypeA)),
+			}
+			desired[setKey(host)] = host
+			srvName := "_" + strings.ToLower(label) + "._tcp." + name
+			if err := validateDNSName(srvName); err != nil {
+				logger.Warn("Allocation's port label doesn't make a valid SRV name, leaving out its SRV record", errorFields(err, f))
+				continue
+			}
+			k := syncKey{srvName, route53.RRTypeSrv, ""}
+			srv := desired[k]
+			if srv == nil {
+				srv = &route53.ResourceRecordSet{
+					Name: aws.String(srvName),
+					Type: aws.String(route53.RRTypeSrv),
+					TTL:  aws.Int64(recordTTL(o.ttl, route53.RRTypeSrv)),
+				}
+				desired[k] = srv
+			}
+			srv.ResourceRecords = append(srv.ResourceRecords, &route53.ResourceRecord{
+				Value: aws.String("0 " + strconv.FormatInt(o.weight, 10) + " " + strconv.Itoa(port) + " " + target + "."),
+			})
+		}
+	}
+	return desired
+}
@@ -0,0 +1,862 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// options holds the flags shared between commands.
+type options struct {
+	hostnames     stringList
+	zoneName      string
+	zoneID        string
+	cname         bool
+	cnameTarget   string
+	addressSource string
+	appendAZ      bool
+	appendRegion  bool
+	unique        bool
+	shared        bool
+	alias         aliasTarget
+	setIdentifier string
+	weight        int64
+	ttl           int64
+	healthCheckID string
+	lockTable     string
+	lockFile      string
+	lockTimeout   time.Duration
+	debug         bool
+	logFormat     string
+	logLevelName  string
+	otlpEndpoint  string
+	timeout       time.Duration
+	output        string
+	configFile    string
+
+	// hostnameFile and valueFile give the hostnames and the value of the
+	// record instead of -hostname and the instance, fileValue being the
+	// value read from valueFile
+	hostnameFile string
+	valueFile    string
+	fileValue    string
+
+	// mxName is the MX record this host adds itself to, with mxPriority
+	mxName     string
+	mxPriority int64
+
+	// account is where a registration of a -config file keeps its
+	// records, the agent's own account unless it names a role_arn
+	account awsAccount
+
+	// force lets deregistering remove records without an ownership marker,
+	// and sync take them over
+	force bool
+
+	// dryRun makes register and deregister print how they would change the
+	// records instead of changing them
+	dryRun bool
+
+	// setFlags holds the names of the flags given on the command line,
+	// which take precedence over the -config file
+	setFlags map[string]bool
+
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         bool
+
+	metadataMaxRetries     int
+	metadataInitialBackoff time.Duration
+	metadataMaxBackoff     time.Duration
+	changeMaxRetries       int
+
+	webIdentityTokenFile string
+	roleARN              string
+	roleSessionName      string
+	profile              string
+
+	region  string
+	useFIPS bool
+
+	proxy          string
+	caBundle       string
+	requestTimeout time.Duration
+
+	auditLogFile     string
+	auditLogMaxSize  int
+	auditLogMaxFiles int
+	changeComment    string
+
+	zoneCacheFile string
+	zoneCacheTTL  time.Duration
+
+	// parallel is how many zones of the -config file are worked on at once
+	parallel int
+
+	cloudWatchNamespace  string
+	cloudWatchDimensions string
+	statsdAddr           string
+	statsdPrefix         string
+	statsdDogstatsd      bool
+
+	onSuccessExec string
+	onFailureExec string
+	webhookURL    string
+	snsTopicARN   string
+
+	slackWebhookURL string
+	slackSeverity   string
+
+	verify bool
+	// verifyResolvers is a comma separated list of recursive resolvers,
+	// polled every verifyResolversInterval until they answer with the new
+	// values or verifyResolversTimeout runs out
+	verifyResolvers         string
+	verifyResolversTimeout  time.Duration
+	verifyResolversInterval time.Duration
+
+	// createZone creates the -zonename hosted zone when there is none,
+	// private to createZoneVPC when it's set
+	createZone     bool
+	createZoneVPC  string
+	createZoneTags string
+	// autoAssociate associates this instance's VPC with the private zone
+	// registered in when it isn't
+	autoAssociate bool
+	// checkDelegation warns when the zone isn't delegated to its name servers
+	checkDelegation bool
+	// delegate keeps the NS records delegating the zone in parentZoneID,
+	// or the closest public zone above it
+	delegate     bool
+	parentZoneID string
+	// calculatedHealthCheck keeps a calculated health check over the health
+	// checks of every record sharing the name
+	calculatedHealthCheck     bool
+	calculatedHealthThreshold int64
+	// weightFrom derives the weight of the records from the host's vCPUs
+	// or instance type, weightMap being the -weight-map file once read
+	weightFrom    string
+	weightMapFile string
+	weightMap     map[string]int64
+	// healthCheckAlarm is the CloudWatch alarm whose health check the
+	// records get, alarmHealthCheckID its id once it was looked up
+	healthCheckAlarm       string
+	healthCheckAlarmRegion string
+	insufficientDataStatus string
+	alarmHealthCheckID     string
+
+	// rollbackFile keeps the record sets as they were before register
+	// changed them, for the rollback command
+	rollbackFile            string
+	rollbackOnVerifyFailure bool
+
+	testAnswer  bool
+	answerQuery answerQuery
+
+	daemon         bool
+	interval       time.Duration
+	refresh        time.Duration
+	healthAddr     string
+	debugEndpoints bool
+
+	// deregisterOnStop makes the daemon remove the records when it stops,
+	// as it does on Fargate
+	deregisterOnStop bool
+
+	// waitForHealthyURL is the local service register waits for before
+	// publishing the record
+	waitForHealthyURL      string
+	waitForHealthyTimeout  time.Duration
+	waitForHealthyInterval time.Duration
+
+	// healthProbeURL is the local service the daemon keeps probing, taking
+	// the records out of service with unhealthyAction while it fails
+	healthProbeURL      string
+	healthProbeInterval time.Duration
+	unhealthyThreshold  int
+	healthyThreshold    int
+	unhealthyAction     string
+
+	// activateAt, deactivateAt and window bound when the records are in
+	// service, see registrationWindow
+	activateAt     string
+	deactivateAt   string
+	window         string
+	windowDuration time.Duration
+	windowTimezone string
+	inactiveAction string
+
+	// resolvedZoneName is the name of the zone given by -zoneId alone,
+	// once it was looked up
+	resolvedZoneName string
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// newFlagSet creates the flag set of a command, printing the list of
+// commands above its own flags when asked for help.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		printCommandUsage(name, fs)
+	}
+	return fs
+}
+
+// parse parses the command line of a command, sets up logging accordingly
+// and reads the settings given as SSM parameters.
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	if flagCollector != nil {
+		flagCollector(fs)
+		return errFlagsCollected
+	}
+	fs.Parse(args)
+	o.setFlags = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		o.setFlags[f.Name] = true
+	})
+	if o.debug && o.logLevelName == "info" {
+		o.logLevelName = "debug"
+	}
+	tracing.configure(o.otlpEndpoint)
+	if err := retries.configure(o.maxRetries, o.initialBackoff, o.maxBackoff, o.jitter); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := metadataRetries.configure(o.metadataMaxRetries, o.metadataInitialBackoff, o.metadataMaxBackoff, false); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	// Change batches back off like the other AWS calls
+	if err := changeRetries.configure(o.changeMaxRetries, o.initialBackoff, o.maxBackoff, o.jitter); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := awsHTTP.configure(o.proxy, o.caBundle, o.requestTimeout); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := awsEndpoints.configure(o.region, o.useFIPS); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := awsCredentials.configure(o.webIdentityTokenFile, o.roleARN, o.roleSessionName, o.profile); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := logger.configure(o.logFormat, o.logLevelName); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	logger.Debug("Starting", versionFields(fields{"command": fs.Name()}))
+	if err := audit.configure(o.auditLogFile, o.auditLogMaxSize, o.auditLogMaxFiles); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := changeComments.configure(o.changeComment); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if err := zoneCache.configure(o.zoneCacheFile, o.zoneCacheTTL); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	return o.resolveParameters()
+}
+
+// context returns the context AWS calls of a command run under, which is
+// cancelled once -timeout has passed.
+func (o *options) context() (context.Context, context.CancelFunc) {
+	return o.withTimeout(context.Background())
+}
+
+func (o *options) withTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout > 0 {
+		return context.WithTimeout(parent, o.timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// cleanupTimeout bounds the calls made after a command's own context may
+// have run out, like releasing locks and reporting failures.
+const cleanupTimeout = 10 * time.Second
+
+// sleepContext waits for d, returning early with an error when ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addCommonFlags adds the logging, timeout, retry, credential and network
+// flags every command has.
+func (o *options) addCommonFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.debug, "debug", false, "enable aws logging")
+	fs.StringVar(&o.logFormat, "log-format", "text", "log format: text or json")
+	fs.StringVar(&o.logLevelName, "log-level", "info", "minimum level of logged messages: debug, info, warn or error (debug when -debug is set)")
+	fs.StringVar(&o.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318 (defaults to $OTEL_EXPORTER_OTLP_ENDPOINT, disabled when empty)")
+	fs.DurationVar(&o.timeout, "timeout", 0, "give up when the command hasn't finished after this long, e.g. 30s (no limit when 0; applies to each reconciliation in daemon mode)")
+	fs.IntVar(&o.maxRetries, "max-retries", retries.NumMaxRetries, "how many times a failed AWS call is retried when the error is transient, e.g. throttling")
+	fs.DurationVar(&o.initialBackoff, "initial-backoff", retries.initialBackoff, "wait before the first retry, doubling with every retry after it")
+	fs.DurationVar(&o.maxBackoff, "max-backoff", retries.maxBackoff, "longest wait between retries")
+	fs.BoolVar(&o.jitter, "jitter", retries.jitter, "wait a random duration up to the backoff instead of the full backoff, spreading out retries of hosts that failed together")
+	fs.IntVar(&o.changeMaxRetries, "change-max-retries", changeRetries.NumMaxRetries, "how many times a failed Route53 change batch is retried; batches creating or deleting records only when Route53 surely didn't apply them, e.g. when throttled")
+	fs.IntVar(&o.metadataMaxRetries, "metadata-max-retries", metadataRetries.NumMaxRetries, "how many times a failed instance metadata read is retried")
+	fs.DurationVar(&o.metadataInitialBackoff, "metadata-initial-backoff", metadataRetries.initialBackoff, "wait before the first retry of a metadata read, doubling with every retry after it")
+	fs.DurationVar(&o.metadataMaxBackoff, "metadata-max-backoff", metadataRetries.maxBackoff, "longest wait between retries of a metadata read")
+	fs.StringVar(&o.webIdentityTokenFile, "web-identity-token-file", "", "OIDC token exchanged for the credentials of -role-arn, like the one EKS mounts for IAM roles for service accounts (default $AWS_WEB_IDENTITY_TOKEN_FILE)")
+	fs.StringVar(&o.roleARN, "role-arn", "", "role assumed with the -web-identity-token-file (default $AWS_ROLE_ARN)")
+	fs.StringVar(&o.roleSessionName, "role-session-name", "", "session name of the assumed role, shown in CloudTrail (default $AWS_ROLE_SESSION_NAME or route53_register)")
+	fs.StringVar(&o.profile, "profile", "", "profile of the AWS config and credentials files to use, which may be an SSO profile logged in with aws sso login (default $AWS_PROFILE)")
+	fs.StringVar(&o.region, "region", "", "region of the AWS clients, which also picks the partition, e.g. us-gov-west-1 for GovCloud (default $AWS_REGION, or the instance's region for regional services)")
+	fs.BoolVar(&o.useFIPS, "use-fips", false, "call the FIPS endpoints of the AWS services (those of GovCloud are FIPS endpoints already)")
+	fs.StringVar(&o.proxy, "proxy", "", "proxy AWS calls go through, e.g. http://proxy.internal:3128 (default $HTTPS_PROXY, except for the hosts in $NO_PROXY)")
+	fs.StringVar(&o.caBundle, "ca-bundle", "", "PEM file of CA certificates trusted in addition to the system's, e.g. the one of a TLS intercepting proxy")
+	fs.DurationVar(&o.requestTimeout, "request-timeout", 0, "give up on a single AWS call after this long, retrying it like other transient failures (no limit when 0)")
+	fs.StringVar(&o.auditLogFile, "audit-log", "", "file to append every change submitted to Route53 to, one JSON object per line (disabled when empty)")
+	fs.IntVar(&o.auditLogMaxSize, "audit-log-max-size", 100, "size in MB the audit log is rotated at, keeping the former ones as .1, .2 and so on (never rotated when 0)")
+	fs.IntVar(&o.auditLogMaxFiles, "audit-log-max-files", 5, "how many audit log files to keep, counting the current one")
+	fs.StringVar(&o.changeComment, "change-comment", "", "Go template of the comment of the change batches, e.g. '{{.Comment}} by {{.User}} on {{.InstanceID}} ({{.AMI}}) deploy {{env \"DEPLOY_SHA\"}}', see README (default a description of the change, like \"Host A Record Created\")")
+}
+
+func (o *options) addZoneFlags(fs *flag.FlagSet) {
+	o.addCommonFlags(fs)
+	fs.StringVar(&o.zoneName, "zonename", "", "which zone to use for registering records")
+	fs.StringVar(&o.zoneID, "zoneId", "", "route53 zone id which to use for registering records (instead of searching zone by name)")
+	fs.StringVar(&o.zoneCacheFile, "zone-cache-file", "", "file the ids of the zones looked up by -zonename are kept in between runs, e.g. /var/cache/route53_register/zones.json (disabled when empty)")
+	fs.DurationVar(&o.zoneCacheTTL, "zone-cache-ttl", time.Hour, "how long a zone id is taken from -zone-cache-file before it's looked up again")
+}
+
+func (o *options) addRecordFlags(fs *flag.FlagSet) {
+	o.addZoneFlags(fs)
+	fs.StringVar(&o.configFile, "config", "", "YAML or JSON file describing the records to work on, see README (flags given on the command line override its values)")
+	fs.IntVar(&o.parallel, "parallel", 4, "how many zones of the -config file are worked on at once; the registrations of one zone are applied one after the other")
+	fs.Var(&o.hostnames, "hostname", "which name to use for the new entry, @ for the zone apex, which is also used when it's left out (may be repeated to register several names for this host in one change)")
+	fs.StringVar(&o.hostnameFile, "hostname-file", "", "file holding the names to use instead of -hostname, one per line, read again by the daemon whenever it changes")
+	fs.StringVar(&o.valueFile, "value-file", "", "file holding the value of the record instead of this host's: an IP address, or a hostname with -cname, read again by the daemon whenever it changes")
+	fs.BoolVar(&o.cname, "cname", false, "whether to create CNAME record instead of an A record. (will use hostname instead of IP)")
+	fs.StringVar(&o.cnameTarget, "cname-target", "public", "which hostname of this host a CNAME points at: public, or private for instances without a public IP")
+	fs.StringVar(&o.addressSource, "address-source", "local-ipv4", "where the IP of this host's A record is taken from: elastic-ip, public-ipv4, local-ipv4 or interface[:name], or several separated by commas to use the first one that has an address")
+	fs.BoolVar(&o.appendAZ, "append-az", false, "add the instance's availability zone to the record names, e.g. web.eu-west-1a.example.com for -hostname web")
+	fs.BoolVar(&o.appendRegion, "append-region", false, "add the instance's region to the record names, after the availability zone with -append-az, e.g. web.eu-west-1.example.com")
+	fs.BoolVar(&o.unique, "unique", false, "add the end of the instance id to the first label of the record names, e.g. web-cdef0123.example.com, so instances launched from the same template get names of their own")
+	fs.StringVar(&o.alias.dnsName, "alias-target", "", "DNS name of an AWS resource, e.g. a load balancer, to create an alias A record to instead of an A record of this host's IP")
+	fs.StringVar(&o.alias.zoneID, "alias-zone-id", "", "hosted zone id of the -alias-target, e.g. the canonical hosted zone id of the load balancer")
+	fs.BoolVar(&o.alias.evaluateTargetHealth, "alias-evaluate-target-health", false, "answer with the alias record only while its target is healthy")
+	fs.BoolVar(&o.shared, "shared", false, "add this host's IP to a single round-robin A record shared with other hosts instead of registering a weighted record of its own")
+	fs.StringVar(&o.mxName, "mx-name", "", "also add this host to the MX record of this name, e.g. @ for mail to the zone, which the mail relays of a domain share (disabled when empty)")
+	fs.Int64Var(&o.mxPriority, "mx-priority", 10, "priority of this host in the -mx-name record, lower ones being tried first")
+	fs.StringVar(&o.setIdentifier, "set-identifier", "hostname", "how to identify this host's record among records sharing the name: hostname, instance-id, ip or a custom string")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of this host's record among the weighted records sharing the name")
+	fs.StringVar(&o.weightFrom, "weight-from", "", "derive the weight from the host: vcpu for -weight times its vCPUs, or instance-type-map for the weight -weight-map gives its instance type (default -weight as it is)")
+	fs.StringVar(&o.weightMapFile, "weight-map", "", "YAML or JSON file mapping instance types to weights for -weight-from instance-type-map, e.g. {m5.large: 2, m5.xlarge: 4}")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the record in seconds (default 60 for A, 300 for CNAME records)")
+	fs.StringVar(&o.healthCheckID, "health-check-id", "", "Route53 health check deciding whether this host's weighted record is served")
+	fs.StringVar(&o.healthCheckAlarm, "health-check-alarm", "", "CloudWatch alarm whose state decides whether this host's weighted record is served, through a health check created for it when there is none, for hosts Route53's health checkers can't reach")
+	fs.StringVar(&o.healthCheckAlarmRegion, "health-check-alarm-region", "", "region of the -health-check-alarm (default the region of the AWS clients, or else the instance's)")
+	fs.StringVar(&o.insufficientDataStatus, "health-check-insufficient-data", route53.InsufficientDataHealthStatusLastKnownStatus, "status of a created -health-check-alarm health check while the alarm has insufficient data: Healthy, Unhealthy or LastKnownStatus")
+	fs.BoolVar(&o.calculatedHealthCheck, "calculated-health-check", false, "keep a calculated health check aggregating the health checks of every weighted record sharing the name, for use as a failover target elsewhere")
+	fs.Int64Var(&o.calculatedHealthThreshold, "calculated-health-threshold", 1, "how many of the aggregated health checks must be healthy for the calculated one to be")
+	fs.StringVar(&o.lockTable, "lock-table", "", "DynamoDB table (partition key LockID of type string) used to serialize changes to the record across hosts")
+	fs.DurationVar(&o.lockTimeout, "lock-timeout", 2*time.Minute, "how long to wait for the lock of -lock-table, or for another run on this host to release -lock-file")
+	fs.StringVar(&o.lockFile, "lock-file", defaultLockFile(), "file locked while register, deregister, drain or undrain change this host's records, so runs on the host don't overlap; a daemon holds it while it changes or checks the records, and the file with .daemon appended for as long as it runs (disabled when empty)")
+	fs.StringVar(&o.cloudWatchNamespace, "cloudwatch-namespace", "", "CloudWatch namespace to put RegistrationSucceeded, RegistrationFailed and RegistrationLatency metrics in (disabled when empty)")
+	fs.StringVar(&o.cloudWatchDimensions, "cloudwatch-dimensions", "", "extra dimensions of the CloudWatch metrics as Name=Value pairs separated by commas")
+	fs.StringVar(&o.statsdAddr, "statsd-addr", "", "statsd server to send latency and error metrics to, as host:port (UDP) or unix:///path/to/socket (disabled when empty)")
+	fs.StringVar(&o.statsdPrefix, "statsd-prefix", "route53_register", "prefix of the statsd metric names")
+	fs.BoolVar(&o.statsdDogstatsd, "statsd-dogstatsd", true, "tag statsd metrics with the operation and record name, dogstatsd style")
+	fs.StringVar(&o.onSuccessExec, "on-success-exec", "", "shell command run after each record was changed successfully, or needed no change, told about it in ROUTE53_REGISTER_* variables and a JSON object on stdin")
+	fs.StringVar(&o.onFailureExec, "on-failure-exec", "", "shell command run after a change of a record failed, told about it like -on-success-exec")
+	fs.StringVar(&o.webhookURL, "webhook-url", "", "URL the outcome of each change of a record is POSTed to as a JSON object (disabled when empty)")
+	fs.StringVar(&o.snsTopicARN, "sns-topic-arn", "", "SNS topic to publish an event to whenever a record is created, changed or deleted, with its old and new values (disabled when empty)")
+	fs.StringVar(&o.slackWebhookURL, "slack-webhook-url", "", "Slack compatible incoming webhook to post registrations, drift and failures to (disabled when empty)")
+	fs.StringVar(&o.slackSeverity, "slack-severity", "info", "least severe messages posted to -slack-webhook-url: info (changes), warn (drift) or error (failures)")
+	fs.BoolVar(&o.verify, "verify", false, "after registering, wait for the change to be INSYNC and fail unless the zone's name servers answer with this host's value, or for a weighted record answer for its name and Route53 holds it with this host's value")
+	fs.StringVar(&o.verifyResolvers, "verify-resolvers", "", "recursive resolvers to verify the answer of as well, separated by commas, e.g. 8.8.8.8,1.1.1.1, polled until each answers with the new value, for shared records only as resolvers cache one of several weighted records (implies -verify)")
+	fs.DurationVar(&o.verifyResolversTimeout, "verify-resolvers-timeout", 5*time.Minute, "how long to wait for the -verify-resolvers to answer with the new value, which takes up to the TTL the record had before")
+	fs.DurationVar(&o.verifyResolversInterval, "verify-resolvers-interval", 5*time.Second, "how often the -verify-resolvers are asked for the record while waiting")
+	fs.BoolVar(&o.createZone, "create-zone", false, "create the -zonename hosted zone when there is none, e.g. for the subdomain of an ephemeral environment")
+	fs.StringVar(&o.createZoneVPC, "create-zone-vpc", "", "VPC id the created zone is private to, or instance for this instance's VPC (public when empty)")
+	fs.StringVar(&o.createZoneTags, "create-zone-tags", "", "tags of the created zone as Key=Value pairs separated by commas, in addition to managed-by=route53_register")
+	fs.BoolVar(&o.autoAssociate, "auto-associate", false, "associate this instance's VPC with the private zone before registering, when it isn't, instead of only warning")
+	fs.BoolVar(&o.checkDelegation, "check-delegation", false, "warn when the parent zone doesn't delegate the public zone to its name servers, as its records don't resolve then")
+	fs.BoolVar(&o.delegate, "delegate", false, "create the NS records delegating the public zone to its name servers in its parent zone before registering, e.g. with -create-zone for an environment's subdomain")
+	fs.StringVar(&o.parentZoneID, "parent-zone-id", "", "hosted zone id of the parent zone -delegate keeps the NS records in (default the closest public zone above the zone)")
+	fs.StringVar(&o.rollbackFile, "rollback-file", "", "file the records are saved to before register changes them, so the rollback command can restore them (disabled when empty)")
+	fs.BoolVar(&o.rollbackOnVerifyFailure, "rollback-on-verify-failure", false, "restore the records as they were before the change when -verify fails")
+	fs.BoolVar(&o.testAnswer, "test-answer", false, "log what Route53 answers for the record before and after the change, using its TestDNSAnswer API")
+	fs.StringVar(&o.answerQuery.subnet, "test-answer-subnet", "", "EDNS client subnet to test the answer for, e.g. 203.0.113.0/24 (implies -test-answer)")
+	fs.StringVar(&o.answerQuery.resolverIP, "test-answer-resolver", "", "IP address of the resolver to test the answer for (implies -test-answer)")
+	fs.StringVar(&o.output, "output", "text", "output format of the result: text (log lines only) or json (also print a JSON object to stdout)")
+}
+
+func (o *options) logLevel() *aws.LogLevelType {
+	if o.debug {
+		return aws.LogLevel(aws.LogDebugWithRequestErrors | aws.LogDebugWithHTTPBody)
+	}
+	return aws.LogLevel(aws.LogOff)
+}
+
+func (o *options) validateZone() error {
+	if o.zoneName == "" && o.zoneID == "" {
+		return configError("Either zonename or zoneId parameter is required. It sepecifies the zone in which record is added!")
+	}
+	if id := normalizeZoneID(o.zoneID); strings.ContainsAny(id, "/: ") {
+		return configError("Invalid zoneId " + o.zoneID + ", expected an id like Z123, /hostedzone/Z123 or the zone's ARN")
+	}
+	return nil
+}
+
+// normalizeZoneID returns the bare id of a hosted zone given as its id,
+// its path as the API returns it (/hostedzone/Z123) or its ARN.
+func normalizeZoneID(id string) string {
+	id = strings.TrimSpace(id)
+	if strings.HasPrefix(id, "arn:") {
+		// arn:<partition>:route53:::hostedzone/Z123
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			id = id[i+1:]
+		}
+	}
+	id = strings.TrimPrefix(id, "/")
+	return strings.TrimPrefix(id, "hostedzone/")
+}
+
+// validateNames checks the names of the records of o. Until the name of a
+// zone given by id is known, only duplicates are caught.
+func (o *options) validateNames() error {
+	zone := normalizeName(o.zone())
+	seen := map[string]bool{}
+	for _, h := range o.recordHostnames() {
+		name := o.recordName(h)
+		if seen[name] {
+			return configError("Hostname " + h + " is given more than once")
+		}
+		seen[name] = true
+		if o.unique && isApex(h) {
+			return configError("The unique parameter needs a hostname, the zone apex can't be made unique")
+		}
+		if zone == "" {
+			continue
+		}
+		if !inZone(name, zone) {
+			return configError("Hostname " + h + " is not in zone " + zone)
+		}
+		// Names with topology labels are below the apex
+		if name == zone && o.cname && !o.appendAZ && !o.appendRegion {
+			return configError("The zone apex can't have a CNAME record, it already has the zone's SOA and NS records. Register an A record, or an alias A record with -alias-target")
+		}
+		if err := validateDNSName(name); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+	return nil
+}
+
+func (o *options) validateRecord() error {
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if err := o.validateNames(); err != nil {
+		return err
+	}
+	if err := o.alias.validate(); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if _, ok := cnameTargetPaths[o.cnameTarget]; !ok {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown cname-target %q, expected public or private", o.cnameTarget))
+	}
+	if _, err := parseAddressSources(o.addressSource); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if o.alias.dnsName != "" && o.valueFile != "" {
+		return configError("The alias-target and value-file parameters can't be combined")
+	}
+	if o.alias.dnsName != "" && (o.cname || o.shared) {
+		return configError("Alias records can't be combined with the cname or shared parameters")
+	}
+	if o.alias.dnsName != "" {
+		if err := validateDNSName(normalizeName(o.alias.dnsName)); err != nil {
+			return withExitCode(exitConfig, fmt.Errorf("Invalid alias-target: %v", err))
+		}
+	}
+	if o.output != "text" && o.output != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown output format %q, expected text or json", o.output))
+	}
+	if o.webhookURL != "" {
+		if u, err := url.Parse(o.webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return configError("Invalid webhook-url " + o.webhookURL + ", expected an http or https URL")
+		}
+	}
+	if o.slackWebhookURL != "" {
+		if u, err := url.Parse(o.slackWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return configError("Invalid slack-webhook-url, expected an http or https URL")
+		}
+	}
+	if s, err := parseSeverity(o.slackSeverity); err != nil || s == levelDebug {
+		return configError("Unknown slack-severity " + o.slackSeverity + ", expected info, warn or error")
+	}
+	if o.snsTopicARN != "" {
+		if err := validateTopicARN(o.snsTopicARN); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+	if _, err := parseDimensions(o.cloudWatchDimensions); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if o.verifyResolversTimeout <= 0 || o.verifyResolversInterval <= 0 {
+		return configError("The verify-resolvers-timeout and verify-resolvers-interval parameters must be positive")
+	}
+	if err := o.answerQuery.validate("test-answer-subnet", "test-answer-resolver"); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if o.shared && o.cname {
+		return configError("Shared records can only be A records, CNAMEs can't hold more than one value!")
+	}
+	if o.shared && o.healthCheckID != "" {
+		return configError("Shared records can't have a health check of their own, it would apply to every host in them")
+	}
+	if o.healthCheckAlarm != "" && o.healthCheckID != "" {
+		return configError("The health-check-alarm and health-check-id parameters can't be combined")
+	}
+	if o.shared && o.healthCheckAlarm != "" {
+		return configError("Shared records can't have a health check of their own, it would apply to every host in them")
+	}
+	if err := o.validateMX(); err != nil {
+		return err
+	}
+	switch o.insufficientDataStatus {
+	case route53.InsufficientDataHealthStatusHealthy, route53.InsufficientDataHealthStatusUnhealthy, route53.InsufficientDataHealthStatusLastKnownStatus:
+	default:
+		return configError("Unknown health-check-insufficient-data " + o.insufficientDataStatus + ", expected Healthy, Unhealthy or LastKnownStatus")
+	}
+	if err := o.validateWeightFrom(); err != nil {
+		return err
+	}
+	if o.shared && o.calculatedHealthCheck {
+		return configError("Shared records have no health checks to aggregate, the calculated-health-check parameter needs weighted records")
+	}
+	if o.calculatedHealthThreshold < 1 {
+		return configError("The calculated-health-threshold parameter must be at least 1")
+	}
+	if o.createZone && (o.zoneName == "" || o.zoneID != "") {
+		return configError("The create-zone parameter needs the zonename parameter, and can't be combined with zoneId")
+	}
+	if o.parentZoneID != "" && !o.delegate {
+		return configError("The parent-zone-id parameter needs the delegate parameter")
+	}
+	if o.delegate && o.createZoneVPC != "" {
+		return configError("The delegate parameter can't be combined with create-zone-vpc, private zones aren't delegated")
+	}
+	if o.createZoneVPC != "" && o.createZoneVPC != instanceVPC && !strings.HasPrefix(o.createZoneVPC, "vpc-") {
+		return configError("Invalid create-zone-vpc " + o.createZoneVPC + ", expected a VPC id like vpc-0123456789abcdef0 or instance")
+	}
+	if _, err := parseTags(o.createZoneTags); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	if o.shared && o.savesRollback() {
+		return configError("Shared records can't be rolled back, restoring them would undo the changes of the other hosts")
+	}
+	if err := validateTTL(o.ttl); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getDNSHostedZoneID(ctx context.Context, account awsAccount, DNSName string) (string, error) {
+	sess, err := account.session(nil)
+	if err != nil {
+		return "", err
+	}
+	r53 := route53.New(sess)
+	params := &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(DNSName),
+	}
+
+	zones, err := r53.ListHostedZonesByNameWithContext(ctx, params)
+
+	if err == nil {
+		// Zones are listed from DNSName on, the first one may be the next
+		// zone in line when there is none by that name
+		if len(zones.HostedZones) > 0 && sameRecordName(aws.StringValue(zones.HostedZones[0].Name), DNSName) {
+			return aws.StringValue(zones.HostedZones[0].Id), nil
+		}
+		return "", &exitError{exitZoneNotFound, errors.New("No hosted zone named " + DNSName)}
+	}
+
+	return "", err
+}
+
+// hostedZoneName returns the name of a hosted zone.
+func hostedZoneName(ctx context.Context, account awsAccount, zoneID string) (string, error) {
+	sess, err := account.session(nil)
+	if err != nil {
+		return "", err
+	}
+	out, err := route53.New(sess).GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(normalizeZoneID(zoneID))})
+	if err != nil {
+		return "", err
+	}
+	return normalizeName(aws.StringValue(out.HostedZone.Name)), nil
+}
+
+// checkZoneName fails unless the hosted zone zoneID is named zoneName, so
+// records aren't composed from the name of another zone.
+func checkZoneName(ctx context.Context, account awsAccount, zoneID, zoneName string) error {
+	name, err := hostedZoneName(ctx, account, zoneID)
+	if err != nil {
+		logger.Warn("Hosted zone lookup failed", errorFields(err, fields{"zone_id": zoneID}))
+		return err
+	}
+	if !sameRecordName(name, zoneName) {
+		return configError("Hosted zone " + normalizeZoneID(zoneID) + " is named " + name + ", not " + zoneName)
+	}
+	return nil
+}
+
+// resolveZoneID returns the hosted zone to work in, looking it up by name
+// unless its id was given, in which case its name is looked up instead. When
+// both were given, they must be the same zone.
+func (o *options) resolveZoneID(ctx context.Context) (zoneID string, err error) {
+	if o.zoneID != "" {
+		zoneID = "/hostedzone/" + normalizeZoneID(o.zoneID)
+		switch {
+		case o.zoneName != "":
+			err = checkZoneName(ctx, o.account, zoneID, o.zoneName)
+		case o.resolvedZoneName == "":
+			// Records are named relative to the zone
+			o.resolvedZoneName, err = hostedZoneName(ctx, o.account, zoneID)
+			if err != nil {
+				logger.Warn("Hosted zone lookup failed", errorFields(err, fields{"zone_id": zoneID}))
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		return zoneID, nil
+	}
+	if zoneID, ok := zoneCache.lookup(o.account, o.zoneName); ok {
+		logger.Debug("Resolved hosted zone from cache", fields{"zone_name": o.zoneName, "zone_id": zoneID})
+		return zoneID, nil
+	}
+	s := tracing.Start(ctx, "zone lookup", fields{"zone_name": o.zoneName})
+	defer func() {
+		s.attrs["zone_id"] = zoneID
+		s.End(err)
+	}()
+	// Transient errors are retried by the client according to the retry flags
+	zoneID, err = getDNSHostedZoneID(ctx, o.account, o.zoneName)
+	if err != nil && o.createZone && exitCode(err) == exitZoneNotFound {
+		zoneID, err = o.createHostedZone(ctx)
+	}
+	if err != nil {
+		logger.Warn("Hosted zone lookup failed", errorFields(err, fields{"zone_name": o.zoneName}))
+		return "", err
+	}
+	logger.Debug("Resolved hosted zone", fields{"zone_name": o.zoneName, "zone_id": zoneID})
+	zoneCache.store(o.account, o.zoneName, zoneID)
+	return zoneID, nil
+}
+
+// resolveSetIdentifier turns the -set-identifier strategy into the identifier
+// used to tell this host's record apart from others sharing the same name.
+// Any value that is not a known strategy is used verbatim.
+func resolveSetIdentifier(ctx context.Context, strategy, hostName string, metadataClient *ec2metadata.EC2Metadata) (string, error) {
+	switch strategy {
+	case "hostname":
+		return hostName, nil
+	case "instance-id":
+		return getMetadata(ctx, metadataClient, "/instance-id")
+	case "ip":
+		return getMetadata(ctx, metadataClient, "/local-ipv4")
+	}
+	return strategy, nil
+}
+
+// topologyLabels returns the labels -append-az and -append-region add to
+// the record names, read from the instance identity document.
+func (o *options) topologyLabels(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) ([]string, error) {
+	if !o.appendAZ && !o.appendRegion {
+		return nil, nil
+	}
+	doc, err := getIdentityDocument(ctx, metadataClient)
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	if o.appendAZ {
+		labels = append(labels, doc.AvailabilityZone)
+	}
+	if o.appendRegion {
+		labels = append(labels, doc.Region)
+	}
+	return labels, nil
+}
+
+// cnameTargetPaths are the metadata paths of the hostnames a CNAME can
+// point at.
+var cnameTargetPaths = map[string]string{
+	"public":  "/public-hostname",
+	"private": "/local-hostname",
+}
+
+// resolveTargets works out the records this host should have from the flags
+// and the instance metadata, one for each -hostname.
+func (o *options) resolveTargets(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) ([]*target, error) {
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if o.zoneName == "" {
+		if err = o.validateNames(); err != nil {
+			return nil, err
+		}
+		if err = o.validateMX(); err != nil {
+			return nil, err
+		}
+	}
+	s := tracing.Start(ctx, "metadata fetch", nil)
+	defer func() {
+		s.End(err)
+	}()
+	rrType := route53.RRTypeA
+	value := o.alias.dnsName
+	switch {
+	case value != "":
+	case o.valueFile != "":
+		value = o.fileValue
+		if o.cname {
+			rrType = route53.RRTypeCname
+		}
+	case o.cname:
+		rrType = route53.RRTypeCname
+		value, err = getMetadata(ctx, metadataClient, cnameTargetPaths[o.cnameTarget])
+		if isMetadataNotFound(err) || err == nil && value == "" {
+			msg := "This instance has no " + o.cnameTarget + " hostname for a CNAME to point at"
+			if o.cnameTarget == "public" {
+				msg += ", use -cname-target private in private subnets"
+			}
+			err = configError(msg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		var sources []string
+		if sources, err = parseAddressSources(o.addressSource); err != nil {
+			return nil, withExitCode(exitConfig, err)
+		}
+		var source string
+		if value, source, err = resolveAddress(ctx, metadataClient, sources); err != nil {
+			return nil, err
+		}
+		logger.Debug("Resolved address", fields{"address_source": source, "value": value})
+	}
+	var labels []string
+	if labels, err = o.topologyLabels(ctx, metadataClient); err != nil {
+		return nil, err
+	}
+	var instanceID string
+	if o.unique {
+		if instanceID, err = getMetadata(ctx, metadataClient, "/instance-id"); err != nil {
+			return nil, err
+		}
+	}
+	if o.healthCheckAlarm != "" {
+		if err = o.findAlarmHealthCheck(ctx, metadataClient); err != nil {
+			return nil, err
+		}
+	}
+	var weight int64
+	if weight, err = o.recordWeight(ctx, metadataClient); err != nil {
+		return nil, err
+	}
+	var ts []*target
+	for _, hostname := range o.recordHostnames() {
+		name := o.recordName(hostname)
+		if o.unique {
+			name = uniqueName(name, instanceID)
+		}
+		if len(labels) > 0 {
+			name = insertLabels(name, normalizeName(o.zone()), labels)
+		}
+		if o.unique || len(labels) > 0 {
+			if err = validateDNSName(name); err != nil {
+				return nil, withExitCode(exitConfig, err)
+			}
+		}
+		var setIdentifier string
+		setIdentifier, err = resolveSetIdentifier(ctx, o.setIdentifier, hostname, metadataClient)
+		if err != nil {
+			return nil, err
+		}
+		t := &target{
+			zoneID:        zoneID,
+			name:          name,
+			rrType:        rrType,
+			value:         value,
+			setIdentifier: setIdentifier,
+			weight:        weight,
+			ttl:           recordTTL(o.ttl, rrType),
+			healthCheckID: o.healthCheck(),
+			alias:         o.alias,
+			shared:        o.shared,
+			force:         o.force,
+		}
+		if err = t.validateValue(); err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// withLock runs fn while holding the -lock-table locks of ts, if one is configured.
+func (o *options) withLock(ctx context.Context, ts []*target, metadataClient *ec2metadata.EC2Metadata, fn func() error) error {
+	if o.lockTable == "" {
+		return fn()
+	}
+	var keys []string
+	for _, t := range ts {
+		keys = append(keys, t.zoneID+"/"+t.name)
+	}
+	// Every host takes the locks in the same order, so hosts registering
+	// overlapping names can't end up waiting for each other
+	sort.Strings(keys)
+	return o.withLockKeys(ctx, keys, metadataClient, fn)
+}
+
+func (o *options) withLockKeys(ctx context.Context, keys []string, metadataClient *ec2metadata.EC2Metadata, fn func() error) error {
+	if len(keys) == 0 {
+		return fn()
+	}
+	lock, err := newFleetLock(ctx, o.lockTable, keys[0], metadataClient, o.logLevel())
+	if err != nil {
+		return err
+	}
+	if err = lock.Acquire(ctx, o.lockTimeout); err != nil {
+		return err
+	}
+	err = o.withLockKeys(ctx, keys[1:], metadataClient, fn)
+	// Release even when ctx ran out, other hosts would wait for the lease otherwise
+	releaseCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if rerr := lock.Release(releaseCtx); rerr != nil {
+		logger.Warn("Error releasing lock", errorFields(rerr, fields{"lock": lock.key}))
+	}
+	return err
+}
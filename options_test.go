@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNormalizeZoneID(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Z123", "Z123"},
+		{" Z123 ", "Z123"},
+		{"/hostedzone/Z123", "Z123"},
+		{"hostedzone/Z123", "Z123"},
+		{"arn:aws:route53:::hostedzone/Z123", "Z123"},
+		{"arn:aws-cn:route53:::hostedzone/Z123", "Z123"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeZoneID(tt.in); got != tt.want {
+			t.Errorf("normalizeZoneID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateZone(t *testing.T) {
+	tests := []struct {
+		zoneName, zoneID string
+		wantErr          bool
+	}{
+		{zoneName: "example.com"},
+		{zoneID: "Z123"},
+		{zoneID: "/hostedzone/Z123"},
+		{zoneID: "arn:aws:route53:::hostedzone/Z123"},
+		{wantErr: true},
+		{zoneID: "/change/C123", wantErr: true},
+		{zoneID: "arn:aws:route53:::hostedzone/Z1 Z2", wantErr: true},
+	}
+	for _, tt := range tests {
+		o := options{zoneName: tt.zoneName, zoneID: tt.zoneID}
+		if err := o.validateZone(); (err != nil) != tt.wantErr {
+			t.Errorf("validateZone of zonename %q and zoneId %q = %v, want an error: %v", tt.zoneName, tt.zoneID, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Every record registered by this tool is paired with a TXT record named
+// ownerPrefix + record name holding one ownership marker per registration.
+// The markers tell our records apart from ones created by hand and say when
+// each registration was last refreshed, which is what prune goes by.
+const ownerPrefix = "_route53_register."
+
+const heritage = "route53_register"
+
+type ownerMarker struct {
+	// id is the set identifier of the registration, or its value in a shared record
+	id         string
+	registered time.Time
+	// drainedWeight is the weight to restore on undrain for a drained
+	// registration, zero otherwise
+	drainedWeight int64
+	// source is the command keeping the record other than register, if any
+	source string
+}
+
+func ownerRecordName(name string) string {
+	return ownerPrefix + name
+}
+
+// isOwnerRecordName reports whether a record name is the one of an ownership
+// marker, returning the name of the record it belongs to.
+func isOwnerRecordName(name string) (string, bool) {
+	if !strings.HasPrefix(name, ownerPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, ownerPrefix), true
+}
+
+// String formats the marker as a quoted TXT value. The id goes last so that
+// it may contain the separators itself.
+func (m ownerMarker) String() string {
+	extra := ""
+	if m.drainedWeight > 0 {
+		extra += ",drained=" + strconv.FormatInt(m.drainedWeight, 10)
+	}
+	if m.source != "" {
+		extra += ",source=" + m.source
+	}
+	return fmt.Sprintf("\"heritage=%s,registered=%s%s,id=%s\"", heritage, m.registered.UTC().Format(time.RFC3339), extra, m.id)
+}
+
+// parseOwnerMarker parses a TXT value written by String, reporting false for
+// values that weren't written by this tool.
+func parseOwnerMarker(value string) (ownerMarker, bool) {
+	var m ownerMarker
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "\""), "\"")
+	i := strings.Index(value, ",id=")
+	if i < 0 {
+		return m, false
+	}
+	m.id = value[i+len(",id="):]
+	owned := false
+	for _, field := range strings.Split(value[:i], ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "heritage":
+			owned = kv[1] == heritage
+		case "registered":
+			m.registered, _ = time.Parse(time.RFC3339, kv[1])
+		case "drained":
+			m.drainedWeight, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "source":
+			m.source = kv[1]
+		}
+	}
+	return m, owned
+}
+
+// ownsRecord reports whether markerSet carries an ownership marker with the
+// given id, that of a weighted registration being its set identifier and
+// that of a shared one its value.
+func ownsRecord(markerSet *route53.ResourceRecordSet, id string) bool {
+	for _, v := range recordValues(markerSet) {
+		if m, ok := parseOwnerMarker(v); ok && m.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// notOwnedError is returned when a record, or a value of a shared one, is
+// to be removed but has no ownership marker of ours, e.g. one created by
+// hand that happens to have the name and set identifier of a host's. Such
+// records are only removed with -force.
+type notOwnedError struct {
+	name, rrType, id string
+}
+
+func (e notOwnedError) Error() string {
+	return fmt.Sprintf("%s %s %s has no ownership marker, refusing to remove it", e.name, e.rrType, e.id)
+}
+
+// checkOwned returns the error refusing to remove a record of t that
+// markerSet doesn't own, unless t is forced, which is only logged.
+func checkOwned(t *target, markerSet *route53.ResourceRecordSet, id string) error {
+	if ownsRecord(markerSet, id) {
+		return nil
+	}
+	if !t.force {
+		return withExitCode(exitNotOwned, notOwnedError{t.name, t.rrType, id})
+	}
+	logger.Warn("Removing record without ownership marker", t.fields())
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOwnerMarkerRoundTrip(t *testing.T) {
+	registered := time.Date(2026, time.March, 1, 12, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name   string
+		marker ownerMarker
+		want   string
+	}{
+		{
+			name:   "plain",
+			marker: ownerMarker{id: "i-0123456789abcdef0", registered: registered},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,id=i-0123456789abcdef0"`,
+		},
+		{
+			name:   "drained",
+			marker: ownerMarker{id: "web-1", registered: registered, drainedWeight: 20},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,drained=20,id=web-1"`,
+		},
+		{
+			name:   "source",
+			marker: ownerMarker{id: "web-1", registered: registered, source: "kubernetes"},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,source=kubernetes,id=web-1"`,
+		},
+		{
+			name:   "drained and source",
+			marker: ownerMarker{id: "web-1", registered: registered, drainedWeight: 255, source: "consul"},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,drained=255,source=consul,id=web-1"`,
+		},
+		{
+			name:   "id with separators",
+			marker: ownerMarker{id: "10 mail.example.com.,id=x", registered: registered},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,id=10 mail.example.com.,id=x"`,
+		},
+		{
+			name:   "registered in another time zone",
+			marker: ownerMarker{id: "web-1", registered: registered.In(time.FixedZone("UTC+2", 7200))},
+			want:   `"heritage=route53_register,registered=2026-03-01T12:30:45Z,id=web-1"`,
+		},
+	}
+	for _, tt := range tests {
+		got := tt.marker.String()
+		if got != tt.want {
+			t.Errorf("%s: String() = %s, want %s", tt.name, got, tt.want)
+		}
+		m, ok := parseOwnerMarker(got)
+		if !ok {
+			t.Errorf("%s: parseOwnerMarker(%s) didn't recognize the marker", tt.name, got)
+			continue
+		}
+		if m.id != tt.marker.id || !m.registered.Equal(tt.marker.registered) || m.drainedWeight != tt.marker.drainedWeight || m.source != tt.marker.source {
+			t.Errorf("%s: parseOwnerMarker(%s) = %+v, want %+v", tt.name, got, m, tt.marker)
+		}
+	}
+}
+
+func TestParseOwnerMarkerForeign(t *testing.T) {
+	for _, value := range []string{
+		`""`,
+		`"v=spf1 -all"`,
+		`"heritage=external-dns,external-dns/owner=default"`,
+		`"heritage=route53_register,registered=2026-03-01T12:30:45Z"`,
+		`"heritage=other,registered=2026-03-01T12:30:45Z,id=web-1"`,
+		`"registered=2026-03-01T12:30:45Z,id=web-1"`,
+	} {
+		if m, ok := parseOwnerMarker(value); ok {
+			t.Errorf("parseOwnerMarker(%s) = %+v, want it not recognized", value, m)
+		}
+	}
+}
+
+func TestOwnerRecordName(t *testing.T) {
+	name := ownerRecordName("web.example.com.")
+	if name != "_route53_register.web.example.com." {
+		t.Errorf("ownerRecordName = %s", name)
+	}
+	if record, ok := isOwnerRecordName(name); !ok || record != "web.example.com." {
+		t.Errorf("isOwnerRecordName(%s) = %s, %v", name, record, ok)
+	}
+	if _, ok := isOwnerRecordName("web.example.com."); ok {
+		t.Errorf("isOwnerRecordName recognized a plain record name")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// parameterPrefix marks settings whose value is read from the SSM Parameter
+// Store, e.g. -zoneId ssm:/dns/prod/zone-id.
+const parameterPrefix = "ssm:"
+
+// parameterStore reads parameters with the instance's credentials, each one
+// only once.
+type parameterStore struct {
+	client *ssm.SSM
+	values map[string]string
+}
+
+var parameters parameterStore
+
+// resolve returns value, or the parameter it names when it has the
+// parameterPrefix.
+func (s *parameterStore) resolve(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, value string) (string, error) {
+	if !strings.HasPrefix(value, parameterPrefix) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, parameterPrefix)
+	if name == "" {
+		return "", configError("No parameter name after " + parameterPrefix)
+	}
+	if v, ok := s.values[name]; ok {
+		return v, nil
+	}
+	if s.client == nil {
+		// The Parameter Store is regional, parameters are read from the
+		// region of the instance unless AWS_REGION says otherwise
+		sess, cfg, err := newRegionalSession(ctx, metadataClient, nil)
+		if err != nil {
+			return "", err
+		}
+		s.client = ssm.New(sess, cfg)
+	}
+	out, err := s.client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return "", err
+		}
+		err = awserr.New(aerr.Code(), "Error reading SSM parameter "+name, aerr)
+		if aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return "", withExitCode(exitConfig, err)
+		}
+		return "", err
+	}
+	if s.values == nil {
+		s.values = map[string]string{}
+	}
+	v := aws.StringValue(out.Parameter.Value)
+	s.values[name] = v
+	// The value may be a SecureString, keep it out of the logs
+	logger.Debug("Read SSM parameter", fields{"parameter": name})
+	return v, nil
+}
+
+// forget drops the parameters read so far, making the next resolve read
+// them again.
+func (s *parameterStore) forget() {
+	s.values = nil
+}
+
+// names returns the names of the parameters read so far.
+func (s *parameterStore) names() []string {
+	var names []string
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveParameters replaces the settings that name SSM parameters with
+// the values of those parameters.
+func (o *options) resolveParameters() error {
+	var settings []*string
+	for i := range o.hostnames {
+		settings = append(settings, &o.hostnames[i])
+	}
+	settings = append(settings, &o.zoneName, &o.zoneID, &o.setIdentifier, &o.healthCheckID, &o.lockTable)
+
+	var metadataClient *ec2metadata.EC2Metadata
+	ctx, cancel := o.context()
+	defer cancel()
+	for _, setting := range settings {
+		if !strings.HasPrefix(*setting, parameterPrefix) {
+			continue
+		}
+		if metadataClient == nil {
+			var err error
+			if metadataClient, err = newMetadataClient(); err != nil {
+				return err
+			}
+		}
+		v, err := parameters.resolve(ctx, metadataClient, *setting)
+		if err != nil {
+			return err
+		}
+		*setting = v
+	}
+	return nil
+}
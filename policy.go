@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// policyDocument is an IAM policy.
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect    string                       `json:"Effect"`
+	Action    []string                     `json:"Action"`
+	Resource  []string                     `json:"Resource"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+// policyBuilder collects the actions needed on each set of resources.
+type policyBuilder struct {
+	statements []policyStatement
+}
+
+func (b *policyBuilder) allow(resources []string, actions ...string) {
+	key := strings.Join(resources, ",")
+	for i := range b.statements {
+		s := &b.statements[i]
+		if s.Condition == nil && strings.Join(s.Resource, ",") == key {
+			for _, a := range actions {
+				if !containsString(s.Action, a) {
+					s.Action = append(s.Action, a)
+				}
+			}
+			sort.Strings(s.Action)
+			return
+		}
+	}
+	sort.Strings(actions)
+	b.statements = append(b.statements, policyStatement{Effect: "Allow", Action: actions, Resource: resources})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// policyOperations are the operations iam-policy knows the calls of.
+var policyOperations = []string{"register", "deregister", "drain", "undrain", "status", "daemon", "list", "prune", "shift", "sync", "controller", "discover", "cleanup", "kubernetes", "dnsrecords", "nomad", "consul", "serve", "check", "history", "rollback", "export", "import", "delegate", "ds", "traffic-policy", "spf", "dkim", "dmarc", "change-status", "resolve", "migrate", "swap"}
+
+func runIAMPolicy(args []string) error {
+	var o options
+	fs := newFlagSet("iam-policy")
+	o.addRecordFlags(fs)
+	operation := fs.String("operation", "register", "operation to print the policy for: "+strings.Join(policyOperations, ", "))
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	if !containsString(policyOperations, *operation) {
+		return configError("Unknown operation " + *operation + ", expected one of " + strings.Join(policyOperations, ", "))
+	}
+	if *operation == "change-status" {
+		// Changes belong to no zone
+		return printPolicy(o.policy(*operation, nil, false, false, parameters.names()))
+	}
+	if *operation == "discover" {
+		// The zones are only known from the tags of the instances
+		zones := []string{awsEndpoints.arn("route53", "", "", "hostedzone/*")}
+		return printPolicy(o.policy(*operation, zones, true, false, parameters.names()))
+	}
+	regs := []*options{&o}
+	if o.configFile != "" {
+		var err error
+		if regs, err = o.registrations(); err != nil {
+			return err
+		}
+	}
+	for _, r := range regs {
+		if err := r.validateZone(); err != nil {
+			return err
+		}
+	}
+
+	var zones []string
+	looksUpZones, getsZones := false, false
+	for _, r := range regs {
+		// Zones given by id are got for their name, or to check it against
+		// the one given, which check only does when both are given
+		getsZones = getsZones || r.zoneID != "" && (r.zoneName != "" || *operation != "check")
+		arn := awsEndpoints.arn("route53", "", "", "hostedzone/*")
+		if r.zoneID != "" {
+			arn = awsEndpoints.arn("route53", "", "", "hostedzone/"+normalizeZoneID(r.zoneID))
+		} else {
+			looksUpZones = true
+			zoneID, err := getDNSHostedZoneID(ctx, r.account, r.zoneName)
+			if err != nil {
+				// The policy is still useful with a wildcard, e.g. when
+				// printed from a machine that may not read the zone
+				logger.Warn("Error looking up hosted zone, allowing every zone", errorFields(err, fields{"zone_name": r.zoneName}))
+			} else {
+				arn = awsEndpoints.arn("route53", "", "", "hostedzone/"+normalizeZoneID(zoneID))
+			}
+		}
+		if !containsString(zones, arn) {
+			zones = append(zones, arn)
+		}
+	}
+
+	policy := o.policy(*operation, zones, looksUpZones, getsZones, parameters.names())
+	var roles []string
+	for _, r := range regs {
+		if r.account.roleARN != "" && !containsString(roles, r.account.roleARN) {
+			roles = append(roles, r.account.roleARN)
+		}
+	}
+	if len(roles) > 0 {
+		// The route53 actions are the ones the roles need on their zones
+		policy.Statement = append(policy.Statement, policyStatement{Effect: "Allow", Action: []string{"sts:AssumeRole"}, Resource: roles})
+	}
+	return printPolicy(policy)
+}
+
+func printPolicy(policy policyDocument) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(policy)
+}
+
+// policy returns the IAM policy an operation needs with these options on the
+// given hosted zone ARNs, looking them up by name or getting them by id
+// first, having read the given SSM parameters.
+func (o *options) policy(operation string, zones []string, looksUpZones, getsZones bool, parameterNames []string) policyDocument {
+	var b policyBuilder
+	if len(parameterNames) > 0 {
+		var arns []string
+		for _, name := range parameterNames {
+			if !strings.HasPrefix(name, "/") {
+				name = "/" + name
+			}
+			arns = append(arns, awsEndpoints.arn("ssm", "*", "*", "parameter"+name))
+		}
+		b.allow(arns, "ssm:GetParameter")
+	}
+	const (
+		list   = "route53:ListResourceRecordSets"
+		change = "route53:ChangeResourceRecordSets"
+	)
+	if looksUpZones {
+		b.allow([]string{"*"}, "route53:ListHostedZonesByName")
+	}
+	if getsZones {
+		b.allow(zones, "route53:GetHostedZone")
+	}
+	// Host records are changed through the same steps, which may lock,
+	// verify, test answers and report metrics
+	changesRecords, registers := false, false
+	switch operation {
+	case "register":
+		b.allow(zones, change)
+		if o.shared || o.mxName != "" {
+			b.allow(zones, list)
+		}
+		changesRecords, registers = true, true
+	case "daemon":
+		b.allow(zones, list, change)
+		changesRecords, registers = true, true
+	case "deregister", "drain", "undrain", "rollback":
+		b.allow(zones, list, change)
+		changesRecords = true
+	case "prune", "sync", "import", "swap", "kubernetes", "dnsrecords", "nomad", "consul", "serve", "spf", "dkim", "dmarc":
+		b.allow(zones, list, change)
+	case "cleanup":
+		b.allow(zones, list, change)
+		// The queue is only known to the cleanup command
+		b.allow([]string{awsEndpoints.arn("sqs", "*", "*", "*")}, "sqs:ReceiveMessage", "sqs:DeleteMessage")
+	case "controller", "discover":
+		b.allow(zones, list, change)
+		b.allow([]string{"*"}, "ec2:DescribeInstances")
+	case "shift":
+		b.allow(zones, list, change)
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "healthcheck/*")}, "route53:GetHealthCheckStatus")
+	case "migrate":
+		b.allow(zones, list, change, "route53:GetHostedZone")
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "change/*")}, "route53:GetChange")
+	case "resolve":
+		b.allow(zones, list)
+		b.allow([]string{"*"}, "route53:TestDNSAnswer")
+	case "status", "list", "export":
+		b.allow(zones, list)
+		if operation == "status" {
+			b.allow(zones, "route53:GetDNSSEC")
+		}
+	case "ds":
+		b.allow(zones, "route53:GetDNSSEC")
+	case "delegate":
+		o.allowDelegation(&b, zones)
+	case "check":
+		b.allow(zones, list, "route53:GetHostedZone")
+		b.allow([]string{"*"}, "sts:GetCallerIdentity")
+	case "traffic-policy":
+		b.allow(zones, "route53:ListTrafficPolicyInstancesByHostedZone", "route53:CreateTrafficPolicyInstance")
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "trafficpolicy/*")}, "route53:ListTrafficPolicyVersions", "route53:CreateTrafficPolicyInstance")
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "trafficpolicyinstance/*")}, "route53:UpdateTrafficPolicyInstance", "route53:DeleteTrafficPolicyInstance")
+	case "change-status":
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "change/*")}, "route53:GetChange")
+	case "history":
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "change/*")}, "route53:GetChange")
+		b.allow([]string{"*"}, "cloudtrail:LookupEvents")
+	}
+
+	if (changesRecords || operation == "status") && o.usesAddressSource("elastic-ip") {
+		b.allow([]string{"*"}, "ec2:DescribeAddresses")
+	}
+	if registers && (o.verify || o.verifyResolvers != "") {
+		b.allow(zones, "route53:GetHostedZone")
+		b.allow([]string{awsEndpoints.arn("route53", "", "", "change/*")}, "route53:GetChange")
+	}
+	if changesRecords {
+		if o.testAnswer || o.answerQuery != (answerQuery{}) {
+			b.allow([]string{"*"}, "route53:TestDNSAnswer")
+			b.allow([]string{awsEndpoints.arn("route53", "", "", "change/*")}, "route53:GetChange")
+			b.allow(zones, list)
+		}
+		if o.snsTopicARN != "" {
+			b.allow(zones, list)
+			b.allow([]string{o.snsTopicARN}, "sns:Publish")
+		}
+		if o.savesRollback() {
+			b.allow(zones, list)
+		}
+		if registers {
+			// The zone is read to check the VPC association of private zones
+			b.allow(zones, "route53:GetHostedZone")
+		}
+		if o.autoAssociate {
+			b.allow(zones, "route53:AssociateVPCWithHostedZone")
+			b.allow([]string{"*"}, "ec2:DescribeVpcs")
+		}
+		if o.delegate {
+			o.allowDelegation(&b, zones)
+		}
+		if o.createZone {
+			b.allow([]string{"*"}, "route53:CreateHostedZone")
+			b.allow([]string{awsEndpoints.arn("route53", "", "", "hostedzone/*")}, "route53:ChangeTagsForResource")
+			if o.createZoneVPC != "" {
+				b.allow([]string{"*"}, "ec2:DescribeVpcs")
+			}
+		}
+		if o.healthCheckAlarm != "" {
+			b.allow([]string{"*"}, "route53:ListHealthChecks")
+			if registers {
+				// Route53 reads the alarm on behalf of the caller creating the check
+				b.allow([]string{"*"}, "route53:CreateHealthCheck")
+				b.allow([]string{awsEndpoints.arn("route53", "", "", "healthcheck/*")}, "route53:ChangeTagsForResource")
+				b.allow([]string{awsEndpoints.arn("cloudwatch", "*", "*", "alarm:"+o.healthCheckAlarm)}, "cloudwatch:DescribeAlarms")
+			}
+		}
+		if o.calculatedHealthCheck {
+			b.allow(zones, list)
+			b.allow([]string{"*"}, "route53:ListHealthChecks", "route53:CreateHealthCheck")
+			b.allow([]string{awsEndpoints.arn("route53", "", "", "healthcheck/*")}, "route53:UpdateHealthCheck", "route53:ListTagsForResources", "route53:ChangeTagsForResource")
+		}
+		if o.lockTable != "" {
+			b.allow([]string{awsEndpoints.arn("dynamodb", "*", "*", "table/"+o.lockTable)}, "dynamodb:PutItem", "dynamodb:DeleteItem")
+		}
+		if o.cloudWatchNamespace != "" {
+			b.statements = append(b.statements, policyStatement{
+				Effect:    "Allow",
+				Action:    []string{"cloudwatch:PutMetricData"},
+				Resource:  []string{"*"},
+				Condition: map[string]map[string]string{"StringEquals": {"cloudwatch:namespace": o.cloudWatchNamespace}},
+			})
+		}
+	}
+	return policyDocument{Version: "2012-10-17", Statement: b.statements}
+}
+
+// allowDelegation allows keeping the NS records of zones in their parent,
+// which is any zone unless -parent-zone-id names it.
+func (o *options) allowDelegation(b *policyBuilder, zones []string) {
+	b.allow(zones, "route53:GetHostedZone")
+	parent := awsEndpoints.arn("route53", "", "", "hostedzone/*")
+	if o.parentZoneID != "" {
+		parent = awsEndpoints.arn("route53", "", "", "hostedzone/"+normalizeZoneID(o.parentZoneID))
+		b.allow([]string{parent}, "route53:GetHostedZone")
+	} else {
+		b.allow([]string{"*"}, "route53:ListHostedZonesByName")
+	}
+	b.allow([]string{parent}, "route53:ListResourceRecordSets", "route53:ChangeResourceRecordSets")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runPrune(args []string) error {
+	var o options
+	fs := newFlagSet("prune")
+	o.addZoneFlags(fs)
+	olderThan := fs.Duration("older-than", 0, "remove registrations that haven't been refreshed for this long (required)")
+	prefix := fs.String("prefix", "", "only prune records whose name starts with this prefix")
+	dryRun := fs.Bool("dry-run", false, "only print what would be removed")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *olderThan <= 0 {
+		return configError("The older-than parameter is required, e.g. -older-than 24h")
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-*olderThan)
+	for _, markerSet := range sets {
+		name, ok := isOwnerRecordName(aws.StringValue(markerSet.Name))
+		if !ok || aws.StringValue(markerSet.Type) != route53.RRTypeTxt || !strings.HasPrefix(name, *prefix) {
+			continue
+		}
+		changes, stale := pruneChanges(sets, markerSet, name, cutoff)
+		if len(changes) == 0 {
+			continue
+		}
+		if *dryRun {
+			logger.Info("Would prune stale registrations", fields{"zone_id": zoneID, "record_name": name, "ids": strings.Join(stale, ",")})
+			continue
+		}
+		// One batch per record keeps the delete/create pairs of shared
+		// records together without hitting the batch size limit
+		info, err := submitChanges(ctx, r53, zoneID, "Stale Records Pruned", changes)
+		if err != nil {
+			return err
+		}
+		logger.Info("Pruned stale registrations", fields{"zone_id": zoneID, "record_name": name, "ids": strings.Join(stale, ","), "change_id": aws.StringValue(info.Id)})
+	}
+	return nil
+}
+
+// pruneChanges returns the changes removing the registrations under a marker
+// set that were last refreshed before cutoff, along with their ids.
+func pruneChanges(sets []*route53.ResourceRecordSet, markerSet *route53.ResourceRecordSet, name string, cutoff time.Time) ([]*route53.Change, []string) {
+	staleIDs := map[string]bool{}
+	var stale []string
+	for _, v := range recordValues(markerSet) {
+		// Records kept by sync aren't refreshed, they're removed by sync itself
+		if m, ok := parseOwnerMarker(v); ok && m.source == "" && m.registered.Before(cutoff) {
+			staleIDs[m.id] = true
+			stale = append(stale, m.id)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	var changes []*route53.Change
+	if markerSet.SetIdentifier != nil {
+		// A weighted registration: drop the marker and the record with the same identifier
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: markerSet,
+		})
+		for _, set := range sets {
+			if sameRecordName(aws.StringValue(set.Name), name) && aws.StringValue(set.SetIdentifier) == aws.StringValue(markerSet.SetIdentifier) {
+				changes = append(changes, &route53.Change{
+					Action:            aws.String(route53.ChangeActionDelete),
+					ResourceRecordSet: set,
+				})
+			}
+		}
+		return changes, stale
+	}
+
+	// A shared record: drop the stale values and their markers
+	for _, set := range sets {
+		if sameRecordName(aws.StringValue(set.Name), name) && set.SetIdentifier == nil && aws.StringValue(set.Type) == route53.RRTypeA {
+			var values []string
+			for _, v := range recordValues(set) {
+				if !staleIDs[v] {
+					values = append(values, v)
+				}
+			}
+			changes = append(changes, replaceRecordSet(set, name, route53.RRTypeA, aws.Int64Value(set.TTL), values)...)
+		}
+	}
+	markers := sharedMarkerValues(markerSet, staleIDs)
+	changes = append(changes, replaceRecordSet(markerSet, aws.StringValue(markerSet.Name), route53.RRTypeTxt, aws.Int64Value(markerSet.TTL), markers)...)
+	return changes, stale
+}
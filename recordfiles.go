@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The -hostname-file and -value-file parameters let other provisioning
+// tooling hand the name and value of the record to a running daemon, which
+// polls them and reloads its registrations when they change, as it does for
+// SIGHUP with -config.
+
+// recordFilePollInterval is how often the daemon checks the files.
+const recordFilePollInterval = 2 * time.Second
+
+// readRecordFiles sets the hostnames and the value of the record from
+// -hostname-file and -value-file.
+func (o *options) readRecordFiles() error {
+	if o.hostnameFile != "" {
+		if len(o.hostnames) > 0 {
+			return errors.New("The hostname and hostname-file parameters can't be combined")
+		}
+		lines, err := readFileLines(o.hostnameFile)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return errors.New("The hostname file " + o.hostnameFile + " holds no names")
+		}
+		o.hostnames = lines
+	}
+	if o.valueFile != "" {
+		lines, err := readFileLines(o.valueFile)
+		if err != nil {
+			return err
+		}
+		if len(lines) != 1 {
+			return errors.New("The value file " + o.valueFile + " must hold a single value")
+		}
+		o.fileValue = lines[0]
+	}
+	return nil
+}
+
+// readFileLines returns the lines of a file, trimmed, leaving out blank
+// ones and # comments.
+func readFileLines(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// recordFileWatch tells when the -hostname-file or -value-file of any of
+// the registrations changed.
+type recordFileWatch struct {
+	// applied is what the files held when the registrations were read
+	applied map[string]string
+	// pending is what the changed files held at the last poll. A change is
+	// only reported once a file held the same at two polls in a row, so that
+	// one still being written isn't read half way.
+	pending map[string]string
+}
+
+func newRecordFileWatch(regs []*options) *recordFileWatch {
+	w := &recordFileWatch{applied: map[string]string{}, pending: map[string]string{}}
+	for _, r := range regs {
+		for _, path := range []string{r.hostnameFile, r.valueFile} {
+			if path != "" {
+				w.applied[path] = readWatchedFile(path)
+			}
+		}
+	}
+	return w
+}
+
+// changed returns the files that changed since the last call.
+func (w *recordFileWatch) changed() []string {
+	var changed []string
+	for path, applied := range w.applied {
+		content := readWatchedFile(path)
+		pending, ok := w.pending[path]
+		switch {
+		case content == applied:
+			delete(w.pending, path)
+		case !ok || content != pending:
+			w.pending[path] = content
+		default:
+			w.applied[path] = content
+			delete(w.pending, path)
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// readWatchedFile returns the content of a file, empty when it can't be
+// read, e.g. while it's being replaced.
+func readWatchedFile(path string) string {
+	b, _ := ioutil.ReadFile(path)
+	return string(b)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// target is a record maintained by this tool for one host.
+type target struct {
+	zoneID        string
+	name          string
+	rrType        string
+	value         string
+	setIdentifier string
+	weight        int64
+	ttl           int64
+	healthCheckID string
+	// alias is set for alias records, whose value is the DNS name of the
+	// alias target
+	alias aliasTarget
+	// shared records are plain record sets many hosts add their value to,
+	// instead of each having a weighted record of its own
+	shared bool
+
+	// force removes the record even when it has no ownership marker
+	force bool
+	// drainedWeight is the weight a drained record is restored to, its
+	// weight being zero while it's set
+	drainedWeight int64
+}
+
+// aliasTarget is the AWS resource an alias record points at.
+type aliasTarget struct {
+	dnsName              string
+	zoneID               string
+	evaluateTargetHealth bool
+}
+
+func (a aliasTarget) validate() error {
+	if (a.dnsName == "") != (a.zoneID == "") {
+		return errors.New("The alias-target and alias-zone-id parameters must be given together")
+	}
+	if a.evaluateTargetHealth && a.dnsName == "" {
+		return errors.New("The alias-evaluate-target-health parameter needs an alias-target")
+	}
+	return nil
+}
+
+// validateValue checks that the value fetched for t suits its type, rather
+// than leaving it to Route53 to reject the change.
+func (t *target) validateValue() error {
+	if t.alias.dnsName != "" {
+		return nil
+	}
+	switch t.rrType {
+	case route53.RRTypeA:
+		if ip := net.ParseIP(t.value); ip == nil || ip.To4() == nil {
+			return withExitCode(exitMetadata, fmt.Errorf("The value %q for %s is not an IPv4 address", t.value, t.name))
+		}
+	case route53.RRTypeCname:
+		if t.value == "" {
+			return configError("This instance has no hostname for the CNAME " + t.name + " to point at")
+		}
+		if net.ParseIP(t.value) != nil {
+			return configError("The CNAME " + t.name + " would point at the IP address " + t.value + ", register an A record instead")
+		}
+		if err := validateDNSName(normalizeName(t.value)); err != nil {
+			return withExitCode(exitConfig, fmt.Errorf("Invalid CNAME target: %v", err))
+		}
+	}
+	return nil
+}
+
+// fields describes t for logging.
+func (t *target) fields() fields {
+	f := fields{
+		"zone_id":     t.zoneID,
+		"record_name": t.name,
+		"record_type": t.rrType,
+		"value":       t.value,
+	}
+	if !t.shared {
+		f["set_identifier"] = t.setIdentifier
+	}
+	if name := toUnicode(t.name); name != t.name {
+		f["record_name_unicode"] = name
+	}
+	return f
+}
+
+func newAWSSession(logLevel *aws.LogLevelType) (*session.Session, error) {
+	cfg := awsHTTP.config(awsEndpoints.config(retries.config(&aws.Config{LogLevel: logLevel})))
+	cfg.Credentials = awsCredentials.chain()
+	return session.NewSession(cfg)
+}
+
+// newRegionalSession returns a session along with the config to create
+// clients of regional services with. Unlike Route53 they need a region, and
+// default to the one of the instance.
+func newRegionalSession(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, logLevel *aws.LogLevelType) (*session.Session, *aws.Config, error) {
+	sess, err := newAWSSession(logLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &aws.Config{}
+	if aws.StringValue(sess.Config.Region) == "" {
+		doc, err := getIdentityDocument(ctx, metadataClient)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Region = aws.String(doc.Region)
+	}
+	return sess, cfg, nil
+}
+
+func newRoute53Client(logLevel *aws.LogLevelType) (*route53.Route53, error) {
+	sess, err := newAWSSession(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return route53.New(sess), nil
+}
+
+// newEC2Client returns a client of the EC2 API in the configured region, or
+// the one of the instance we run on.
+func newEC2Client(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) (*ec2.EC2, error) {
+	sess, cfg, err := newRegionalSession(ctx, metadataClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ec2.New(sess, cfg), nil
+}
+
+func newMetadataClient() (*ec2metadata.EC2Metadata, error) {
+	sess, err := session.NewSession(metadataRetries.config(nil))
+	if err != nil {
+		return nil, err
+	}
+	return ec2metadata.New(sess), nil
+}
+
+// sameRecordName compares record names the way Route53 does, ignoring case,
+// the trailing dot it appends to every name it returns and the escapes it
+// returns some characters as.
+func sameRecordName(a, b string) bool {
+	return normalizeName(a) == normalizeName(b)
+}
+
+func resourceRecords(values []string) []*route53.ResourceRecord {
+	var records []*route53.ResourceRecord
+	for _, v := range values {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(v)})
+	}
+	return records
+}
+
+// liveValue returns what a record set resolves to: its values, or the DNS
+// name of its alias target.
+func liveValue(set *route53.ResourceRecordSet) string {
+	if set.AliasTarget != nil {
+		return strings.TrimSuffix(aws.StringValue(set.AliasTarget.DNSName), ".")
+	}
+	return strings.Join(recordValues(set), ",")
+}
+
+func recordValues(set *route53.ResourceRecordSet) []string {
+	var values []string
+	if set != nil {
+		for _, rr := range set.ResourceRecords {
+			values = append(values, aws.StringValue(rr.Value))
+		}
+	}
+	return values
+}
+
+func (t *target) recordSet() *route53.ResourceRecordSet {
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(t.name),
+		Type:            aws.String(t.rrType),
+		ResourceRecords: resourceRecords([]string{t.value}),
+		SetIdentifier:   aws.String(t.setIdentifier),
+		TTL:             aws.Int64(t.ttl),
+		Weight:          aws.Int64(t.weight),
+	}
+	if t.drainedWeight > 0 {
+		set.Weight = aws.Int64(0)
+	}
+	if t.alias.dnsName != "" {
+		// Alias records take the TTL of their target
+		set.ResourceRecords, set.TTL = nil, nil
+		set.AliasTarget = &route53.AliasTarget{
+			DNSName:              aws.String(t.alias.dnsName),
+			HostedZoneId:         aws.String(t.alias.zoneID),
+			EvaluateTargetHealth: aws.Bool(t.alias.evaluateTargetHealth),
+		}
+	}
+	if t.healthCheckID != "" {
+		set.HealthCheckId = aws.String(t.healthCheckID)
+	}
+	return set
+}
+
+// markerSet is the ownership marker accompanying t's weighted record.
+func (t *target) markerSet(registered time.Time) *route53.ResourceRecordSet {
+	marker := ownerMarker{id: t.setIdentifier, registered: registered, drainedWeight: t.drainedWeight}
+	return &route53.ResourceRecordSet{
+		Name:            aws.String(ownerRecordName(t.name)),
+		Type:            aws.String(route53.RRTypeTxt),
+		ResourceRecords: resourceRecords([]string{marker.String()}),
+		SetIdentifier:   aws.String(t.setIdentifier),
+		TTL:             aws.Int64(markerTTL),
+		Weight:          aws.Int64(defaultWeight),
+	}
+}
+
+func submitChanges(ctx context.Context, r53 *route53.Route53, hostedZoneID, comment string, changes []*route53.Change) (*route53.ChangeInfo, error) {
+	comment = changeComments.render(ctx, hostedZoneID, comment)
+	params := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: changes,
+			Comment: aws.String(comment),
+		},
+		HostedZoneId: aws.String(hostedZoneID),
+	}
+	s := tracing.Start(ctx, "change submission", fields{"zone_id": hostedZoneID, "changes": len(changes)})
+	out, err := r53.ChangeResourceRecordSetsWithContext(ctx, params, changeRetries.option())
+	if err != nil {
+		s.End(err)
+		audit.record(ctx, hostedZoneID, comment, changes, nil, err)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == route53.ErrCodeNoSuchHostedZone {
+			// The zone may have been created again under another id
+			zoneCache.forget(hostedZoneID)
+		}
+		return nil, withExitCode(exitChangeFailed, err)
+	}
+	s.attrs["change_id"] = aws.StringValue(out.ChangeInfo.Id)
+	s.End(nil)
+	audit.record(ctx, hostedZoneID, comment, changes, out.ChangeInfo, nil)
+	return out.ChangeInfo, nil
+}
+
+// replaceRecordSet returns the changes swapping current (which may be nil)
+// for a set with the given values, dropping the set when there are none.
+// Route53 only deletes a record set that is given back exactly as stored, so
+// the change fails if somebody else modified current in the meantime.
+func replaceRecordSet(current *route53.ResourceRecordSet, name, rrType string, ttl int64, values []string) []*route53.Change {
+	var changes []*route53.Change
+	if current != nil {
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: current,
+		})
+	}
+	if len(values) > 0 {
+		changes = append(changes, &route53.Change{
+			Action: aws.String(route53.ChangeActionCreate),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            aws.String(rrType),
+				ResourceRecords: resourceRecords(values),
+				TTL:             aws.Int64(ttl),
+			},
+		})
+	}
+	return changes
+}
+
+// findRecordSets returns every record set in the zone with exactly the given
+// name and type, e.g. all weighted records registered under one service name.
+func findRecordSets(ctx context.Context, r53 *route53.Route53, hostedZoneID, name, rrType string) ([]*route53.ResourceRecordSet, error) {
+	var sets []*route53.ResourceRecordSet
+	params := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(hostedZoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(rrType),
+	}
+	err := r53.ListResourceRecordSetsPagesWithContext(ctx, params, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, set := range page.ResourceRecordSets {
+			// Record sets are returned sorted by name and type, so the
+			// first mismatch means we've walked past the ones we want
+			if !sameRecordName(aws.StringValue(set.Name), name) || aws.StringValue(set.Type) != rrType {
+				return false
+			}
+			sets = append(sets, set)
+		}
+		return true
+	})
+	return sets, err
+}
+
+// listRecordSets returns every record set in the zone.
+func listRecordSets(ctx context.Context, r53 *route53.Route53, hostedZoneID string) ([]*route53.ResourceRecordSet, error) {
+	var sets []*route53.ResourceRecordSet
+	params := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(hostedZoneID),
+	}
+	err := r53.ListResourceRecordSetsPagesWithContext(ctx, params, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		sets = append(sets, page.ResourceRecordSets...)
+		return true
+	})
+	return sets, err
+}
+
+// findIdentifiedSet returns the record set carrying setIdentifier, or nil.
+func findIdentifiedSet(sets []*route53.ResourceRecordSet, setIdentifier string) *route53.ResourceRecordSet {
+	for _, set := range sets {
+		if aws.StringValue(set.SetIdentifier) == setIdentifier {
+			return set
+		}
+	}
+	return nil
+}
+
+// findPlainSet returns the record set without a set identifier, or nil.
+func findPlainSet(sets []*route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	for _, set := range sets {
+		if set.SetIdentifier == nil {
+			return set
+		}
+	}
+	return nil
+}
+
+// upsertRecords creates or updates the weighted records of ts along with
+// their ownership markers.
+func upsertRecords(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+	changes := upsertChanges(ts, time.Now())
+	info, err := submitChanges(ctx, r53, ts[0].zoneID, "Host "+ts[0].rrType+" Record Created", changes)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range ts {
+		f := t.fields()
+		f["change_id"] = aws.StringValue(info.Id)
+		logger.Info("Record created", f)
+	}
+	return info, nil
+}
+
+// upsertChanges returns the changes creating or updating the weighted
+// records of ts and their ownership markers.
+func upsertChanges(ts []*target, now time.Time) []*route53.Change {
+	var changes []*route53.Change
+	for _, t := range ts {
+		changes = append(changes,
+			&route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: t.recordSet(),
+			},
+			&route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: t.markerSet(now),
+			},
+		)
+	}
+	return changes
+}
+
+// deleteRecords removes the weighted records of ts and their ownership
+// markers while leaving records registered by other hosts under the same
+// names alone. A record without a marker of ours fails the whole batch
+// unless its target is forced.
+func deleteRecords(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+	changes, deleted, err := deleteChanges(ctx, r53, ts)
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+	info, err := submitChanges(ctx, r53, ts[0].zoneID, "Host "+ts[0].rrType+" Record Deleted", changes)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range deleted {
+		f := t.fields()
+		f["change_id"] = aws.StringValue(info.Id)
+		logger.Info("Record deleted", f)
+	}
+	return info, nil
+}
+
+// deleteChanges returns the changes deleteRecords makes, along with the
+// targets that have records to delete.
+func deleteChanges(ctx context.Context, r53 *route53.Route53, ts []*target) ([]*route53.Change, []*target, error) {
+	var changes []*route53.Change
+	var deleted []*target
+	for _, t := range ts {
+		sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+		if err != nil {
+			return nil, nil, err
+		}
+		markerSets, err := findRecordSets(ctx, r53, t.zoneID, ownerRecordName(t.name), route53.RRTypeTxt)
+		if err != nil {
+			return nil, nil, err
+		}
+		set, markerSet := findIdentifiedSet(sets, t.setIdentifier), findIdentifiedSet(markerSets, t.setIdentifier)
+		if set != nil {
+			if err := checkOwned(t, markerSet, t.setIdentifier); err != nil {
+				return nil, nil, err
+			}
+		}
+		found := false
+		for _, s := range []*route53.ResourceRecordSet{set, markerSet} {
+			if s != nil {
+				changes = append(changes, &route53.Change{
+					Action:            aws.String(route53.ChangeActionDelete),
+					ResourceRecordSet: s,
+				})
+				found = true
+			}
+		}
+		if found {
+			deleted = append(deleted, t)
+		} else {
+			logger.Info("Record not found, nothing to delete", t.fields())
+		}
+	}
+	return changes, deleted, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/protocol/xml/xmlutil"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// fakeRoute53 serves the record set calls of the Route53 API from memory,
+// rejecting change batches the way Route53 does: as a whole, when a record
+// set to create exists or one to delete isn't given back exactly as stored.
+type fakeRoute53 struct {
+	t    *testing.T
+	mu   sync.Mutex
+	sets []*route53.ResourceRecordSet
+	// batches are the change batches applied so far
+	batches [][]*route53.Change
+}
+
+func newFakeRoute53(t *testing.T, sets ...*route53.ResourceRecordSet) (*fakeRoute53, *route53.Route53) {
+	f := &fakeRoute53{t: t, sets: sets}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	return f, route53.New(sess)
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/rrset"):
+		f.list(w, r)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/rrset/"):
+		f.change(w, r)
+	case r.Method == "GET" && strings.Contains(r.URL.Path, "/change/"):
+		fmt.Fprint(w, `<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>INSYNC</Status><SubmittedAt>2026-01-01T00:00:00Z</SubmittedAt></ChangeInfo></GetChangeResponse>`)
+	default:
+		f.t.Errorf("Unexpected Route53 call %s %s", r.Method, r.URL)
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// list answers ListResourceRecordSets with the sets of the name and type
+// asked for, or all of them.
+func (f *fakeRoute53) list(w http.ResponseWriter, r *http.Request) {
+	name, rrType := r.URL.Query().Get("name"), r.URL.Query().Get("type")
+	out := &route53.ListResourceRecordSetsOutput{IsTruncated: aws.Bool(false), MaxItems: aws.String("300")}
+	for _, set := range f.sets {
+		if name == "" || sameRecordName(aws.StringValue(set.Name), name) && aws.StringValue(set.Type) == rrType {
+			out.ResourceRecordSets = append(out.ResourceRecordSets, set)
+		}
+	}
+	fmt.Fprint(w, "<ListResourceRecordSetsResponse>")
+	if err := xmlutil.BuildXML(out, xml.NewEncoder(w)); err != nil {
+		f.t.Error(err)
+	}
+	fmt.Fprint(w, "</ListResourceRecordSetsResponse>")
+}
+
+// change applies a ChangeResourceRecordSets batch.
+func (f *fakeRoute53) change(w http.ResponseWriter, r *http.Request) {
+	var in route53.ChangeResourceRecordSetsInput
+	if err := xmlutil.UnmarshalXML(&in, xml.NewDecoder(r.Body), ""); err != nil {
+		f.t.Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sets := append([]*route53.ResourceRecordSet(nil), f.sets...)
+	for _, c := range in.ChangeBatch.Changes {
+		set := c.ResourceRecordSet
+		i := -1
+		for j, s := range sets {
+			if sameRecordName(aws.StringValue(s.Name), aws.StringValue(set.Name)) && aws.StringValue(s.Type) == aws.StringValue(set.Type) && aws.StringValue(s.SetIdentifier) == aws.StringValue(set.SetIdentifier) {
+				i = j
+			}
+		}
+		desc := fmt.Sprintf("[name='%s.', type='%s']", normalizeName(aws.StringValue(set.Name)), aws.StringValue(set.Type))
+		switch aws.StringValue(c.Action) {
+		case route53.ChangeActionCreate:
+			if i >= 0 {
+				invalidChangeBatch(w, "Tried to create resource record set "+desc+" but it already exists")
+				return
+			}
+			sets = append(sets, set)
+		case route53.ChangeActionDelete:
+			if i < 0 || sets[i].String() != set.String() {
+				invalidChangeBatch(w, "Tried to delete resource record set "+desc+" but it was not found")
+				return
+			}
+			sets = append(sets[:i], sets[i+1:]...)
+		case route53.ChangeActionUpsert:
+			if i < 0 {
+				sets = append(sets, set)
+			} else {
+				sets[i] = set
+			}
+		}
+	}
+	f.sets = sets
+	f.batches = append(f.batches, in.ChangeBatch.Changes)
+	fmt.Fprint(w, `<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status><SubmittedAt>2026-01-01T00:00:00Z</SubmittedAt></ChangeInfo></ChangeResourceRecordSetsResponse>`)
+}
+
+func invalidChangeBatch(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<InvalidChangeBatch><Messages><Message>%s</Message></Messages></InvalidChangeBatch>`, message)
+}
+
+// find returns the set of the name, type and set identifier, or nil.
+func (f *fakeRoute53) find(name, rrType, setIdentifier string) *route53.ResourceRecordSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.sets {
+		if sameRecordName(aws.StringValue(s.Name), name) && aws.StringValue(s.Type) == rrType && aws.StringValue(s.SetIdentifier) == setIdentifier {
+			return s
+		}
+	}
+	return nil
+}
+
+// testNow is the time the tests register records at.
+var testNow = time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// testRecordSet returns a record set of values, weighted when setIdentifier
+// is given.
+func testRecordSet(name, rrType, setIdentifier string, weight int64, values ...string) *route53.ResourceRecordSet {
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(name + "."),
+		Type:            aws.String(rrType),
+		TTL:             aws.Int64(60),
+		ResourceRecords: resourceRecords(values),
+	}
+	if setIdentifier != "" {
+		set.SetIdentifier, set.Weight = aws.String(setIdentifier), aws.Int64(weight)
+	}
+	return set
+}
+
+func TestTargetRecordSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		t          target
+		wantWeight int64
+		wantMarker string
+	}{
+		{
+			name:       "weighted",
+			t:          target{name: "web.example.com", rrType: "A", value: "10.0.0.1", setIdentifier: "web-1", weight: 20, ttl: 60},
+			wantWeight: 20,
+			wantMarker: "id=web-1",
+		},
+		{
+			name:       "drained",
+			t:          target{name: "web.example.com", rrType: "A", value: "10.0.0.1", setIdentifier: "web-1", weight: 20, ttl: 60, drainedWeight: 30},
+			wantWeight: 0,
+			wantMarker: "drained=30,id=web-1",
+		},
+	}
+	for _, tt := range tests {
+		set := tt.t.recordSet()
+		if aws.Int64Value(set.Weight) != tt.wantWeight || liveValue(set) != "10.0.0.1" || aws.StringValue(set.SetIdentifier) != "web-1" {
+			t.Errorf("%s: recordSet = %s", tt.name, set)
+		}
+		marker := liveValue(tt.t.markerSet(testNow))
+		if !strings.HasSuffix(marker, tt.wantMarker+`"`) {
+			t.Errorf("%s: markerSet holds %s, want it to end with %s", tt.name, marker, tt.wantMarker)
+		}
+	}
+}
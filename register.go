@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runRegister(args []string) error {
+	var o options
+	fs := newFlagSet("register")
+	o.addRecordFlags(fs)
+	deregister := fs.Bool("deregister", false, "remove this host's record instead of creating it (same as the deregister command)")
+	fs.BoolVar(&o.force, "force", false, "with -deregister or deregister lines of -stdin, also remove records that have no ownership marker, e.g. ones created by hand")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "only print how the records would change")
+	fs.BoolVar(&o.daemon, "daemon", false, "keep running, registering the record again whenever it stops matching this host")
+	fs.DurationVar(&o.interval, "interval", time.Minute, "how often the daemon checks the record")
+	fs.DurationVar(&o.refresh, "refresh", 6*time.Hour, "how often the daemon registers the record even if it matches, refreshing its ownership marker")
+	fs.StringVar(&o.healthAddr, "health-addr", "", "address the daemon serves /healthz and /readyz on, e.g. :8080 (disabled when empty)")
+	fs.BoolVar(&o.debugEndpoints, "debug-endpoints", false, "also serve net/http/pprof under /debug/pprof/ and runtime stats under /debug/stats on -health-addr, for profiling the daemon")
+	fs.StringVar(&o.waitForHealthyURL, "wait-for-healthy", "", "only register once the local service is up: tcp://host:port accepting connections, e.g. tcp://:8080, or an http(s) URL answering with a 2xx or 3xx status")
+	fs.DurationVar(&o.waitForHealthyTimeout, "wait-for-healthy-timeout", 5*time.Minute, "how long to wait for the service before failing without registering (no limit when 0)")
+	fs.DurationVar(&o.waitForHealthyInterval, "wait-for-healthy-interval", 2*time.Second, "how often to try the service while waiting for it")
+	fs.StringVar(&o.healthProbeURL, "health-probe", "", "local service the daemon keeps probing, like -wait-for-healthy, taking the records out of service while it's unhealthy (disabled when empty)")
+	fs.DurationVar(&o.healthProbeInterval, "health-probe-interval", 10*time.Second, "how often the daemon probes the service")
+	fs.IntVar(&o.unhealthyThreshold, "unhealthy-threshold", 3, "failed probes in a row after which the service is unhealthy")
+	fs.IntVar(&o.healthyThreshold, "healthy-threshold", 2, "successful probes in a row after which an unhealthy service has recovered")
+	fs.StringVar(&o.unhealthyAction, "unhealthy-action", "drain", "what to do with the records of an unhealthy service: drain (set their weight to zero) or deregister")
+	fs.StringVar(&o.activateAt, "activate-at", "", "only publish the records from this time on, e.g. 2024-03-01T08:00:00Z")
+	fs.StringVar(&o.deactivateAt, "deactivate-at", "", "take the records out of service from this time on, e.g. 2024-03-01T18:00:00Z")
+	fs.StringVar(&o.window, "window", "", "cron expression of when the daemon puts the records into service for -window-duration, e.g. '0 8 * * 1-5' (always when empty)")
+	fs.DurationVar(&o.windowDuration, "window-duration", 0, "how long the records stay in service each time the -window opens, e.g. 10h")
+	fs.StringVar(&o.windowTimezone, "window-timezone", "UTC", "time zone the -window is in, e.g. Asia/Tokyo")
+	fs.StringVar(&o.inactiveAction, "inactive-action", "drain", "what the daemon does with the records outside the window: drain (set their weight to zero) or deregister")
+	fargate := fs.Bool("fargate", false, "run as an ECS task on Fargate: take the task's id and private IP from the task metadata endpoint instead of the instance metadata, keep the records like -daemon and deregister them on SIGTERM")
+	stdin := fs.Bool("stdin", false, "register the records of the newline delimited JSON requests read from stdin, see README, instead of this host's")
+	batchSize := fs.Int("batch-size", 100, "most records changed in one change batch with -stdin")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if o.force && !*deregister && !*stdin {
+		return configError("The force parameter needs the deregister or stdin parameter")
+	}
+	if o.dryRun && (o.daemon || *fargate || *stdin) {
+		return configError("The dry-run parameter can't be combined with the daemon, fargate or stdin parameters")
+	}
+	if *fargate {
+		if *stdin || *deregister {
+			return configError("The fargate parameter can't be combined with the stdin or deregister parameters")
+		}
+		if err := taskMetadataEndpoint.configure(); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		o.daemon, o.deregisterOnStop = true, true
+	}
+	window, err := o.newRegistrationWindow()
+	if err != nil {
+		return err
+	}
+	if window != nil && (*deregister || *stdin) {
+		return configError("The activate-at, deactivate-at and window parameters can't be combined with the deregister or stdin parameters")
+	}
+	if *stdin {
+		if o.daemon || o.configFile != "" || o.waitForHealthyURL != "" {
+			return configError("The stdin parameter can't be combined with the daemon, config or wait-for-healthy parameters")
+		}
+		ctx, cancel := o.context()
+		defer cancel()
+		action := "register"
+		if *deregister {
+			action = "deregister"
+		}
+		return o.runBatch(ctx, os.Stdin, action, *batchSize)
+	}
+	if window != nil && !o.daemon && !window.contains(time.Now()) {
+		logger.Warn("Outside the registration window, not registering", window.fields())
+		return nil
+	}
+	if o.waitForHealthyURL != "" {
+		if *deregister {
+			return configError("The wait-for-healthy and deregister parameters can't be combined")
+		}
+		if err := validateHealthURL(o.waitForHealthyURL); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		if o.waitForHealthyInterval <= 0 {
+			return configError("The wait-for-healthy-interval parameter must be positive")
+		}
+		if err := o.waitForHealthy(); err != nil {
+			return err
+		}
+	}
+	if o.healthProbeURL != "" && !o.daemon {
+		return configError("The health-probe parameter needs the daemon parameter")
+	}
+	if o.daemon {
+		if *deregister {
+			return configError("The daemon and deregister parameters can't be combined")
+		}
+		return o.runDaemon()
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	operation, change := "register", hostChange(registerTargets)
+	if *deregister {
+		operation, change = "deregister", deregisterTargets
+	}
+	return o.withHostLock(ctx, func() error {
+		return o.eachRegistration(func(r *options) error {
+			return r.changeHostRecord(ctx, operation, change)
+		})
+	})
+}
+
+func runDeregister(args []string) error {
+	var o options
+	fs := newFlagSet("deregister")
+	o.addRecordFlags(fs)
+	fs.BoolVar(&o.force, "force", false, "also remove records that have no ownership marker, e.g. ones created by hand")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "only print how the records would change")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.withHostLock(ctx, func() error {
+		return o.eachRegistration(func(r *options) error {
+			return r.changeHostRecord(ctx, "deregister", deregisterTargets)
+		})
+	})
+}
+
+// The targets of a change all come from the same options, so they share
+// their zone, type and routing.
+
+func registerTargets(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+	if ts[0].shared {
+		return updateSharedRecords(ctx, r53, ts, true)
+	}
+	return upsertRecords(ctx, r53, ts)
+}
+
+func deregisterTargets(ctx context.Context, r53 *route53.Route53, ts []*target) (*route53.ChangeInfo, error) {
+	if ts[0].shared {
+		return updateSharedRecords(ctx, r53, ts, false)
+	}
+	return deleteRecords(ctx, r53, ts)
+}
+
+// hostChange applies a change to a host's records in a single change batch,
+// returning nil ChangeInfo when there was nothing to change.
+type hostChange func(context.Context, *route53.Route53, []*target) (*route53.ChangeInfo, error)
+
+// result is what -output json prints for each record once a command has changed it.
+type result struct {
+	Record   string `json:"record"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	ZoneID   string `json:"zone_id"`
+	ChangeID string `json:"change_id,omitempty"`
+	// Status is the Route53 change status (PENDING or INSYNC), UNCHANGED
+	// when there was nothing to do, or FAILED
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Hint tells what to do about a failure of a known kind
+	Hint string `json:"hint,omitempty"`
+}
+
+func (o *options) printResult(t *target, info *route53.ChangeInfo, err error) {
+	if o.output != "json" {
+		return
+	}
+	json.NewEncoder(os.Stdout).Encode(newResult(t, info, err))
+}
+
+// newResult describes the outcome of a change to t, which may be nil when
+// the records couldn't be worked out.
+func newResult(t *target, info *route53.ChangeInfo, err error) result {
+	r := result{Status: "UNCHANGED"}
+	if t != nil {
+		r.Record, r.Type, r.Value, r.ZoneID = t.name, t.rrType, t.value, t.zoneID
+	}
+	if info != nil {
+		r.ChangeID, r.Status = aws.StringValue(info.Id), aws.StringValue(info.Status)
+	}
+	if err != nil {
+		r.Status, r.Error = "FAILED", err.Error()
+		if f := describeFailure(err); f != nil {
+			r.Hint = f.hint
+		}
+	}
+	return r
+}
+
+// changeHostRecord resolves this host's records and applies change to them,
+// reporting the outcome of the operation for each record.
+func (o *options) changeHostRecord(ctx context.Context, operation string, change hostChange) error {
+	if err := o.validateRecord(); err != nil {
+		return err
+	}
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return err
+	}
+	if o.dryRun {
+		return o.dryRunHostRecord(ctx, metadataClient, operation)
+	}
+	start := time.Now()
+	ts, saved, info, err := o.resolveAndChange(ctx, metadataClient, operation, o.withMX(operation, change))
+	if err == nil && operation == "register" && (o.verify || o.verifyResolvers != "") {
+		err = o.verifyTargets(ctx, ts, info)
+		if err != nil && o.rollbackOnVerifyFailure && len(saved) > 0 {
+			o.rollbackAfterFailure(metadataClient, ts, saved)
+		}
+	}
+	if err == nil && o.calculatedHealthCheck && (operation == "register" || operation == "deregister") {
+		o.updateCalculatedHealthChecks(ctx, ts)
+	}
+	elapsed := time.Since(start)
+	if len(ts) == 0 {
+		// Still report the failure when the records couldn't be resolved
+		ts = []*target{nil}
+	}
+	// Failures are worth reporting all the more when they were timeouts
+	reportCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	for _, t := range ts {
+		o.printResult(t, info, err)
+		if o.cloudWatchNamespace != "" {
+			if merr := o.putCloudWatchMetrics(reportCtx, metadataClient, operation, t, err, elapsed); merr != nil {
+				logger.Warn("Error putting CloudWatch metrics", errorFields(merr, nil))
+			}
+		}
+		if o.statsdAddr != "" {
+			if merr := o.sendStatsd(operation, t, err, elapsed); merr != nil {
+				logger.Warn("Error sending statsd metrics", errorFields(merr, nil))
+			}
+		}
+		o.runHooks(reportCtx, operation, newResult(t, info, err))
+		o.notifyResult(reportCtx, operation, newResult(t, info, err))
+	}
+	return err
+}
+
+// dryRunHostRecord resolves this host's records and prints how registering
+// or deregistering them would change the live record sets, -mx-name
+// included, without changing anything.
+func (o *options) dryRunHostRecord(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, operation string) error {
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	ts, err := o.resolveTargets(ctx, metadataClient)
+	if err != nil {
+		return err
+	}
+	add := operation == "register"
+	var changes []*route53.Change
+	switch {
+	case ts[0].shared:
+		for _, t := range ts {
+			c, err := sharedRecordChanges(ctx, r53, t, add)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, c...)
+		}
+	case add:
+		changes = upsertChanges(ts, time.Now())
+	default:
+		if changes, _, err = deleteChanges(ctx, r53, ts); err != nil {
+			return err
+		}
+	}
+	if o.mxName != "" {
+		c, err := sharedRecordChanges(ctx, r53, o.mxTarget(ts), add)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, c...)
+	}
+	if len(changes) == 0 {
+		logger.Info("Nothing to change", fields{"zone_id": ts[0].zoneID})
+		return nil
+	}
+	live, err := liveRecordSets(ctx, r53, ts[0].zoneID, changes)
+	if err != nil {
+		return err
+	}
+	printDryRun(ts[0].zoneID, changes, live)
+	return nil
+}
+
+// liveRecordSets returns the record sets of the names and types changes
+// touch.
+func liveRecordSets(ctx context.Context, r53 *route53.Route53, zoneID string, changes []*route53.Change) ([]*route53.ResourceRecordSet, error) {
+	var sets []*route53.ResourceRecordSet
+	seen := map[string]bool{}
+	for _, c := range changes {
+		name, rrType := normalizeName(aws.StringValue(c.ResourceRecordSet.Name)), aws.StringValue(c.ResourceRecordSet.Type)
+		if seen[name+"|"+rrType] {
+			continue
+		}
+		seen[name+"|"+rrType] = true
+		found, err := findRecordSets(ctx, r53, zoneID, name, rrType)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, found...)
+	}
+	return sets, nil
+}
+
+// resolveAndChange resolves this host's records and applies change to them.
+// When register saves the records for rollback, it also returns the ones
+// the change altered, as they were before.
+func (o *options) resolveAndChange(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, operation string, change hostChange) ([]*target, []*rollbackEntry, *route53.ChangeInfo, error) {
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ts, err := o.resolveTargets(ctx, metadataClient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if operation == "register" {
+		if err := o.checkVPCAssociation(ctx, r53, metadataClient, ts[0].zoneID); err != nil {
+			return ts, nil, nil, err
+		}
+		if o.healthCheckAlarm != "" && o.alarmHealthCheckID == "" {
+			if err := o.createAlarmHealthCheck(ctx, r53, metadataClient); err != nil {
+				return ts, nil, nil, err
+			}
+			for _, t := range ts {
+				t.healthCheckID = o.alarmHealthCheckID
+			}
+		}
+		if o.delegate {
+			if err := delegateZone(ctx, r53, ts[0].zoneID, o.parentZoneID, false); err != nil {
+				return ts, nil, nil, err
+			}
+		}
+		if o.checkDelegation {
+			if _, err := checkDelegation(ctx, r53, ts[0].zoneID, o.zone()); err != nil {
+				logger.Warn("Delegation check failed", errorFields(err, fields{"zone_id": ts[0].zoneID}))
+			}
+		}
+	}
+	testAnswer := o.testAnswer || o.answerQuery != answerQuery{}
+	if testAnswer {
+		for _, t := range ts {
+			o.logTestAnswer(ctx, r53, t, "before")
+		}
+	}
+	var info *route53.ChangeInfo
+	var before, after map[*target]*recordState
+	var saved []*rollbackEntry
+	err = o.withLock(ctx, ts, metadataClient, func() error {
+		if o.snsTopicARN != "" {
+			before = recordSnapshots(ctx, r53, ts)
+		}
+		if operation == "register" && o.savesRollback() {
+			// Without the records as they were, the change couldn't be undone
+			if saved, err = rollbackSnapshots(ctx, r53, ts); err != nil {
+				return err
+			}
+		}
+		info, err = change(ctx, r53, ts)
+		if err == nil && info != nil && o.rollbackFile != "" && len(saved) > 0 {
+			if serr := saveRollbackEntries(o.rollbackFile, saved, aws.StringValue(info.Id)); serr != nil {
+				logger.Warn("Error saving records for rollback", errorFields(serr, fields{"rollback_file": o.rollbackFile}))
+			}
+		}
+		if err == nil && info != nil && o.snsTopicARN != "" {
+			after = recordSnapshots(ctx, r53, ts)
+		}
+		return err
+	})
+	if after != nil {
+		o.notifyChanges(ctx, metadataClient, operation, ts, info, before, after)
+	}
+	if err == nil && testAnswer {
+		// The answer only reflects the change once it reached every name server
+		if werr := waitInSync(ctx, r53, info); werr != nil {
+			logger.Warn("Error waiting for change to be in sync", errorFields(werr, nil))
+		}
+		for _, t := range ts {
+			o.logTestAnswer(ctx, r53, t, "after")
+		}
+	}
+	if info == nil {
+		saved = nil
+	}
+	return ts, saved, info, err
+}
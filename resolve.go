@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// resolvedSet is a record set of the name as resolve reports it: how it is
+// routed, the share of the answers its routing policy gives it and the share
+// Route53 answered with it.
+type resolvedSet struct {
+	SetIdentifier string   `json:"set_identifier,omitempty"`
+	Routing       string   `json:"routing"`
+	Weight        *int64   `json:"weight,omitempty"`
+	Region        string   `json:"region,omitempty"`
+	Location      string   `json:"location,omitempty"`
+	Failover      string   `json:"failover,omitempty"`
+	HealthCheckID string   `json:"health_check_id,omitempty"`
+	Values        []string `json:"values,omitempty"`
+	AliasTarget   string   `json:"alias_target,omitempty"`
+	// Expected is the share of the answers the routing policy gives the
+	// set when all are healthy, unknown for geolocation sets and for
+	// latency sets without -client-region
+	Expected *float64 `json:"expected,omitempty"`
+	Answered int      `json:"answered"`
+}
+
+// resolveReport is what resolve prints for a name.
+type resolveReport struct {
+	Record       string `json:"record"`
+	Type         string `json:"type"`
+	ClientSubnet string `json:"client_subnet,omitempty"`
+	ResolverIP   string `json:"resolver_ip,omitempty"`
+	ClientRegion string `json:"client_region,omitempty"`
+	Queries      int    `json:"queries"`
+	// Unmatched counts the answers none of the sets has the values of, e.g.
+	// those of alias records, which answer with the addresses of their target
+	Unmatched     int            `json:"unmatched"`
+	ResponseCodes map[string]int `json:"response_codes"`
+	Sets          []*resolvedSet `json:"sets"`
+}
+
+func runResolve(args []string) error {
+	var o options
+	fs := newFlagSet("resolve")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "name to resolve, relative to the zone, @ for the zone apex (required)")
+	rrType := fs.String("type", route53.RRTypeA, "record type to resolve")
+	fs.StringVar(&o.answerQuery.subnet, "subnet", "", "EDNS client subnet of the simulated clients, e.g. 203.0.113.0/24")
+	fs.StringVar(&o.answerQuery.resolverIP, "resolver", "", "IP address of the resolver the simulated clients ask")
+	clientRegion := fs.String("client-region", "", "AWS region the clients are closest to, telling which latency record they should get, e.g. eu-west-1")
+	queries := fs.Int("queries", 20, "how many times the answer is tested, weighted records being picked anew for every query")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *hostname == "" {
+		return configError("The hostname parameter is required, e.g. -hostname web")
+	}
+	if *queries <= 0 || *queries > maxResolveQueries {
+		return withExitCode(exitConfig, fmt.Errorf("The queries parameter must be between 1 and %d", maxResolveQueries))
+	}
+	if *format != "table" && *format != "json" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown format %q, expected table or json", *format))
+	}
+	if err := o.answerQuery.validate("subnet", "resolver"); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	name := qualifyName(*hostname, o.zone())
+	t := strings.ToUpper(*rrType)
+	sets, err := findRecordSets(ctx, r53, zoneID, name, t)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		return withExitCode(exitConfig, errors.New("There is no "+t+" record "+name))
+	}
+
+	report := newResolveReport(name, t, sets, *clientRegion)
+	report.ClientSubnet, report.ResolverIP = o.answerQuery.subnet, o.answerQuery.resolverIP
+	if err := report.query(ctx, r53, zoneID, o.answerQuery, *queries); err != nil {
+		return err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.print()
+}
+
+// maxResolveQueries bounds -queries, TestDNSAnswer counting against the five
+// requests per second Route53 allows an account.
+const maxResolveQueries = 500
+
+// newResolveReport describes the record sets of a name with the share of
+// the answers their routing policy gives each of them.
+func newResolveReport(name, rrType string, sets []*route53.ResourceRecordSet, clientRegion string) *resolveReport {
+	r := &resolveReport{Record: name, Type: rrType, ClientRegion: clientRegion, ResponseCodes: map[string]int{}}
+	var totalWeight int64
+	weighted := 0
+	for _, set := range sets {
+		if set.Weight != nil {
+			totalWeight += aws.Int64Value(set.Weight)
+			weighted++
+		}
+	}
+	for _, set := range sets {
+		s := &resolvedSet{
+			SetIdentifier: aws.StringValue(set.SetIdentifier),
+			Routing:       routingPolicy(set),
+			Weight:        set.Weight,
+			Region:        aws.StringValue(set.Region),
+			Failover:      aws.StringValue(set.Failover),
+			HealthCheckID: aws.StringValue(set.HealthCheckId),
+			Values:        recordValues(set),
+		}
+		if set.AliasTarget != nil {
+			s.AliasTarget = aws.StringValue(set.AliasTarget.DNSName)
+		}
+		if g := set.GeoLocation; g != nil {
+			s.Location = strings.Join(nonEmpty(aws.StringValue(g.ContinentCode), aws.StringValue(g.CountryCode), aws.StringValue(g.SubdivisionCode)), "/")
+		}
+		switch s.Routing {
+		case "weighted":
+			// Route53 answers with every set alike when all weights are 0
+			share := 1 / float64(weighted)
+			if totalWeight > 0 {
+				share = float64(aws.Int64Value(set.Weight)) / float64(totalWeight)
+			}
+			s.Expected = &share
+		case "latency":
+			if clientRegion != "" {
+				share := 0.0
+				if s.Region == clientRegion {
+					share = 1
+				}
+				s.Expected = &share
+			}
+		case "failover":
+			share := 0.0
+			if s.Failover == route53.ResourceRecordSetFailoverPrimary {
+				share = 1
+			}
+			s.Expected = &share
+		case "simple", "multivalue":
+			share := 1.0
+			s.Expected = &share
+		}
+		r.Sets = append(r.Sets, s)
+	}
+	return r
+}
+
+// routingPolicy names the routing policy of a record set.
+func routingPolicy(set *route53.ResourceRecordSet) string {
+	switch {
+	case set.Weight != nil:
+		return "weighted"
+	case set.Region != nil:
+		return "latency"
+	case set.GeoLocation != nil:
+		return "geolocation"
+	case set.Failover != nil:
+		return "failover"
+	case aws.BoolValue(set.MultiValueAnswer):
+		return "multivalue"
+	}
+	return "simple"
+}
+
+func nonEmpty(values ...string) []string {
+	var s []string
+	for _, v := range values {
+		if v != "" {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// query asks TestDNSAnswer for the name n times, counting for every set
+// how many answers carried its values. A weighted set is picked anew for
+// every query, so the counts show how the weights spread the traffic.
+func (r *resolveReport) query(ctx context.Context, r53 *route53.Route53, zoneID string, q answerQuery, n int) error {
+	for i := 0; i < n; i++ {
+		out, err := testDNSAnswer(ctx, r53, zoneID, r.Record, r.Type, q)
+		if err != nil {
+			return err
+		}
+		r.Queries++
+		r.ResponseCodes[aws.StringValue(out.ResponseCode)]++
+		answers := aws.StringValueSlice(out.RecordData)
+		matched := false
+		for _, s := range r.Sets {
+			if s.answers(answers) {
+				s.Answered++
+				matched = true
+			}
+		}
+		if !matched && len(answers) > 0 {
+			r.Unmatched++
+		}
+		logger.Debug("Route53 test answer", fields{"record_name": r.Record, "record_type": r.Type, "answer": strings.Join(answers, ","), "response_code": aws.StringValue(out.ResponseCode)})
+	}
+	return nil
+}
+
+// answers reports whether an answer carries one of the set's values.
+func (s *resolvedSet) answers(answers []string) bool {
+	for _, v := range s.Values {
+		for _, a := range answers {
+			if sameRecordName(v, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *resolveReport) print() error {
+	line := fmt.Sprintf("%s %s, %d queries", r.Record, r.Type, r.Queries)
+	if r.ClientSubnet != "" {
+		line += " from client subnet " + r.ClientSubnet
+	}
+	if r.ResolverIP != "" {
+		line += " through resolver " + r.ResolverIP
+	}
+	fmt.Println(line)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SET ID\tROUTING\tWEIGHT\tREGION/LOCATION\tHEALTH CHECK\tEXPECTED\tANSWERED\tVALUES")
+	for _, s := range r.Sets {
+		expected := "-"
+		if s.Expected != nil {
+			expected = fmt.Sprintf("%.0f%%", *s.Expected*100)
+		}
+		values := strings.Join(s.Values, ",")
+		if s.AliasTarget != "" {
+			values = "ALIAS " + s.AliasTarget
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.0f%% (%d)\t%s\n", dash(s.SetIdentifier), s.Routing, optionalInt(s.Weight),
+			dash(firstNonEmpty(s.Region, s.Location, s.Failover)), dash(s.HealthCheckID), expected,
+			float64(s.Answered)*100/float64(r.Queries), s.Answered, values)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if r.Unmatched > 0 {
+		fmt.Printf("%d answers matched no set, e.g. those of alias records\n", r.Unmatched)
+	}
+	if len(r.ResponseCodes) > 1 || r.ResponseCodes["NOERROR"] == 0 {
+		var codes []string
+		for code, n := range r.ResponseCodes {
+			codes = append(codes, fmt.Sprintf("%s %d", code, n))
+		}
+		sort.Strings(codes)
+		fmt.Println("Response codes: " + strings.Join(codes, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// retryPolicy decides how failed calls are retried. It is the Retryer of the
+// clients we create, one policy per class of calls. Which errors are worth
+// retrying (throttling, 5xx responses, network errors) is left to the SDK's
+// default retryer.
+type retryPolicy struct {
+	client.DefaultRetryer
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         bool
+	// flagPrefix is the prefix of the flags setting the policy
+	flagPrefix string
+	// changes is set for the policy of Route53 change batches, which are
+	// retried only when that's safe
+	changes bool
+}
+
+func init() {
+	// Hosts that failed together should not all retry at the same moment
+	rand.Seed(time.Now().UnixNano())
+}
+
+// retries is the policy of AWS calls. Route53 throttles an account at five
+// requests per second, so they back off long and with jitter.
+var retries = retryPolicy{
+	DefaultRetryer: client.DefaultRetryer{NumMaxRetries: 4},
+	initialBackoff: time.Second,
+	maxBackoff:     20 * time.Second,
+	jitter:         true,
+}
+
+// metadataRetries is the policy of instance metadata reads. The service is
+// local to the host and only fails briefly, e.g. while the network comes up
+// at boot, so they are retried quickly.
+var metadataRetries = retryPolicy{
+	DefaultRetryer: client.DefaultRetryer{NumMaxRetries: 3},
+	initialBackoff: 100 * time.Millisecond,
+	maxBackoff:     time.Second,
+	flagPrefix:     "metadata-",
+}
+
+// changeRetries is the policy of Route53 change batches, backing off like
+// the other AWS calls.
+var changeRetries = retryPolicy{
+	DefaultRetryer: client.DefaultRetryer{NumMaxRetries: 4},
+	initialBackoff: time.Second,
+	maxBackoff:     20 * time.Second,
+	jitter:         true,
+	flagPrefix:     "change-",
+	changes:        true,
+}
+
+func (p *retryPolicy) configure(maxRetries int, initialBackoff, maxBackoff time.Duration, jitter bool) error {
+	if maxRetries < 0 {
+		return errors.New(p.flagPrefix + "max-retries can't be negative")
+	}
+	if initialBackoff <= 0 || maxBackoff < initialBackoff {
+		return errors.New(p.flagPrefix + "initial-backoff must be positive and no longer than " + p.flagPrefix + "max-backoff")
+	}
+	p.NumMaxRetries = maxRetries
+	p.initialBackoff, p.maxBackoff, p.jitter = initialBackoff, maxBackoff, jitter
+	return nil
+}
+
+// backoff returns how long to wait before the given retry, counting from 0:
+// initialBackoff doubling with every retry up to maxBackoff, or with jitter a
+// random duration up to that.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.maxBackoff
+	if retry < 32 {
+		if b := p.initialBackoff << uint(retry); b > 0 && b < d {
+			d = b
+		}
+	}
+	if p.jitter {
+		d = time.Duration(rand.Int63n(int64(d))) + 1
+	}
+	return d
+}
+
+// ShouldRetry implements request.Retryer. A change batch may have been
+// applied although the call failed, e.g. when the response was lost, and
+// creating or deleting a record a second time fails. So batches other than
+// plain upserts are retried only when Route53 surely didn't apply them: when
+// it throttled them, or when the connection failed before they were sent.
+func (p retryPolicy) ShouldRetry(r *request.Request) bool {
+	if !p.DefaultRetryer.ShouldRetry(r) {
+		return false
+	}
+	if !p.changes || upsertsOnly(r.Params) {
+		return true
+	}
+	if request.IsErrorThrottle(r.Error) || isDialError(r.Error) {
+		return true
+	}
+	logger.Warn("Not retrying change batch, it may have been applied", errorFields(r.Error, fields{"operation": r.Operation.Name}))
+	return false
+}
+
+// upsertsOnly tells whether params is a change batch of upserts only, which
+// leaves the zone the same however often it's applied.
+func upsertsOnly(params interface{}) bool {
+	input, ok := params.(*route53.ChangeResourceRecordSetsInput)
+	if !ok || input.ChangeBatch == nil {
+		return false
+	}
+	for _, c := range input.ChangeBatch.Changes {
+		if aws.StringValue(c.Action) != route53.ChangeActionUpsert {
+			return false
+		}
+	}
+	return true
+}
+
+// isDialError tells whether err is the failure to connect, when nothing was
+// sent yet.
+func isDialError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case awserr.Error:
+			err = e.OrigErr()
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			return e.Op == "dial"
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// RetryRules implements request.Retryer.
+func (p retryPolicy) RetryRules(r *request.Request) time.Duration {
+	d := p.backoff(r.RetryCount)
+	logger.Warn("Retrying failed call", errorFields(r.Error, fields{
+		"operation": r.Operation.Name,
+		"retry":     r.RetryCount + 1,
+		"backoff":   d.String(),
+	}))
+	return d
+}
+
+// config returns cfg, or a new config, with the policy as its Retryer.
+func (p retryPolicy) config(cfg *aws.Config) *aws.Config {
+	if cfg == nil {
+		cfg = &aws.Config{}
+	}
+	return request.WithRetryer(cfg, p)
+}
+
+// option returns the request option making the policy the Retryer of a
+// single call, for calls of another class than the rest of their client's.
+func (p retryPolicy) option() request.Option {
+	return func(r *request.Request) {
+		r.Retryer = p
+	}
+}
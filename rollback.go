@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// rollbackEntry is a record as it was before register changed it, saved to
+// -rollback-file.
+type rollbackEntry struct {
+	ZoneID        string `json:"zone_id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	SetIdentifier string `json:"set_identifier"`
+	// Record and Marker are the record set and its ownership marker as they
+	// were, nil when there was none
+	Record   *route53.ResourceRecordSet `json:"record"`
+	Marker   *route53.ResourceRecordSet `json:"marker"`
+	ChangeID string                     `json:"change_id,omitempty"`
+	Saved    time.Time                  `json:"saved"`
+}
+
+func (e *rollbackEntry) key() string {
+	return normalizeZoneID(e.ZoneID) + "|" + e.Name + "|" + e.Type + "|" + e.SetIdentifier
+}
+
+func rollbackKey(t *target) string {
+	return (&rollbackEntry{ZoneID: t.zoneID, Name: t.name, Type: t.rrType, SetIdentifier: t.setIdentifier}).key()
+}
+
+func (o *options) savesRollback() bool {
+	return o.rollbackFile != "" || o.rollbackOnVerifyFailure
+}
+
+func runRollback(args []string) error {
+	var o options
+	fs := newFlagSet("rollback")
+	o.addRecordFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if err := o.validateRecord(); err != nil {
+		return err
+	}
+	if o.rollbackFile == "" {
+		return configError("The rollback-file parameter is required, it holds the records to restore")
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return err
+	}
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	ts, err := o.resolveTargets(ctx, metadataClient)
+	if err != nil {
+		return err
+	}
+	entries, err := loadRollbackEntries(o.rollbackFile)
+	if err != nil {
+		return err
+	}
+	byKey := map[string]*rollbackEntry{}
+	for _, e := range entries {
+		byKey[e.key()] = e
+	}
+	var restore []*rollbackEntry
+	var restored []*target
+	for _, t := range ts {
+		if e, ok := byKey[rollbackKey(t)]; ok {
+			restore = append(restore, e)
+			restored = append(restored, t)
+		} else {
+			logger.Info("No saved record to roll back to", t.fields())
+		}
+	}
+	if len(restore) == 0 {
+		return withExitCode(exitConfig, errors.New("No saved records of this host in rollback file "+o.rollbackFile))
+	}
+	var info *route53.ChangeInfo
+	err = o.withLock(ctx, restored, metadataClient, func() error {
+		info, err = restoreRecords(ctx, r53, restore)
+		return err
+	})
+	for _, t := range restored {
+		o.printResult(t, info, err)
+	}
+	if err != nil {
+		return err
+	}
+	for i, t := range restored {
+		f := t.fields()
+		if info != nil {
+			f["change_id"] = aws.StringValue(info.Id)
+		}
+		f["saved"] = restore[i].Saved.Format(time.RFC3339)
+		logger.Info("Record rolled back", f)
+	}
+	if err := removeRollbackEntries(o.rollbackFile, restore); err != nil {
+		logger.Warn("Error removing restored records from rollback file", errorFields(err, fields{"rollback_file": o.rollbackFile}))
+	}
+	return nil
+}
+
+// rollbackAfterFailure restores the records saved before a change whose
+// verification failed. The failure stands either way, so a failed rollback
+// is only logged.
+func (o *options) rollbackAfterFailure(metadataClient *ec2metadata.EC2Metadata, ts []*target, saved []*rollbackEntry) {
+	// The verification may have used up the deadline of the command
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		logger.Error("Rollback failed", errorFields(err, nil))
+		return
+	}
+	var info *route53.ChangeInfo
+	err = o.withLock(ctx, ts, metadataClient, func() error {
+		info, err = restoreRecords(ctx, r53, saved)
+		return err
+	})
+	if err != nil {
+		logger.Error("Rollback failed", errorFields(err, fields{"zone_id": saved[0].ZoneID}))
+		return
+	}
+	for _, e := range saved {
+		f := fields{"record_name": e.Name, "record_type": e.Type, "set_identifier": e.SetIdentifier}
+		if info != nil {
+			f["change_id"] = aws.StringValue(info.Id)
+		}
+		logger.Warn("Record rolled back after failed verification", f)
+	}
+	if o.rollbackFile != "" {
+		if err := removeRollbackEntries(o.rollbackFile, saved); err != nil {
+			logger.Warn("Error removing restored records from rollback file", errorFields(err, fields{"rollback_file": o.rollbackFile}))
+		}
+	}
+}
+
+// rollbackSnapshots returns the records of ts and their ownership markers as
+// they are now, leaving out those register wouldn't change.
+func rollbackSnapshots(ctx context.Context, r53 *route53.Route53, ts []*target) ([]*rollbackEntry, error) {
+	var entries []*rollbackEntry
+	now := time.Now().UTC()
+	for _, t := range ts {
+		sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+		if err != nil {
+			return nil, err
+		}
+		record := findIdentifiedSet(sets, t.setIdentifier)
+		if record != nil && newRecordState(record).equal(newRecordState(t.recordSet())) && aws.StringValue(record.HealthCheckId) == t.healthCheckID {
+			continue
+		}
+		markers, err := findRecordSets(ctx, r53, t.zoneID, ownerRecordName(t.name), route53.RRTypeTxt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &rollbackEntry{
+			ZoneID:        t.zoneID,
+			Name:          t.name,
+			Type:          t.rrType,
+			SetIdentifier: t.setIdentifier,
+			Record:        record,
+			Marker:        findIdentifiedSet(markers, t.setIdentifier),
+			Saved:         now,
+		})
+	}
+	return entries, nil
+}
+
+// restoreRecords puts the records of entries, which all belong to one zone,
+// back as they were in a single change batch: the saved record sets are
+// upserted, and the current ones deleted where there was none.
+func restoreRecords(ctx context.Context, r53 *route53.Route53, entries []*rollbackEntry) (*route53.ChangeInfo, error) {
+	var changes []*route53.Change
+	for _, e := range entries {
+		for _, saved := range []struct {
+			name, rrType string
+			set          *route53.ResourceRecordSet
+		}{
+			{e.Name, e.Type, e.Record},
+			{ownerRecordName(e.Name), route53.RRTypeTxt, e.Marker},
+		} {
+			if saved.set != nil {
+				changes = append(changes, &route53.Change{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: saved.set})
+				continue
+			}
+			sets, err := findRecordSets(ctx, r53, e.ZoneID, saved.name, saved.rrType)
+			if err != nil {
+				return nil, err
+			}
+			if current := findIdentifiedSet(sets, e.SetIdentifier); current != nil {
+				changes = append(changes, &route53.Change{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: current})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return submitChanges(ctx, r53, entries[0].ZoneID, "Host "+entries[0].Type+" Record Rolled Back", changes)
+}
+
+func loadRollbackEntries(path string) ([]*rollbackEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*rollbackEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, errors.New("Error parsing rollback file " + path + ": " + err.Error())
+	}
+	return entries, nil
+}
+
+// saveRollbackEntries adds entries, saved before change changeID, to the
+// rollback file at path, replacing those saved earlier for the same records.
+func saveRollbackEntries(path string, entries []*rollbackEntry, changeID string) error {
+	for _, e := range entries {
+		e.ChangeID = changeID
+	}
+	return updateRollbackFile(path, entries, nil)
+}
+
+// removeRollbackEntries removes the records of entries from the rollback
+// file at path once they were restored.
+func removeRollbackEntries(path string, entries []*rollbackEntry) error {
+	return updateRollbackFile(path, nil, entries)
+}
+
+func updateRollbackFile(path string, add, remove []*rollbackEntry) error {
+	current, err := loadRollbackEntries(path)
+	if err != nil {
+		return err
+	}
+	drop := map[string]bool{}
+	for _, e := range append(add, remove...) {
+		drop[e.key()] = true
+	}
+	entries := []*rollbackEntry{}
+	for _, e := range current {
+		if !drop[e.key()] {
+			entries = append(entries, e)
+		}
+	}
+	entries = append(entries, add...)
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash doesn't leave half a file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
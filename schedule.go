@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registrationWindow is when the records of the host are in service, as
+// set by -activate-at, -deactivate-at and -window. Outside of it register
+// doesn't publish them and the daemon takes them out of service like it
+// does for a failing -health-probe, e.g. to send traffic to the capacity of
+// a region during its business hours only.
+type registrationWindow struct {
+	activateAt, deactivateAt time.Time
+	// schedule opens a window lasting duration whenever it matches, in
+	// location
+	schedule *cronSchedule
+	duration time.Duration
+	location *time.Location
+
+	// active is whether the window was open at the last check
+	active bool
+}
+
+// maxWindowDuration bounds -window-duration, a window open all week being
+// no window at all.
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// newRegistrationWindow returns the window of the -activate-at,
+// -deactivate-at and -window parameters, nil when none was given.
+func (o *options) newRegistrationWindow() (*registrationWindow, error) {
+	if o.activateAt == "" && o.deactivateAt == "" && o.window == "" {
+		return nil, nil
+	}
+	w := &registrationWindow{active: true}
+	var err error
+	if o.activateAt != "" {
+		if w.activateAt, err = time.Parse(time.RFC3339, o.activateAt); err != nil {
+			return nil, configError("The activate-at parameter must be a time like 2024-03-01T08:00:00Z")
+		}
+	}
+	if o.deactivateAt != "" {
+		if w.deactivateAt, err = time.Parse(time.RFC3339, o.deactivateAt); err != nil {
+			return nil, configError("The deactivate-at parameter must be a time like 2024-03-01T18:00:00Z")
+		}
+	}
+	if !w.activateAt.IsZero() && !w.deactivateAt.IsZero() && !w.deactivateAt.After(w.activateAt) {
+		return nil, configError("The deactivate-at parameter must be later than activate-at")
+	}
+	if o.window == "" {
+		return w, nil
+	}
+	if !o.daemon {
+		return nil, configError("The window parameter needs the daemon parameter")
+	}
+	if w.schedule, err = parseCron(o.window); err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	if o.windowDuration <= 0 || o.windowDuration >= maxWindowDuration {
+		return nil, withExitCode(exitConfig, fmt.Errorf("The window-duration parameter must be positive and less than %s", maxWindowDuration))
+	}
+	w.duration = o.windowDuration
+	if w.location, err = time.LoadLocation(o.windowTimezone); err != nil {
+		return nil, withExitCode(exitConfig, fmt.Errorf("Unknown window-timezone %q, expected a name like Europe/Berlin", o.windowTimezone))
+	}
+	return w, nil
+}
+
+// contains tells whether the window is open at now.
+func (w *registrationWindow) contains(now time.Time) bool {
+	if !w.activateAt.IsZero() && now.Before(w.activateAt) {
+		return false
+	}
+	if !w.deactivateAt.IsZero() && !now.Before(w.deactivateAt) {
+		return false
+	}
+	if w.schedule == nil {
+		return true
+	}
+	// Open when the schedule matched a minute of the last duration
+	for t := now.In(w.location).Truncate(time.Minute); now.Sub(t) < w.duration; t = t.Add(-time.Minute) {
+		if w.schedule.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// check tells whether the window is open at now, logging when it opened or
+// closed since the last check.
+func (w *registrationWindow) check(now time.Time) bool {
+	active := w.contains(now)
+	if active != w.active {
+		if active {
+			logger.Info("Registration window opened, putting the records into service", w.fields())
+		} else {
+			logger.Info("Registration window closed, taking the records out of service", w.fields())
+		}
+		w.active = active
+	}
+	return active
+}
+
+func (w *registrationWindow) fields() fields {
+	f := fields{}
+	if !w.activateAt.IsZero() {
+		f["activate_at"] = w.activateAt.Format(time.RFC3339)
+	}
+	if !w.deactivateAt.IsZero() {
+		f["deactivate_at"] = w.deactivateAt.Format(time.RFC3339)
+	}
+	if w.schedule != nil {
+		f["window"] = w.schedule.expr
+		f["window_duration"] = w.duration.String()
+	}
+	return f
+}
+
+// cronSchedule is a cron expression of five fields: minute, hour, day of
+// month, month and day of week, each a *, a number, a range like 1-5 or a
+// list of those, optionally with a step like */15.
+type cronSchedule struct {
+	expr string
+	// fields hold a bit for every value the field matches
+	fields [5]uint64
+	// Like cron, a day matches either day field when both are restricted
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("Invalid window %q, expected five fields: minute hour day-of-month month day-of-week", expr)
+	}
+	c := &cronSchedule{expr: expr, anyDayOfMonth: parts[2] == "*", anyDayOfWeek: parts[4] == "*"}
+	for i, f := range cronFields {
+		bits, err := parseCronField(parts[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s %q in window %q: %v", f.name, parts[i], expr, err)
+		}
+		c.fields[i] = bits
+	}
+	// Sunday is 7 as well as 0
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("the step must be a positive number")
+			}
+			span, step = part[:i], n
+		}
+		lo, hi := min, max
+		if span != "*" {
+			bounds := strings.SplitN(span, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("expected a number, range or *")
+			}
+			hi = lo
+			switch {
+			case len(bounds) == 2:
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("expected a number, range or *")
+				}
+			case step > 1:
+				// 5/15 steps from 5 to the end of the range
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("values must be between %d and %d", min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches tells whether the schedule matches the minute of t.
+func (c *cronSchedule) matches(t time.Time) bool {
+	has := func(field, v int) bool {
+		return c.fields[field]&(1<<uint(v)) != 0
+	}
+	if !has(0, t.Minute()) || !has(1, t.Hour()) || !has(3, int(t.Month())) {
+		return false
+	}
+	dayOfMonth, dayOfWeek := has(2, t.Day()), has(4, int(t.Weekday()))
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
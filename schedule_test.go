@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		wantErr  bool
+	}{
+		{field: "*", min: 1, max: 12, want: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{field: "5", min: 0, max: 59, want: []int{5}},
+		{field: "1,3,5", min: 0, max: 6, want: []int{1, 3, 5}},
+		{field: "9-17", min: 0, max: 23, want: []int{9, 10, 11, 12, 13, 14, 15, 16, 17}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "5/15", min: 0, max: 59, want: []int{5, 20, 35, 50}},
+		{field: "10-20/5", min: 0, max: 59, want: []int{10, 15, 20}},
+		{field: "1-5,0", min: 0, max: 7, want: []int{0, 1, 2, 3, 4, 5}},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "0", min: 1, max: 31, wantErr: true},
+		{field: "5-3", min: 0, max: 59, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "*/x", min: 0, max: 59, wantErr: true},
+		{field: "mon", min: 0, max: 7, wantErr: true},
+		{field: "1-x", min: 0, max: 7, wantErr: true},
+	}
+	for _, tt := range tests {
+		bits, err := parseCronField(tt.field, tt.min, tt.max)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q, %d, %d) succeeded, want an error", tt.field, tt.min, tt.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q, %d, %d) failed: %v", tt.field, tt.min, tt.max, err)
+			continue
+		}
+		var got []int
+		for v := 0; v < 64; v++ {
+			if bits&(1<<uint(v)) != 0 {
+				got = append(got, v)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCronField(%q, %d, %d) = %v, want %v", tt.field, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"* 24 * * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2026-03-01 is a Sunday, 2026-03-02 a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(1, 0, 0), true},
+		{"30 2 * * *", at(1, 2, 30), true},
+		{"30 2 * * *", at(1, 2, 31), false},
+		{"30 2 * * *", at(1, 3, 30), false},
+		{"*/15 * * * *", at(1, 4, 45), true},
+		{"*/15 * * * *", at(1, 4, 46), false},
+		{"0 9-17 * * *", at(2, 17, 0), true},
+		{"0 9-17 * * *", at(2, 18, 0), false},
+		{"0 0 * 3 *", at(2, 0, 0), true},
+		{"0 0 * 4 *", at(2, 0, 0), false},
+		// Only the day of the week restricts the days
+		{"0 2 * * 1-5", at(2, 2, 0), true},
+		{"0 2 * * 1-5", at(1, 2, 0), false},
+		// Sunday is 7 as well as 0
+		{"0 2 * * 7", at(1, 2, 0), true},
+		{"0 2 * * 0", at(1, 2, 0), true},
+		// Only the day of the month restricts the days
+		{"0 2 1 * *", at(1, 2, 0), true},
+		{"0 2 1 * *", at(2, 2, 0), false},
+		// Both restrict the days, either matching as with cron
+		{"0 2 15 * 1", at(2, 2, 0), true},
+		{"0 2 1 * 1", at(1, 2, 0), true},
+		{"0 2 15 * 1", at(1, 2, 0), false},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := c.matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
+
+func TestRegistrationWindowContains(t *testing.T) {
+	schedule, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &registrationWindow{
+		schedule:     schedule,
+		duration:     time.Hour,
+		location:     time.UTC,
+		deactivateAt: time.Date(2026, time.March, 10, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2026, time.March, 1, 1, 59, 0, 0, time.UTC), false},
+		{time.Date(2026, time.March, 1, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, time.March, 1, 2, 59, 59, 0, time.UTC), true},
+		{time.Date(2026, time.March, 1, 3, 0, 0, 0, time.UTC), false},
+		// The window opens in its own time zone
+		{time.Date(2026, time.March, 1, 2, 30, 0, 0, time.FixedZone("UTC+1", 3600)), false},
+		{time.Date(2026, time.March, 10, 2, 30, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.t.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
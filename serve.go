@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// registrationRequest is the body of the serve API's requests. Hostname is
+// relative to Zone, like -hostname, which is the zone of the server unless
+// given.
+type registrationRequest struct {
+	Zone          string `json:"zone"`
+	Hostname      string `json:"hostname"`
+	Type          string `json:"type"`
+	Value         string `json:"value"`
+	SetIdentifier string `json:"set_identifier"`
+	Weight        *int64 `json:"weight"`
+	TTL           *int64 `json:"ttl"`
+}
+
+// registrationServer registers records on behalf of the processes calling
+// its API, only in the zones and under the name prefixes its policy grants
+// them. Without authentication every caller gets the same grant, the zone
+// of the server and the -allow-prefix names.
+type registrationServer struct {
+	o        *options
+	r53      *route53.Route53
+	zoneID   string
+	auth     string
+	serverID string
+	policy   *servePolicy
+	tls      *tls.Config
+
+	// zoneIDs caches the ids of the other zones granted by the policy
+	mu      sync.Mutex
+	zoneIDs map[string]string
+}
+
+// serveError is an error of the caller's making, answered with its status.
+type serveError struct {
+	status int
+	msg    string
+}
+
+func (e *serveError) Error() string {
+	return e.msg
+}
+
+func runServe(args []string) error {
+	var o options
+	fs := newFlagSet("serve")
+	o.addZoneFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:8053", "address to serve the registration API on")
+	var prefixes stringList
+	fs.Var(&prefixes, "allow-prefix", "prefix of the names, relative to the zone, callers may register records under: team-a takes team-a.example.com and team-a.web.example.com, team-a- also team-a-web.example.com (required, may be repeated)")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records whose request has none, in seconds (default 60 for A and AAAA, 300 for CNAME records)")
+	fs.Int64Var(&o.weight, "weight", defaultWeight, "weight of the records whose request has none")
+	auth := fs.String("auth", authNone, "how callers are authenticated: none, mtls (client certificates signed by -client-ca) or sigv4 (signed STS GetCallerIdentity requests)")
+	policyFile := fs.String("auth-policy", "", "YAML or JSON file granting zones and name prefixes to the authenticated callers, see README (required with -auth)")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate to serve the API over HTTPS with (required with -auth mtls or sigv4)")
+	tlsKey := fs.String("tls-key", "", "PEM private key of -tls-cert")
+	clientCA := fs.String("client-ca", "", "PEM file of the CA certificates the client certificates must be signed by (required with -auth mtls)")
+	serverID := fs.String("sigv4-server-id", "route53_register", "value of the "+serverIDHeader+" header SigV4 callers must sign, telling this server apart from others")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	switch *auth {
+	case authNone:
+		if len(prefixes) == 0 {
+			return configError("The allow-prefix parameter is required, callers may only register records under the allowed prefixes")
+		}
+		if *policyFile != "" {
+			return configError("The auth-policy parameter needs -auth mtls or sigv4")
+		}
+	case authMTLS, authSigV4:
+		if *policyFile == "" {
+			return configError("The auth-policy parameter is required with -auth " + *auth)
+		}
+		if len(prefixes) > 0 {
+			return configError("The allow-prefix parameter only applies without -auth, grant prefixes in the auth-policy instead")
+		}
+	default:
+		return configError("Unknown auth " + *auth + ", expected none, mtls or sigv4")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return configError("The tls-cert and tls-key parameters must be given together")
+	}
+	if *auth == authMTLS && (*tlsCert == "" || *clientCA == "") {
+		return configError("The mtls auth needs the tls-cert, tls-key and client-ca parameters")
+	}
+	// Anybody on the way could replay a signature sent in the clear
+	if *auth == authSigV4 && *tlsCert == "" {
+		return configError("The sigv4 auth needs the tls-cert and tls-key parameters")
+	}
+	if *clientCA != "" && *auth != authMTLS {
+		return configError("The client-ca parameter needs -auth mtls")
+	}
+	ctx, cancel := o.context()
+	zoneID, err := o.resolveZoneID(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	s := &registrationServer{o: &o, r53: r53, zoneID: zoneID, auth: *auth, serverID: *serverID, zoneIDs: map[string]string{}}
+	zone := normalizeName(o.zone())
+	if *policyFile != "" {
+		if s.policy, err = loadServePolicy(*policyFile, zone); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	} else {
+		g := serveGrant{Identity: "*", Zones: []string{zone}}
+		for _, p := range prefixes {
+			g.Prefixes = append(g.Prefixes, normalizeName(p))
+		}
+		s.policy = &servePolicy{Identities: []serveGrant{g}}
+	}
+	if *tlsCert != "" {
+		if s.tls, err = serveTLSConfig(*tlsCert, *tlsKey, *clientCA); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+	return s.serve(*listen)
+}
+
+// serve answers API requests on addr until stopped.
+func (s *registrationServer) serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	server := &http.Server{Handler: s.handler(), TLSConfig: s.tls}
+	running, stopRunning := untilStopped()
+	defer stopRunning()
+	go func() {
+		<-running.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+	logger.Info("Serving registration API", fields{"addr": l.Addr().String(), "zone_id": s.zoneID, "auth": s.auth, "tls": s.tls != nil})
+	if s.tls != nil {
+		// The certificates are in the TLS config already
+		err = server.ServeTLS(l, "", "")
+	} else {
+		err = server.Serve(l)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handler serves POST /v1/register, POST /v1/deregister and GET /v1/status,
+// the latter taking the request's fields as query parameters.
+func (s *registrationServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/register", s.endpoint("register", "POST", s.register))
+	mux.HandleFunc("/v1/deregister", s.endpoint("deregister", "POST", s.deregister))
+	mux.HandleFunc("/v1/status", s.endpoint("status", "GET", s.status))
+	return mux
+}
+
+func (s *registrationServer) endpoint(name, method string, fn func(context.Context, *target) (map[string]interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, root := tracing.StartTrace(r.Context(), "serve "+name, fields{"remote_addr": r.RemoteAddr})
+		body, err := s.handle(r.WithContext(ctx), root, method, fn)
+		root.End(err)
+		if ferr := tracing.Flush(root); ferr != nil {
+			logger.Warn("Error exporting traces", errorFields(ferr, nil))
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadGateway
+			if se, ok := err.(*serveError); ok {
+				status = se.status
+			} else {
+				logger.Error("Request failed", errorFields(err, fields{"operation": name, "remote_addr": r.RemoteAddr, "identity": root.attrs["identity"]}))
+			}
+			body = map[string]interface{}{"error": err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (s *registrationServer) handle(r *http.Request, root *span, method string, fn func(context.Context, *target) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if r.Method != method {
+		return nil, &serveError{http.StatusMethodNotAllowed, "Use " + method}
+	}
+	identity, err := s.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if s.auth != authNone {
+		root.attrs["identity"] = identity
+	}
+	var req registrationRequest
+	if method == "GET" {
+		q := r.URL.Query()
+		req = registrationRequest{Zone: q.Get("zone"), Hostname: q.Get("hostname"), Type: q.Get("type"), SetIdentifier: q.Get("set_identifier")}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &serveError{http.StatusBadRequest, "Invalid request body: " + err.Error()}
+	}
+	ctx, cancel := s.o.withTimeout(r.Context())
+	defer cancel()
+	t, err := s.target(ctx, identity, req)
+	if err != nil {
+		return nil, err
+	}
+	return fn(ctx, t)
+}
+
+// target checks a request of identity, returning the record it is about.
+func (s *registrationServer) target(ctx context.Context, identity string, req registrationRequest) (*target, error) {
+	zone := normalizeName(firstNonEmpty(req.Zone, s.o.zone()))
+	t, err := req.target(zone, s.o.weight, s.o.ttl)
+	if err != nil {
+		return nil, &serveError{http.StatusBadRequest, err.Error()}
+	}
+	g := s.policy.grant(identity)
+	if g == nil {
+		return nil, &serveError{http.StatusForbidden, identity + " isn't granted any names"}
+	}
+	if !g.allows(zone, t.name) {
+		return nil, &serveError{http.StatusForbidden, t.name + " isn't under any of the prefixes " + strings.Join(g.Prefixes, ", ") + " in the zones " + strings.Join(g.Zones, ", ")}
+	}
+	if t.zoneID, err = s.zoneIDOf(ctx, zone); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// target checks req, returning the record it is about in zone, weight and
+// ttl applying unless req has its own. The zone id is left to the caller.
+func (req registrationRequest) target(zone string, weight, ttl int64) (*target, error) {
+	if req.Hostname == "" || req.SetIdentifier == "" {
+		return nil, errors.New("hostname and set_identifier are required")
+	}
+	t := &target{
+		name:          qualifyName(req.Hostname, zone),
+		rrType:        strings.ToUpper(firstNonEmpty(req.Type, route53.RRTypeA)),
+		value:         req.Value,
+		setIdentifier: req.SetIdentifier,
+		weight:        weight,
+		ttl:           ttl,
+	}
+	if req.Weight != nil {
+		t.weight = *req.Weight
+	}
+	if req.TTL != nil {
+		t.ttl = *req.TTL
+	}
+	if err := validateDNSName(t.name); err != nil {
+		return nil, err
+	}
+	if !inZone(t.name, zone) {
+		return nil, errors.New(t.name + " is not in zone " + zone)
+	}
+	switch t.rrType {
+	case route53.RRTypeA, route53.RRTypeAaaa, route53.RRTypeCname:
+	default:
+		return nil, errors.New("Unsupported type " + t.rrType + ", expected A, AAAA or CNAME")
+	}
+	if t.weight < 0 || t.weight > 255 || t.ttl < 0 || t.ttl > maxTTL {
+		return nil, errors.New("weight must be between 0 and 255 and ttl between 0 and " + strconv.Itoa(maxTTL))
+	}
+	t.ttl = recordTTL(t.ttl, t.rrType)
+	return t, nil
+}
+
+// validateRequestValue checks the value of a record to register for a
+// request, which unlike those of hosts may be an AAAA record.
+func validateRequestValue(t *target) error {
+	if err := t.validateValue(); err != nil {
+		return err
+	}
+	if ip := net.ParseIP(t.value); t.rrType == route53.RRTypeAaaa && (ip == nil || ip.To4() != nil) {
+		return errors.New("The value " + t.value + " isn't an IPv6 address")
+	}
+	return nil
+}
+
+// zoneIDOf returns the id of a zone granted by the policy, looking it up the
+// first time it's asked for.
+func (s *registrationServer) zoneIDOf(ctx context.Context, zone string) (string, error) {
+	if zone == normalizeName(s.o.zone()) {
+		return s.zoneID, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.zoneIDs[zone]; ok {
+		return id, nil
+	}
+	id, err := getDNSHostedZoneID(ctx, s.o.account, zone)
+	if err != nil {
+		return "", err
+	}
+	s.zoneIDs[zone] = id
+	return id, nil
+}
+
+func (s *registrationServer) register(ctx context.Context, t *target) (map[string]interface{}, error) {
+	if err := validateRequestValue(t); err != nil {
+		return nil, &serveError{http.StatusBadRequest, err.Error()}
+	}
+	info, err := upsertRecords(ctx, s.r53, []*target{t})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"record_name": t.name, "change_id": aws.StringValue(info.Id)}, nil
+}
+
+func (s *registrationServer) deregister(ctx context.Context, t *target) (map[string]interface{}, error) {
+	info, err := deleteRecords(ctx, s.r53, []*target{t})
+	if _, ok := unwrapExitError(err).(notOwnedError); ok {
+		return nil, &serveError{http.StatusConflict, err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"record_name": t.name, "deleted": info != nil}
+	if info != nil {
+		body["change_id"] = aws.StringValue(info.Id)
+	}
+	return body, nil
+}
+
+func (s *registrationServer) status(ctx context.Context, t *target) (map[string]interface{}, error) {
+	sets, err := findRecordSets(ctx, s.r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"record_name": t.name, "registered": false}
+	if set := findIdentifiedSet(sets, t.setIdentifier); set != nil {
+		body["registered"] = true
+		body["values"] = recordValues(set)
+		body["weight"] = aws.Int64Value(set.Weight)
+		body["ttl"] = aws.Int64Value(set.TTL)
+	}
+	return body, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Authentication modes of the serve API.
+const (
+	authNone  = "none"
+	authMTLS  = "mtls"
+	authSigV4 = "sigv4"
+)
+
+// serverIDHeader carries the -sigv4-server-id of the server a SigV4 caller
+// means to talk to. It is part of the signature, so a signature sent to one
+// server can't be replayed against another one.
+const serverIDHeader = "X-Route53-Register-Server-Id"
+
+// stsIdentityBody is the body of the STS GetCallerIdentity request SigV4
+// callers sign.
+const stsIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+const stsIdentityContentType = "application/x-www-form-urlencoded; charset=utf-8"
+
+// regionPattern matches the names of AWS regions, e.g. us-east-1 or
+// us-gov-west-1.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// servePolicy is the content of an -auth-policy file.
+type servePolicy struct {
+	Identities []serveGrant `yaml:"identities"`
+}
+
+// serveGrant lets the callers matching Identity register records in Zones
+// under Prefixes. Identity is a pattern as in path.Match, e.g.
+// arn:aws:sts::123456789012:assumed-role/team-a/* or *.team-a.internal.
+type serveGrant struct {
+	Identity string   `yaml:"identity"`
+	Zones    []string `yaml:"zones"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+func loadServePolicy(file, defaultZone string) (*servePolicy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p servePolicy
+	if err = yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, fmt.Errorf("Error parsing auth policy %s: %v", file, err)
+	}
+	if len(p.Identities) == 0 {
+		return nil, errors.New("Auth policy " + file + " grants nothing to anybody")
+	}
+	for i := range p.Identities {
+		g := &p.Identities[i]
+		if _, err := path.Match(g.Identity, ""); err != nil || g.Identity == "" {
+			return nil, fmt.Errorf("Invalid identity %q in auth policy %s", g.Identity, file)
+		}
+		if len(g.Prefixes) == 0 {
+			return nil, fmt.Errorf("Identity %s of auth policy %s has no prefixes", g.Identity, file)
+		}
+		if len(g.Zones) == 0 {
+			g.Zones = []string{defaultZone}
+		}
+		for j := range g.Zones {
+			g.Zones[j] = normalizeName(g.Zones[j])
+		}
+		for j := range g.Prefixes {
+			g.Prefixes[j] = normalizeName(g.Prefixes[j])
+		}
+	}
+	return &p, nil
+}
+
+// grant returns the grant of the first pattern identity matches, or nil.
+func (p *servePolicy) grant(identity string) *serveGrant {
+	for i := range p.Identities {
+		if ok, _ := path.Match(p.Identities[i].Identity, identity); ok {
+			return &p.Identities[i]
+		}
+	}
+	return nil
+}
+
+// allows tells whether the grant covers the record name in zone. Prefixes
+// match the name relative to the zone on label boundaries, team-a taking
+// team-a and team-a.web but not team-ab, unless they end with a hyphen:
+// team-a- takes the names whose first label starts with it.
+func (g *serveGrant) allows(zone, name string) bool {
+	relative := strings.TrimSuffix(name, "."+zone)
+	if relative == name || !containsString(g.Zones, zone) {
+		// The apex, or a name outside the zone
+		return false
+	}
+	for _, p := range g.Prefixes {
+		if relative == p || strings.HasPrefix(relative, p+".") {
+			return true
+		}
+		if strings.HasSuffix(p, "-") && !strings.Contains(p, ".") && strings.HasPrefix(relative, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveTLSConfig returns the TLS settings of the server, requiring a client
+// certificate signed by clientCA when it's given.
+func serveTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in client CA " + clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// authenticate returns who made r: the common name of its verified client
+// certificate with mTLS, or the ARN STS tells the signature belongs to with
+// SigV4.
+func (s *registrationServer) authenticate(r *http.Request) (string, error) {
+	switch s.auth {
+	case authMTLS:
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return "", &serveError{http.StatusUnauthorized, "A verified client certificate is required"}
+		}
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+	case authSigV4:
+		return s.sigV4Identity(r)
+	}
+	return "", nil
+}
+
+// sigV4Identity passes the signature of a GetCallerIdentity request, which
+// the caller sent along in the headers of r, on to STS. STS only answers
+// when the signature is valid, and tells whose credentials made it.
+func (s *registrationServer) sigV4Identity(r *http.Request) (string, error) {
+	authz := r.Header.Get("Authorization")
+	scope, signed, ok := parseSigV4Authorization(authz)
+	if !ok {
+		return "", &serveError{http.StatusUnauthorized, "A SigV4 signed GetCallerIdentity request is required in the Authorization header"}
+	}
+	if !containsString(signed, strings.ToLower(serverIDHeader)) || r.Header.Get(serverIDHeader) != s.serverID {
+		return "", &serveError{http.StatusUnauthorized, "The signature must cover the " + serverIDHeader + " header of this server, " + s.serverID}
+	}
+	endpoint, err := stsEndpoint(scope)
+	if err != nil {
+		return "", &serveError{http.StatusUnauthorized, err.Error()}
+	}
+	req, err := http.NewRequest("POST", endpoint+"/", strings.NewReader(stsIdentityBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", stsIdentityContentType)
+	for _, h := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token", serverIDHeader} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := awsHTTP.client.Do(req.WithContext(r.Context()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusBadRequest {
+		return "", &serveError{http.StatusUnauthorized, "STS rejected the signature: " + resp.Status}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("STS GetCallerIdentity: %s", resp.Status)
+	}
+	var out struct {
+		Arn string `xml:"GetCallerIdentityResult>Arn"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Arn, nil
+}
+
+// stsEndpoint returns the STS endpoint of the region of a credential scope,
+// e.g. AKID/20060102/us-east-1/sts/aws4_request. The region picks the host
+// the signature is sent to, so it has to be the name of a region, and the
+// endpoint one of AWS: otherwise a caller could have its own server vouch
+// for any identity.
+func stsEndpoint(scope string) (string, error) {
+	parts := strings.Split(scope, "/")
+	if len(parts) != 5 || parts[3] != "sts" || parts[4] != "aws4_request" {
+		return "", errors.New("The signature isn't one for STS")
+	}
+	if !regionPattern.MatchString(parts[2]) {
+		return "", fmt.Errorf("The signature is for an invalid region %q", parts[2])
+	}
+	endpoint, err := awsEndpoints.EndpointFor("sts", parts[2])
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" || !(strings.HasSuffix(host, ".amazonaws.com") || strings.HasSuffix(host, ".amazonaws.com.cn")) {
+		return "", fmt.Errorf("The STS endpoint %s of region %s isn't one of AWS", endpoint.URL, parts[2])
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// parseSigV4Authorization returns the credential scope and signed headers
+// of a SigV4 Authorization header. Headers naming a part twice or lacking
+// the signature are refused, as STS might read them differently.
+func parseSigV4Authorization(authz string) (scope string, signed []string, ok bool) {
+	if !strings.HasPrefix(authz, "AWS4-HMAC-SHA256 ") {
+		return "", nil, false
+	}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(strings.TrimPrefix(authz, "AWS4-HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[1] == "" || seen[kv[0]] {
+			return "", nil, false
+		}
+		seen[kv[0]] = true
+		switch kv[0] {
+		case "Credential":
+			scope = kv[1]
+		case "SignedHeaders":
+			signed = strings.Split(kv[1], ";")
+		case "Signature":
+		default:
+			return "", nil, false
+		}
+	}
+	if !seen["Credential"] || !seen["SignedHeaders"] || !seen["Signature"] {
+		return "", nil, false
+	}
+	return scope, signed, true
+}
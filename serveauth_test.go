@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSigV4Authorization(t *testing.T) {
+	const scope = "AKIDEXAMPLE/20261015/us-east-1/sts/aws4_request"
+	tests := []struct {
+		name       string
+		authz      string
+		wantScope  string
+		wantSigned []string
+		wantOK     bool
+	}{
+		{
+			name:       "valid",
+			authz:      "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=content-type;host;x-amz-date;x-route53-register-server-id, Signature=abc123",
+			wantScope:  scope,
+			wantSigned: []string{"content-type", "host", "x-amz-date", "x-route53-register-server-id"},
+			wantOK:     true,
+		},
+		{
+			name:       "without spaces",
+			authz:      "AWS4-HMAC-SHA256 Credential=" + scope + ",SignedHeaders=host,Signature=abc123",
+			wantScope:  scope,
+			wantSigned: []string{"host"},
+			wantOK:     true,
+		},
+		{name: "empty"},
+		{name: "other scheme", authz: "Bearer Credential=" + scope + ", SignedHeaders=host, Signature=abc123"},
+		{name: "SigV2", authz: "AWS AKIDEXAMPLE:abc123"},
+		{name: "scheme alone", authz: "AWS4-HMAC-SHA256 "},
+		{name: "no signature", authz: "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=host"},
+		{name: "no signed headers", authz: "AWS4-HMAC-SHA256 Credential=" + scope + ", Signature=abc123"},
+		{name: "no credential", authz: "AWS4-HMAC-SHA256 SignedHeaders=host, Signature=abc123"},
+		{name: "empty credential", authz: "AWS4-HMAC-SHA256 Credential=, SignedHeaders=host, Signature=abc123"},
+		{name: "part without value", authz: "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=host, Signature=abc123, Junk"},
+		{name: "unknown part", authz: "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=host, Signature=abc123, Region=us-west-2"},
+		{
+			// STS might read the first credential while we'd go by the
+			// last one
+			name:  "credential twice",
+			authz: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261015/evil.example.com/sts/aws4_request, Credential=" + scope + ", SignedHeaders=host, Signature=abc123",
+		},
+		{name: "signed headers twice", authz: "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=host, SignedHeaders=x-route53-register-server-id, Signature=abc123"},
+	}
+	for _, tt := range tests {
+		scope, signed, ok := parseSigV4Authorization(tt.authz)
+		if scope != tt.wantScope || !reflect.DeepEqual(signed, tt.wantSigned) || ok != tt.wantOK {
+			t.Errorf("%s: parseSigV4Authorization = %q, %q, %v, want %q, %q, %v", tt.name, scope, signed, ok, tt.wantScope, tt.wantSigned, tt.wantOK)
+		}
+	}
+}
+
+func TestSTSEndpoint(t *testing.T) {
+	tests := []struct {
+		scope   string
+		want    string
+		wantErr bool
+	}{
+		{scope: "AKIDEXAMPLE/20261015/us-east-1/sts/aws4_request", want: "https://sts.amazonaws.com"},
+		// The standard partition has a global endpoint, which clients sign
+		// for
+		{scope: "AKIDEXAMPLE/20261015/eu-west-1/sts/aws4_request", want: "https://sts.amazonaws.com"},
+		{scope: "AKIDEXAMPLE/20261015/us-gov-west-1/sts/aws4_request", want: "https://sts.us-gov-west-1.amazonaws.com"},
+		{scope: "AKIDEXAMPLE/20261015/cn-north-1/sts/aws4_request", want: "https://sts.cn-north-1.amazonaws.com.cn"},
+		{scope: "AKIDEXAMPLE/20261015/mx-central-1/sts/aws4_request", want: "https://sts.amazonaws.com"},
+		{scope: "AKIDEXAMPLE/20261015/us-east-1/s3/aws4_request", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/us-east-1/sts/other", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/us-east-1/sts", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/us-east-1/sts/aws4_request/extra", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015//sts/aws4_request", wantErr: true},
+		// Regions that would send the signature to another host
+		{scope: "AKIDEXAMPLE/20261015/evil.example.com?/sts/aws4_request", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/evil.example.com#/sts/aws4_request", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/us-east-1.evil.example.com:443@x/sts/aws4_request", wantErr: true},
+		{scope: "AKIDEXAMPLE/20261015/US-EAST-1/sts/aws4_request", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := stsEndpoint(tt.scope)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("stsEndpoint(%q) = %q, %v, want %q, error %v", tt.scope, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestServeGrantAllows(t *testing.T) {
+	g := &serveGrant{Zones: []string{"example.com", "team-a.example.com"}, Prefixes: []string{"team-a", "ci-"}}
+	tests := []struct {
+		zone, name string
+		want       bool
+	}{
+		{"example.com", "team-a.example.com", true},
+		{"example.com", "team-a.web.example.com", true},
+		{"example.com", "team-ab.example.com", false},
+		{"example.com", "team-a-web.example.com", false},
+		{"example.com", "web.team-a.example.com", false},
+		{"example.com", "ci-1.example.com", true},
+		{"example.com", "ci-.example.com", true},
+		{"example.com", "ci.example.com", false},
+		{"example.com", "web.ci-1.example.com", false},
+		{"example.com", "example.com", false},
+		// The zone of team-a.example.com has the prefix as its name, not
+		// its records
+		{"team-a.example.com", "team-a.example.com", false},
+		{"team-a.example.com", "web.team-a.example.com", false},
+		{"team-a.example.com", "team-a.team-a.example.com", true},
+		{"example.org", "team-a.example.org", false},
+		{"example.com", "team-a.example.org", false},
+	}
+	for _, tt := range tests {
+		if got := g.allows(tt.zone, tt.name); got != tt.want {
+			t.Errorf("allows(%q, %q) = %v, want %v", tt.zone, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// serviceActions are the actions of the service command.
+var serviceActions = []string{"install", "uninstall", "run"}
+
+// runService installs the daemon as a Windows service, removes it, or runs
+// it under the service control manager, which is what the installed service
+// does. The flags after -- are the daemon's.
+func runService(args []string) error {
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	var o options
+	fs := newFlagSet("service")
+	o.addCommonFlags(fs)
+	name := fs.String("name", "route53_register", "name of the Windows service")
+	displayName := fs.String("display-name", "Route53 registration", "(install only) name of the service as the Services console shows it")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if !containsString(serviceActions, action) {
+		return configError(fmt.Sprintf("Unknown action %q, expected %s", action, strings.Join(serviceActions, ", ")))
+	}
+	if *name == "" {
+		return configError("The name parameter is required")
+	}
+	switch action {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		// The service runs us again, with the same flags
+		command := append([]string{exe, "service", "run", "-name", *name, "--"}, fs.Args()...)
+		if err := installService(*name, *displayName, command); err != nil {
+			return err
+		}
+		logger.Info("Installed service", fields{"name": *name})
+		return nil
+	case "uninstall":
+		if err := uninstallService(*name); err != nil {
+			return err
+		}
+		logger.Info("Uninstalled service", fields{"name": *name})
+		return nil
+	}
+	return runAsService(*name, append([]string{"-daemon"}, fs.Args()...))
+}
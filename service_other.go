@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errNotWindows = withExitCode(exitConfig, errors.New("Services are only supported on Windows, systemd-unit prints a unit for systemd"))
+
+func installService(name, displayName string, command []string) error {
+	return errNotWindows
+}
+
+func uninstallService(name string) error {
+	return errNotWindows
+}
+
+func runAsService(name string, daemonArgs []string) error {
+	return errNotWindows
+}
@@ -0,0 +1,290 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of the service control manager and event log APIs we use, as
+// the syscall package doesn't have them.
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procOpenSCManagerW                = advapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW                = advapi32.NewProc("CreateServiceW")
+	procOpenServiceW                  = advapi32.NewProc("OpenServiceW")
+	procChangeServiceConfig2W         = advapi32.NewProc("ChangeServiceConfig2W")
+	procControlService                = advapi32.NewProc("ControlService")
+	procDeleteService                 = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle            = advapi32.NewProc("CloseServiceHandle")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyExW               = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW                = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKeyW                 = advapi32.NewProc("RegDeleteKeyW")
+)
+
+const (
+	scManagerAllAccess = 0xf003f
+	serviceAllAccess   = 0xf01ff
+
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	serviceConfigDescription    = 1
+	serviceConfigFailureActions = 2
+	scActionRestart             = 1
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+	serviceAcceptStop         = 1
+	serviceAcceptShutdown     = 4
+
+	errorCallNotImplemented    = 120
+	errorServiceSpecificError  = 1066
+	errorFailedServiceCtrlConn = 1063
+
+	eventlogErrorType       = 1
+	eventlogWarningType     = 2
+	eventlogInformationType = 4
+
+	regOptionNonVolatile = 0
+	keySetValue          = 0x2
+	regExpandSZ          = 2
+	regDWORD             = 4
+)
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceDescription struct {
+	description *uint16
+}
+
+type scAction struct {
+	actionType uint32
+	delay      uint32
+}
+
+type serviceFailureActions struct {
+	resetPeriod uint32
+	rebootMsg   *uint16
+	command     *uint16
+	actions     uint32
+	action      *scAction
+}
+
+// eventLogKey is where the event sources of the Application log are
+// registered.
+const eventLogKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\`
+
+// The service control manager calls back into serviceMain and
+// serviceHandler on threads of its own, which learn what to run from these.
+var service struct {
+	name       string
+	daemonArgs []string
+	handle     uintptr
+	status     serviceStatus
+	err        error
+}
+
+func utf16Ptr(s string) *uint16 {
+	p, _ := syscall.UTF16PtrFromString(s)
+	return p
+}
+
+// windowsCommandLine joins args the way the service control manager splits
+// them again.
+func windowsCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = syscall.EscapeArg(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func installService(name, displayName string, command []string) error {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, scManagerAllAccess)
+	if scm == 0 {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+	h, _, err := procCreateServiceW.Call(scm,
+		uintptr(unsafe.Pointer(utf16Ptr(name))),
+		uintptr(unsafe.Pointer(utf16Ptr(displayName))),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(utf16Ptr(windowsCommandLine(command)))),
+		0, 0, 0,
+		// LocalSystem, which gets the instance role's credentials
+		0, 0)
+	if h == 0 {
+		return err
+	}
+	defer procCloseServiceHandle.Call(h)
+
+	description := serviceDescription{utf16Ptr("Keeps this instance's records registered in Route53")}
+	procChangeServiceConfig2W.Call(h, serviceConfigDescription, uintptr(unsafe.Pointer(&description)))
+	// Restarted like systemd's Restart=on-failure, the failure count being
+	// reset after a day
+	restart := scAction{actionType: scActionRestart, delay: 10000}
+	actions := serviceFailureActions{resetPeriod: 86400, actions: 1, action: &restart}
+	if r, _, err := procChangeServiceConfig2W.Call(h, serviceConfigFailureActions, uintptr(unsafe.Pointer(&actions))); r == 0 {
+		logger.Warn("Error setting the service to restart on failure", errorFields(err, fields{"name": name}))
+	}
+	if err := installEventSource(name); err != nil {
+		logger.Warn("Error registering event log source, messages will lack their description", errorFields(err, fields{"name": name}))
+	}
+	return nil
+}
+
+// installEventSource registers name as a source of the Application event
+// log, formatting its messages with EventCreate.exe, which passes them
+// through as they are.
+func installEventSource(name string) error {
+	var key syscall.Handle
+	r, _, _ := procRegCreateKeyExW.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), uintptr(unsafe.Pointer(utf16Ptr(eventLogKey+name))),
+		0, 0, regOptionNonVolatile, keySetValue, 0, uintptr(unsafe.Pointer(&key)), 0)
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	defer syscall.RegCloseKey(key)
+	file, _ := syscall.UTF16FromString(`%SystemRoot%\System32\EventCreate.exe`)
+	if r, _, _ := procRegSetValueExW.Call(uintptr(key), uintptr(unsafe.Pointer(utf16Ptr("EventMessageFile"))), 0, regExpandSZ,
+		uintptr(unsafe.Pointer(&file[0])), uintptr(len(file)*2)); r != 0 {
+		return syscall.Errno(r)
+	}
+	types := uint32(eventlogErrorType | eventlogWarningType | eventlogInformationType)
+	if r, _, _ := procRegSetValueExW.Call(uintptr(key), uintptr(unsafe.Pointer(utf16Ptr("TypesSupported"))), 0, regDWORD,
+		uintptr(unsafe.Pointer(&types)), 4); r != 0 {
+		return syscall.Errno(r)
+	}
+	return nil
+}
+
+func uninstallService(name string) error {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, scManagerAllAccess)
+	if scm == 0 {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+	h, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(utf16Ptr(name))), serviceAllAccess)
+	if h == 0 {
+		return err
+	}
+	defer procCloseServiceHandle.Call(h)
+	// A running service is deleted once it stopped
+	var status serviceStatus
+	procControlService.Call(h, serviceControlStop, uintptr(unsafe.Pointer(&status)))
+	if r, _, err := procDeleteService.Call(h); r == 0 {
+		return err
+	}
+	if r, _, _ := procRegDeleteKeyW.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), uintptr(unsafe.Pointer(utf16Ptr(eventLogKey+name)))); r != 0 {
+		logger.Warn("Error removing event log source", errorFields(syscall.Errno(r), fields{"name": name}))
+	}
+	return nil
+}
+
+// runAsService hands this process to the service control manager, which
+// runs the daemon with daemonArgs in serviceMain and returns once it stopped.
+func runAsService(name string, daemonArgs []string) error {
+	service.name, service.daemonArgs = name, daemonArgs
+	table := []serviceTableEntry{{utf16Ptr(name), syscall.NewCallback(serviceMain)}, {nil, 0}}
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if err == syscall.Errno(errorFailedServiceCtrlConn) {
+			return withExitCode(exitConfig, errors.New("service run is only run by the service control manager, start the service instead"))
+		}
+		return err
+	}
+	return service.err
+}
+
+func serviceMain(argc uint32, argv **uint16) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(utf16Ptr(service.name))), syscall.NewCallback(serviceHandler), 0)
+	if h == 0 {
+		service.err = err
+		return 0
+	}
+	service.handle = h
+	if events, _, _ := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(utf16Ptr(service.name)))); events != 0 {
+		// Services have no console, their output goes to the event log
+		logger.mu.Lock()
+		logger.system = func(s severity, line string) { reportEvent(events, s, line) }
+		logger.mu.Unlock()
+	}
+	setServiceStatus(serviceStartPending, 0, nil)
+	setServiceStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, nil)
+	service.err = runRegister(service.daemonArgs)
+	if service.err != nil {
+		logger.Error("Service failed", errorFields(service.err, nil))
+	}
+	setServiceStatus(serviceStopped, 0, service.err)
+	return 0
+}
+
+func serviceHandler(control, eventType uint32, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, 0, nil)
+		select {
+		case stopRequests <- "service stop":
+		default:
+		}
+	case serviceControlInterrogate:
+		setServiceStatus(service.status.currentState, service.status.controlsAccepted, nil)
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// setServiceStatus reports our state to the service control manager, with
+// the exit status of err once stopped.
+func setServiceStatus(state, accepts uint32, err error) {
+	service.status = serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state, controlsAccepted: accepts}
+	if err != nil {
+		service.status.win32ExitCode = errorServiceSpecificError
+		service.status.serviceSpecificExitCode = uint32(exitCode(err))
+	}
+	procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&service.status)))
+}
+
+// reportEvent writes a log line to the event log, with the event type of
+// its level.
+func reportEvent(events uintptr, s severity, line string) {
+	eventType := eventlogInformationType
+	switch s {
+	case levelWarn:
+		eventType = eventlogWarningType
+	case levelError:
+		eventType = eventlogErrorType
+	}
+	strs := []*uint16{utf16Ptr(line)}
+	// Event ids 1 to 1000 of EventCreate.exe print the string as it is
+	procReportEventW.Call(events, uintptr(eventType), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxSharedAttempts bounds how many times a shared record update is retried
+// when other hosts keep modifying the record set underneath us.
+const maxSharedAttempts = 10
+
+// isConcurrentModification reports whether a change was rejected because the
+// record set no longer looks like it did when we read it, another host having
+// changed it in between, or because a change of ours is still pending. A
+// batch rejected for anything else, like an invalid value, would be rejected
+// again however often it's retried.
+func isConcurrentModification(err error) bool {
+	aerr, ok := unwrapExitError(err).(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case route53.ErrCodePriorRequestNotComplete:
+		return true
+	case route53.ErrCodeInvalidChangeBatch:
+		// Route53 gives a message for each change it rejected
+		batch, ok := aerr.(awserr.BatchedErrors)
+		if !ok || len(batch.OrigErrs()) == 0 {
+			return false
+		}
+		for _, e := range batch.OrigErrs() {
+			if e == nil || !isStaleRecordSetMessage(e.Error()) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isStaleRecordSetMessage reports whether Route53 rejected a change with
+// message because the record set to delete is gone or was changed, or the
+// one to create exists already.
+func isStaleRecordSetMessage(message string) bool {
+	return strings.Contains(message, "but it was not found") ||
+		strings.Contains(message, "but it already exists") ||
+		strings.Contains(message, "but the values provided do not match the current values")
+}
+
+// sharedRecordValues returns the values of a shared record set after adding
+// or removing value, and whether that changed anything.
+func sharedRecordValues(current *route53.ResourceRecordSet, value string, add bool) ([]string, bool) {
+	var values []string
+	found := false
+	for _, v := range recordValues(current) {
+		if v == value {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		values = append(values, v)
+	}
+	if add && !found {
+		values = append(values, value)
+	}
+	return values, found != add
+}
+
+// sharedMarkerValues returns the ownership markers of a shared record after
+// dropping the ones whose id is in drop, plus any markers in add.
+func sharedMarkerValues(current *route53.ResourceRecordSet, drop map[string]bool, add ...ownerMarker) []string {
+	var values []string
+	for _, v := range recordValues(current) {
+		if m, ok := parseOwnerMarker(v); ok && drop[m.id] {
+			continue
+		}
+		values = append(values, v)
+	}
+	for _, m := range add {
+		values = append(values, m.String())
+	}
+	return values
+}
+
+// updateSharedRecords adds the values of ts to (or removes them from) plain
+// record sets that many hosts contribute to. Old sets are deleted and new ones
+// created in the same change batch, so Route53 rejects the change if another
+// host modified them since we read them; in that case we read them again and
+// retry.
+func updateSharedRecords(ctx context.Context, r53 *route53.Route53, ts []*target, add bool) (*route53.ChangeInfo, error) {
+	for attempt := 1; ; attempt++ {
+		var changes []*route53.Change
+		var changed []*target
+		for _, t := range ts {
+			c, err := sharedRecordChanges(ctx, r53, t, add)
+			if err != nil {
+				return nil, err
+			}
+			if len(c) == 0 {
+				logger.Info("Shared record doesn't contain value, nothing to remove", t.fields())
+				continue
+			}
+			changes = append(changes, c...)
+			changed = append(changed, t)
+		}
+		if len(changes) == 0 {
+			return nil, nil
+		}
+		info, err := submitChanges(ctx, r53, ts[0].zoneID, "Shared "+ts[0].rrType+" Record Updated", changes)
+		if err == nil {
+			for _, t := range changed {
+				f := t.fields()
+				f["change_id"] = aws.StringValue(info.Id)
+				if add {
+					logger.Info("Added value to shared record", f)
+				} else {
+					logger.Info("Removed value from shared record", f)
+				}
+			}
+			return info, nil
+		}
+		if !isConcurrentModification(err) || attempt >= maxSharedAttempts {
+			return nil, err
+		}
+		logger.Warn("Shared record changed concurrently, retrying", errorFields(err, fields{"zone_id": ts[0].zoneID}))
+		if err = sleepContext(ctx, time.Duration(attempt)*200*time.Millisecond+time.Duration(rand.Int63n(int64(time.Second)))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sharedRecordChanges reads the shared record set of t and its markers and
+// returns the changes adding or removing t's value, none when there is
+// nothing to remove.
+func sharedRecordChanges(ctx context.Context, r53 *route53.Route53, t *target, add bool) ([]*route53.Change, error) {
+	markerName := ownerRecordName(t.name)
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return nil, err
+	}
+	markerSets, err := findRecordSets(ctx, r53, t.zoneID, markerName, route53.RRTypeTxt)
+	if err != nil {
+		return nil, err
+	}
+	current, currentMarker := findPlainSet(sets), findPlainSet(markerSets)
+
+	values, changed := sharedRecordValues(current, t.value, add)
+	if !add && changed {
+		if err := checkOwned(t, currentMarker, t.value); err != nil {
+			return nil, err
+		}
+	}
+	drop := map[string]bool{t.value: true}
+	var markers []string
+	if add {
+		// Re-adding our marker refreshes its timestamp even when the
+		// value itself is already there
+		markers = sharedMarkerValues(currentMarker, drop, ownerMarker{id: t.value, registered: time.Now()})
+	} else {
+		markers = sharedMarkerValues(currentMarker, drop)
+	}
+	if !add && !changed && len(markers) == len(recordValues(currentMarker)) {
+		return nil, nil
+	}
+
+	// The sets keep the TTL they have, so that hosts registering with
+	// another -ttl don't change it back and forth
+	var changes []*route53.Change
+	if changed {
+		changes = append(changes, replaceRecordSet(current, t.name, t.rrType, liveTTL(current, t.ttl), values)...)
+	}
+	changes = append(changes, replaceRecordSet(currentMarker, markerName, route53.RRTypeTxt, liveTTL(currentMarker, markerTTL), markers)...)
+	return changes, nil
+}
+
+// liveTTL returns the TTL of set, or ttl when it doesn't exist.
+func liveTTL(set *route53.ResourceRecordSet, ttl int64) int64 {
+	if set != nil && set.TTL != nil {
+		return *set.TTL
+	}
+	return ttl
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestSharedRecordValues(t *testing.T) {
+	set := func(values ...string) *route53.ResourceRecordSet {
+		s := &route53.ResourceRecordSet{Name: aws.String("web.example.com"), Type: aws.String("A")}
+		for _, v := range values {
+			s.ResourceRecords = append(s.ResourceRecords, &route53.ResourceRecord{Value: aws.String(v)})
+		}
+		return s
+	}
+	tests := []struct {
+		name        string
+		current     *route53.ResourceRecordSet
+		value       string
+		add         bool
+		want        []string
+		wantChanged bool
+	}{
+		{"add to a new set", nil, "10.0.0.1", true, []string{"10.0.0.1"}, true},
+		{"add to others", set("10.0.0.1", "10.0.0.2"), "10.0.0.3", true, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, true},
+		{"add again", set("10.0.0.1", "10.0.0.2"), "10.0.0.2", true, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"remove", set("10.0.0.1", "10.0.0.2", "10.0.0.3"), "10.0.0.2", false, []string{"10.0.0.1", "10.0.0.3"}, true},
+		{"remove the last", set("10.0.0.1"), "10.0.0.1", false, nil, true},
+		{"remove a missing value", set("10.0.0.1"), "10.0.0.2", false, []string{"10.0.0.1"}, false},
+		{"remove from no set", nil, "10.0.0.1", false, nil, false},
+	}
+	for _, tt := range tests {
+		got, changed := sharedRecordValues(tt.current, tt.value, tt.add)
+		if !reflect.DeepEqual(got, tt.want) || changed != tt.wantChanged {
+			t.Errorf("%s: sharedRecordValues = %q, %v, want %q, %v", tt.name, got, changed, tt.want, tt.wantChanged)
+		}
+	}
+}
+
+func TestIsConcurrentModification(t *testing.T) {
+	invalidChangeBatch := func(messages ...string) error {
+		var errs []error
+		for _, m := range messages {
+			errs = append(errs, awserr.New(route53.ErrCodeInvalidChangeBatch, m, nil))
+		}
+		return withExitCode(exitChangeFailed, awserr.NewRequestFailure(awserr.NewBatchError(route53.ErrCodeInvalidChangeBatch, "ChangeBatch errors occurred", errs), 400, "req"))
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"prior request", awserr.New(route53.ErrCodePriorRequestNotComplete, "pending", nil), true},
+		{"deleted", invalidChangeBatch("Tried to delete resource record set [name='web.example.com.', type='A'] but it was not found"), true},
+		{"created", invalidChangeBatch("Tried to create resource record set [name='web.example.com.', type='A'] but it already exists"), true},
+		{"values changed", invalidChangeBatch("Tried to delete resource record set [name='web.example.com.', type='A'] but the values provided do not match the current values"), true},
+		{"invalid value", invalidChangeBatch("ARRDATAIllegalIPv4Address (Value is not a valid IPv4 address) encountered with '10.0.0'"), false},
+		{"stale and invalid", invalidChangeBatch("Tried to create resource record set [name='web.example.com.', type='A'] but it already exists", "ARRDATAIllegalIPv4Address (Value is not a valid IPv4 address) encountered with '10.0.0'"), false},
+		{"no messages", invalidChangeBatch(), false},
+		{"access denied", awserr.New("AccessDenied", "denied", nil), false},
+		{"other error", errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := isConcurrentModification(tt.err); got != tt.want {
+			t.Errorf("%s: isConcurrentModification = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateSharedRecordsKeepsTTL(t *testing.T) {
+	live := testRecordSet("web.example.com", "A", "", 0, "10.0.0.1")
+	live.TTL = aws.Int64(300)
+	f, r53 := newFakeRoute53(t, live)
+	tg := &target{zoneID: "Z1", name: "web.example.com", rrType: "A", value: "10.0.0.2", ttl: 60}
+	if _, err := updateSharedRecords(context.Background(), r53, []*target{tg}, true); err != nil {
+		t.Fatal(err)
+	}
+	set := f.find("web.example.com", "A", "")
+	if got := recordValues(set); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) || aws.Int64Value(set.TTL) != 300 {
+		t.Errorf("shared record = %q with TTL %d, want both values with TTL 300", got, aws.Int64Value(set.TTL))
+	}
+	if marker := f.find(ownerRecordName("web.example.com"), "TXT", ""); aws.Int64Value(marker.TTL) != markerTTL {
+		t.Errorf("new marker set has TTL %d, want %d", aws.Int64Value(marker.TTL), markerTTL)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxWeight is the largest weight Route53 accepts for a record.
+const maxWeight = 255
+
+func runShift(args []string) error {
+	var o options
+	fs := newFlagSet("shift")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "name of the weighted records to shift weight between")
+	rrType := fs.String("type", route53.RRTypeA, "type of the weighted records")
+	from := fs.String("from", "", "set identifier of the record to move weight away from")
+	to := fs.String("to", "", "set identifier of the record to move weight to")
+	step := fs.Int("step", 10, "percentage of the combined weight to move at each step")
+	interval := fs.Duration("interval", 2*time.Minute, "time to wait between steps")
+	healthCheckID := fs.String("health-check-id", "", "health check watched after each step, rolling back when it's unhealthy (defaults to the one of the -to record)")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	// An interrupted shift is rolled back rather than left half way
+	running, stopRunning := untilStopped()
+	defer stopRunning()
+	ctx, cancel := o.withTimeout(running)
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *hostname == "" || *from == "" || *to == "" {
+		return configError("The hostname, from and to parameters are required")
+	}
+	if *from == *to {
+		return configError("The from and to parameters must name different records")
+	}
+	if *step <= 0 || *step > 100 {
+		return configError("The step parameter must be between 1 and 100")
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	name := o.recordName(*hostname)
+	sets, err := findRecordSets(ctx, r53, zoneID, name, strings.ToUpper(*rrType))
+	if err != nil {
+		return err
+	}
+	fromSet, toSet := findIdentifiedSet(sets, *from), findIdentifiedSet(sets, *to)
+	if fromSet == nil || toSet == nil {
+		return errors.New("Both " + *from + " and " + *to + " records must exist under " + name)
+	}
+	if *healthCheckID == "" {
+		*healthCheckID = aws.StringValue(toSet.HealthCheckId)
+	}
+
+	fromWeight, toWeight := aws.Int64Value(fromSet.Weight), aws.Int64Value(toSet.Weight)
+	// The combined weight is kept, so that the share of other records under
+	// the name doesn't change, as far as a single record may hold it
+	total := fromWeight + toWeight
+	if total == 0 {
+		return configError("The " + *from + " and " + *to + " records both have weight 0, there is no weight to shift")
+	}
+	if total > maxWeight {
+		total = maxWeight
+	}
+
+	setWeights := func(ctx context.Context, f, t int64) error {
+		fromSet.Weight, toSet.Weight = aws.Int64(f), aws.Int64(t)
+		changes := []*route53.Change{
+			{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: fromSet},
+			{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: toSet},
+		}
+		info, err := submitChanges(ctx, r53, zoneID, "Weight Shifted", changes)
+		if err == nil {
+			logger.Info("Weights shifted", fields{
+				"zone_id":     zoneID,
+				"record_name": name,
+				"change_id":   aws.StringValue(info.Id),
+				"from":        *from,
+				"from_weight": f,
+				"to":          *to,
+				"to_weight":   t,
+			})
+		}
+		return err
+	}
+	// rollBack restores the weights the records had before the shift. It
+	// gets a context of its own, as ctx is done when the shift was
+	// interrupted or timed out.
+	rollBack := func() error {
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		return setWeights(rollbackCtx, fromWeight, toWeight)
+	}
+
+	var percent int64
+	if fromWeight+toWeight > 0 {
+		percent = toWeight * 100 / (fromWeight + toWeight)
+	}
+	started := false
+	err = func() error {
+		for percent < 100 {
+			percent += int64(*step)
+			if percent > 100 {
+				percent = 100
+			}
+			shifted := total * percent / 100
+			started = true
+			if err := setWeights(ctx, total-shifted, shifted); err != nil {
+				return err
+			}
+			if *healthCheckID == "" {
+				if percent < 100 {
+					if err := sleepContext(ctx, *interval); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := sleepContext(ctx, *interval); err != nil {
+				return err
+			}
+			healthy, err := healthCheckHealthy(ctx, r53, *healthCheckID)
+			if err != nil {
+				return err
+			}
+			if !healthy {
+				logger.Warn("Health check unhealthy, rolling back", fields{"record_name": name, "health_check_id": *healthCheckID})
+				if err := rollBack(); err != nil {
+					return err
+				}
+				return fmt.Errorf("Shift from %s to %s rolled back at %d%%", *from, *to, percent)
+			}
+		}
+		return nil
+	}()
+	if err != nil && started && ctx.Err() != nil {
+		logger.Warn("Shift interrupted, rolling back", errorFields(err, fields{"record_name": name}))
+		if rollbackErr := rollBack(); rollbackErr != nil {
+			return fmt.Errorf("Shift from %s to %s interrupted at %d%%, rolling back failed: %v", *from, *to, percent, rollbackErr)
+		}
+		return fmt.Errorf("Shift from %s to %s interrupted at %d%%, rolled back: %v", *from, *to, percent, err)
+	}
+	return err
+}
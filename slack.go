@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// slackPrefixes start the messages of each severity, standing out in a
+// channel without relying on the formatting of a particular chat service.
+var slackPrefixes = map[severity]string{
+	levelInfo:  "[info]",
+	levelWarn:  "[warning]",
+	levelError: "[error]",
+}
+
+// notifySlack posts text to -slack-webhook-url when s is at least
+// -slack-severity. The message is a Slack incoming webhook payload, which
+// Mattermost, Rocket.Chat and others take as well. Failures are logged.
+func (o *options) notifySlack(ctx context.Context, s severity, text string) {
+	if o.slackWebhookURL == "" {
+		return
+	}
+	minimum, err := parseSeverity(o.slackSeverity)
+	if err != nil || s < minimum {
+		return
+	}
+	host, _ := os.Hostname()
+	payload, err := json.Marshal(map[string]string{"text": fmt.Sprintf("%s %s (from %s)", slackPrefixes[s], text, host)})
+	if err != nil {
+		return
+	}
+	if err := postWebhook(ctx, o.slackWebhookURL, payload); err != nil {
+		logger.Warn("Error posting to Slack webhook", errorFields(err, nil))
+	}
+}
+
+// notifyResult posts the outcome of an operation on a record: failures as
+// errors, and changes that were made as info. Records that needed no change
+// aren't worth a message.
+func (o *options) notifyResult(ctx context.Context, operation string, r result) {
+	switch {
+	case r.Status == "FAILED":
+		name := r.Record
+		if name == "" {
+			name = "this host's records"
+		}
+		o.notifySlack(ctx, levelError, fmt.Sprintf("%s of %s failed: %s", strings.Title(operation), name, r.Error))
+	case r.Status != "UNCHANGED":
+		o.notifySlack(ctx, levelInfo, fmt.Sprintf("%s of %s %s %s succeeded", strings.Title(operation), r.Record, r.Type, r.Value))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// recordState is a record as the notifications describe it before and
+// after a change.
+type recordState struct {
+	Values []string `json:"values"`
+	Weight *int64   `json:"weight,omitempty"`
+	TTL    *int64   `json:"ttl,omitempty"`
+}
+
+func newRecordState(set *route53.ResourceRecordSet) *recordState {
+	if set == nil {
+		return nil
+	}
+	s := &recordState{Weight: set.Weight, TTL: set.TTL}
+	if set.AliasTarget != nil {
+		s.Values = []string{liveValue(set)}
+	} else {
+		s.Values = recordValues(set)
+	}
+	return s
+}
+
+func (s *recordState) equal(other *recordState) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return strings.Join(s.Values, ",") == strings.Join(other.Values, ",") &&
+		aws.Int64Value(s.Weight) == aws.Int64Value(other.Weight) &&
+		aws.Int64Value(s.TTL) == aws.Int64Value(other.TTL)
+}
+
+// recordEvent is the message published to -sns-topic-arn when a record was
+// created, changed or deleted.
+type recordEvent struct {
+	Event         string       `json:"event"`
+	Operation     string       `json:"operation"`
+	Record        string       `json:"record"`
+	Type          string       `json:"type"`
+	SetIdentifier string       `json:"set_identifier,omitempty"`
+	ZoneID        string       `json:"zone_id"`
+	ChangeID      string       `json:"change_id,omitempty"`
+	InstanceID    string       `json:"instance_id,omitempty"`
+	Old           *recordState `json:"old"`
+	New           *recordState `json:"new"`
+	Time          time.Time    `json:"time"`
+}
+
+// recordSnapshot returns the live record set of t, or nil when it has none.
+func recordSnapshot(ctx context.Context, r53 *route53.Route53, t *target) (*recordState, error) {
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return nil, err
+	}
+	if t.shared {
+		return newRecordState(findPlainSet(sets)), nil
+	}
+	return newRecordState(findIdentifiedSet(sets, t.setIdentifier)), nil
+}
+
+// recordSnapshots returns the live record sets of ts. Notifications are
+// only a report, so a record that couldn't be read is left out.
+func recordSnapshots(ctx context.Context, r53 *route53.Route53, ts []*target) map[*target]*recordState {
+	states := map[*target]*recordState{}
+	for _, t := range ts {
+		s, err := recordSnapshot(ctx, r53, t)
+		if err != nil {
+			logger.Warn("Error reading record for notification", errorFields(err, t.fields()))
+			continue
+		}
+		states[t] = s
+	}
+	return states
+}
+
+// notifyChanges publishes an event for each of ts whose record differs
+// between before and after.
+func (o *options) notifyChanges(ctx context.Context, metadataClient *ec2metadata.EC2Metadata, operation string, ts []*target, info *route53.ChangeInfo, before, after map[*target]*recordState) {
+	instanceID, err := getMetadata(ctx, metadataClient, "/instance-id")
+	if err != nil {
+		logger.Warn("Error reading instance id for notification", errorFields(err, nil))
+	}
+	for _, t := range ts {
+		old, ok := before[t]
+		current, read := after[t]
+		if !ok || !read || old.equal(current) {
+			continue
+		}
+		e := recordEvent{
+			Event:      "changed",
+			Operation:  operation,
+			Record:     t.name,
+			Type:       t.rrType,
+			ZoneID:     t.zoneID,
+			InstanceID: instanceID,
+			Old:        old,
+			New:        current,
+			Time:       time.Now().UTC(),
+		}
+		switch {
+		case old == nil:
+			e.Event = "created"
+		case current == nil:
+			e.Event = "deleted"
+		}
+		if !t.shared {
+			e.SetIdentifier = t.setIdentifier
+		}
+		if info != nil {
+			e.ChangeID = aws.StringValue(info.Id)
+		}
+		if err := o.publishEvent(ctx, e); err != nil {
+			logger.Warn("Error publishing notification", errorFields(err, fields{"topic_arn": o.snsTopicARN, "record_name": t.name}))
+		}
+	}
+}
+
+// publishEvent publishes e to -sns-topic-arn, with its event as a message
+// attribute subscriptions can filter on.
+func (o *options) publishEvent(ctx context.Context, e recordEvent) error {
+	message, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sess, err := newAWSSession(o.logLevel())
+	if err != nil {
+		return err
+	}
+	// The topic is called in its own region
+	client := sns.New(sess, &aws.Config{Region: aws.String(strings.Split(o.snsTopicARN, ":")[3])})
+	subject := "route53_register: " + e.Event + " " + e.Record
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+	_, err = client.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(o.snsTopicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"event": {DataType: aws.String("String"), StringValue: aws.String(e.Event)},
+		},
+	})
+	return err
+}
+
+// validateTopicARN checks that arn is the ARN of an SNS topic.
+func validateTopicARN(arn string) error {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" || parts[5] == "" {
+		return errors.New("Invalid sns-topic-arn " + arn + ", expected an ARN like arn:aws:sns:us-east-1:123456789012:dns-changes")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// sendStatsd emits the outcome of an operation on t to a statsd server as
+// <prefix>.registration.latency, .success and .errors. The dogstatsd format
+// additionally tags them with the operation and record name.
+func (o *options) sendStatsd(operation string, t *target, err error, elapsed time.Duration) error {
+	network, addr := "udp", o.statsdAddr
+	if strings.HasPrefix(addr, "unix://") {
+		network, addr = "unixgram", strings.TrimPrefix(addr, "unix://")
+	}
+	conn, derr := net.DialTimeout(network, addr, time.Second)
+	if derr != nil {
+		return derr
+	}
+	defer conn.Close()
+
+	tags := ""
+	if o.statsdDogstatsd {
+		tags = "|#operation:" + operation
+		if t != nil {
+			tags += ",record:" + t.name
+		}
+	}
+	counter := "success"
+	if err != nil {
+		counter = "errors"
+	}
+	// Each metric goes in a datagram of its own since not every server
+	// accepts several lines per packet
+	for _, line := range []string{
+		fmt.Sprintf("%s.registration.latency:%d|ms%s", o.statsdPrefix, int64(elapsed/time.Millisecond), tags),
+		fmt.Sprintf("%s.registration.%s:1|c%s", o.statsdPrefix, counter, tags),
+	} {
+		if _, werr := conn.Write([]byte(line)); werr != nil {
+			return werr
+		}
+	}
+	return nil
+}
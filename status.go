@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runStatus(args []string) error {
+	var o options
+	fs := newFlagSet("status")
+	o.addRecordFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.eachRegistration(func(r *options) error {
+		return r.status(ctx)
+	})
+}
+
+// status prints whether the records described by o match this host.
+func (o *options) status(ctx context.Context) error {
+	if err := o.validateRecord(); err != nil {
+		return err
+	}
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return err
+	}
+	ts, err := o.resolveTargets(ctx, metadataClient)
+	if err != nil {
+		return err
+	}
+	var drifted []string
+	diff := newDiffPrinter(os.Stdout)
+	for _, t := range ts {
+		sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+		if err != nil {
+			return err
+		}
+		if err := t.loadDrained(ctx, r53); err != nil {
+			return err
+		}
+		drift := t.drift(sets)
+		if len(drift) == 0 {
+			fmt.Printf("OK %s %s %s\n", t.name, t.rrType, t.value)
+			continue
+		}
+		fmt.Printf("DRIFT %s %s %s\n", t.name, t.rrType, t.value)
+		diff.diffs(drift)
+		drifted = append(drifted, t.name)
+	}
+	if len(ts) > 0 {
+		printDNSSECStatus(ctx, r53, ts[0].zoneID)
+	}
+	if len(drifted) > 0 {
+		return &exitError{exitVerifyFailed, errors.New("Record " + strings.Join(drifted, ", ") + " doesn't match this host")}
+	}
+	return nil
+}
+
+// drift describes how the live record sets under t's name differ from what
+// registering t would produce, if at all. A drained record is expected at
+// weight zero, once loadDrained read its marker.
+func (t *target) drift(sets []*route53.ResourceRecordSet) []fieldDiff {
+	if t.shared {
+		current := findPlainSet(sets)
+		if current == nil {
+			return []fieldDiff{{"values", noValue, t.value}}
+		}
+		values := recordValues(current)
+		for _, v := range values {
+			if v == t.value {
+				return nil
+			}
+		}
+		return []fieldDiff{{"values", strings.Join(values, ","), strings.Join(append(values, t.value), ",")}}
+	}
+
+	current := findIdentifiedSet(sets, t.setIdentifier)
+	if current == nil {
+		return []fieldDiff{{"set_identifier", noValue, t.setIdentifier}}
+	}
+	var drift []fieldDiff
+	desired := t.recordSet()
+	if live, want := liveValue(current), strings.TrimSuffix(t.value, "."); !sameRecordName(live, want) {
+		drift = append(drift, fieldDiff{"values", live, want})
+	}
+	if live, want := aws.Int64Value(current.TTL), aws.Int64Value(desired.TTL); live != want {
+		drift = append(drift, fieldDiff{"ttl", strconv.FormatInt(live, 10), strconv.FormatInt(want, 10)})
+	}
+	if live, want := aws.Int64Value(current.Weight), aws.Int64Value(desired.Weight); live != want {
+		drift = append(drift, fieldDiff{"weight", strconv.FormatInt(live, 10), strconv.FormatInt(want, 10)})
+	}
+	if live, want := aws.StringValue(current.HealthCheckId), aws.StringValue(desired.HealthCheckId); live != want {
+		drift = append(drift, fieldDiff{"health_check", firstNonEmpty(live, noValue), firstNonEmpty(want, noValue)})
+	}
+	return drift
+}
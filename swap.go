@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runSwap(args []string) error {
+	var o options
+	fs := newFlagSet("swap")
+	o.addZoneFlags(fs)
+	hostname := fs.String("hostname", "", "name of the weighted records whose set identifiers -a and -b are; without it -a and -b are names of plain records")
+	rrType := fs.String("type", route53.RRTypeA, "type of the records")
+	a := fs.String("a", "", "set identifier, or name without -hostname, of the first record, e.g. blue (required)")
+	b := fs.String("b", "", "set identifier, or name without -hostname, of the second record, e.g. green (required)")
+	what := fs.String("swap", "", "what the records exchange: weights, or values, which carry their alias target and health check along (default weights for weighted records, values otherwise)")
+	dryRun := fs.Bool("dry-run", false, "only print how the records would change")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *a == "" || *b == "" {
+		return configError("The a and b parameters are required")
+	}
+	if *a == *b {
+		return configError("The a and b parameters must name two different records")
+	}
+	if *what != "" && *what != "weights" && *what != "values" {
+		return withExitCode(exitConfig, fmt.Errorf("Unknown swap %q, expected weights or values", *what))
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	t := strings.ToUpper(*rrType)
+	var setA, setB *route53.ResourceRecordSet
+	if *hostname != "" {
+		name := o.recordName(*hostname)
+		sets, err := findRecordSets(ctx, r53, zoneID, name, t)
+		if err != nil {
+			return err
+		}
+		setA, setB = findIdentifiedSet(sets, *a), findIdentifiedSet(sets, *b)
+		if setA == nil || setB == nil {
+			return withExitCode(exitConfig, errors.New("Both "+*a+" and "+*b+" "+t+" records must exist under "+name))
+		}
+	} else {
+		for _, s := range []struct {
+			name string
+			set  **route53.ResourceRecordSet
+		}{{*a, &setA}, {*b, &setB}} {
+			name := o.recordName(s.name)
+			sets, err := findRecordSets(ctx, r53, zoneID, name, t)
+			if err != nil {
+				return err
+			}
+			if *s.set = findPlainSet(sets); *s.set == nil {
+				return withExitCode(exitConfig, errors.New("There is no "+t+" record "+name+" without a set identifier"))
+			}
+		}
+	}
+	if *what == "" {
+		*what = "values"
+		if setA.Weight != nil && setB.Weight != nil {
+			*what = "weights"
+		}
+	}
+	if *what == "weights" && (setA.Weight == nil || setB.Weight == nil) {
+		return configError("Only weighted records have weights to swap, use -swap values")
+	}
+
+	newA, newB := swapRecordSets(setA, setB, *what)
+	if *dryRun {
+		diff := newDiffPrinter(os.Stdout)
+		for _, p := range [][2]*route53.ResourceRecordSet{{setA, newA}, {setB, newB}} {
+			diff.change(&route53.Change{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: p[1]}, p[0])
+		}
+		return nil
+	}
+	// Deleting the sets as they were read makes Route53 reject the batch
+	// when either changed since, rather than swapping something else
+	changes := []*route53.Change{
+		{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: setA},
+		{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: setB},
+		{Action: aws.String(route53.ChangeActionCreate), ResourceRecordSet: newA},
+		{Action: aws.String(route53.ChangeActionCreate), ResourceRecordSet: newB},
+	}
+	info, err := submitChanges(ctx, r53, zoneID, "Records Swapped", changes)
+	if err != nil {
+		return err
+	}
+	logger.Info("Records swapped", fields{
+		"zone_id":   zoneID,
+		"swap":      *what,
+		"a":         *a,
+		"b":         *b,
+		"a_changes": strings.Join(diffStrings(diffRecordSets(setA, newA)), "; "),
+		"b_changes": strings.Join(diffStrings(diffRecordSets(setB, newB)), "; "),
+		"change_id": aws.StringValue(info.Id),
+	})
+	return nil
+}
+
+// swapRecordSets returns copies of a and b exchanging their weights, or
+// their values. Values take their alias target and health check along, as
+// those belong to the endpoint, and their TTL when one of them is an alias
+// record, which has none.
+func swapRecordSets(a, b *route53.ResourceRecordSet, what string) (*route53.ResourceRecordSet, *route53.ResourceRecordSet) {
+	newA, newB := *a, *b
+	if what == "weights" {
+		newA.Weight, newB.Weight = b.Weight, a.Weight
+		return &newA, &newB
+	}
+	newA.ResourceRecords, newB.ResourceRecords = b.ResourceRecords, a.ResourceRecords
+	newA.AliasTarget, newB.AliasTarget = b.AliasTarget, a.AliasTarget
+	newA.HealthCheckId, newB.HealthCheckId = b.HealthCheckId, a.HealthCheckId
+	if a.AliasTarget != nil || b.AliasTarget != nil {
+		newA.TTL, newB.TTL = b.TTL, a.TTL
+	}
+	return &newA, &newB
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+// syncSource tags the ownership markers of the records kept by sync. It
+// leaves records registered by hosts alone, and prune leaves its records alone.
+const syncSource = "sync"
+
+// desiredRecords is the content of a sync -file.
+type desiredRecords struct {
+	Records []desiredRecord `yaml:"records"`
+}
+
+type desiredRecord struct {
+	// Name is relative to the zone
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`
+	TTL           *int64   `yaml:"ttl"`
+	Values        []string `yaml:"values"`
+	SetIdentifier string   `yaml:"set_identifier"`
+	Weight        *int64   `yaml:"weight"`
+}
+
+// syncKey identifies a record set within a zone. Names are lower case and
+// without trailing dot.
+type syncKey struct {
+	name          string
+	rrType        string
+	setIdentifier string
+}
+
+func setKey(set *route53.ResourceRecordSet) syncKey {
+	return syncKey{normalizeName(aws.StringValue(set.Name)), aws.StringValue(set.Type), aws.StringValue(set.SetIdentifier)}
+}
+
+// markerKey is the key of the marker set carrying the ownership marker of k.
+func (k syncKey) markerKey() syncKey {
+	return syncKey{ownerRecordName(k.name), route53.RRTypeTxt, k.setIdentifier}
+}
+
+func runSync(args []string) error {
+	var o options
+	fs := newFlagSet("sync")
+	o.addZoneFlags(fs)
+	file := fs.String("file", "", "YAML or JSON file listing the records the zone should have under -prefix, see README (required)")
+	prefix := fs.String("prefix", "", "leading labels of the names of the records kept by sync, relative to the zone, e.g. static. (required)")
+	interval := fs.Duration("interval", time.Minute, "how often to reconcile the zone with the file")
+	once := fs.Bool("once", false, "reconcile once and exit instead of running continuously")
+	dryRun := fs.Bool("dry-run", false, "only print the changes that would be made")
+	fs.BoolVar(&o.force, "force", false, "take over records the file names that exist without an ownership marker of sync, instead of leaving them alone")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *file == "" || *prefix == "" {
+		return configError("The file and prefix parameters are required")
+	}
+	return o.repeat("sync", fields{"file": *file}, *once, *interval, func(ctx context.Context) error {
+		return o.syncZone(ctx, *file, normalizeName(*prefix), *dryRun)
+	})
+}
+
+// repeat runs fn once, or every interval until stopped, each run being a
+// trace of its own. Failed runs are logged and tried again on the next
+// interval.
+func (o *options) repeat(name string, f fields, once bool, interval time.Duration, fn func(context.Context) error) error {
+	if once {
+		ctx, cancel := o.context()
+		defer cancel()
+		return fn(ctx)
+	}
+	if interval <= 0 {
+		return configError("The interval parameter must be positive")
+	}
+
+	running, stopRunning := untilStopped()
+	defer stopRunning()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		traced, root := tracing.StartTrace(running, name, f)
+		ctx, cancel := o.withTimeout(traced)
+		err := fn(ctx)
+		cancel()
+		root.End(err)
+		if ferr := tracing.Flush(root); ferr != nil {
+			logger.Warn("Error exporting traces", errorFields(ferr, nil))
+		}
+		if running.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Error(strings.Title(name)+" failed", errorFields(err, f))
+		}
+		select {
+		case <-ticker.C:
+		case <-running.Done():
+			return nil
+		}
+	}
+}
+
+// syncZone makes the records of the zone under prefix match the file, reading
+// the file again every time so changes to it are picked up.
+func (o *options) syncZone(ctx context.Context, file, prefix string, dryRun bool) error {
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	desired, err := loadDesiredRecords(file, o.zone(), prefix)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	sets, err := listRecordSets(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	zone := normalizeName(o.zone())
+	changes := syncChanges(sets, desired, func(name string) bool { return underPrefix(name, zone, prefix) }, syncSource, o.force, time.Now())
+	return submitSyncChanges(ctx, r53, zoneID, "Records Synced", sets, changes, len(desired), dryRun)
+}
+
+// submitSyncChanges logs and submits the changes bringing records in line.
+// A dry run only logs them and prints how they would change the live sets.
+func submitSyncChanges(ctx context.Context, r53 *route53.Route53, zoneID, comment string, sets []*route53.ResourceRecordSet, changes []*route53.Change, records int, dryRun bool) error {
+	if len(changes) == 0 {
+		logger.Debug("Records in sync", fields{"zone_id": zoneID, "records": records})
+		return nil
+	}
+	if dryRun {
+		printDryRun(zoneID, changes, sets)
+		return nil
+	}
+	for _, c := range changes {
+		logger.Debug("Changing record", changeFields(zoneID, c))
+	}
+	info, err := submitChanges(ctx, r53, zoneID, comment, changes)
+	if err != nil {
+		return err
+	}
+	logger.Info("Synced records", fields{"zone_id": zoneID, "changes": len(changes), "change_id": aws.StringValue(info.Id)})
+	return nil
+}
+
+// printDryRun logs the changes that would be made and prints how they would
+// change the live sets, which have to include those the changes replace.
+func printDryRun(zoneID string, changes []*route53.Change, sets []*route53.ResourceRecordSet) {
+	live := map[syncKey]*route53.ResourceRecordSet{}
+	for _, set := range sets {
+		live[setKey(set)] = set
+	}
+	diff := newDiffPrinter(os.Stdout)
+	for _, c := range changes {
+		logger.Info("Would change record", changeFields(zoneID, c))
+		diff.change(c, live[setKey(c.ResourceRecordSet)])
+	}
+}
+
+func changeFields(zoneID string, c *route53.Change) fields {
+	f := fields{
+		"zone_id":     zoneID,
+		"action":      aws.StringValue(c.Action),
+		"record_name": aws.StringValue(c.ResourceRecordSet.Name),
+		"record_type": aws.StringValue(c.ResourceRecordSet.Type),
+	}
+	if c.ResourceRecordSet.SetIdentifier != nil {
+		f["set_identifier"] = aws.StringValue(c.ResourceRecordSet.SetIdentifier)
+	}
+	return f
+}
+
+func loadDesiredRecords(path, zoneName, prefix string) (map[syncKey]*route53.ResourceRecordSet, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d desiredRecords
+	if err = yaml.UnmarshalStrict(b, &d); err != nil {
+		return nil, fmt.Errorf("Error parsing records file %s: %v", path, err)
+	}
+	desired := map[syncKey]*route53.ResourceRecordSet{}
+	for i, r := range d.Records {
+		set, err := r.recordSet(zoneName)
+		if err != nil {
+			return nil, fmt.Errorf("Record %d of %s: %v", i+1, path, err)
+		}
+		k := setKey(set)
+		if !underPrefix(k.name, normalizeName(zoneName), prefix) {
+			return nil, fmt.Errorf("Record %d of %s: %s is outside of prefix %s", i+1, path, k.name, prefix)
+		}
+		if desired[k] != nil {
+			return nil, fmt.Errorf("Record %d of %s: %s %s is listed more than once", i+1, path, k.name, k.rrType)
+		}
+		desired[k] = set
+	}
+	return desired, nil
+}
+
+func (r desiredRecord) recordSet(zoneName string) (*route53.ResourceRecordSet, error) {
+	if r.Name == "" || r.Type == "" {
+		return nil, errors.New("name and type are required")
+	}
+	if len(r.Values) == 0 {
+		return nil, errors.New("record " + r.Name + " has no values")
+	}
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(qualifyName(r.Name, zoneName)),
+		Type:            aws.String(strings.ToUpper(r.Type)),
+		ResourceRecords: resourceRecords(formatRecordValues(strings.ToUpper(r.Type), r.Values)),
+		TTL:             aws.Int64(defaultTTL(strings.ToUpper(r.Type))),
+	}
+	if r.TTL != nil {
+		set.TTL = r.TTL
+	}
+	if r.SetIdentifier != "" {
+		set.SetIdentifier = aws.String(r.SetIdentifier)
+		set.Weight = aws.Int64(defaultWeight)
+		if r.Weight != nil {
+			set.Weight = r.Weight
+		}
+	} else if r.Weight != nil {
+		return nil, errors.New("record " + r.Name + " has a weight but no set_identifier")
+	}
+	return set, nil
+}
+
+// sameRecordSet reports whether a live record set already looks like the
+// desired one.
+func sameRecordSet(live, desired *route53.ResourceRecordSet) bool {
+	if (live.AliasTarget != nil) != (desired.AliasTarget != nil) || aws.Int64Value(live.TTL) != aws.Int64Value(desired.TTL) || aws.Int64Value(live.Weight) != aws.Int64Value(desired.Weight) ||
+		aws.BoolValue(live.MultiValueAnswer) != aws.BoolValue(desired.MultiValueAnswer) {
+		return false
+	}
+	if a, b := live.AliasTarget, desired.AliasTarget; a != nil {
+		return normalizeName(aws.StringValue(a.DNSName)) == normalizeName(aws.StringValue(b.DNSName)) &&
+			aws.StringValue(a.HostedZoneId) == aws.StringValue(b.HostedZoneId) &&
+			aws.BoolValue(a.EvaluateTargetHealth) == aws.BoolValue(b.EvaluateTargetHealth)
+	}
+	return sameValues(live, desired)
+}
+
+// underPrefix reports whether the record name is one of those sync keeps
+// under prefix: the name relative to zone is prefix or starts with its
+// labels, so that static takes static.db but not staticdb or the apex of a
+// zone named static.com.
+func underPrefix(name, zone, prefix string) bool {
+	relative := strings.TrimSuffix(name, "."+zone)
+	if relative == name {
+		// The apex, or a name outside the zone
+		return false
+	}
+	return relative == prefix || strings.HasPrefix(relative, prefix+".")
+}
+
+// syncChanges returns the changes making the record sets whose names are in
+// scope, every one when it's nil, match desired. Only records carrying an
+// ownership marker of source are updated or removed, other records under the
+// same names are left alone unless force takes over those that desired names.
+func syncChanges(sets []*route53.ResourceRecordSet, desired map[syncKey]*route53.ResourceRecordSet, scope func(name string) bool, source string, force bool, now time.Time) []*route53.Change {
+	current := map[syncKey]*route53.ResourceRecordSet{}
+	for _, set := range sets {
+		current[setKey(set)] = set
+	}
+	owned := sourceRecords(sets, source)
+
+	var desiredKeys, ownedKeys []syncKey
+	for k := range desired {
+		desiredKeys = append(desiredKeys, k)
+	}
+	for k := range owned {
+		ownedKeys = append(ownedKeys, k)
+	}
+
+	var changes []*route53.Change
+	var keptKeys, markerKeys []syncKey
+	kept := map[syncKey]ownerMarker{}
+	touched := map[syncKey]bool{}
+	touch := func(k syncKey) {
+		if mk := k.markerKey(); !touched[mk] {
+			touched[mk] = true
+			markerKeys = append(markerKeys, mk)
+		}
+	}
+	for _, k := range sortKeys(desiredKeys) {
+		want, live := desired[k], current[k]
+		m, ours := owned[k]
+		if live != nil && !ours {
+			if !force {
+				logger.Warn("Record exists but isn't kept by "+source+", leaving it alone", fields{"record_name": k.name, "record_type": k.rrType})
+				continue
+			}
+			logger.Warn("Taking over record that isn't kept by "+source, fields{"record_name": k.name, "record_type": k.rrType})
+		}
+		if live == nil || !sameRecordSet(live, want) {
+			changes = append(changes, &route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: want,
+			})
+			m = ownerMarker{registered: now}
+			touch(k)
+		}
+		m.id, m.source = k.rrType, source
+		kept[k] = m
+		keptKeys = append(keptKeys, k)
+	}
+	for _, k := range sortKeys(ownedKeys) {
+		if _, ok := kept[k]; ok || scope != nil && !scope(k.name) {
+			continue
+		}
+		if live := current[k]; live != nil {
+			changes = append(changes, &route53.Change{
+				Action:            aws.String(route53.ChangeActionDelete),
+				ResourceRecordSet: live,
+			})
+		}
+		touch(k)
+	}
+
+	for _, mk := range sortKeys(markerKeys) {
+		var values []string
+		for _, v := range recordValues(current[mk]) {
+			if m, ok := parseOwnerMarker(v); !ok || m.source != source {
+				values = append(values, v)
+			}
+		}
+		for _, k := range keptKeys {
+			if k.markerKey() == mk {
+				values = append(values, kept[k].String())
+			}
+		}
+		changes = append(changes, syncMarkerChange(current[mk], mk, values))
+	}
+	return changes
+}
+
+// sourceRecords returns the keys of the records carrying an ownership marker
+// of source, with their markers.
+func sourceRecords(sets []*route53.ResourceRecordSet, source string) map[syncKey]ownerMarker {
+	owned := map[syncKey]ownerMarker{}
+	for _, set := range sets {
+		k := setKey(set)
+		name, ok := isOwnerRecordName(k.name)
+		if !ok || k.rrType != route53.RRTypeTxt {
+			continue
+		}
+		// A marker's id is the type of the record it owns, as records of
+		// several types may share a name and so a marker set
+		for _, v := range recordValues(set) {
+			if m, ok := parseOwnerMarker(v); ok && m.source == source {
+				owned[syncKey{name, m.id, k.setIdentifier}] = m
+			}
+		}
+	}
+	return owned
+}
+
+// syncMarkerChange returns the change giving the marker set mk the given
+// values, deleting it when there are none.
+func syncMarkerChange(live *route53.ResourceRecordSet, mk syncKey, values []string) *route53.Change {
+	if len(values) == 0 {
+		return &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: live,
+		}
+	}
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(mk.name),
+		Type:            aws.String(route53.RRTypeTxt),
+		ResourceRecords: resourceRecords(values),
+		TTL:             aws.Int64(markerTTL),
+	}
+	if mk.setIdentifier != "" {
+		set.SetIdentifier = aws.String(mk.setIdentifier)
+		set.Weight = aws.Int64(defaultWeight)
+	}
+	return &route53.Change{
+		Action:            aws.String(route53.ChangeActionUpsert),
+		ResourceRecordSet: set,
+	}
+}
+
+func lessKey(a, b syncKey) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	if a.rrType != b.rrType {
+		return a.rrType < b.rrType
+	}
+	return a.setIdentifier < b.setIdentifier
+}
+
+// sortKeys sorts keys in place, keeping the order of changes and log lines
+// stable between runs.
+func sortKeys(keys []syncKey) []syncKey {
+	sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+	return keys
+}
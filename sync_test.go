@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestUnderPrefix(t *testing.T) {
+	tests := []struct {
+		name, zone, prefix string
+		want               bool
+	}{
+		{"static.example.com", "example.com", "static", true},
+		{"static.db.example.com", "example.com", "static", true},
+		{"static.db.example.com", "example.com", "static.db", true},
+		{"staticdb.example.com", "example.com", "static", false},
+		{"web.static.example.com", "example.com", "static", false},
+		{"static.db.example.com", "example.com", "static.d", false},
+		// The prefix is relative to the zone, so it never takes the apex
+		{"static.com", "static.com", "static", false},
+		{"web.static.com", "static.com", "static", false},
+		{"static.example.org", "example.com", "static", false},
+	}
+	for _, tt := range tests {
+		if got := underPrefix(tt.name, tt.zone, tt.prefix); got != tt.want {
+			t.Errorf("underPrefix(%q, %q, %q) = %v, want %v", tt.name, tt.zone, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSyncChanges(t *testing.T) {
+	synced := ownerMarker{id: "A", registered: testNow, source: syncSource}
+	imported := ownerMarker{id: "A", registered: testNow, source: importSource}
+	marker := func(name string, markers ...ownerMarker) *route53.ResourceRecordSet {
+		var values []string
+		for _, m := range markers {
+			values = append(values, m.String())
+		}
+		return testRecordSet(ownerRecordName(name), "TXT", "", 0, values...)
+	}
+	tests := []struct {
+		name    string
+		sets    []*route53.ResourceRecordSet
+		desired []*route53.ResourceRecordSet
+		force   bool
+		want    []string
+		// wantMarker are the sources of the markers of static.db.example.com
+		// after the changes
+		wantMarker []string
+	}{
+		{
+			name:       "create",
+			desired:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1")},
+			want:       []string{"UPSERT static.db.example.com A", "UPSERT _route53_register.static.db.example.com TXT"},
+			wantMarker: []string{"sync"},
+		},
+		{
+			name:       "update",
+			sets:       []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", synced)},
+			desired:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.2")},
+			want:       []string{"UPSERT static.db.example.com A", "UPSERT _route53_register.static.db.example.com TXT"},
+			wantMarker: []string{"sync"},
+		},
+		{
+			name:    "in sync",
+			sets:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", synced)},
+			desired: []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1")},
+		},
+		{
+			name: "prune",
+			sets: []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", synced)},
+			want: []string{"DELETE static.db.example.com A", "DELETE _route53_register.static.db.example.com TXT"},
+		},
+		{
+			// staticdb is outside of the prefix static
+			name: "prune on label boundaries",
+			sets: []*route53.ResourceRecordSet{testRecordSet("staticdb.example.com", "A", "", 0, "10.0.0.1"), marker("staticdb.example.com", synced)},
+		},
+		{
+			name:       "prune keeps the markers of others",
+			sets:       []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", imported, synced)},
+			want:       []string{"DELETE static.db.example.com A", "UPSERT _route53_register.static.db.example.com TXT"},
+			wantMarker: []string{"import"},
+		},
+		{
+			name: "record of import is left alone",
+			sets: []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", imported)},
+		},
+		{
+			name:    "record without marker is left alone",
+			sets:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1")},
+			desired: []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.2")},
+		},
+		{
+			name:       "record without marker is taken over with force",
+			sets:       []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1")},
+			desired:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.2")},
+			force:      true,
+			want:       []string{"UPSERT static.db.example.com A", "UPSERT _route53_register.static.db.example.com TXT"},
+			wantMarker: []string{"sync"},
+		},
+		{
+			name:       "record of import is taken over with force",
+			sets:       []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.1"), marker("static.db.example.com", imported)},
+			desired:    []*route53.ResourceRecordSet{testRecordSet("static.db.example.com", "A", "", 0, "10.0.0.2")},
+			force:      true,
+			want:       []string{"UPSERT static.db.example.com A", "UPSERT _route53_register.static.db.example.com TXT"},
+			wantMarker: []string{"import", "sync"},
+		},
+	}
+	scope := func(name string) bool { return underPrefix(name, "example.com", "static") }
+	for _, tt := range tests {
+		desired := map[syncKey]*route53.ResourceRecordSet{}
+		for _, set := range tt.desired {
+			desired[setKey(set)] = set
+		}
+		changes := syncChanges(tt.sets, desired, scope, syncSource, tt.force, testNow)
+		var got, gotMarker []string
+		for _, c := range changes {
+			set := c.ResourceRecordSet
+			got = append(got, aws.StringValue(c.Action)+" "+normalizeName(aws.StringValue(set.Name))+" "+aws.StringValue(set.Type))
+			if aws.StringValue(c.Action) != route53.ChangeActionUpsert || normalizeName(aws.StringValue(set.Name)) != ownerRecordName("static.db.example.com") {
+				continue
+			}
+			for _, v := range recordValues(set) {
+				if m, ok := parseOwnerMarker(v); ok {
+					gotMarker = append(gotMarker, m.source)
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: syncChanges = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(gotMarker, tt.wantMarker) {
+			t.Errorf("%s: marker sources = %q, want %q", tt.name, gotMarker, tt.wantMarker)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends state, e.g. READY=1, to the service manager when it runs us
+// as a Type=notify service, and does nothing otherwise. Like sd_notify(3) it
+// talks to the datagram socket named by $NOTIFY_SOCKET, which starts with @
+// when it's abstract.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyState is sdNotify for states that are only a courtesy to the service
+// manager, logging failures.
+func notifyState(state string) {
+	if err := sdNotify(state); err != nil {
+		logger.Warn("Error notifying systemd", errorFields(err, fields{"state": state}))
+	}
+}
+
+// watchdogInterval returns how often systemd expects WATCHDOG=1, half its
+// WatchdogSec= as sd_watchdog_enabled(3) recommends, or 0 when the watchdog
+// isn't enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runWatchdog pings the systemd watchdog until stop is closed, as long as
+// alive reports the daemon's loop still making progress, so systemd restarts
+// a daemon wedged in a reconciliation.
+func runWatchdog(stop <-chan struct{}, alive func() bool) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if alive() {
+				notifyState("WATCHDOG=1")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func runSystemdUnit(args []string) error {
+	var o options
+	fs := newFlagSet("systemd-unit")
+	o.addCommonFlags(fs)
+	binary, _ := os.Executable()
+	fs.StringVar(&binary, "binary", binary, "path of route53_register the unit runs (default the path of this one)")
+	watchdog := fs.Duration("watchdog", 5*time.Minute, "WatchdogSec= of the unit: systemd restarts the daemon this long after its loop stopped making progress, i.e. hasn't finished a reconciliation for 3 times its -interval (none when 0)")
+	description := fs.String("description", "Register this host in Route53", "Description= of the unit")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if binary == "" {
+		return configError("The binary parameter is required, the path of this binary couldn't be found")
+	}
+	if *watchdog < 0 {
+		return configError("The watchdog parameter can't be negative")
+	}
+	// The flags after -- are the daemon's
+	daemonArgs := fs.Args()
+	fmt.Print(systemdUnit(*description, binary, daemonArgs, *watchdog))
+	return nil
+}
+
+// systemdUnit returns a unit running the daemon with args.
+func systemdUnit(description, binary string, args []string, watchdog time.Duration) string {
+	command := []string{systemdQuote(binary), "-daemon"}
+	reloads := false
+	for _, a := range args {
+		command = append(command, systemdQuote(a))
+		if a == "-config" || a == "--config" || strings.HasPrefix(a, "-config=") || strings.HasPrefix(a, "--config=") {
+			reloads = true
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\n", description)
+	b.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+	b.WriteString("[Service]\nType=notify\nNotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(command, " "))
+	if reloads {
+		// Without a config file SIGHUP would stop the daemon
+		b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	}
+	if watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", int64((watchdog+time.Second-1)/time.Second))
+	}
+	// Until the first registration succeeds, e.g. while Route53 throttles
+	b.WriteString("TimeoutStartSec=5min\n")
+	b.WriteString("Restart=on-failure\nRestartSec=10s\n")
+	// Invalid flags won't get better by restarting
+	b.WriteString("RestartPreventExitStatus=2\n\n")
+	b.WriteString("[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// systemdQuote quotes s as a word of a systemd command line when needed,
+// escaping the specifiers and variables systemd would expand.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	s = strings.Replace(s, "$", "$$", -1)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal OpenTelemetry tracer exporting spans as OTLP/HTTP JSON, covering
+// just enough to see where a registration spends its time. Traces continue
+// the one in the TRACEPARENT environment variable when it's set, so they can
+// be correlated with the rest of instance provisioning. A root span is
+// carried in the context of the calls below it, so that the traces of
+// concurrent requests stay apart.
+
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    fields
+	err      error
+	// root is the root span of the trace, nil for the root span itself
+	root *span
+}
+
+// rootOf returns the root span s belongs to.
+func (s *span) rootOf() *span {
+	if s.root != nil {
+		return s.root
+	}
+	return s
+}
+
+type traceKey struct{}
+
+const (
+	// maxFinishedSpans bounds the spans waiting to be exported, dropping
+	// the oldest ones beyond it
+	maxFinishedSpans = 2048
+	// processFlushInterval is how often the spans of the process root are
+	// exported while a long running command, like the daemon or serve,
+	// keeps adding to them
+	processFlushInterval = time.Minute
+)
+
+type tracer struct {
+	mu       sync.Mutex
+	endpoint string
+	service  string
+	// root is the trace of spans started with a context carrying none
+	root     *span
+	finished []*span
+	client   *http.Client
+}
+
+var tracing = &tracer{service: "route53_register", client: &http.Client{Timeout: 5 * time.Second}}
+
+func (tr *tracer) configure(endpoint string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	tr.endpoint = strings.TrimSuffix(endpoint, "/")
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		tr.service = name
+	}
+}
+
+// parseTraceparent parses a W3C traceparent header value.
+func parseTraceparent(value string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, true
+}
+
+// StartTrace starts a root span, returning ctx carrying it for the spans
+// started below it.
+func (tr *tracer) StartTrace(ctx context.Context, name string, attrs fields) (context.Context, *span) {
+	s := &span{name: name, start: time.Now(), attrs: attrs}
+	if traceID, parentID, ok := parseTraceparent(os.Getenv("TRACEPARENT")); ok {
+		s.traceID, s.parentID = traceID, parentID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, traceKey{}, s), s
+}
+
+// StartProcessTrace starts the root span of the spans started with a
+// context carrying none, as the contexts commands create themselves do.
+// Its spans are exported every processFlushInterval, not only once the
+// command is done.
+func (tr *tracer) StartProcessTrace(name string) *span {
+	_, s := tr.StartTrace(context.Background(), name, nil)
+	tr.mu.Lock()
+	tr.root = s
+	tr.mu.Unlock()
+	go func() {
+		for range time.Tick(processFlushInterval) {
+			if err := tr.Flush(s); err != nil {
+				logger.Warn("Error exporting traces", errorFields(err, nil))
+			}
+		}
+	}()
+	return s
+}
+
+// Start starts a span below the root span ctx carries, or the one of the
+// process.
+func (tr *tracer) Start(ctx context.Context, name string, attrs fields) *span {
+	root, _ := ctx.Value(traceKey{}).(*span)
+	if root == nil {
+		tr.mu.Lock()
+		root = tr.root
+		tr.mu.Unlock()
+	}
+	if root == nil {
+		_, s := tr.StartTrace(ctx, name, attrs)
+		return s
+	}
+	s := &span{traceID: root.traceID, parentID: root.spanID, name: name, start: time.Now(), attrs: attrs, root: root}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// End finishes the span, marking it failed when err is not nil.
+func (s *span) End(err error) {
+	s.end = time.Now()
+	s.err = err
+	tracing.mu.Lock()
+	if tracing.endpoint != "" {
+		if n := len(tracing.finished); n >= maxFinishedSpans {
+			copy(tracing.finished, tracing.finished[n-maxFinishedSpans+1:])
+			tracing.finished = tracing.finished[:maxFinishedSpans-1]
+		}
+		tracing.finished = append(tracing.finished, s)
+	}
+	tracing.mu.Unlock()
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(f fields) []otlpAttribute {
+	attrs := []otlpAttribute{}
+	for k, v := range f {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: fmt.Sprint(v)}})
+	}
+	return attrs
+}
+
+// Flush exports the spans of the trace of root finished so far to the OTLP
+// endpoint, leaving those of other traces to theirs.
+func (tr *tracer) Flush(root *span) error {
+	tr.mu.Lock()
+	var spans, others []*span
+	for _, s := range tr.finished {
+		if s.rootOf() == root {
+			spans = append(spans, s)
+		} else {
+			others = append(others, s)
+		}
+	}
+	tr.finished = others
+	endpoint := tr.endpoint
+	tr.mu.Unlock()
+	if endpoint == "" || len(spans) == 0 {
+		return nil
+	}
+
+	var exported []map[string]interface{}
+	for _, s := range spans {
+		e := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            map[string]interface{}{"code": 1},
+		}
+		if s.parentID != [8]byte{} {
+			e["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			e["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		exported = append(exported, e)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(fields{"service.name": tr.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "route53_register"},
+						"spans": exported,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := tr.client.Post(endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP endpoint returned %s", resp.Status)
+	}
+	return nil
+}
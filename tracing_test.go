@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFinishedSpansCapped(t *testing.T) {
+	tracing.mu.Lock()
+	tracing.endpoint, tracing.finished = "http://127.0.0.1:4318", nil
+	tracing.mu.Unlock()
+	defer func() {
+		tracing.mu.Lock()
+		tracing.endpoint, tracing.finished = "", nil
+		tracing.mu.Unlock()
+	}()
+
+	_, root := tracing.StartTrace(context.Background(), "root", nil)
+	var last *span
+	for i := 0; i < maxFinishedSpans+10; i++ {
+		s := &span{name: "child", root: root}
+		s.End(nil)
+		last = s
+	}
+	tracing.mu.Lock()
+	defer tracing.mu.Unlock()
+	if n := len(tracing.finished); n != maxFinishedSpans {
+		t.Errorf("%d spans waiting, want %d", n, maxFinishedSpans)
+	}
+	if tracing.finished[len(tracing.finished)-1] != last {
+		t.Error("the newest span was dropped instead of the oldest")
+	}
+}
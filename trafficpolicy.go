@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func runTrafficPolicy(args []string) error {
+	var o options
+	fs := newFlagSet("traffic-policy")
+	o.addZoneFlags(fs)
+	fs.Var(&o.hostnames, "hostname", "name to create the traffic policy instance for, relative to the zone, @ for the zone apex, which is also used when it's left out (may be repeated)")
+	policyID := fs.String("policy-id", "", "id of the traffic policy to instantiate (required)")
+	version := fs.Int64("policy-version", 0, "version of the traffic policy (default the latest)")
+	fs.Int64Var(&o.ttl, "ttl", 0, "TTL of the records the traffic policy instance creates, in seconds (default 300)")
+	remove := fs.Bool("delete", false, "delete the traffic policy instances of the names, along with their records, instead of creating them")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+
+	if err := o.validateZone(); err != nil {
+		return err
+	}
+	if *policyID == "" && !*remove {
+		return configError("The policy-id parameter is required")
+	}
+	if *version < 0 {
+		return configError("The policy-version parameter must be positive")
+	}
+	zoneID, err := o.resolveZoneID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := o.validateNames(); err != nil {
+		return err
+	}
+	r53, err := newRoute53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	if !*remove && *version == 0 {
+		if *version, err = latestTrafficPolicyVersion(ctx, r53, *policyID); err != nil {
+			return err
+		}
+	}
+	instances, err := listTrafficPolicyInstances(ctx, r53, zoneID)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, hostname := range o.recordHostnames() {
+		name := o.recordName(hostname)
+		if *remove {
+			err = deleteTrafficPolicyInstance(ctx, r53, instances[name], name)
+		} else {
+			err = applyTrafficPolicyInstance(ctx, r53, zoneID, instances[name], name, *policyID, *version, recordTTL(o.ttl, ""))
+		}
+		if err != nil {
+			logger.Error("Traffic policy instance failed", errorFields(err, fields{"record_name": name, "policy_id": *policyID}))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return withExitCode(exitChangeFailed, fmt.Errorf("%d of %d traffic policy instances failed", failed, len(o.recordHostnames())))
+	}
+	return nil
+}
+
+// latestTrafficPolicyVersion returns the newest version of a traffic policy.
+func latestTrafficPolicyVersion(ctx context.Context, r53 *route53.Route53, policyID string) (int64, error) {
+	var latest int64
+	input := &route53.ListTrafficPolicyVersionsInput{Id: aws.String(policyID)}
+	for {
+		out, err := r53.ListTrafficPolicyVersionsWithContext(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range out.TrafficPolicies {
+			if v := aws.Int64Value(p.Version); v > latest {
+				latest = v
+			}
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			break
+		}
+		input.TrafficPolicyVersionMarker = out.TrafficPolicyVersionMarker
+	}
+	if latest == 0 {
+		return 0, withExitCode(exitConfig, errors.New("Traffic policy "+policyID+" has no versions"))
+	}
+	return latest, nil
+}
+
+// listTrafficPolicyInstances returns the traffic policy instances of a zone
+// by their normalized name.
+func listTrafficPolicyInstances(ctx context.Context, r53 *route53.Route53, zoneID string) (map[string]*route53.TrafficPolicyInstance, error) {
+	instances := map[string]*route53.TrafficPolicyInstance{}
+	input := &route53.ListTrafficPolicyInstancesByHostedZoneInput{HostedZoneId: aws.String(normalizeZoneID(zoneID))}
+	for {
+		out, err := r53.ListTrafficPolicyInstancesByHostedZoneWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range out.TrafficPolicyInstances {
+			instances[normalizeName(aws.StringValue(i.Name))] = i
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			return instances, nil
+		}
+		input.TrafficPolicyInstanceNameMarker = out.TrafficPolicyInstanceNameMarker
+		input.TrafficPolicyInstanceTypeMarker = out.TrafficPolicyInstanceTypeMarker
+	}
+}
+
+// applyTrafficPolicyInstance creates the traffic policy instance of name,
+// or updates current when it runs another policy, version or TTL.
+func applyTrafficPolicyInstance(ctx context.Context, r53 *route53.Route53, zoneID string, current *route53.TrafficPolicyInstance, name, policyID string, version, ttl int64) error {
+	f := fields{"record_name": name, "policy_id": policyID, "policy_version": version, "ttl": ttl}
+	if current == nil {
+		out, err := r53.CreateTrafficPolicyInstanceWithContext(ctx, &route53.CreateTrafficPolicyInstanceInput{
+			HostedZoneId:         aws.String(normalizeZoneID(zoneID)),
+			Name:                 aws.String(name),
+			TTL:                  aws.Int64(ttl),
+			TrafficPolicyId:      aws.String(policyID),
+			TrafficPolicyVersion: aws.Int64(version),
+		})
+		if err != nil {
+			return err
+		}
+		f["instance_id"] = aws.StringValue(out.TrafficPolicyInstance.Id)
+		f["state"] = aws.StringValue(out.TrafficPolicyInstance.State)
+		logger.Info("Created traffic policy instance", f)
+		return nil
+	}
+	f["instance_id"] = aws.StringValue(current.Id)
+	if aws.StringValue(current.TrafficPolicyId) == policyID && aws.Int64Value(current.TrafficPolicyVersion) == version && aws.Int64Value(current.TTL) == ttl {
+		logger.Info("Traffic policy instance is up to date", f)
+		return nil
+	}
+	out, err := r53.UpdateTrafficPolicyInstanceWithContext(ctx, &route53.UpdateTrafficPolicyInstanceInput{
+		Id:                   current.Id,
+		TTL:                  aws.Int64(ttl),
+		TrafficPolicyId:      aws.String(policyID),
+		TrafficPolicyVersion: aws.Int64(version),
+	})
+	if err != nil {
+		return err
+	}
+	f["state"] = aws.StringValue(out.TrafficPolicyInstance.State)
+	logger.Info("Updated traffic policy instance", f)
+	return nil
+}
+
+// deleteTrafficPolicyInstance deletes current, the traffic policy instance
+// of name, which takes the records it created along.
+func deleteTrafficPolicyInstance(ctx context.Context, r53 *route53.Route53, current *route53.TrafficPolicyInstance, name string) error {
+	if current == nil {
+		logger.Info("No traffic policy instance to delete", fields{"record_name": name})
+		return nil
+	}
+	_, err := r53.DeleteTrafficPolicyInstanceWithContext(ctx, &route53.DeleteTrafficPolicyInstanceInput{Id: current.Id})
+	if err != nil {
+		return err
+	}
+	logger.Info("Deleted traffic policy instance", fields{"record_name": name, "instance_id": aws.StringValue(current.Id)})
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// typeTTLs are the TTLs records get when -ttl is left out, or 0: short for
+// addresses, which change as hosts come and go, longer for the types
+// naming other records or holding text, which rarely change.
+var typeTTLs = map[string]int64{
+	route53.RRTypeA:    60,
+	route53.RRTypeAaaa: 60,
+	route53.RRTypeSrv:  60,
+}
+
+// otherTTL is the TTL of the types typeTTLs doesn't list, e.g. CNAME,
+// TXT and MX.
+const otherTTL = 300
+
+// maxTTL is the largest TTL Route53 takes, RFC 2181's 2^31-1 seconds.
+const maxTTL = 2147483647
+
+// markerTTL is the TTL of the ownership markers, which are only read
+// through the Route53 API and never cached.
+const markerTTL = 0
+
+// defaultTTL returns the TTL of records of rrType when none is given.
+func defaultTTL(rrType string) int64 {
+	if ttl, ok := typeTTLs[rrType]; ok {
+		return ttl
+	}
+	return otherTTL
+}
+
+// recordTTL returns ttl, or the default TTL of rrType when it's 0.
+func recordTTL(ttl int64, rrType string) int64 {
+	if ttl == 0 {
+		return defaultTTL(rrType)
+	}
+	return ttl
+}
+
+// validateTTL checks a -ttl, 0 standing for the default of the type.
+func validateTTL(ttl int64) error {
+	if ttl < 0 || ttl > maxTTL {
+		return configError("The ttl parameter must be between 1 and " + strconv.Itoa(maxTTL) + ", or 0 for the default of the record type")
+	}
+	return nil
+}
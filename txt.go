@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// maxTXTString is the longest character string of a TXT record, whose
+// length is a single byte. Longer values are split into several strings,
+// which resolvers and mail servers join again.
+const maxTXTString = 255
+
+// formatTXT returns s as the value of a TXT record the way Route53 takes
+// it: quoted strings of up to maxTXTString bytes, separated by spaces.
+func formatTXT(s string) string {
+	var parts []string
+	for len(s) > maxTXTString {
+		parts = append(parts, quoteTXT(s[:maxTXTString]))
+		s = s[maxTXTString:]
+	}
+	parts = append(parts, quoteTXT(s))
+	return strings.Join(parts, " ")
+}
+
+func quoteTXT(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// parseTXT returns the strings of a TXT value as Route53 returns it joined
+// into one, the reverse of formatTXT.
+func parseTXT(value string) string {
+	return strings.Join(splitTXT(value), "")
+}
+
+// splitTXT returns the character strings of a TXT value. Unquoted words are
+// taken as strings of their own, the way Route53 reads them.
+func splitTXT(value string) []string {
+	var parts []string
+	var b strings.Builder
+	quoted, escaped, inString := false, false, false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case escaped && i+3 <= len(value) && isOctalEscape(value[i:i+3]):
+			// Route53 returns bytes outside of printable ASCII as \ddd
+			n, _ := strconv.ParseUint(value[i:i+3], 8, 8)
+			b.WriteByte(byte(n))
+			i += 2
+			escaped = false
+		case escaped:
+			b.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped, inString = true, true
+		case c == '"' && quoted:
+			parts = append(parts, b.String())
+			b.Reset()
+			quoted, inString = false, false
+		case c == '"':
+			if inString {
+				parts = append(parts, b.String())
+				b.Reset()
+			}
+			quoted, inString = true, true
+		case c == ' ' && !quoted:
+			if inString {
+				parts = append(parts, b.String())
+				b.Reset()
+				inString = false
+			}
+		default:
+			b.WriteByte(c)
+			inString = true
+		}
+	}
+	if inString {
+		parts = append(parts, b.String())
+	}
+	return parts
+}
+
+// isTXTType tells whether values of rrType are character strings.
+func isTXTType(rrType string) bool {
+	return rrType == route53.RRTypeTxt || rrType == route53.RRTypeSpf
+}
+
+// txtValue returns a TXT value given in a records file the way Route53
+// takes it. An unquoted value is one string, like the record of an SPF
+// policy written out in YAML, and gets quoted; strings longer than
+// maxTXTString bytes, like DKIM keys, are split into several, which
+// Route53 would reject otherwise.
+func txtValue(value string) string {
+	if !strings.HasPrefix(strings.TrimSpace(value), `"`) {
+		return formatTXT(value)
+	}
+	var parts []string
+	for _, s := range splitTXT(value) {
+		parts = append(parts, formatTXT(s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatRecordValues returns values of a record of rrType the way Route53 takes
+// them.
+func formatRecordValues(rrType string, values []string) []string {
+	if !isTXTType(rrType) {
+		return values
+	}
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = txtValue(v)
+	}
+	return formatted
+}
+
+// comparableValues returns the values of set in a form comparable with
+// those of another set, TXT values being compared by the strings they join
+// into, however they are split and quoted.
+func comparableValues(set *route53.ResourceRecordSet) []string {
+	values := recordValues(set)
+	if !isTXTType(aws.StringValue(set.Type)) {
+		return values
+	}
+	for i, v := range values {
+		values[i] = parseTXT(v)
+	}
+	return values
+}
+
+func isOctalEscape(s string) bool {
+	_, err := strconv.ParseUint(s, 8, 8)
+	return err == nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatTXT(t *testing.T) {
+	long := strings.Repeat("a", maxTXTString)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", `""`},
+		{"short", "v=spf1 -all", `"v=spf1 -all"`},
+		{"quotes and backslashes", `say "hi" \o/`, `"say \"hi\" \\o/"`},
+		{"exactly one string", long, `"` + long + `"`},
+		{"one byte over", long + "b", `"` + long + `" "b"`},
+		{"several strings", long + long + "cd", `"` + long + `" "` + long + `" "cd"`},
+		// Escaping doesn't count towards the length of a string
+		{"escaped at the boundary", strings.Repeat("a", maxTXTString-1) + `""`, `"` + strings.Repeat("a", maxTXTString-1) + `\"" "\""`},
+	}
+	for _, tt := range tests {
+		if got := formatTXT(tt.in); got != tt.want {
+			t.Errorf("%s: formatTXT = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`"v=spf1 -all"`, []string{"v=spf1 -all"}},
+		{`"abc" "def"`, []string{"abc", "def"}},
+		{`"abc""def"`, []string{"abc", "def"}},
+		{`""`, []string{""}},
+		{`unquoted words`, []string{"unquoted", "words"}},
+		{`"say \"hi\"" \\o/`, []string{`say "hi"`, `\o/`}},
+		{`"caf\303\251"`, []string{"café"}},
+	}
+	for _, tt := range tests {
+		if got := splitTXT(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTXT(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTXTRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, maxTXTString - 1, maxTXTString, maxTXTString + 1, 2 * maxTXTString, 1000} {
+		value := strings.Repeat(`k"\`, n)[:n]
+		formatted := formatTXT(value)
+		for _, s := range splitTXT(formatted) {
+			if len(s) > maxTXTString {
+				t.Errorf("formatTXT of %d bytes has a string of %d bytes", n, len(s))
+			}
+		}
+		if got := parseTXT(formatted); got != value {
+			t.Errorf("parseTXT(formatTXT(%q)) = %q", value, got)
+		}
+	}
+}
+
+func TestTXTValue(t *testing.T) {
+	long := strings.Repeat("k", maxTXTString+10)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"unquoted is one string", "v=spf1 include:example.com -all", `"v=spf1 include:example.com -all"`},
+		{"quoted strings are kept", `"v=DKIM1; k=rsa;" "p=abc"`, `"v=DKIM1; k=rsa;" "p=abc"`},
+		{"long unquoted is split", long, `"` + long[:maxTXTString] + `" "` + long[maxTXTString:] + `"`},
+		{"long quoted string is split", `"v=DKIM1; " "` + long + `"`, `"v=DKIM1; " "` + long[:maxTXTString] + `" "` + long[maxTXTString:] + `"`},
+	}
+	for _, tt := range tests {
+		if got := txtValue(tt.in); got != tt.want {
+			t.Errorf("%s: txtValue = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// vpcResolver is the Amazon provided DNS server of every VPC, the only one
+// answering for private hosted zones.
+const vpcResolver = "169.254.169.253"
+
+// changeSyncDelay is how often we poll a change until Route53 has applied it
+// on all of its name servers.
+const changeSyncDelay = 5 * time.Second
+
+// verifyTargets waits for info to be INSYNC and checks that the name servers
+// of the zone answer with the values of ts, then waits for any
+// -verify-resolvers to do so as well.
+func (o *options) verifyTargets(ctx context.Context, ts []*target, info *route53.ChangeInfo) (err error) {
+	s := tracing.Start(ctx, "verification", nil)
+	defer func() {
+		s.End(err)
+	}()
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return err
+	}
+	if err = waitInSync(ctx, r53, info); err != nil {
+		return withExitCode(exitVerifyFailed, err)
+	}
+	servers, err := zoneNameServers(ctx, r53, ts[0].zoneID)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if !t.shared {
+			if err = verifyRecordSet(ctx, r53, t); err != nil {
+				return withExitCode(exitVerifyFailed, err)
+			}
+		}
+		for _, server := range servers {
+			if err = verifyAnswer(ctx, server, t); err != nil {
+				return withExitCode(exitVerifyFailed, err)
+			}
+		}
+		f := t.fields()
+		f["servers"] = strings.Join(servers, ",")
+		logger.Info("Record verified", f)
+	}
+	if resolvers := splitList(o.verifyResolvers); len(resolvers) > 0 {
+		return o.verifyPropagation(ctx, ts, resolvers)
+	}
+	return nil
+}
+
+// verifyPropagation polls every resolver until it answers with the values
+// of ts, logging how long that took. Resolvers keep serving what they
+// cached of the record before the change until its TTL runs out, so this
+// can take a while. It fails with the resolvers that still didn't once
+// -verify-resolvers-timeout ran out. Weighted records are left out: a
+// resolver caches whichever of the records sharing the name it was
+// answered with, so it may not answer with ours before the timeout however
+// well the change propagated.
+func (o *options) verifyPropagation(ctx context.Context, ts []*target, resolvers []string) error {
+	var polled []*target
+	for _, t := range ts {
+		if t.shared {
+			polled = append(polled, t)
+		} else {
+			logger.Info("Not waiting for the resolvers to answer with a weighted record, which they may not pick", t.fields())
+		}
+	}
+	if len(polled) == 0 {
+		return nil
+	}
+	ts = polled
+	ctx, cancel := context.WithTimeout(ctx, o.verifyResolversTimeout)
+	defer cancel()
+	started := time.Now()
+	errs := make([]error, len(resolvers))
+	var wg sync.WaitGroup
+	for i, resolver := range resolvers {
+		wg.Add(1)
+		go func(i int, resolver string) {
+			defer wg.Done()
+			errs[i] = pollResolver(ctx, resolver, ts, o.verifyResolversInterval, started)
+		}(i, resolver)
+	}
+	wg.Wait()
+	var pending []string
+	for _, err := range errs {
+		if err != nil {
+			pending = append(pending, err.Error())
+		}
+	}
+	if len(pending) > 0 {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("Not every resolver answers with the new value after %s: %s", time.Since(started).Round(time.Second), strings.Join(pending, "; ")))
+	}
+	return nil
+}
+
+// pollResolver asks resolver for the records of ts every interval until it
+// answers with their values, returning the last mismatch once ctx is done.
+func pollResolver(ctx context.Context, resolver string, ts []*target, interval time.Duration, started time.Time) error {
+	var mismatch error
+	for attempt := 1; ; attempt++ {
+		var err error
+		for _, t := range ts {
+			if err = verifyAnswer(ctx, resolver, t); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			f := ts[0].fields()
+			f["resolver"] = resolver
+			f["after"] = time.Since(started).Round(time.Second).String()
+			f["attempts"] = attempt
+			logger.Info("Resolver answers with the new value", f)
+			return nil
+		}
+		if ctx.Err() == nil || mismatch == nil {
+			// A query cut short by the timeout tells less than the last answer
+			mismatch = err
+		}
+		logger.Debug("Resolver doesn't answer with the new value yet", errorFields(err, fields{"resolver": resolver, "attempt": attempt}))
+		if sleepContext(ctx, interval) != nil {
+			return mismatch
+		}
+	}
+}
+
+// waitInSync waits until Route53 has applied a change on all of its name
+// servers. A nil change, made when there was nothing to do, is in sync.
+func waitInSync(ctx context.Context, r53 *route53.Route53, info *route53.ChangeInfo) error {
+	if info == nil {
+		return nil
+	}
+	err := r53.WaitUntilResourceRecordSetsChangedWithContext(ctx, &route53.GetChangeInput{Id: info.Id},
+		request.WithWaiterDelay(request.ConstantWaiterDelay(changeSyncDelay)))
+	if err != nil {
+		return err
+	}
+	logger.Debug("Change in sync", fields{"change_id": aws.StringValue(info.Id)})
+	return nil
+}
+
+// zoneNameServers returns the servers answering for a hosted zone: the ones
+// of its delegation set for public zones, the VPC resolver for private ones.
+func zoneNameServers(ctx context.Context, r53 *route53.Route53, zoneID string) ([]string, error) {
+	out, err := r53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(zoneID)})
+	if err != nil {
+		return nil, err
+	}
+	if out.HostedZone.Config != nil && aws.BoolValue(out.HostedZone.Config.PrivateZone) {
+		return []string{vpcResolver}, nil
+	}
+	if out.DelegationSet == nil {
+		return nil, fmt.Errorf("Hosted zone %s has no name servers", zoneID)
+	}
+	return aws.StringValueSlice(out.DelegationSet.NameServers), nil
+}
+
+// verifyAnswer checks that server answers queries for t's name with t's
+// value. A server answers with one of the weighted records sharing a name,
+// picked anew for every query, so for a weighted record any answer will do,
+// verifyRecordSet checking its value.
+func verifyAnswer(ctx context.Context, server string, t *target) error {
+	answers, err := dnsQuery(ctx, server, t.name, dnsTypes[t.rrType])
+	if err != nil {
+		return err
+	}
+	if len(answers) == 0 {
+		return fmt.Errorf("%s has no %s record for %s", server, t.rrType, t.name)
+	}
+	// Alias records answer with the addresses of their target, which we
+	// don't know
+	if t.alias.dnsName != "" || !t.shared {
+		return nil
+	}
+	for _, a := range answers {
+		if sameRecordName(a, t.value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s answered %s for %s, want %s", server, strings.Join(answers, ","), t.name, t.value)
+}
+
+// verifyRecordSet checks that Route53 holds t's weighted record with t's
+// value, finding it by its set identifier rather than by chance among the
+// answers of the name servers.
+func verifyRecordSet(ctx context.Context, r53 *route53.Route53, t *target) error {
+	sets, err := findRecordSets(ctx, r53, t.zoneID, t.name, t.rrType)
+	if err != nil {
+		return err
+	}
+	set := findIdentifiedSet(sets, t.setIdentifier)
+	if set == nil {
+		return fmt.Errorf("Route53 has no %s record %s with set identifier %s", t.rrType, t.name, t.setIdentifier)
+	}
+	if live := liveValue(set); !sameRecordName(live, strings.TrimSuffix(t.value, ".")) {
+		return fmt.Errorf("Route53 has %s for %s (%s), want %s", live, t.name, t.setIdentifier, t.value)
+	}
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionFields adds the build of this binary to f, for the log lines
+// telling which one runs on a host.
+func versionFields(f fields) fields {
+	if f == nil {
+		f = fields{}
+	}
+	f["version"] = version
+	f["commit"] = commit
+	f["build_date"] = buildDate
+	return f
+}
+
+func printVersion() {
+	fmt.Printf("route53_register %s (commit %s, built %s with %s for %s/%s)\n", version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
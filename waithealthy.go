@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// healthProbeTimeout bounds each attempt at the local service, so a hung
+// connection is retried rather than waited on.
+const healthProbeTimeout = 5 * time.Second
+
+// validateHealthURL checks that -wait-for-healthy is a tcp://host:port,
+// http:// or https:// URL.
+func validateHealthURL(s string) error {
+	u, err := url.Parse(s)
+	if err == nil {
+		switch u.Scheme {
+		case "tcp":
+			if _, _, err = net.SplitHostPort(u.Host); err == nil {
+				return nil
+			}
+		case "http", "https":
+			if u.Host != "" {
+				return nil
+			}
+		}
+	}
+	return errors.New("Invalid wait-for-healthy " + s + ", expected tcp://host:port or an http(s):// URL, e.g. tcp://:8080")
+}
+
+// probeHealth makes a single attempt at the service of a -wait-for-healthy
+// URL: a tcp URL must accept a connection, an http one answer with a 2xx
+// or 3xx status. A tcp URL without a host is the local host.
+func probeHealth(ctx context.Context, client *http.Client, u string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme == "tcp" {
+		host, port, _ := net.SplitHostPort(parsed.Host)
+		if host == "" {
+			host = "localhost"
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s answered with status %d", u, resp.StatusCode)
+	}
+	return nil
+}
+
+// waitForHealthy waits until the service of -wait-for-healthy is up,
+// trying it every -wait-for-healthy-interval for up to
+// -wait-for-healthy-timeout, so the record isn't published while clients
+// would still be refused.
+func (o *options) waitForHealthy() error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if o.waitForHealthyTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, o.waitForHealthyTimeout)
+	}
+	defer cancel()
+	// The service is local, so neither the proxy nor the CA bundle of the
+	// AWS calls apply, and redirects are an answer of their own
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	start := time.Now()
+	f := fields{"url": o.waitForHealthyURL}
+	for attempt := 1; ; attempt++ {
+		err := probeHealth(ctx, client, o.waitForHealthyURL)
+		if err == nil {
+			f["waited"] = time.Since(start).Round(time.Millisecond).String()
+			logger.Info("Service is healthy", f)
+			return nil
+		}
+		f["attempt"] = attempt
+		logger.Debug("Service isn't healthy yet", errorFields(err, f))
+		if sleepContext(ctx, o.waitForHealthyInterval) != nil {
+			return fmt.Errorf("Service at %s didn't become healthy within %s, not registering: %v", o.waitForHealthyURL, o.waitForHealthyTimeout, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"gopkg.in/yaml.v2"
+)
+
+// validateWeightFrom checks -weight-from and the -weight-map it may need.
+func (o *options) validateWeightFrom() error {
+	switch o.weightFrom {
+	case "", "vcpu":
+	case "instance-type-map":
+		if o.weightMapFile == "" {
+			return configError("The weight-map parameter is required with -weight-from instance-type-map")
+		}
+	default:
+		return configError("Unknown weight-from " + o.weightFrom + ", expected vcpu or instance-type-map")
+	}
+	if o.weightFrom != "" && o.shared {
+		return configError("Shared records have no weight, the weight-from parameter needs weighted records")
+	}
+	return nil
+}
+
+// loadWeightMap reads a YAML or JSON file mapping instance types to
+// weights, e.g. m5.large: 2.
+func loadWeightMap(path string) (map[string]int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]int64
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("Error parsing weight map %s: %v", path, err)
+	}
+	for instanceType, w := range m {
+		if w < 0 || w > maxWeight {
+			return nil, fmt.Errorf("Weight %d of %s in %s is out of range, expected 0 to %d", w, instanceType, path, maxWeight)
+		}
+	}
+	return m, nil
+}
+
+// recordWeight returns the weight of this host's records: -weight, or with
+// -weight-from vcpu that many times the vCPUs of the host, and with
+// instance-type-map the weight the map gives the instance's type, or
+// -weight for types it doesn't list.
+func (o *options) recordWeight(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) (int64, error) {
+	switch o.weightFrom {
+	case "vcpu":
+		vcpus := int64(runtime.NumCPU())
+		if w := o.weight * vcpus; w <= maxWeight {
+			return w, nil
+		}
+		logger.Warn("Weight is above the highest Route53 takes, capping it", fields{"weight": o.weight, "vcpus": vcpus, "max_weight": maxWeight})
+		return maxWeight, nil
+	case "instance-type-map":
+		if o.weightMap == nil {
+			m, err := loadWeightMap(o.weightMapFile)
+			if err != nil {
+				return 0, withExitCode(exitConfig, err)
+			}
+			o.weightMap = m
+		}
+		instanceType, err := getMetadata(ctx, metadataClient, "/instance-type")
+		if err != nil {
+			return 0, err
+		}
+		w, ok := o.weightMap[instanceType]
+		if !ok {
+			logger.Warn("Instance type isn't in the weight map, using -weight", fields{"instance_type": instanceType, "weight_map": o.weightMapFile, "weight": o.weight})
+			return o.weight, nil
+		}
+		return w, nil
+	}
+	return o.weight, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// instanceVPC is the -create-zone-vpc naming the VPC of the instance we
+// run on.
+const instanceVPC = "instance"
+
+// getInstanceVPC returns the VPC of the instance's primary network
+// interface and the region it's in.
+func getInstanceVPC(ctx context.Context, metadataClient *ec2metadata.EC2Metadata) (vpcID, region string, err error) {
+	mac, err := getMetadata(ctx, metadataClient, "/mac")
+	if err != nil {
+		return "", "", err
+	}
+	if vpcID, err = getMetadata(ctx, metadataClient, "/network/interfaces/macs/"+mac+"/vpc-id"); err != nil {
+		return "", "", err
+	}
+	doc, err := getIdentityDocument(ctx, metadataClient)
+	if err != nil {
+		return "", "", err
+	}
+	return vpcID, doc.Region, nil
+}
+
+// zoneVPC returns the VPC -create-zone-vpc names, and its region: the one of
+// the AWS clients when set, or else the instance's.
+func (o *options) zoneVPC(ctx context.Context) (*route53.VPC, error) {
+	metadataClient, err := newMetadataClient()
+	if err != nil {
+		return nil, err
+	}
+	if o.createZoneVPC == instanceVPC {
+		vpcID, region, err := getInstanceVPC(ctx, metadataClient)
+		if err != nil {
+			return nil, err
+		}
+		return &route53.VPC{VPCId: aws.String(vpcID), VPCRegion: aws.String(region)}, nil
+	}
+	region := awsEndpoints.region
+	if region == "" {
+		doc, err := getIdentityDocument(ctx, metadataClient)
+		if err != nil {
+			return nil, err
+		}
+		region = doc.Region
+	}
+	return &route53.VPC{VPCId: aws.String(o.createZoneVPC), VPCRegion: aws.String(region)}, nil
+}
+
+// createHostedZone creates the -zonename hosted zone, private to the
+// -create-zone-vpc when it's set, and tags it as created by this tool.
+func (o *options) createHostedZone(ctx context.Context) (string, error) {
+	r53, err := o.account.route53Client(o.logLevel())
+	if err != nil {
+		return "", err
+	}
+	name := normalizeName(o.zoneName)
+	input := &route53.CreateHostedZoneInput{
+		Name: aws.String(name),
+		// Route53 creates a zone only once for the same reference
+		CallerReference: aws.String(fmt.Sprintf("%s-%d", heritage, time.Now().UnixNano())),
+		HostedZoneConfig: &route53.HostedZoneConfig{
+			Comment: aws.String("Created by " + heritage),
+		},
+	}
+	if o.createZoneVPC != "" {
+		if input.VPC, err = o.zoneVPC(ctx); err != nil {
+			return "", err
+		}
+		input.HostedZoneConfig.PrivateZone = aws.Bool(true)
+	}
+	out, err := r53.CreateHostedZoneWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	zoneID := aws.StringValue(out.HostedZone.Id)
+	f := fields{"zone_name": name, "zone_id": zoneID, "private": o.createZoneVPC != ""}
+	if out.DelegationSet != nil {
+		// The parent zone has to delegate to these before the records resolve
+		f["name_servers"] = strings.Join(aws.StringValueSlice(out.DelegationSet.NameServers), ",")
+	}
+	logger.Info("Created hosted zone", f)
+
+	tags, err := parseTags(o.createZoneTags)
+	if err != nil {
+		return "", withExitCode(exitConfig, err)
+	}
+	tags = append([]*route53.Tag{{Key: aws.String("managed-by"), Value: aws.String(heritage)}}, tags...)
+	_, err = r53.ChangeTagsForResourceWithContext(ctx, &route53.ChangeTagsForResourceInput{
+		ResourceType: aws.String(route53.TagResourceTypeHostedzone),
+		ResourceId:   aws.String(normalizeZoneID(zoneID)),
+		AddTags:      tags,
+	})
+	if err != nil {
+		// The zone is there and usable, only harder to tell apart
+		logger.Warn("Error tagging hosted zone", errorFields(err, f))
+	}
+	return zoneID, nil
+}
+
+// parseTags parses Key=Value pairs separated by commas.
+func parseTags(s string) ([]*route53.Tag, error) {
+	var tags []*route53.Tag
+	if s == "" {
+		return nil, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.New("Invalid tag " + pair + ", expected Key=Value")
+		}
+		tags = append(tags, &route53.Tag{Key: aws.String(kv[0]), Value: aws.String(kv[1])})
+	}
+	return tags, nil
+}
+
+// checkVPCAssociation makes sure the VPC of this instance is associated with
+// the zone when it's private, as the records of a private zone only resolve
+// in its VPCs. An unassociated VPC is associated with -auto-associate, and
+// warned about otherwise. The check is best effort, it is skipped when the
+// zone or the instance's VPC can't be read.
+func (o *options) checkVPCAssociation(ctx context.Context, r53 *route53.Route53, metadataClient *ec2metadata.EC2Metadata, zoneID string) error {
+	out, err := r53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(normalizeZoneID(zoneID))})
+	if err != nil {
+		logger.Debug("Skipping VPC association check", errorFields(err, fields{"zone_id": zoneID}))
+		return nil
+	}
+	if out.HostedZone.Config == nil || !aws.BoolValue(out.HostedZone.Config.PrivateZone) {
+		return nil
+	}
+	vpcID, region, err := getInstanceVPC(ctx, metadataClient)
+	if err != nil {
+		logger.Debug("Skipping VPC association check", errorFields(err, fields{"zone_id": zoneID}))
+		return nil
+	}
+	for _, vpc := range out.VPCs {
+		if aws.StringValue(vpc.VPCId) == vpcID && aws.StringValue(vpc.VPCRegion) == region {
+			return nil
+		}
+	}
+	f := fields{"zone_id": zoneID, "vpc_id": vpcID, "vpc_region": region}
+	if !o.autoAssociate {
+		logger.Warn("This instance's VPC isn't associated with the private zone, its records don't resolve from here", f)
+		return nil
+	}
+	_, err = r53.AssociateVPCWithHostedZoneWithContext(ctx, &route53.AssociateVPCWithHostedZoneInput{
+		HostedZoneId: aws.String(zoneID),
+		VPC:          &route53.VPC{VPCId: aws.String(vpcID), VPCRegion: aws.String(region)},
+		Comment:      aws.String("Associated by " + heritage),
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Associated VPC with private zone", f)
+	return nil
+}
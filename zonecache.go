@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// zoneCacheEntry is a hosted zone looked up by name, saved to
+// -zone-cache-file.
+type zoneCacheEntry struct {
+	ZoneID   string    `json:"zone_id"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// zoneIDCache keeps the ids of the zones looked up by name between runs, so
+// hosts registering often, or a fleet booting together, don't spend a
+// ListHostedZonesByName call on every run, which Route53 throttles along
+// with the other calls of the account. Like the audit log it is set up from
+// the flags of the command, and disabled without a file.
+type zoneIDCache struct {
+	mu   sync.Mutex
+	path string
+	ttl  time.Duration
+}
+
+var zoneCache zoneIDCache
+
+func (c *zoneIDCache) configure(path string, ttl time.Duration) error {
+	if path != "" && ttl <= 0 {
+		return errors.New("zone-cache-ttl must be positive")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.path, c.ttl = path, ttl
+	return nil
+}
+
+// zoneCacheKey returns the key of the zone named name. The same name may be
+// another zone for other credentials, the role of another account or in
+// another partition.
+func zoneCacheKey(account awsAccount, name string) string {
+	key := awsEndpoints.partition(awsEndpoints.region) + "|" + awsCredentials.profile + "|" + awsCredentials.roleARN + "|" + normalizeName(name)
+	if account != (awsAccount{}) {
+		key = account.key() + "|" + key
+	}
+	return key
+}
+
+// lookup returns the id of the zone named name in account when it was
+// cached less than the TTL ago.
+func (c *zoneIDCache) lookup(account awsAccount, name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" {
+		return "", false
+	}
+	entries, err := c.load()
+	if err != nil {
+		logger.Warn("Error reading zone cache", errorFields(err, fields{"zone_cache_file": c.path}))
+		return "", false
+	}
+	e, ok := entries[zoneCacheKey(account, name)]
+	if !ok || time.Since(e.Resolved) >= c.ttl {
+		return "", false
+	}
+	return e.ZoneID, true
+}
+
+// store caches zoneID as the id of the zone named name in account. The cache
+// only saves calls, so failing to write it is only logged.
+func (c *zoneIDCache) store(account awsAccount, name, zoneID string) {
+	c.update(func(entries map[string]zoneCacheEntry) {
+		entries[zoneCacheKey(account, name)] = zoneCacheEntry{ZoneID: zoneID, Resolved: time.Now().UTC()}
+	})
+}
+
+// forget drops zoneID from the cache, e.g. once Route53 says it no longer
+// exists because the zone was deleted and created again.
+func (c *zoneIDCache) forget(zoneID string) {
+	c.update(func(entries map[string]zoneCacheEntry) {
+		for k, e := range entries {
+			if normalizeZoneID(e.ZoneID) == normalizeZoneID(zoneID) {
+				delete(entries, k)
+			}
+		}
+	})
+}
+
+func (c *zoneIDCache) update(change func(map[string]zoneCacheEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" {
+		return
+	}
+	err := func() error {
+		entries, err := c.load()
+		if err != nil {
+			return err
+		}
+		change(entries)
+		// Expired entries would only be looked up again
+		for k, e := range entries {
+			if time.Since(e.Resolved) >= c.ttl {
+				delete(entries, k)
+			}
+		}
+		return c.save(entries)
+	}()
+	if err != nil {
+		logger.Warn("Error writing zone cache", errorFields(err, fields{"zone_cache_file": c.path}))
+	}
+}
+
+func (c *zoneIDCache) load() (map[string]zoneCacheEntry, error) {
+	entries := map[string]zoneCacheEntry{}
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		// A damaged cache is rebuilt from the lookups
+		logger.Warn("Ignoring unparsable zone cache", errorFields(err, fields{"zone_cache_file": c.path}))
+		return map[string]zoneCacheEntry{}, nil
+	}
+	return entries, nil
+}
+
+func (c *zoneIDCache) save(entries map[string]zoneCacheEntry) error {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so hosts' runs overlapping don't leave half
+	// a file
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}